@@ -0,0 +1,110 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	appleSiliconOnce sync.Once
+	appleSilicon     bool
+)
+
+// isAppleSilicon reports whether run is on an M-series Mac, including when
+// run itself is an x86_64 build translated by Rosetta 2.
+func isAppleSilicon() bool {
+	appleSiliconOnce.Do(func() {
+		if runtime.GOOS != "darwin" {
+			return
+		}
+		if runtime.GOARCH == "arm64" {
+			appleSilicon = true
+			return
+		}
+		out, err := outputCmd(exec.Command("sysctl", "-n", "hw.optional.arm64"))
+		appleSilicon = err == nil && strings.TrimSpace(string(out)) == "1"
+	})
+	return appleSilicon
+}
+
+// brewPrefix returns where Homebrew installs: /opt/homebrew on Apple
+// Silicon, /usr/local on Intel Macs. HOMEBREW_PREFIX wins when set.
+func brewPrefix() string {
+	if prefix := os.Getenv("HOMEBREW_PREFIX"); prefix != "" {
+		return prefix
+	}
+	if isAppleSilicon() {
+		return "/opt/homebrew"
+	}
+	return "/usr/local"
+}
+
+// brewBinary returns the Homebrew copy of bin when bin is not on PATH, which
+// happens when the shell profile never ran `brew shellenv`. It returns ""
+// elsewhere.
+func brewBinary(bin string) string {
+	if runtime.GOOS != "darwin" || strings.ContainsRune(bin, filepath.Separator) {
+		return ""
+	}
+	if _, err := exec.LookPath(bin); err == nil {
+		return ""
+	}
+	path := filepath.Join(brewPrefix(), "bin", bin)
+	if info, err := os.Stat(path); err == nil && info.Mode()&0o111 != 0 {
+		return path
+	}
+	return ""
+}
+
+// rosettaBinary reports whether bin, as found on PATH, is an Intel-only
+// program that runs under Rosetta 2 on this Apple Silicon Mac.
+func rosettaBinary(bin string) bool {
+	if !isAppleSilicon() {
+		return false
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return false
+	}
+	if fat, err := macho.OpenFat(path); err == nil {
+		defer fat.Close()
+		for _, arch := range fat.Arches {
+			if arch.Cpu == macho.CpuArm64 {
+				return false
+			}
+		}
+		return true
+	}
+	f, err := macho.Open(path)
+	if err != nil {
+		return false // Scripts and shims can't be told apart
+	}
+	defer f.Close()
+	return f.Cpu == macho.CpuAmd64
+}
+
+// installCommandLineTools replaces running `xcode-select --install` blindly:
+// the command only opens a GUI dialog and returns immediately, so the
+// install can't be awaited.
+func installCommandLineTools() error {
+	if cmd := exec.Command("xcode-select", "-p"); runCmd(cmd) == nil {
+		return newRunError("runtime-unavailable", exitRuntimeUnavailable,
+			"The Xcode Command Line Tools are installed but the compiler was not found. "+
+				"Try `sudo xcode-select --reset` or reinstall them.")
+	}
+	fmt.Println("A dialog will now ask to install the Xcode Command Line Tools.")
+	cmd := exec.Command("xcode-select", "--install")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runCmd(cmd); err != nil {
+		return newRunError("runtime-unavailable", exitRuntimeUnavailable, "Cannot start the installation: %v", err)
+	}
+	return newRunError("runtime-unavailable", exitRuntimeUnavailable,
+		"Finish the installation in the dialog, then re-run this command.")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keepBuild keeps the build directory instead of removing it after the run
+// (--keep), for the compiled program to be used elsewhere.
+var keepBuild bool
+
+// outPath is where the compile step writes the program instead of the build
+// directory (--out): the executable, Java's class directory or the .NET
+// build output. It outlives the run.
+var outPath string
+
+// builtOutputsFile lists the --out targets run has written, with their
+// modification time then, so that building one again needn't --force.
+func builtOutputsFile() string {
+	return filepath.Join(dataDir(), "built-outputs.json")
+}
+
+func readBuiltOutputs() map[string]time.Time {
+	outputs := map[string]time.Time{}
+	if data, err := os.ReadFile(builtOutputsFile()); err == nil {
+		json.Unmarshal(data, &outputs)
+	}
+	return outputs
+}
+
+// checkOutPath refuses an --out target that is one of the sources, even with
+// --force, or that exists and wasn't built there by run, unless --force.
+// Compilers overwrite their output without asking, so -o main.c would
+// replace the source with the program.
+func checkOutPath(sources []string) error {
+	target, err := filepath.Abs(outPath)
+	if err != nil {
+		return usageError("Invalid --out %q: %v", outPath, err)
+	}
+	built := readBuiltOutputs()
+	for _, path := range []string{target, target + ".exe"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		for _, source := range sources {
+			if sourceInfo, err := os.Stat(source); err == nil && os.SameFile(info, sourceInfo) {
+				return usageError("--out %s is the source file %s; the program would overwrite it.", outPath, source)
+			}
+		}
+		if when, ok := built[path]; ok && when.Equal(info.ModTime()) {
+			continue
+		}
+		if !force {
+			return usageError("--out %s already exists and wasn't built by run; --force overwrites it.", outPath)
+		}
+	}
+	return nil
+}
+
+// recordOutPath notes that run has just built the --out target, so that a
+// later build may overwrite it.
+func recordOutPath() {
+	target, err := filepath.Abs(outPath)
+	if err != nil {
+		return
+	}
+	outputs := readBuiltOutputs()
+	for path := range outputs {
+		if _, err := os.Stat(path); err != nil {
+			delete(outputs, path) // Removed since
+		}
+	}
+	for _, path := range []string{target, target + ".exe"} {
+		if info, err := os.Stat(path); err == nil {
+			outputs[path] = info.ModTime()
+		}
+	}
+	data, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(dataDir(), 0o755); err != nil {
+		return
+	}
+	tmp := builtOutputsFile() + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+		os.Rename(tmp, builtOutputsFile())
+	}
+}
+
+// compilerArgs go to the compiler, before the source file (--compiler-args,
+// --cflag), e.g. -O2 or -std=c++20.
+var compilerArgs []string
+
+// runtimeArgs go to the interpreter or VM, before the source file or Java's
+// class (--runtime-args), e.g. -u for python3 or -Xmx2g for java.
+var runtimeArgs []string
+
+// buildSeq numbers the build directories of an invocation, which may build
+// the same source twice, as in run bench ab a.c a.c.
+var buildSeq atomic.Int64
+
+// newBuildDir returns the build directory for sourceFile: private to this
+// invocation and under the system's temporary directory, named
+// run-<pid>-<hash>. makeBuildDir creates it.
+func newBuildDir(sourceFile string) string {
+	abs, _ := filepath.Abs(sourceFile)
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d", abs, buildSeq.Add(1)))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("run-%d-%x", os.Getpid(), sum[:4]))
+}
+
+// makeBuildDir creates the plan's build directory. One left behind by a
+// crashed run whose PID has come round again is replaced; if another user
+// made it, creating it fails rather than building where they can write.
+func (p execPlan) makeBuildDir() error {
+	if p.BuildDir == "" {
+		return nil
+	}
+	err := os.Mkdir(p.BuildDir, 0o700)
+	if errors.Is(err, os.ErrExist) {
+		os.RemoveAll(p.BuildDir)
+		err = os.Mkdir(p.BuildDir, 0o700)
+	}
+	if err != nil {
+		return err
+	}
+	logEvent("build-dir", map[string]any{"path": p.BuildDir})
+	if outPath != "" {
+		// Most compilers don't create the directory of their output
+		return os.MkdirAll(filepath.Dir(p.Executable), 0o755)
+	}
+	return nil
+}
+
+// keptArtifact is what --keep keeps: the executable, or the directory of
+// Java classes or the .NET project or build output.
+func (p execPlan) keptArtifact() string {
+	switch filepath.Ext(p.SourceFile) {
+	case ".java", ".cs":
+		return p.Executable
+	}
+	if _, err := os.Stat(p.Executable + ".exe"); err == nil {
+		return p.Executable + ".exe"
+	}
+	return p.Executable
+}
+
+// buildDirFlags are the compiler flags that send the intermediate files of
+// ext, which land next to the source by default, to dir.
+func buildDirFlags(ext, dir string) []string {
+	switch ext {
+	case ".hs":
+		return []string{"-outputdir", dir}
+	case ".pas":
+		return []string{"-FU" + dir}
+	}
+	return nil
+}
+
+// outputArgs are the source and output arguments of the compilers that
+// don't take the source followed by -o <program>, as placeholders.
+var outputArgs = map[string][]string{
+	".nim": {"-o:{exe}", "{file}"}, // Options go before the file
+	".pas": {"-o{exe}", "{file}"},
+	".zig": {"-femit-bin={exe}", "{file}"},
+	".vb":  {"-out:{exe}", "{file}"},
+	".fs":  {"-o:{exe}", "{file}"},
+}
+
+// outputFlags returns the arguments that compile sources of ext into the
+// program exe.
+func outputFlags(ext string, sources []string, exe string) []string {
+	args, ok := outputArgs[ext]
+	if !ok {
+		args = []string{"{file}", "-o", "{exe}"}
+	}
+	var flags []string
+	for _, arg := range args {
+		if arg == "{file}" {
+			flags = append(flags, sources...) // One argument each
+			continue
+		}
+		flags = append(flags, expandCommand([]string{arg}, map[string][]string{"file": sources[:1], "exe": {exe}})...)
+	}
+	return flags
+}
+
+// artifactSize returns the size in bytes of what the compile step produced:
+// the executable, a Java program's .class files or a .NET project's build
+// output. It returns -1 when that can't be determined.
+func (p execPlan) artifactSize() int64 {
+	switch {
+	case filepath.Ext(p.SourceFile) == ".cs":
+		if p.Executable == filepath.Dir(p.SourceFile) {
+			return dirSize(filepath.Join(p.Executable, "bin"))
+		}
+		return dirSize(p.Executable)
+	case filepath.Ext(p.SourceFile) == ".java":
+		// Main.class plus nested classes such as Main$Node.class
+		class := strings.TrimSuffix(filepath.Base(p.SourceFile), ".java")
+		matches, _ := filepath.Glob(filepath.Join(p.Executable, class+"*.class"))
+		var total int64 = -1
+		for _, path := range matches {
+			name := strings.TrimSuffix(filepath.Base(path), ".class")
+			if name != class && !strings.HasPrefix(name, class+"$") {
+				continue
+			}
+			if info, err := os.Stat(path); err == nil {
+				total = max(total, 0) + info.Size()
+			}
+		}
+		return total
+	}
+	// Windows compilers add .exe
+	for _, path := range []string{p.Executable, p.Executable + ".exe"} {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return -1
+}
+
+func dirSize(dir string) int64 {
+	var total int64 = -1
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total = max(total, 0) + info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// formatSize renders a byte count with binary units, e.g. "1.4 MB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
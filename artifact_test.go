@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOutOverwrite checks which existing files --out may replace.
+func TestOutOverwrite(t *testing.T) {
+	requireTools(t, "gcc")
+	env := newRunEnv(t)
+	const source = "int main(void) { return 0; }\n"
+	tests := []struct {
+		name     string
+		existing string // Written before the run, if set
+		args     []string
+		code     int
+	}{
+		{"new", "", []string{"-c", "-o", "bin/prog", "main.c"}, 0},
+		{"not built by run", "notes\n", []string{"-c", "-o", "prog", "main.c"}, exitUsage},
+		{"forced", "notes\n", []string{"-c", "--force", "-o", "prog", "main.c"}, 0},
+		{"the source", "", []string{"-c", "-o", "main.c", "main.c"}, exitUsage},
+		{"the source, forced", "", []string{"-c", "--force", "-o", "main.c", "main.c"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "main.c", source)
+			if tt.existing != "" {
+				writeFile(t, dir, "prog", tt.existing)
+			}
+			res := env.run(t, dir, tt.args...)
+			if res.Code != tt.code {
+				t.Fatalf("exit code %d, want %d\n%s%s", res.Code, tt.code, res.Stdout, res.Stderr)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "main.c")); string(data) != source {
+				t.Errorf("main.c was overwritten")
+			}
+			if tt.existing != "" && tt.code != 0 {
+				if data, _ := os.ReadFile(filepath.Join(dir, "prog")); string(data) != tt.existing {
+					t.Errorf("prog was overwritten")
+				}
+			}
+		})
+	}
+
+	// Building again over what run built needs no --force
+	dir := t.TempDir()
+	writeFile(t, dir, "main.c", source)
+	for range 2 {
+		if res := env.run(t, dir, "-c", "-o", "prog", "main.c"); res.Code != 0 {
+			t.Fatalf("exit code %d\n%s", res.Code, strings.TrimSpace(res.Stdout+res.Stderr))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// auditFile scans the file for red flags before running it (--audit).
+var auditFile bool
+
+// auditPattern is a construct that --audit points out before running a file.
+// The config file adds more with auditPatterns.
+type auditPattern struct {
+	Name      string   `json:"name"`
+	Pattern   string   `json:"pattern"`             // Regular expression matched against each line
+	Languages []string `json:"languages,omitempty"` // Extensions it applies to; all when empty
+
+	re *regexp.Regexp
+}
+
+// auditPatterns are the red flags --audit looks for. Most apply to every
+// language, as scripts in any language shell out; shell and Python get
+// patterns of their own where their syntax differs.
+var auditPatterns = []auditPattern{
+	{Name: "runs sudo", Pattern: `(^|[;&|(]|\bthen|\bdo)\s*sudo\s`, Languages: []string{".sh"}},
+	{Name: "runs sudo", Pattern: `["'\x60]\s*sudo\s`},
+	{Name: "rm -rf on a variable", Pattern: `\brm\s+(-\w+\s+)*-\w*[rR]\w*\s+[^#]*\$`, Languages: []string{".sh"}},
+	{Name: "removes a computed directory tree", Pattern: `shutil\.rmtree\(\s*[^"'\s)]`, Languages: []string{".py"}},
+	{Name: "pipes a download into a shell", Pattern: `\b(curl|wget)\b[^|#]*\|\s*(sudo\s+)?(ba|da|z)?sh\b`},
+	{Name: "pipes decoded base64 into a shell", Pattern: `\bbase64\s+(-d|-D|--decode)\b[^|]*\|\s*(sudo\s+)?(ba|da|z)?sh\b`},
+	{Name: "executes decoded base64", Pattern: `\bexec\(\s*(base64\.)?b64decode\(`, Languages: []string{".py"}},
+	{Name: "makes files writable by everyone", Pattern: `\bchmod\s+(-R\s+)?0?777\b`},
+	{Name: "makes files writable by everyone", Pattern: `\bos\.chmod\([^)]*\b0o?777\b`, Languages: []string{".py"}},
+	{Name: "writes to a dotfile in the home directory", Pattern: `>>?\s*"?(\$HOME|\$\{HOME\}|~)/\.\w`, Languages: []string{".sh"}},
+	{Name: "writes to a dotfile in the home directory", Pattern: `open\(\s*(os\.path\.expanduser\(\s*)?["'](~|\$HOME)/\.\w[^)]*["'][aw]`, Languages: []string{".py"}},
+}
+
+// auditFinding is a line that matched an audit pattern.
+type auditFinding struct {
+	Line int
+	Name string
+	Text string
+}
+
+// compileAuditPatterns compiles the patterns that are not compiled yet,
+// naming the first invalid one.
+func compileAuditPatterns(patterns []auditPattern) error {
+	for i := range patterns {
+		if patterns[i].re != nil {
+			continue
+		}
+		re, err := regexp.Compile(patterns[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("audit pattern %q: %v", patterns[i].Name, err)
+		}
+		patterns[i].re = re
+	}
+	return nil
+}
+
+// auditSource returns the lines of sourceFile, a file of language ext, that
+// match an audit pattern.
+func auditSource(sourceFile, ext string) ([]auditFinding, error) {
+	if err := compileAuditPatterns(auditPatterns); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var findings []auditFinding
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		for _, p := range auditPatterns {
+			if len(p.Languages) > 0 && !slices.Contains(p.Languages, ext) {
+				continue
+			}
+			if p.re.MatchString(line) {
+				findings = append(findings, auditFinding{Line: n, Name: p.Name, Text: line})
+				break
+			}
+		}
+	}
+	return findings, scanner.Err()
+}
+
+// confirmAudit lists the red flags in sourceFile and asks whether to run it
+// anyway; --yes does without asking. With --dry-run the findings are only
+// listed.
+func confirmAudit(sourceFile, ext string, dryRun bool) error {
+	findings, err := auditSource(sourceFile, ext)
+	if err != nil {
+		return newRunError("error", 1, "Cannot audit %s: %v", sourceFile, err)
+	}
+	logEvent("audit", map[string]any{"file": sourceFile, "findings": len(findings)})
+	if len(findings) == 0 {
+		infof("Audit: nothing suspicious found in %s.\n", sourceFile)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Audit: %s has %d line(s) worth a look before running it:\n", sourceFile, len(findings))
+	for _, f := range findings {
+		fmt.Fprintf(os.Stderr, "  %s:%d: %s\n      %s\n", sourceFile, f.Line, f.Name, truncateLine(strings.TrimSpace(f.Text)))
+	}
+	switch {
+	case dryRun, assumeYes:
+		return nil
+	case !isTerminal(os.Stdin):
+		return newRunError("audit-declined", exitAuditDeclined,
+			"Not running %s without a confirmation; pass --yes to run it anyway.", sourceFile)
+	case !askGoAhead("not running it"):
+		return newRunError("audit-declined", exitAuditDeclined, "Not running %s.", sourceFile)
+	}
+	return nil
+}
+
+// truncateLine shortens a quoted source line to fit on a terminal line.
+func truncateLine(line string) string {
+	const max = 100
+	if runes := []rune(line); len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	return line
+}
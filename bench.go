@@ -0,0 +1,735 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchOptions holds the settings of a --bench invocation.
+type benchOptions struct {
+	Runs       int
+	Warmup     int  // Untimed iterations before measuring
+	JSON       bool // Print the report as JSON instead of text
+	Isolate    bool // Give every iteration a fresh empty working directory
+	Assertions []benchAssertion
+
+	VerifyWith  string // File holding the expected output of every iteration
+	VerifyFirst bool   // Expect every iteration to print what the first did
+
+	// Input is a file every iteration reads as its stdin. Without it
+	// iterations get no stdin, so a program that reads input can't block.
+	Input string
+
+	// Stats are the statistics to report (--bench-stats); nil reports
+	// defaultBenchStats.
+	Stats []string
+}
+
+// benchAssertion is an upper bound on one statistic, e.g. --assert-max-p99 400ms.
+type benchAssertion struct {
+	Stat string
+	Max  time.Duration
+}
+
+// assertionResult is the outcome of one benchAssertion.
+type assertionResult struct {
+	Stat     string `json:"stat"`
+	MaxNs    int64  `json:"maxNs"`
+	ActualNs int64  `json:"actualNs"`
+	Passed   bool   `json:"passed"`
+	Note     string `json:"note,omitempty"`
+}
+
+// assertableStats lists the statistics accepted by --assert-max-<stat>.
+var assertableStats = []string{"mean", "median", "min", "max", "stddev", "p50", "p90", "p95", "p99"}
+
+// benchStats summarizes the measured iteration times.
+type benchStats struct {
+	Total  time.Duration
+	Mean   time.Duration
+	Median time.Duration
+	Min    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+}
+
+// benchEnvironment describes the machine a benchmark ran on. Every field is
+// best-effort and left empty when it can't be determined.
+type benchEnvironment struct {
+	OS             string `json:"os"`
+	Arch           string `json:"arch"`
+	WSL            string `json:"wsl,omitempty"`
+	CPUModel       string `json:"cpuModel,omitempty"`
+	Cores          int    `json:"cores"`
+	RuntimeVersion string `json:"runtimeVersion,omitempty"`
+	Build          string `json:"build"`
+	Governor       string `json:"governor,omitempty"`
+	PowerState     string `json:"powerState,omitempty"`
+	LoadAverage    string `json:"loadAverage,omitempty"`
+}
+
+// benchReport is the JSON form of a benchmark; durations are nanoseconds.
+type benchReport struct {
+	File     string `json:"file"`
+	Language string `json:"language"`
+	Runs     int    `json:"runs"`
+	Warmup   int    `json:"warmup"`
+	Failed   int    `json:"failed"`
+	reportedStats
+	Environment benchEnvironment  `json:"environment"`
+	Warnings    []string          `json:"warnings,omitempty"`
+	Assertions  []assertionResult `json:"assertions,omitempty"`
+
+	// Size of the compiled program; absent for interpreted languages
+	ArtifactBytes int64 `json:"artifactBytes,omitempty"`
+	// Iterations whose output didn't match, left out of the statistics
+	Invalid      int    `json:"invalid,omitempty"`
+	VerifiedWith string `json:"verifiedWith,omitempty"`
+	// Digest of the source file when the benchmark started
+	SourceSHA256 string `json:"sourceSha256"`
+	// The warm process the iterations ran in (--use-daemon), if any
+	Daemon string `json:"daemon,omitempty"`
+	// How long run's own phases before the iterations took
+	RunProfile []phaseTiming `json:"runProfile,omitempty"`
+	// The compiled program kept by --keep
+	KeptArtifact string `json:"keptArtifact,omitempty"`
+	// What the program was compiled with, so optimized builds are told
+	// apart from unoptimized ones
+	CompileCommand []string `json:"compileCommand,omitempty"`
+}
+
+// jitExts lists languages whose first iterations are dominated by JIT
+// warm-up and therefore need warmup runs for stable numbers.
+var jitExts = map[string]bool{
+	".java": true, ".kt": true, ".groovy": true, ".cs": true, ".fs": true,
+	".vb": true, ".js": true, ".ts": true, ".jl": true, ".dart": true,
+}
+
+func performBenchmark(sourceFile string, config LanguageConfig, ext string, opts benchOptions) error {
+	runs := opts.Runs
+	// Progress goes to stderr when stdout carries the JSON report
+	var out io.Writer = os.Stdout
+	if opts.JSON {
+		out = os.Stderr
+	}
+
+	fmt.Fprintf(out, "🔥  Running benchmark with %d iterations...\n", runs)
+	fmt.Fprintln(out, strings.Repeat("=", 50))
+
+	times := make([]time.Duration, 0, runs)
+	failed, invalid := 0, 0
+	verifier, err := newOutputVerifier(opts)
+	if err != nil {
+		return usageError("Cannot read the expected output: %v", err)
+	}
+
+	// The digest attributes the results to an exact version of the source
+	sourceSum, err := fileSHA256(sourceFile)
+	if err != nil {
+		return newRunError("file-not-found", exitNoInput, "Cannot read %s: %v", sourceFile, err)
+	}
+
+	// Compile once if needed. The plan is shared with executeFile, so the
+	// .NET project is prepared the same way, in the build directory.
+	plan, removeCopy, err := shebangSafePlan(sourceFile, config, ext)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot strip the #! line: %v\n", err)
+		return &runError{Status: "compile-failed", Code: exitCompileFailed}
+	}
+	defer removeCopy()
+	depsBase := dataDepsBase(plan)
+	if err := checkDataDeps(depsBase); err != nil {
+		return err
+	}
+	if err := plan.makeBuildDir(); err != nil {
+		fmt.Fprintf(out, "Cannot create the build directory: %v\n", err)
+		return &runError{Status: "compile-failed", Code: exitCompileFailed}
+	}
+	defer plan.cleanup()
+
+	if plan.Prepare != nil {
+		prepare := beginPhase("prepare")
+		step := startStep("preparing " + sourceFile)
+		err := plan.Prepare(step)
+		step.Finish(err)
+		prepare.end(err)
+		if err != nil {
+			fmt.Fprintf(out, "Preparation failed: %v\n", err)
+			return &runError{Status: "compile-failed", Code: exitCompileFailed}
+		}
+	}
+	var artifactBytes int64 = -1
+	var keptArtifact string
+	cacheKey := plan.buildCacheKey()
+	releaseBuild := func() {}
+	if cacheKey != "" {
+		releaseBuild = lockBuild(cacheKey, sourceFile)
+	}
+	if cacheKey != "" && !noBuildCache && plan.restoreBuild(cacheKey) {
+		fmt.Fprintf(out, "Using the cached build of %s.\n\n", sourceFile)
+		artifactBytes = plan.artifactSize()
+	} else if plan.Compile != nil {
+		fmt.Fprintf(out, "Compiling %s...\n", sourceFile)
+		cmd := plan.command(context.Background(), plan.Compile)
+		compile := beginPhase("compile")
+		step := startStep("compiling " + sourceFile)
+		cmd.Stdout = step
+		cmd.Stderr = step
+		err := runCmd(cmd)
+		step.Finish(err)
+		compile.end(err)
+		if err != nil {
+			releaseBuild()
+			fmt.Fprintf(out, "Compilation failed: %v\n", err)
+			return &runError{Status: "compile-failed", Code: exitCompileFailed}
+		}
+		if cacheKey != "" {
+			plan.saveBuild(cacheKey, sourceFile, ext)
+		}
+		artifactBytes = plan.artifactSize()
+		if artifactBytes >= 0 {
+			fmt.Fprintf(out, "✓ Compilation successful (%s)\n", formatSize(artifactBytes))
+		} else {
+			fmt.Fprint(out, "✓ Compilation successful\n")
+		}
+		if keepBuild {
+			keptArtifact = plan.keptArtifact()
+			fmt.Fprintf(out, "Keeping %s (--keep)\n", keptArtifact)
+		}
+		fmt.Fprintln(out)
+	}
+	releaseBuild()
+	executableName := plan.Executable
+
+	// Isolated iterations run elsewhere, so they need absolute paths to the
+	// source and binary; the build directory's already are.
+	isolate := opts.Isolate
+	runSource, runExecutable := plan.SourceFile, executableName
+	if !config.IsCompiled && plan.SourceFile == sourceFile {
+		snapshot, removeSnapshot, err := snapshotSource(sourceFile)
+		if err != nil {
+			return newRunError("error", 1, "Cannot snapshot %s for the benchmark: %v", sourceFile, err)
+		}
+		defer removeSnapshot()
+		runSource = snapshot
+	}
+	if plan.Dir != "" && !config.IsCompiled {
+		// Iterations run in the program's directory, like a normal run
+		runSource, _ = filepath.Abs(runSource)
+	}
+	var isolateRoot string
+	if isolate {
+		runSource, _ = filepath.Abs(runSource)
+		runExecutable, _ = filepath.Abs(executableName)
+		var err error
+		if isolateRoot, err = os.MkdirTemp("", "run-bench-"); err != nil {
+			return fmt.Errorf("cannot create isolation directory: %w", err)
+		}
+	}
+
+	// A warm JVM makes iterations incomparable with fresh starts, so the
+	// report says which it was
+	var daemon *daemonState
+	daemonNote, daemonMissed := "", ""
+	if useDaemon {
+		switch ext {
+		case ".java":
+			state, ok := javaDaemon()
+			if !ok {
+				daemonMissed = "no JVM daemon is running (run daemon start)"
+			} else if reason := daemonFallback(state, sourceFile); reason != "" {
+				daemonMissed = reason
+			} else {
+				daemon = &state
+				daemonNote = fmt.Sprintf("warm JVM (run daemon, pid %d)", state.PID)
+			}
+		case ".cs":
+			daemonNote = "MSBuild server, dotnet run --no-build"
+		}
+	}
+
+	// All iterations share one fake home, as runs of the program would
+	var homeEnv []string
+	if fakeHome {
+		home, env, err := newFakeHome()
+		if err != nil {
+			return newRunError("error", 1, "Cannot create the fake home: %v", err)
+		}
+		defer finishFakeHome(home)
+		homeEnv = env
+	}
+
+	// runOnce runs one iteration with its output going to stdout, or
+	// nowhere when stdout is nil
+	runOnce := func(stdout io.Writer) error {
+		if daemon != nil {
+			if stdout == nil {
+				stdout = io.Discard
+			}
+			class := config.ClassNameFn(filepath.Base(sourceFile))
+			code, err := runInDaemon(*daemon, plan.Executable, class, programArgs, stdout, io.Discard)
+			if err == nil && code != 0 {
+				err = fmt.Errorf("exit status %d", code)
+			}
+			return err
+		}
+		ctx, cancel := withRunTimeout(context.Background())
+		defer cancel()
+		// Through plan.command, like run file, for the same directory and
+		// environment
+		var argv []string
+		switch {
+		case hasPlaceholders(config.RunCmd), ext == ".cs":
+			argv = plan.Run // Already has the program's arguments
+		case ext == ".java":
+			argv = append(append([]string{config.RunCmd[0]}, runtimeArgs...), "-cp", plan.Executable, config.ClassNameFn(filepath.Base(sourceFile)))
+		case config.IsCompiled:
+			argv = []string{localPath(runExecutable)}
+		default:
+			argv = append(append(append([]string{}, config.RunCmd...), runtimeArgs...), runSource)
+		}
+		if !hasPlaceholders(config.RunCmd) && ext != ".cs" {
+			argv = append(argv, programArgs...)
+		}
+		cmd := plan.command(ctx, argv)
+
+		addEnv(cmd, homeEnv)
+
+		if isolate {
+			dir, err := os.MkdirTemp(isolateRoot, "iter-")
+			if err != nil {
+				return err
+			}
+			if err := placeDataDeps(depsBase, dir); err != nil {
+				return err
+			}
+			cmd.Dir = dir
+		}
+		if opts.Input != "" {
+			input, err := os.Open(opts.Input)
+			if err != nil {
+				return err
+			}
+			defer input.Close()
+			cmd.Stdin = input
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = nil
+		applyTimeout(cmd)
+		if err := runCmd(cmd); err != nil {
+			if timedOut(ctx) {
+				return fmt.Errorf("timed out after %v", runTimeout)
+			}
+			return err
+		}
+		return nil
+	}
+
+	// Without isolation, watch the directory the iterations run in, the
+	// program's own or the working directory, for files the program leaves
+	// behind, which usually make later iterations faster than the first.
+	iterDir := plan.Dir
+	if iterDir == "" {
+		iterDir = "."
+	}
+	fileCount := func() int {
+		entries, _ := os.ReadDir(iterDir)
+		return len(entries)
+	}
+	initialFiles := fileCount()
+	var churn string
+	trackFiles := func() {
+		if isolate || churn != "" {
+			return
+		}
+		if n := fileCount(); n != initialFiles {
+			where := "The working directory"
+			if plan.Dir != "" {
+				where = plan.Dir
+			}
+			churn = fmt.Sprintf("%s went from %d to %d entries during the benchmark; "+
+				"files written by one iteration may speed up the next. Use --bench-isolate.", where, initialFiles, n)
+		}
+	}
+
+	// Ctrl+C stops the benchmark between iterations, so that the snapshot
+	// and the compiled program are still removed
+	stopWatching := watchInterrupts()
+	defer stopWatching()
+
+	// Warm up caches and JITs without measuring
+	for i := 0; i < opts.Warmup && interruptError() == nil; i++ {
+		fmt.Fprintf(out, "Warmup %d/%d...\r", i+1, opts.Warmup)
+		runOnce(nil)
+		trackFiles()
+	}
+
+	// Run benchmark iterations
+	for i := 0; i < runs && interruptError() == nil; i++ {
+		fmt.Fprintf(out, "Run %d/%d... ", i+1, runs)
+
+		var output *outputCapture
+		var stdout io.Writer // Output is suppressed unless it is verified
+		if verifier != nil {
+			output = newOutputCapture()
+			stdout = output
+		}
+		start := time.Now()
+		err := runOnce(stdout)
+		elapsed := time.Since(start)
+		trackFiles()
+
+		if verifier != nil {
+			if ok, diff := verifier.verify(output); !ok {
+				invalid++
+				fmt.Fprintf(out, "✗ Wrong output (%s)\n", diff)
+				continue
+			}
+		}
+		times = append(times, elapsed)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "✗ Failed (%v)\n", err)
+		} else {
+			fmt.Fprintf(out, "✓ %s\r", formatDuration(elapsed))
+		}
+	}
+
+	if isolateRoot != "" {
+		os.RemoveAll(isolateRoot)
+	}
+
+	if re := interruptError(); re != nil {
+		fmt.Fprintln(out)
+		return re
+	}
+	if len(times) == 0 {
+		return newRunError("verification-failed", exitBenchFailed,
+			"\nEvery iteration's output differed from %s; there is nothing to report.", verifier.source)
+	}
+	stats := computeStats(times)
+	env := gatherEnvironment(config, ext)
+	warnings := noiseWarnings(env, ext, opts)
+	if daemonMissed != "" {
+		warnings = append(warnings, "--use-daemon was given but "+daemonMissed+"; every iteration started a fresh JVM.")
+	}
+	if bin := config.CheckCmd[0]; rosettaBinary(bin) {
+		warnings = append(warnings, fmt.Sprintf("%s is an x86_64 build running under Rosetta 2; "+
+			"install the native arm64 toolchain for representative numbers.", bin))
+	}
+	if onWindowsDrive(sourceFile) {
+		warnings = append(warnings, "The file is on a Windows drive mounted into WSL, where file access is slow; "+
+			"copy it into the Linux file system (e.g. your home directory) for representative numbers.")
+	}
+	if churn != "" {
+		warnings = append(warnings, churn)
+	}
+	if changed := sourceChangeWarning(sourceFile, sourceSum); changed != "" {
+		warnings = append(warnings, changed)
+	}
+	assertions := evaluateAssertions(opts.Assertions, stats, times)
+	chosen := opts.Stats
+	if chosen == nil {
+		chosen = defaultBenchStats
+	}
+
+	if opts.JSON {
+		fmt.Fprintln(out)
+		report := benchReport{
+			File:        sourceFile,
+			Language:    config.Name,
+			Runs:        runs,
+			Warmup:      opts.Warmup,
+			Failed:      failed,
+			Environment: env,
+			Warnings:    warnings,
+			Assertions:  assertions,
+
+			reportedStats: reportStats(chosen, stats, times),
+
+			ArtifactBytes: max(artifactBytes, 0),
+			Invalid:       invalid,
+			SourceSHA256:  sourceSum,
+			Daemon:        daemonNote,
+			RunProfile:    recordedPhases(),
+			KeptArtifact:  keptArtifact,
+
+			CompileCommand: plan.Compile,
+		}
+		if verifier != nil {
+			report.VerifiedWith = verifier.source
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return assertionError(assertions)
+	}
+
+	// Print results
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("  Benchmark Results:")
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Printf("Runs:         %d\n", runs)
+	if failed > 0 {
+		fmt.Printf("Failed:       %d\n", failed)
+	}
+	if verifier != nil {
+		fmt.Printf("Invalid:      %d (output differed from %s; not in the statistics)\n", invalid, verifier.source)
+	}
+	for _, stat := range chosen {
+		value, note := statValue(stat, stats, times)
+		fmt.Printf("%-14s%8s", statLabel(stat), formatDuration(value))
+		if note != "" {
+			fmt.Printf("  [%s]", note)
+		}
+		fmt.Println()
+	}
+	if artifactBytes >= 0 {
+		fmt.Printf("Binary size:  %s\n", formatSize(artifactBytes))
+	}
+	fmt.Printf("Source:       sha256 %s\n", sourceSum)
+	if daemonNote != "" {
+		fmt.Printf("Daemon:       %s\n", daemonNote)
+	}
+	fmt.Println(strings.Repeat("-", 50))
+	printEnvironment(env)
+	fmt.Println(strings.Repeat("=", 50))
+	for _, w := range warnings {
+		fmt.Printf("⚠  %s\n", w)
+	}
+
+	if len(assertions) > 0 {
+		fmt.Println("\nAssertions:")
+		for _, a := range assertions {
+			max, actual := time.Duration(a.MaxNs), time.Duration(a.ActualNs)
+			if a.Passed {
+				fmt.Printf("  ✓ %-7s %8s <= %s", a.Stat, formatDuration(actual), formatDuration(max))
+			} else {
+				fmt.Printf("  ✗ %-7s %8s > %s (+%s)", a.Stat, formatDuration(actual), formatDuration(max), formatDuration(actual-max))
+			}
+			if a.Note != "" {
+				fmt.Printf("  [%s]", a.Note)
+			}
+			fmt.Println()
+		}
+	}
+	return assertionError(assertions)
+}
+
+// parseAssertion parses the flag name and value of --assert-max-<stat> <duration>.
+func parseAssertion(flag, value string) (benchAssertion, error) {
+	stat := strings.TrimPrefix(flag, "--assert-max-")
+	known := false
+	for _, s := range assertableStats {
+		known = known || s == stat
+	}
+	if !known {
+		return benchAssertion{}, fmt.Errorf("unknown statistic %q in %s (available: %s)",
+			stat, flag, strings.Join(assertableStats, ", "))
+	}
+	max, err := time.ParseDuration(value)
+	if err != nil {
+		return benchAssertion{}, fmt.Errorf("invalid duration for %s: %v", flag, err)
+	}
+	return benchAssertion{Stat: stat, Max: max}, nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted times.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(0, min(rank, len(sorted))-1)]
+}
+
+// percentileNote explains when there are too few runs for percentile p to
+// be more than the maximum in disguise.
+func percentileNote(p float64, runs int) string {
+	needed := int(math.Ceil(100 / (100 - p)))
+	if runs < needed {
+		return fmt.Sprintf("low confidence: p%g needs at least %d runs", p, needed)
+	}
+	return ""
+}
+
+// evaluateAssertions checks each assertion against the computed statistics.
+// sorted must be the sorted iteration times.
+func evaluateAssertions(assertions []benchAssertion, stats benchStats, sorted []time.Duration) []assertionResult {
+	results := make([]assertionResult, 0, len(assertions))
+	for _, a := range assertions {
+		actual, note := statValue(a.Stat, stats, sorted)
+		results = append(results, assertionResult{
+			Stat:     a.Stat,
+			MaxNs:    int64(a.Max),
+			ActualNs: int64(actual),
+			Passed:   actual <= a.Max,
+			Note:     note,
+		})
+	}
+	return results
+}
+
+// assertionError returns the error for the failed assertions, if any.
+func assertionError(results []assertionResult) error {
+	var failed []string
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, r.Stat)
+		}
+	}
+	if len(failed) > 0 {
+		// Printed here, as stdout may carry the JSON report
+		fmt.Fprintf(os.Stderr, "Benchmark assertions failed: %s\n", strings.Join(failed, ", "))
+		return &runError{Status: "assertion-failed", Code: exitBenchFailed}
+	}
+	return nil
+}
+
+// computeStats sorts times in place and summarizes them.
+func computeStats(times []time.Duration) benchStats {
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	var stats benchStats
+	for _, t := range times {
+		stats.Total += t
+	}
+	stats.Min = times[0]
+	stats.Max = times[len(times)-1]
+	stats.Mean = stats.Total / time.Duration(len(times))
+	stats.Median = times[len(times)/2]
+
+	var sumSquaredDiffs float64
+	for _, t := range times {
+		diff := float64(t - stats.Mean)
+		sumSquaredDiffs += diff * diff
+	}
+
+	// Standard deviation is the square root of variance
+	stats.StdDev = time.Duration(math.Sqrt(sumSquaredDiffs / float64(len(times))))
+	return stats
+}
+
+// gatherEnvironment collects the benchmark metadata. Nothing here may fail
+// the benchmark, so every probe silently gives up on error.
+func gatherEnvironment(config LanguageConfig, ext string) benchEnvironment {
+	env := benchEnvironment{
+		OS:    runtime.GOOS,
+		Arch:  runtime.GOARCH,
+		WSL:   wslDistro(),
+		Cores: runtime.NumCPU(),
+		Build: "n/a (interpreted)",
+	}
+	if config.IsCompiled {
+		env.Build = "debug (no optimization flags)"
+	}
+
+	env.RuntimeVersion = runtimeVersion(config.CheckCmd)
+	env.CPUModel = cpuModel()
+
+	if runtime.GOOS == "linux" {
+		env.Governor = readTrimmed("/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor")
+		if profile := readTrimmed("/sys/firmware/acpi/platform_profile"); profile != "" {
+			env.PowerState = profile
+		}
+		if matches, _ := filepath.Glob("/sys/class/power_supply/BAT*/status"); len(matches) > 0 {
+			if status := readTrimmed(matches[0]); status != "" {
+				env.PowerState = strings.TrimSpace(env.PowerState + " " + strings.ToLower(status))
+			}
+		}
+		if fields := strings.Fields(readTrimmed("/proc/loadavg")); len(fields) > 0 {
+			env.LoadAverage = fields[0]
+		}
+	}
+	return env
+}
+
+// runtimeVersion returns the first line printed by the language's check
+// command, which is its version banner for nearly every toolchain.
+func runtimeVersion(checkCmd []string) string {
+	var buf strings.Builder
+	cmd := exec.Command(checkCmd[0], checkCmd[1:]...)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if runCmd(cmd) != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(buf.String()), "\n")
+	return strings.TrimSpace(line)
+}
+
+func cpuModel() string {
+	switch runtime.GOOS {
+	case "linux":
+		data, err := os.ReadFile("/proc/cpuinfo")
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "model name" {
+				return strings.TrimSpace(value)
+			}
+		}
+	case "darwin":
+		out, err := outputCmd(exec.Command("sysctl", "-n", "machdep.cpu.brand_string"))
+		if err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	case "windows":
+		return os.Getenv("PROCESSOR_IDENTIFIER")
+	}
+	return ""
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// noiseWarnings flags conditions that are known to make numbers unreliable.
+func noiseWarnings(env benchEnvironment, ext string, opts benchOptions) []string {
+	var warnings []string
+	if strings.Contains(env.PowerState, "discharging") || strings.Contains(env.PowerState, "low-power") ||
+		env.Governor == "powersave" {
+		warnings = append(warnings, "Running on battery or in power-saving mode; results may be throttled.")
+	}
+	if jitExts[ext] && opts.Warmup < 2 {
+		warnings = append(warnings, fmt.Sprintf("%s uses a JIT; use --warmup 2 or more for stable numbers.", ext))
+	}
+	if load, err := strconv.ParseFloat(env.LoadAverage, 64); err == nil && load > float64(env.Cores) {
+		warnings = append(warnings, fmt.Sprintf("Load average %.2f exceeds the %d available cores; other processes are competing for CPU.", load, env.Cores))
+	}
+	return warnings
+}
+
+func printEnvironment(env benchEnvironment) {
+	fmt.Printf("Platform:     %s/%s, %d cores", env.OS, env.Arch, env.Cores)
+	if env.WSL != "" {
+		fmt.Printf(" (WSL: %s)", env.WSL)
+	}
+	fmt.Println()
+	if env.CPUModel != "" {
+		fmt.Printf("CPU:          %s\n", env.CPUModel)
+	}
+	if env.RuntimeVersion != "" {
+		fmt.Printf("Toolchain:    %s\n", env.RuntimeVersion)
+	}
+	fmt.Printf("Build:        %s\n", env.Build)
+	if env.Governor != "" {
+		fmt.Printf("Governor:     %s\n", env.Governor)
+	}
+	if env.PowerState != "" {
+		fmt.Printf("Power:        %s\n", env.PowerState)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestBenchChurn checks that files a benchmarked program writes where it
+// runs, next to its source when run from elsewhere, are noticed.
+func TestBenchChurn(t *testing.T) {
+	requireTools(t, "python3")
+	env := newRunEnv(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "app/cache.py", "open(\"cache.txt\", \"w\").write(\"warm\")\n")
+
+	res := env.run(t, dir, "--bench", "3", "app/cache.py")
+	if res.Code != 0 {
+		t.Fatalf("exit code %d\n%s%s", res.Code, res.Stdout, res.Stderr)
+	}
+	if out := res.Stdout + res.Stderr; !strings.Contains(out, "app went from") {
+		t.Errorf("the file written next to the source went unnoticed:\n%s", out)
+	}
+
+	res = env.run(t, dir, "--bench", "3", "--bench-isolate", "app/cache.py")
+	if out := res.Stdout + res.Stderr; strings.Contains(out, "entries during the benchmark") {
+		t.Errorf("isolated iterations reported churn:\n%s", out)
+	}
+}
+
+// TestBenchCSharpKeepsDirectory benchmarks a C# file, one that builds and
+// one that doesn't, and checks that run's own working directory is where
+// it was afterwards.
+func TestBenchCSharpKeepsDirectory(t *testing.T) {
+	requireTools(t, "dotnet")
+	tests := []struct {
+		name, source string
+	}{
+		{"builds", "System.Console.WriteLine(\"hi\");\n"},
+		{"fails to build", "System.Console.WriteLine(undeclared);\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeFile(t, t.TempDir(), "Program.cs", tt.source)
+			before, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			performBenchmark(file, languageConfigs[".cs"], ".cs", benchOptions{Runs: 1})
+			after, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after != before {
+				os.Chdir(before)
+				t.Errorf("benchmarking moved run from %s to %s", before, after)
+			}
+		})
+	}
+}
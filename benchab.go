@@ -0,0 +1,421 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// abAlpha is the significance level of the A/B comparison.
+const abAlpha = 0.05
+
+// abVariant is one side of an A/B benchmark, compiled and ready to run.
+type abVariant struct {
+	File  string
+	Label string // "A" or "B"
+	Name  string // Language name
+	plan  execPlan
+	times []time.Duration
+	stats benchStats // Of times, once they are sorted
+
+	removeCopy func() // Removes the source copy made by shebangSafePlan
+}
+
+// abVariantReport summarizes one variant; durations are nanoseconds.
+type abVariantReport struct {
+	File     string `json:"file"`
+	Language string `json:"language"`
+	reportedStats
+}
+
+// defaultABStats are the statistics `run bench ab` reports without
+// --bench-stats.
+var defaultABStats = []string{"mean", "median", "min", "max", "stddev"}
+
+// abReport is the JSON form of `run bench ab`. DiffNs is B's mean minus A's,
+// so negative values mean B is faster; Speedup is A's mean over B's.
+type abReport struct {
+	A           abVariantReport `json:"a"`
+	B           abVariantReport `json:"b"`
+	Runs        int             `json:"runs"`
+	Warmup      int             `json:"warmup"`
+	Speedup     float64         `json:"speedup"`
+	DiffNs      int64           `json:"diffNs"`
+	CILowNs     int64           `json:"ciLowNs"`
+	CIHighNs    int64           `json:"ciHighNs"`
+	T           float64         `json:"t"`
+	DF          float64         `json:"df"`
+	P           float64         `json:"p"`
+	Alpha       float64         `json:"alpha"`
+	Significant bool            `json:"significant"`
+	// The variants from lowest to highest SortedBy (--sort-by)
+	SortedBy string   `json:"sortedBy,omitempty"`
+	Order    []string `json:"order,omitempty"`
+}
+
+// benchABCommand implements `run bench ab <a> <b> [--runs n] [--warmup n]
+// [--bench-stats list] [--sort-by stat] [--json]`. It interleaves the
+// iterations of both variants, so drift in machine load affects them alike,
+// and tests the difference with Welch's t-test.
+func benchABCommand(args []string) error {
+	runs, warmup, asJSON := 20, 1, false
+	chosen, sortBy := defaultABStats, ""
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--runs" || arg == "--warmup":
+			if i+1 >= len(args) || !isNumeric(args[i+1]) {
+				return usageError("%s requires a number of iterations", arg)
+			}
+			n := &runs
+			if arg == "--warmup" {
+				n = &warmup
+			}
+			fmt.Sscanf(args[i+1], "%d", n)
+			i++
+		case arg == "--json":
+			asJSON = true
+			jsonReport = true
+		case arg == "--bench-stats":
+			if i+1 >= len(args) {
+				return usageError("Missing value for --bench-stats (e.g. mean,median,p95)")
+			}
+			stats, err := parseBenchStats(args[i+1])
+			if err != nil {
+				return usageError("%v", err)
+			}
+			chosen = stats
+			i++
+		case arg == "--sort-by":
+			if i+1 >= len(args) {
+				return usageError("Missing value for --sort-by (e.g. median, mean or p99)")
+			}
+			stat, err := parseBenchStat(args[i+1])
+			if err != nil {
+				return usageError("--sort-by: %v", err)
+			}
+			sortBy = stat
+			i++
+		case strings.HasPrefix(arg, "-"):
+			return usageError("Unknown bench ab option: %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 2 {
+		return usageError("Usage: run bench ab <a> <b> [--runs n] [--warmup n] [--bench-stats list] [--sort-by stat] [--json]")
+	}
+	if runs < 2 {
+		return usageError("bench ab needs at least 2 runs per variant.")
+	}
+
+	// Progress goes to stderr when stdout carries the JSON report
+	var out io.Writer = os.Stdout
+	if asJSON {
+		out = os.Stderr
+	}
+
+	variants := make([]*abVariant, 2)
+	for i, file := range files {
+		v, err := prepareVariant(file, string(rune('A'+i)), out)
+		if v != nil {
+			defer v.removeCopy()
+			defer v.plan.cleanup()
+		}
+		if err != nil {
+			return err
+		}
+		variants[i] = v
+	}
+	a, b := variants[0], variants[1]
+
+	fmt.Fprintf(out, "🔥  A/B benchmark: %s vs %s, %d interleaved runs each...\n", a.File, b.File, runs)
+	for i := 0; i < warmup+runs; i++ {
+		for _, v := range variants {
+			elapsed, err := v.runOnce()
+			if err != nil {
+				code := 1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					code = exitCode(exitErr.ProcessState)
+				}
+				return newRunError("program-failed", code, "%s (%s) failed on iteration %d: %v", v.Label, v.File, i+1, err)
+			}
+			if i >= warmup {
+				v.times = append(v.times, elapsed)
+			}
+		}
+		fmt.Fprintf(out, "\r  %d/%d", i+1, warmup+runs)
+	}
+	fmt.Fprintln(out)
+
+	for _, v := range variants {
+		v.stats = computeStats(v.times)
+	}
+	report := compareVariants(a, b, chosen)
+	report.Runs, report.Warmup = runs, warmup
+	if sortBy != "" {
+		variants = sortVariants(variants, sortBy)
+		report.SortedBy = sortBy
+		for _, v := range variants {
+			report.Order = append(report.Order, v.Label)
+		}
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printABReport(report, variants, chosen)
+	return nil
+}
+
+// sortVariants returns the variants from lowest to highest stat; variants
+// that tie keep their order.
+func sortVariants(variants []*abVariant, stat string) []*abVariant {
+	sorted := slices.Clone(variants)
+	slices.SortStableFunc(sorted, func(x, y *abVariant) int {
+		vx, _ := statValue(stat, x.stats, x.times)
+		vy, _ := statValue(stat, y.stats, y.times)
+		return cmp.Compare(vx, vy)
+	})
+	return sorted
+}
+
+// prepareVariant detects the language of file and compiles it if needed.
+// The returned variant, if any, must be cleaned up even on error.
+func prepareVariant(file, label string, out io.Writer) (*abVariant, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, newRunError("file-not-found", exitNoInput, "File not found: %s", file)
+	}
+	ext, ok := detectExt(file)
+	config, supported := languageConfigs[ext]
+	if !ok || !supported {
+		return nil, newRunError("unsupported-language", exitUnsupported, "Unsupported file type: %s", file)
+	}
+	if !checkRuntime(config.CheckCmd) {
+		return nil, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+			"Runtime '%s' for %s not found; run the file once to install it.", config.CheckCmd[0], file)
+	}
+
+	plan, removeCopy, err := shebangSafePlan(file, config, ext)
+	if err != nil {
+		return nil, newRunError("compile-failed", exitCompileFailed, "Cannot strip the #! line of %s: %v", file, err)
+	}
+	v := &abVariant{File: file, Label: label, Name: config.Name, plan: plan, removeCopy: removeCopy}
+	if err := v.plan.makeBuildDir(); err != nil {
+		return v, newRunError("compile-failed", exitCompileFailed, "Cannot create the build directory: %v", err)
+	}
+	if v.plan.Prepare != nil {
+		step := startStep("preparing " + file)
+		err := v.plan.Prepare(step)
+		step.Finish(err)
+		if err != nil {
+			return v, newRunError("compile-failed", exitCompileFailed, "Preparation of %s failed: %v", file, err)
+		}
+	}
+	if v.plan.Compile != nil {
+		fmt.Fprintf(out, "Compiling %s...\n", file)
+		cmd := v.plan.command(context.Background(), v.plan.Compile)
+		step := startStep("compiling " + file)
+		cmd.Stdout = step
+		cmd.Stderr = step
+		err := runCmd(cmd)
+		step.Finish(err)
+		if err != nil {
+			return v, newRunError("compile-failed", exitCompileFailed, "Compilation of %s failed: %v", file, err)
+		}
+	}
+	return v, nil
+}
+
+// runOnce runs the variant with its output discarded and times it.
+func (v *abVariant) runOnce() (time.Duration, error) {
+	cmd := v.plan.command(context.Background(), v.plan.Run)
+	start := time.Now()
+	err := runCmd(cmd)
+	return time.Since(start), err
+}
+
+// compareVariants reports the chosen statistics of both variants and
+// Welch's t-test on their means. The variants' times must be sorted.
+func compareVariants(a, b *abVariant, chosen []string) abReport {
+	report := abReport{A: variantReport(a, chosen), B: variantReport(b, chosen), Alpha: abAlpha}
+
+	meanA, varA := sampleMoments(a.times)
+	meanB, varB := sampleMoments(b.times)
+	nA, nB := float64(len(a.times)), float64(len(b.times))
+	se2A, se2B := varA/nA, varB/nB
+	se := math.Sqrt(se2A + se2B)
+
+	diff := meanB - meanA
+	report.DiffNs = int64(diff)
+	report.Speedup = meanA / meanB
+	if se == 0 {
+		// Identical, constant samples: nothing to test
+		report.P = 1
+		report.DF = nA + nB - 2
+		report.CILowNs, report.CIHighNs = report.DiffNs, report.DiffNs
+		report.Significant = diff != 0
+		if report.Significant {
+			report.P = 0
+		}
+		return report
+	}
+	// Welch–Satterthwaite degrees of freedom
+	df := (se2A + se2B) * (se2A + se2B) / (se2A*se2A/(nA-1) + se2B*se2B/(nB-1))
+	t := diff / se
+	report.T, report.DF = t, df
+	report.P = 2 * studentTTail(math.Abs(t), df)
+	margin := studentTQuantile(1-abAlpha/2, df) * se
+	report.CILowNs, report.CIHighNs = int64(diff-margin), int64(diff+margin)
+	report.Significant = report.P < abAlpha
+	return report
+}
+
+func variantReport(v *abVariant, chosen []string) abVariantReport {
+	return abVariantReport{
+		File:          v.File,
+		Language:      v.Name,
+		reportedStats: reportStats(chosen, v.stats, v.times),
+	}
+}
+
+// sampleMoments returns the mean and the unbiased sample variance, in
+// nanoseconds and nanoseconds squared.
+func sampleMoments(times []time.Duration) (mean, variance float64) {
+	for _, t := range times {
+		mean += float64(t)
+	}
+	mean /= float64(len(times))
+	for _, t := range times {
+		d := float64(t) - mean
+		variance += d * d
+	}
+	return mean, variance / float64(len(times)-1)
+}
+
+// studentTTail is the probability that a Student's t variable with df
+// degrees of freedom exceeds t (t >= 0).
+func studentTTail(t, df float64) float64 {
+	return 0.5 * regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// studentTQuantile returns the t with P(T <= t) = p for p > 0.5, by bisection.
+func studentTQuantile(p, df float64) float64 {
+	lo, hi := 0.0, 1000.0
+	for range 100 {
+		mid := (lo + hi) / 2
+		if 1-studentTTail(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// regIncBeta is the regularized incomplete beta function I_x(a, b),
+// evaluated with its continued fraction.
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The fraction converges quickly only below the mean; use symmetry above it
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(b, a, 1-x)/b
+	}
+	return front * betaFraction(a, b, x) / a
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with the modified Lentz method.
+func betaFraction(a, b, x float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1.0; m <= 300; m++ {
+		for _, num := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < 1e-12 {
+			break
+		}
+	}
+	return h
+}
+
+// printABReport prints the report with a column per variant, in the order
+// of variants.
+func printABReport(r abReport, variants []*abVariant, chosen []string) {
+	ns := func(v int64) string { return formatDuration(time.Duration(v)) }
+	fmt.Println(strings.Repeat("=", 50))
+	fmt.Println("  A/B Benchmark Results:")
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Printf("A: %s (%s)\n", r.A.File, r.A.Language)
+	fmt.Printf("B: %s (%s)\n", r.B.File, r.B.Language)
+	fmt.Printf("Runs:         %d each, interleaved (%d warmup)\n", r.Runs, r.Warmup)
+	if r.SortedBy != "" {
+		fmt.Printf("Sorted by:    %s, lowest first\n", r.SortedBy)
+	}
+	first, second := variants[0], variants[1]
+	fmt.Printf("%-13s %8s %10s\n", "", first.Label, second.Label)
+	for _, stat := range chosen {
+		v1, note := statValue(stat, first.stats, first.times)
+		v2, _ := statValue(stat, second.stats, second.times)
+		fmt.Printf("%-13s %8s %10s", statLabel(stat), formatDuration(v1), formatDuration(v2))
+		if note != "" {
+			fmt.Printf("  [%s]", note)
+		}
+		fmt.Println()
+	}
+	fmt.Println(strings.Repeat("-", 50))
+
+	switch {
+	case r.Speedup >= 1:
+		fmt.Printf("B is %.2fx faster than A\n", r.Speedup)
+	default:
+		fmt.Printf("B is %.2fx slower than A\n", 1/r.Speedup)
+	}
+	fmt.Printf("Difference:   %s (%.0f%% confidence interval %s to %s)\n",
+		ns(r.DiffNs), (1-r.Alpha)*100, ns(r.CILowNs), ns(r.CIHighNs))
+	fmt.Printf("Welch's t:    t = %.2f, df = %.1f, p = %.4f\n", r.T, r.DF, r.P)
+	if r.Significant {
+		fmt.Printf("✓ The difference is significant (p < %.2f)\n", r.Alpha)
+	} else {
+		fmt.Printf("✗ No significant difference (p >= %.2f); try more runs\n", r.Alpha)
+	}
+	fmt.Println(strings.Repeat("=", 50))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotSource copies sourceFile for a benchmark of an interpreted
+// language, whose iterations would otherwise each read the file anew and
+// measure a mix of versions if it is saved mid-run. The copy sits next to
+// the original, so that imports relative to it still resolve, and is
+// removed by the returned function.
+func snapshotSource(sourceFile string) (string, func(), error) {
+	content, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return "", nil, err
+	}
+	base := filepath.Base(sourceFile)
+	ext := filepath.Ext(base)
+	name := fmt.Sprintf("%s.run-bench-%d%s", strings.TrimSuffix(base, ext), os.Getpid(), ext)
+	snapshot := filepath.Join(filepath.Dir(sourceFile), name)
+	if err := os.WriteFile(snapshot, content, 0o600); err != nil {
+		return "", nil, err
+	}
+	logEvent("snapshot", map[string]any{"file": sourceFile, "copy": snapshot})
+	return snapshot, func() { os.Remove(snapshot) }, nil
+}
+
+// sourceChangeWarning says how sourceFile differs from the version with
+// digest sum that was benchmarked, or returns "" when it doesn't.
+func sourceChangeWarning(sourceFile, sum string) string {
+	now, err := fileSHA256(sourceFile)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Sprintf("%s was deleted during the benchmark; every iteration ran the version from the start (sha256 %s).", sourceFile, sum[:12])
+	case err == nil && now != sum:
+		return fmt.Sprintf("%s changed during the benchmark; every iteration ran the version from the start (sha256 %s), not the current one.", sourceFile, sum[:12])
+	}
+	return ""
+}
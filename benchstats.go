@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultBenchStats are the statistics a benchmark reports without
+// --bench-stats, in the order it prints them.
+var defaultBenchStats = []string{"total", "mean", "median", "min", "max", "stddev"}
+
+// statLabels are the labels of the statistics in text reports; percentiles
+// are labelled P50, P95 and so on.
+var statLabels = map[string]string{
+	"total":  "Total time:",
+	"mean":   "Average:",
+	"median": "Median:",
+	"min":    "Min:",
+	"max":    "Max:",
+	"stddev": "Std Dev:",
+}
+
+// parseBenchStat checks one statistic name: one of statLabels or a
+// percentile pNN with 0 < NN < 100.
+func parseBenchStat(stat string) (string, error) {
+	stat = strings.ToLower(strings.TrimSpace(stat))
+	if _, ok := statLabels[stat]; ok {
+		return stat, nil
+	}
+	if p, err := strconv.ParseFloat(strings.TrimPrefix(stat, "p"), 64); strings.HasPrefix(stat, "p") && err == nil && p > 0 && p < 100 {
+		return stat, nil
+	}
+	return "", fmt.Errorf("unknown statistic %q (available: %s, or a percentile such as p95)",
+		stat, strings.Join(defaultBenchStats, ", "))
+}
+
+// parseBenchStats parses the value of --bench-stats, e.g. mean,median,p95.
+func parseBenchStats(value string) ([]string, error) {
+	var stats []string
+	for _, s := range strings.Split(value, ",") {
+		stat, err := parseBenchStat(s)
+		if err != nil {
+			return nil, fmt.Errorf("--bench-stats: %v", err)
+		}
+		if !slices.Contains(stats, stat) {
+			stats = append(stats, stat)
+		}
+	}
+	return stats, nil
+}
+
+// statLabel returns the text report label of stat.
+func statLabel(stat string) string {
+	if label, ok := statLabels[stat]; ok {
+		return label
+	}
+	return strings.ToUpper(stat) + ":"
+}
+
+// statValue returns stat of the iteration times, which must be sorted, and
+// the percentileNote of a percentile computed from too few of them.
+func statValue(stat string, stats benchStats, sorted []time.Duration) (time.Duration, string) {
+	switch stat {
+	case "total":
+		return stats.Total, ""
+	case "mean":
+		return stats.Mean, ""
+	case "median":
+		return stats.Median, ""
+	case "min":
+		return stats.Min, ""
+	case "max":
+		return stats.Max, ""
+	case "stddev":
+		return stats.StdDev, ""
+	}
+	p, _ := strconv.ParseFloat(strings.TrimPrefix(stat, "p"), 64)
+	return percentile(sorted, p), percentileNote(p, len(sorted))
+}
+
+// reportedStats is the JSON form of the chosen statistics. The fixed ones
+// are pointers so that a statistic left out of --bench-stats is left out of
+// the report too; durations are nanoseconds.
+type reportedStats struct {
+	TotalNs  *int64 `json:"totalNs,omitempty"`
+	MeanNs   *int64 `json:"meanNs,omitempty"`
+	MedianNs *int64 `json:"medianNs,omitempty"`
+	MinNs    *int64 `json:"minNs,omitempty"`
+	MaxNs    *int64 `json:"maxNs,omitempty"`
+	StdDevNs *int64 `json:"stdDevNs,omitempty"`
+	// Percentiles by name, e.g. "p95"
+	PercentilesNs map[string]int64 `json:"percentilesNs,omitempty"`
+	// Why a percentile is low confidence, by name
+	StatNotes map[string]string `json:"statNotes,omitempty"`
+}
+
+// reportStats computes the chosen statistics of the sorted times for a JSON
+// report.
+func reportStats(chosen []string, stats benchStats, sorted []time.Duration) reportedStats {
+	var r reportedStats
+	for _, stat := range chosen {
+		d, note := statValue(stat, stats, sorted)
+		ns := int64(d)
+		switch stat {
+		case "total":
+			r.TotalNs = &ns
+		case "mean":
+			r.MeanNs = &ns
+		case "median":
+			r.MedianNs = &ns
+		case "min":
+			r.MinNs = &ns
+		case "max":
+			r.MaxNs = &ns
+		case "stddev":
+			r.StdDevNs = &ns
+		default:
+			if r.PercentilesNs == nil {
+				r.PercentilesNs = map[string]int64{}
+			}
+			r.PercentilesNs[stat] = ns
+		}
+		if note != "" {
+			if r.StatNotes == nil {
+				r.StatNotes = map[string]string{}
+			}
+			r.StatNotes[stat] = note
+		}
+	}
+	return r
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// maxVerifyCapture is how much of an iteration's output is kept in memory
+// to show where it differs from the expected output. The comparison itself
+// covers the whole output through its digest and length, so a difference
+// past this point is still caught, only reported less precisely.
+const maxVerifyCapture = 1 << 20
+
+// outputCapture records a program's output for --verify-with and
+// --verify-first: its SHA-256, its length and its first maxVerifyCapture
+// bytes.
+type outputCapture struct {
+	head bytes.Buffer
+	sum  hash.Hash
+	n    int64
+}
+
+func newOutputCapture() *outputCapture {
+	return &outputCapture{sum: sha256.New()}
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	if room := maxVerifyCapture - c.head.Len(); room > 0 {
+		c.head.Write(p[:min(len(p), room)])
+	}
+	c.sum.Write(p)
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// outputVerifier compares the output of benchmark iterations with the
+// expected output: a file's content or the first measured iteration's.
+type outputVerifier struct {
+	expected *outputCapture
+	source   string // Where the expected output came from, for reports
+}
+
+// newOutputVerifier returns the verifier for opts, or nil when the output is
+// not verified.
+func newOutputVerifier(opts benchOptions) (*outputVerifier, error) {
+	switch {
+	case opts.VerifyWith != "":
+		f, err := os.Open(opts.VerifyWith)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		expected := newOutputCapture()
+		if _, err := io.Copy(expected, f); err != nil {
+			return nil, err
+		}
+		return &outputVerifier{expected: expected, source: opts.VerifyWith}, nil
+	case opts.VerifyFirst:
+		return &outputVerifier{source: "the first iteration"}, nil
+	}
+	return nil, nil
+}
+
+// verify reports whether got is the expected output and, if not, how it
+// differs. With --verify-first the first output it sees becomes the
+// expected one.
+func (v *outputVerifier) verify(got *outputCapture) (bool, string) {
+	if v.expected == nil {
+		v.expected = got
+		return true, ""
+	}
+	want := v.expected
+	if got.n == want.n && bytes.Equal(got.sum.Sum(nil), want.sum.Sum(nil)) {
+		return true, ""
+	}
+	a, b := got.head.Bytes(), want.head.Bytes()
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) && i == len(b) {
+		// The difference lies past what was kept
+		return false, fmt.Sprintf("differs after the first %s", formatSize(int64(i)))
+	}
+	line := bytes.Count(b[:i], []byte("\n")) + 1
+	if got.n != want.n {
+		return false, fmt.Sprintf("%d bytes instead of %d, first difference on line %d", got.n, want.n, line)
+	}
+	return false, fmt.Sprintf("first difference on line %d", line)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// largeSourceSize is the size above which a source file is probably not the
+// file meant to be run; maxSourceSize in a config file changes it.
+var largeSourceSize int64 = 4 << 20
+
+// binaryMagic are the headers of executables that end up passed to run.
+var binaryMagic = []struct {
+	Magic []byte
+	Kind  string
+}{
+	{[]byte("\x7fELF"), "an ELF executable"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "a Mach-O executable"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "a Mach-O executable"},
+	{[]byte{0xca, 0xfe, 0xba, 0xbe}, "a universal binary or Java class"},
+	{[]byte("MZ"), "a Windows executable"},
+}
+
+// binaryKind describes what path is when it looks like a binary file rather
+// than source code: an executable format or, failing that, any file with NUL
+// bytes near its start. It returns "" for text.
+func binaryKind(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head, _ := io.ReadAll(io.LimitReader(f, 8<<10))
+	for _, m := range binaryMagic {
+		if bytes.HasPrefix(head, m.Magic) {
+			return m.Kind
+		}
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binary data"
+	}
+	return ""
+}
+
+// checkSourceFile refuses binary files, which an interpreter would only turn
+// into garbage, unless --force is given, and warns about unusually large
+// sources. It applies whether the language comes from the extension or not.
+func checkSourceFile(path string) error {
+	if !force {
+		if kind := binaryKind(path); kind != "" {
+			return newRunError("binary-file", exitUnsupported,
+				"%s looks like a binary file (%s), not source code. Use --force to run it anyway.", path, kind)
+		}
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > largeSourceSize {
+		warnf("%s is %.1f MB, unusually large for source code. Is it the right file?",
+			path, float64(info.Size())/(1<<20))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// noBuildCache compiles even when the build is cached (--no-cache). The new
+// build replaces the cached one.
+var noBuildCache bool
+
+// buildCacheDir returns where finished build directories are kept, one per
+// source content and compile command. Each entry is a directory named by
+// its key, holding the build directory as build/ and a cacheEntry as
+// entry.json, whose modification time is when the build was last used.
+func buildCacheDir() string {
+	return filepath.Join(cacheDir(), "builds")
+}
+
+// cacheEntry describes a cached build for `run cache list`. Fields are only
+// ever added, so that list can read the entries of other versions of run.
+type cacheEntry struct {
+	Source   string    `json:"source"`
+	Language string    `json:"language"` // Extension, e.g. .rs
+	Compile  []string  `json:"compile,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// buildCacheKey identifies the build of the plan: the content of the source
+// and of its --sources, the local headers they include, the compile command, with the paths that change
+// from run to run left out, and the compiler binary, so that upgrading it
+// builds again. It returns "" for builds that can't be reused, such as C#'s,
+// whose .NET project records where it was built, or one written to --out.
+func (p execPlan) buildCacheKey() string {
+	if p.Compile == nil || p.Prepare != nil || p.BuildDir == "" || outPath != "" {
+		return ""
+	}
+	content, err := os.ReadFile(p.SourceFile)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(content)
+	seen := map[string]bool{}
+	hashIncludes(h, p.SourceFile, content, seen)
+	for _, unit := range p.Sources {
+		content, err := os.ReadFile(unit)
+		if err != nil {
+			return ""
+		}
+		h.Write([]byte("\x00" + unit + "\x00"))
+		h.Write(content)
+		hashIncludes(h, unit, content, seen)
+	}
+	for _, arg := range p.Compile {
+		arg = strings.ReplaceAll(arg, p.BuildDir, "{tmpdir}")
+		arg = strings.ReplaceAll(arg, p.SourceFile, "{file}")
+		h.Write([]byte("\x00" + arg))
+	}
+	compiler, err := compilerIdentity(p.Compile[0])
+	if err != nil {
+		return ""
+	}
+	h.Write([]byte("\x00" + compiler))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// compilerIdentity tells a compiler binary apart from other versions of it
+// by its resolved path, size and modification time, which is much cheaper
+// than asking it for its version on every run.
+func compilerIdentity(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", path, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// localInclude matches a C or C++ #include of a header next to the source,
+// as opposed to a system header in <>.
+var localInclude = regexp.MustCompile(`(?m)^\s*#\s*include\s*"([^"]+)"`)
+
+// hashIncludes adds the headers that content, the source at path, includes
+// with "" to h, and the ones they include in turn, so that editing a header
+// builds again. Headers that can't be read are left to the compiler.
+func hashIncludes(h hash.Hash, path string, content []byte, seen map[string]bool) {
+	for _, m := range localInclude.FindAllSubmatch(content, -1) {
+		header := filepath.Join(filepath.Dir(path), string(m[1]))
+		if seen[header] {
+			continue
+		}
+		seen[header] = true
+		included, err := os.ReadFile(header)
+		if err != nil {
+			continue
+		}
+		h.Write([]byte("\x00" + string(m[1]) + "\x00"))
+		h.Write(included)
+		hashIncludes(h, header, included, seen)
+	}
+}
+
+// lockBuild takes the lock of the build of key, so that a concurrent run
+// of the same source waits for this one to compile and save it and then
+// reuses it, instead of compiling alongside. The returned function releases
+// the lock. Without the lock, e.g. after waiting too long, the build just
+// goes ahead.
+func lockBuild(key, sourceFile string) (release func()) {
+	if err := os.MkdirAll(buildCacheDir(), 0o700); err != nil {
+		return func() {}
+	}
+	release, err := acquireLock(filepath.Join(buildCacheDir(), key+".lock"), "building "+sourceFile)
+	if err != nil {
+		warnf("%v", err)
+		return func() {}
+	}
+	return release
+}
+
+// restoreBuild fills the plan's build directory from the cache, reporting
+// whether there was a build to restore.
+func (p execPlan) restoreBuild(key string) bool {
+	entry := filepath.Join(buildCacheDir(), key)
+	if _, err := os.Stat(filepath.Join(entry, "build")); err != nil {
+		return false
+	}
+	if err := copyTree(filepath.Join(entry, "build"), p.BuildDir); err != nil {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(filepath.Join(entry, "entry.json"), now, now)
+	logEvent("build-cache", map[string]any{"key": key, "hit": true})
+	return true
+}
+
+// saveBuild copies the plan's finished build directory into the cache,
+// replacing an older build of the same key, e.g. after --no-cache. source
+// is the file as given and ext its language. Failing to is harmless: the
+// next run compiles again.
+func (p execPlan) saveBuild(key, source, ext string) {
+	entry := filepath.Join(buildCacheDir(), key)
+	if err := os.MkdirAll(buildCacheDir(), 0o700); err != nil {
+		return
+	}
+	// Built aside and renamed, so a concurrent run never sees half an entry
+	tmp, err := os.MkdirTemp(buildCacheDir(), key+".tmp-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	data, _ := json.MarshalIndent(cacheEntry{Source: source, Language: ext, Compile: p.Compile, Created: time.Now().UTC()}, "", "  ")
+	if err := copyTree(p.BuildDir, filepath.Join(tmp, "build")); err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "entry.json"), append(data, '\n'), 0o600); err != nil {
+		return
+	}
+	if os.Rename(tmp, entry) != nil {
+		os.RemoveAll(entry)
+		if os.Rename(tmp, entry) != nil {
+			return
+		}
+	}
+	logEvent("build-cache", map[string]any{"key": key, "hit": false})
+}
+
+// copyTree copies the files under src into dst, keeping their permissions,
+// e.g. an executable's.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
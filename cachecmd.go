@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachedBuild is an entry of the build cache as `run cache` sees it.
+type cachedBuild struct {
+	Key      string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+	cacheEntry
+}
+
+// cachedBuilds returns the entries of the build cache, most recently used
+// first. Entries without a readable entry.json, e.g. from an older run,
+// have an empty Source.
+func cachedBuilds() []cachedBuild {
+	dirs, _ := os.ReadDir(buildCacheDir())
+	var builds []cachedBuild
+	for _, d := range dirs {
+		if !d.IsDir() || strings.Contains(d.Name(), ".tmp-") {
+			continue
+		}
+		b := cachedBuild{Key: d.Name(), Path: filepath.Join(buildCacheDir(), d.Name())}
+		b.Size = max(dirSize(b.Path), 0)
+		meta := filepath.Join(b.Path, "entry.json")
+		if info, err := os.Stat(meta); err == nil {
+			b.LastUsed = info.ModTime()
+			if data, err := os.ReadFile(meta); err == nil {
+				json.Unmarshal(data, &b.cacheEntry)
+			}
+		} else if info, err := d.Info(); err == nil {
+			b.LastUsed = info.ModTime()
+		}
+		builds = append(builds, b)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].LastUsed.After(builds[j].LastUsed) })
+	return builds
+}
+
+// parseAge parses an age such as 30d, 2w or 12h: a Go duration, or a whole
+// number of days or weeks.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			if days, err := strconv.Atoi(n); err == nil && days >= 0 {
+				return time.Duration(days) * unit, nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (e.g. 30d, 2w or 12h)", s)
+	}
+	return d, nil
+}
+
+// cacheCommand implements `run cache list|clear|prune --older-than <age>`
+// for the build cache.
+func cacheCommand(args []string) {
+	usage := func() {
+		fmt.Println("Usage: run cache list | clear | prune --older-than <age, e.g. 30d> [--dry-run]")
+		os.Exit(exitUsage)
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			usage()
+		}
+		builds := cachedBuilds()
+		if len(builds) == 0 {
+			fmt.Printf("The build cache is empty (%s).\n", buildCacheDir())
+			return
+		}
+		var total int64
+		fmt.Printf("%-8s %-10s %-15s %s\n", "Language", "Size", "Last used", "Source")
+		fmt.Println(strings.Repeat("-", 70))
+		for _, b := range builds {
+			total += b.Size
+			language, source := b.Language, b.Source
+			if source == "" {
+				language, source = "?", "(unknown, from an older run: "+b.Key+")"
+			}
+			fmt.Printf("%-8s %-10s %-15s %s\n", language, formatSize(b.Size), ago(b.LastUsed), source)
+		}
+		fmt.Printf("\n%d builds, %s in %s\n", len(builds), formatSize(total), buildCacheDir())
+	case "clear":
+		if len(args) > 1 {
+			usage()
+		}
+		builds := cachedBuilds()
+		var total int64
+		for _, b := range builds {
+			total += b.Size
+		}
+		if err := os.RemoveAll(buildCacheDir()); err != nil {
+			fmt.Printf("Cannot remove %s: %v\n", buildCacheDir(), err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d builds (%s)\n", len(builds), formatSize(total))
+	case "prune":
+		var age time.Duration
+		var ageText string
+		var dryRun bool
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--older-than" && i+1 < len(args):
+				d, err := parseAge(args[i+1])
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(exitUsage)
+				}
+				age, ageText = d, args[i+1]
+				i++
+			case args[i] == "--dry-run":
+				dryRun = true
+			default:
+				usage()
+			}
+		}
+		if ageText == "" {
+			usage()
+		}
+		cutoff := time.Now().Add(-age)
+		var count int
+		var total int64
+		for _, b := range cachedBuilds() {
+			if b.LastUsed.After(cutoff) {
+				continue
+			}
+			if !dryRun {
+				if err := os.RemoveAll(b.Path); err != nil {
+					warnf("Cannot remove %s: %v", b.Path, err)
+					continue
+				}
+			}
+			count++
+			total += b.Size
+		}
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d builds (%s) not used in %s\n", verb, count, formatSize(total), ageText)
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// projectConfigName is the per-project config file, looked up from the
+// source file's directory upwards.
+const projectConfigName = ".run.json"
+
+// runConfig is the contents of a config file. Both the global and the project
+// config use it; `//` comments are allowed.
+type runConfig struct {
+	Defaults  []string                    `json:"defaults,omitempty"`  // Like RUN_DEFAULT_FLAGS; global config only
+	Languages map[string]languageOverride `json:"languages,omitempty"` // Keyed by extension
+	// MaxSourceSize is the size in bytes above which a source file draws a warning
+	MaxSourceSize int64 `json:"maxSourceSize,omitempty"`
+	// PromptTimeout is how many seconds an install prompt waits for an
+	// answer; negative waits indefinitely
+	PromptTimeout int `json:"promptTimeout,omitempty"`
+	// RememberDeclines is how many days a declined runtime installation is
+	// not offered again
+	RememberDeclines int `json:"rememberDeclines,omitempty"`
+	// AuditPatterns are red flags for --audit on top of the built-in ones
+	AuditPatterns []auditPattern `json:"auditPatterns,omitempty"`
+	// Profiles are named sets of flags selected with --profile
+	Profiles map[string]runProfile `json:"profiles,omitempty"`
+	// DockerImages replace fields of the container recipes of run package,
+	// keyed by extension
+	DockerImages map[string]dockerImage `json:"dockerImages,omitempty"`
+}
+
+// languageOverride replaces fields of a built-in language, or defines a new
+// one. Unset fields keep their current value.
+type languageOverride struct {
+	Name    string   `json:"name,omitempty"`
+	Check   []string `json:"check,omitempty"`
+	Install []string `json:"install,omitempty"`
+	Compile []string `json:"compile,omitempty"`
+	Run     []string `json:"run,omitempty"`
+	Repl    []string `json:"repl,omitempty"`
+
+	Format      []string `json:"format,omitempty"`
+	FormatCheck []string `json:"formatCheck,omitempty"`
+}
+
+// configFields are the language fields `run config` reports, in order.
+var configFields = []string{"name", "check", "install", "compile", "run", "repl", "format", "formatCheck"}
+
+var (
+	// globalConfig is the loaded global config, nil when there is none.
+	globalConfig *runConfig
+	// fieldSources records which layer set each language field, keyed by
+	// extension and then field; missing entries are built-in.
+	fieldSources = map[string]map[string]string{}
+)
+
+// globalConfigPath returns the global config file; RUN_CONFIG overrides it.
+func globalConfigPath() string {
+	if path := os.Getenv("RUN_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(configDir(), "config.json")
+}
+
+// findProjectConfig returns the nearest .run.json in dir or its parents, or
+// "" when there is none.
+func findProjectConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadConfigFile reads the config at path. A missing file is not an error
+// and yields nil. Values of the wrong type are left unset, as
+// validateConfigFile reports them.
+func loadConfigFile(path string) (*runConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config runConfig
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(stripComments(data), &config); err != nil && !errors.As(err, &typeErr) {
+		return nil, configFileError(path, data, err)
+	}
+	return &config, nil
+}
+
+// stripComments blanks out `//` comments outside of JSON strings.
+func stripComments(data []byte) []byte {
+	out := append([]byte{}, data...)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inString && out[i] == '\\':
+			i++
+		case out[i] == '"':
+			inString = !inString
+		case !inString && out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+// loadGlobalConfig loads the global config and applies its language
+// overrides. A broken config is reported and ignored rather than making
+// every command fail.
+func loadGlobalConfig() {
+	path := globalConfigPath()
+	config, err := loadConfigFile(path)
+	if err != nil {
+		fmt.Printf("Warning: ignoring config file %v\n", err)
+		return
+	}
+	if config == nil {
+		return
+	}
+	// run config validate reports them itself
+	if len(os.Args) < 3 || os.Args[1] != "config" || os.Args[2] != "validate" {
+		problems, _ := validateConfigFile(path)
+		for _, p := range problems {
+			fmt.Printf("Warning: %s\n", p)
+		}
+	}
+	if err := applyConfig(config, "global config"); err != nil {
+		fmt.Printf("Warning: %s: %v\n", path, err)
+	}
+	globalConfig = config
+}
+
+// loadProjectConfig applies the .run.json governing sourceFile, if any, and
+// returns its path. Unknown keys and values of the wrong type are warned
+// about, or are an error with --strict-config.
+func loadProjectConfig(sourceFile string) (string, error) {
+	path := findProjectConfig(filepath.Dir(sourceFile))
+	if path == "" {
+		return "", nil
+	}
+	config, err := loadConfigFile(path)
+	if err != nil || config == nil {
+		return path, err
+	}
+	problems, err := validateConfigFile(path)
+	if err != nil {
+		return path, err
+	}
+	if len(problems) > 0 && strictConfig {
+		lines := make([]string, len(problems))
+		for i, p := range problems {
+			lines[i] = p.String()
+		}
+		return path, errors.New(strings.Join(lines, "\n"))
+	}
+	for _, p := range problems {
+		warnf("%s", p)
+	}
+	if err := applyConfig(config, "project config"); err != nil {
+		return path, fmt.Errorf("%s: %v", path, err)
+	}
+	return path, nil
+}
+
+// applyConfig overlays the language overrides of config onto languageConfigs,
+// recording source as the origin of every field it sets.
+func applyConfig(config *runConfig, source string) error {
+	if config.MaxSourceSize > 0 {
+		largeSourceSize = config.MaxSourceSize
+	}
+	if config.PromptTimeout != 0 {
+		promptTimeout = time.Duration(config.PromptTimeout) * time.Second
+	}
+	if config.RememberDeclines > 0 {
+		declineMemory = time.Duration(config.RememberDeclines) * 24 * time.Hour
+	}
+	if len(config.AuditPatterns) > 0 {
+		if err := compileAuditPatterns(config.AuditPatterns); err != nil {
+			return err
+		}
+		for i := range config.AuditPatterns {
+			for j, ext := range config.AuditPatterns[i].Languages {
+				config.AuditPatterns[i].Languages[j] = normalizeExt(ext)
+			}
+		}
+		auditPatterns = append(auditPatterns, config.AuditPatterns...)
+	}
+	for key, override := range config.DockerImages {
+		ext := normalizeExt(key)
+		recipe := dockerImages[ext]
+		if override.Build != "" {
+			recipe.Build = override.Build
+		}
+		if override.Image != "" {
+			recipe.Image = override.Image
+		}
+		if len(override.Compile) > 0 {
+			recipe.Compile = override.Compile
+		}
+		if override.Setup != nil {
+			recipe.Setup = override.Setup
+		}
+		if len(override.Entrypoint) > 0 {
+			recipe.Entrypoint = override.Entrypoint
+		}
+		if recipe.Image == "" {
+			return fmt.Errorf("dockerImages entry %s needs an image", ext)
+		}
+		dockerImages[ext] = recipe
+	}
+
+	extensions := make([]string, 0, len(config.Languages))
+	for ext := range config.Languages {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+
+	for _, key := range extensions {
+		override := config.Languages[key]
+		ext := normalizeExt(key)
+		lang, ok := languageConfigs[ext]
+		if !ok {
+			// A new language needs at least a way to run it
+			if len(override.Run) == 0 && len(override.Compile) == 0 {
+				return fmt.Errorf("language %s needs a run or compile command", ext)
+			}
+			lang = LanguageConfig{Name: ext, IsCompiled: len(override.Compile) > 0}
+			lang.InstallCmd = func() []string {
+				return []string{"echo", "Please install the runtime for " + ext + " manually."}
+			}
+			if len(override.Compile) > 0 {
+				lang.CheckCmd = []string{override.Compile[0], "--version"}
+			} else {
+				lang.CheckCmd = []string{override.Run[0], "--version"}
+			}
+			setFieldSource(ext, "language", source)
+		}
+
+		if err := checkPlaceholders(override.Compile); err != nil {
+			return fmt.Errorf("language %s: compile: %v", ext, err)
+		}
+		if err := checkPlaceholders(override.Run); err != nil {
+			return fmt.Errorf("language %s: run: %v", ext, err)
+		}
+
+		set := func(field string, apply func()) {
+			apply()
+			setFieldSource(ext, field, source)
+		}
+		if override.Name != "" {
+			set("name", func() { lang.Name = override.Name })
+		}
+		if len(override.Check) > 0 {
+			set("check", func() { lang.CheckCmd = override.Check })
+		}
+		if len(override.Install) > 0 {
+			install := override.Install
+			set("install", func() { lang.InstallCmd = func() []string { return install } })
+		}
+		if len(override.Compile) > 0 {
+			set("compile", func() { lang.CompileCmd = override.Compile })
+		}
+		if len(override.Run) > 0 {
+			set("run", func() { lang.RunCmd = override.Run })
+		}
+		if override.Repl != nil {
+			set("repl", func() { lang.ReplCmd = override.Repl })
+		}
+		if len(override.Format) > 0 {
+			set("format", func() { lang.FormatCmd = override.Format })
+		}
+		if len(override.FormatCheck) > 0 {
+			set("formatCheck", func() { lang.FormatCheckCmd = override.FormatCheck })
+		}
+		languageConfigs[ext] = lang
+	}
+	return nil
+}
+
+func setFieldSource(ext, field, source string) {
+	if fieldSources[ext] == nil {
+		fieldSources[ext] = map[string]string{}
+	}
+	fieldSources[ext][field] = source
+}
+
+// fieldSource returns the layer that set field of ext.
+func fieldSource(ext, field string) string {
+	if source := fieldSources[ext][field]; source != "" {
+		return source
+	}
+	return "built-in"
+}
+
+// configEntry is one language field of `run config`.
+type configEntry struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// effectiveLanguage returns the fields of ext as run would use them, with
+// environment overrides such as RUN_PY_BIN applied to the commands.
+func effectiveLanguage(ext string) map[string]configEntry {
+	lang := languageConfigs[ext]
+	entries := map[string]configEntry{}
+	add := func(field string, argv []string) {
+		if len(argv) == 0 {
+			return
+		}
+		entries[field] = configEntry{Value: shellJoin(argv), Source: fieldSource(ext, field)}
+	}
+	withEnv := func(field string, argv []string) {
+		resolved, envSource := resolveRuntimeSource(ext, argv)
+		add(field, resolved)
+		if envSource != "" {
+			entries[field] = configEntry{Value: entries[field].Value, Source: envSource}
+		}
+	}
+
+	entries["name"] = configEntry{Value: lang.Name, Source: fieldSource(ext, "name")}
+	add("check", lang.CheckCmd)
+	add("install", lang.InstallCmd())
+	withEnv("compile", lang.CompileCmd)
+	if lang.IsCompiled {
+		add("run", lang.RunCmd)
+	} else {
+		withEnv("run", lang.RunCmd)
+	}
+	add("repl", lang.ReplCmd)
+	add("format", lang.FormatCmd)
+	add("formatCheck", lang.FormatCheckCmd)
+	return entries
+}
+
+// versionManagerFiles are the files version managers use to pin a runtime
+// version for a directory tree.
+var versionManagerFiles = map[string][]string{
+	".py":   {".python-version", ".tool-versions"},
+	".js":   {".nvmrc", ".node-version", ".tool-versions"},
+	".ts":   {".nvmrc", ".node-version", ".tool-versions"},
+	".rb":   {".ruby-version", ".tool-versions"},
+	".go":   {".go-version", ".tool-versions"},
+	".rs":   {"rust-toolchain.toml", "rust-toolchain"},
+	".java": {".java-version", ".sdkmanrc", ".tool-versions"},
+	".cs":   {"global.json"},
+}
+
+// findVersionManagerFile returns the nearest version pin for ext above dir.
+func findVersionManagerFile(ext, dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range versionManagerFiles[ext] {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// configReport is the JSON form of `run config`.
+type configReport struct {
+	GlobalConfig  string                            `json:"globalConfig"`
+	ProjectConfig string                            `json:"projectConfig,omitempty"`
+	Defaults      *configEntry                      `json:"defaults,omitempty"`
+	File          string                            `json:"file,omitempty"`
+	VirtualEnv    string                            `json:"virtualEnv,omitempty"`
+	VersionFile   string                            `json:"versionFile,omitempty"`
+	Languages     map[string]map[string]configEntry `json:"languages"`
+}
+
+// configCommand implements `run config [--file f] [--json]`,
+// `run config path`, `run config init [--force]`,
+// `run config profiles [--json]` and `run config validate [path]`.
+func configCommand(args []string) {
+	if len(args) > 0 && args[0] == "path" {
+		fmt.Printf("Global config:  %s%s\n", globalConfigPath(), missingNote(globalConfigPath()))
+		if project := findProjectConfig("."); project != "" {
+			fmt.Printf("Project config: %s\n", project)
+		} else {
+			fmt.Printf("Project config: none (%s in this or a parent directory)\n", projectConfigName)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "init" {
+		configInit(len(args) > 1 && args[1] == "--force")
+		return
+	}
+	if len(args) > 0 && args[0] == "profiles" {
+		profilesCommand(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "validate" {
+		configValidate(args[1:])
+		return
+	}
+
+	var file string
+	var asJSON bool
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--json":
+			asJSON = true
+		case args[i] == "--file" && i+1 < len(args):
+			file = args[i+1]
+			i++
+		default:
+			fmt.Println("Usage: run config [--file <file>] [--json] | run config path | run config init [--force] | run config profiles [--json] | run config validate [path]")
+			os.Exit(exitUsage)
+		}
+	}
+
+	report := configReport{GlobalConfig: globalConfigPath(), Languages: map[string]map[string]configEntry{}}
+	extensions := supportedExtensions()
+	if file != "" {
+		project, err := loadProjectConfig(file)
+		if err != nil {
+			fmt.Printf("Invalid project config: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		ext, ok := detectExt(file)
+		if !ok {
+			fmt.Printf("Unsupported file type: %s\n", ext)
+			os.Exit(exitUnsupported)
+		}
+		report.File = file
+		report.ProjectConfig = project
+		report.VersionFile = findVersionManagerFile(ext, filepath.Dir(file))
+		if ext == ".py" {
+			report.VirtualEnv = os.Getenv("VIRTUAL_ENV")
+		}
+		extensions = []string{ext}
+	}
+	if flags, source, _ := defaultFlags(nil); len(flags) > 0 {
+		report.Defaults = &configEntry{Value: shellJoin(flags), Source: source}
+	}
+	for _, ext := range extensions {
+		report.Languages[ext] = effectiveLanguage(ext)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+
+	fmt.Printf("Global config:  %s%s\n", report.GlobalConfig, missingNote(report.GlobalConfig))
+	if report.File != "" {
+		fmt.Printf("File:           %s\n", report.File)
+		fmt.Printf("Project config: %s\n", valueOrNone(report.ProjectConfig))
+		if report.VirtualEnv != "" {
+			fmt.Printf("Virtualenv:     %s\n", report.VirtualEnv)
+		}
+		if report.VersionFile != "" {
+			fmt.Printf("Version pin:    %s\n", report.VersionFile)
+		}
+	}
+	if report.Defaults != nil {
+		fmt.Printf("Default flags:  %s  [%s]\n", report.Defaults.Value, report.Defaults.Source)
+	}
+	for _, ext := range extensions {
+		fmt.Printf("\n%s\n", ext)
+		entries := report.Languages[ext]
+		for _, field := range configFields {
+			if entry, ok := entries[field]; ok {
+				fmt.Printf("  %-12s %s  [%s]\n", field+":", entry.Value, entry.Source)
+			}
+		}
+	}
+}
+
+func missingNote(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return " (not found)"
+	}
+	return ""
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// starterConfig is written by `run config init`.
+const starterConfig = `// Global configuration for run. Language overrides can also be made per
+// project in a .run.json file, which takes precedence over this file.
+{
+  // Flags added in front of every file run, like RUN_DEFAULT_FLAGS
+  // (which takes precedence when set). Only read from the global config.
+  "defaults": [],
+
+  // Source files larger than this many bytes get a warning, as they are
+  // usually not the file meant to be run.
+  // "maxSourceSize": 4194304,
+
+  // Seconds to wait for an answer when asking to install something before
+  // taking it as no; -1 waits indefinitely.
+  // "promptTimeout": 60,
+
+  // Days a declined runtime installation is not offered again; by default it
+  // is only remembered for the rest of the invocation.
+  // "rememberDeclines": 7,
+
+  // Named sets of flags, selected with --profile <name>. Flags given on the
+  // command line override the profile's; a .run.json can add profiles or
+  // replace global ones.
+  "profiles": {
+    // "bench": { "flags": ["--bench", "20", "--warmup", "3"] },
+    // "bench-ci": { "extends": "bench", "flags": ["--json", "--assert-max-mean", "200ms"] }
+  },
+
+  // Container recipes for run package --docker, keyed by extension. Fields
+  // that are left out keep the built-in value.
+  // "dockerImages": {
+  //   ".py": { "image": "python:3.13-slim" },
+  //   ".cpp": { "build": "gcc:14", "image": "scratch" }
+  // },
+
+  // Extra red flags for --audit: a regular expression matched against each
+  // line, optionally only for some extensions.
+  // "auditPatterns": [
+  //   { "name": "deletes the database", "pattern": "DROP\\s+TABLE", "languages": [".py"] }
+  // ],
+
+  // Per-language overrides keyed by extension. Any of name, check, install,
+  // compile, run, repl, format and formatCheck can be set; unset fields keep
+  // the built-in value. Unknown extensions define new languages.
+  "languages": {
+    // ".py": { "run": ["python3.12"] },
+    // ".rb": { "format": ["rubocop", "-a"], "formatCheck": ["rubocop"] },
+    // ".lisp": { "name": "Common Lisp", "run": ["sbcl", "--script"] }
+  }
+}
+`
+
+func configInit(force bool) {
+	path := globalConfigPath()
+	if _, err := os.Stat(path); err == nil && !force {
+		fmt.Printf("%s already exists. Use --force to overwrite it.\n", path)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fmt.Printf("Cannot create %s: %v\n", filepath.Dir(path), err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, []byte(starterConfig), 0o644); err != nil {
+		fmt.Printf("Cannot write %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Created %s\n", path)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// strictConfig makes a project config with problems an error instead of a
+// warning (--strict-config).
+var strictConfig bool
+
+// configProblem is an unknown key or a value of the wrong type in a config
+// file.
+type configProblem struct {
+	Path string // The file
+	Line int
+	Msg  string
+}
+
+func (p configProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Msg)
+}
+
+// validateConfigFile checks the config at path against runConfig. A syntax
+// error is returned as the error; unknown keys and values of the wrong type
+// are returned as problems, which loadConfigFile otherwise ignores.
+func validateConfigFile(path string) ([]configProblem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// stripComments keeps offsets, so lines are those of the file
+	data = stripComments(data)
+	if err := json.Unmarshal(data, new(any)); err != nil {
+		return nil, configFileError(path, data, err)
+	}
+	v := &configValidator{path: path, data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	v.dec.UseNumber()
+	v.value(reflect.TypeOf(runConfig{}), "")
+	return v.problems, nil
+}
+
+// configFileError adds path, and the line for a syntax error, to err from
+// decoding data.
+func configFileError(path string, data []byte, err error) error {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		// Offset is just past the offending byte
+		return fmt.Errorf("%s:%d: %v", path, bytes.Count(data[:max(syntax.Offset-1, 0)], []byte("\n"))+1, err)
+	}
+	return fmt.Errorf("%s: %v", path, err)
+}
+
+// configValidator walks the tokens of a config file alongside the Go type
+// they decode into.
+type configValidator struct {
+	path     string
+	data     []byte
+	dec      *json.Decoder
+	problems []configProblem
+}
+
+func (v *configValidator) report(format string, a ...any) {
+	line := lineAt(v.data, int(v.dec.InputOffset()))
+	v.problems = append(v.problems, configProblem{Path: v.path, Line: line, Msg: fmt.Sprintf(format, a...)})
+}
+
+// value checks the next value, which decodes into t and is at key.
+func (v *configValidator) value(t reflect.Type, key string) {
+	// Reported before reading, so the line is the value's
+	offset := v.dec.InputOffset()
+	tok, err := v.dec.Token()
+	if err != nil || tok == nil {
+		return // null leaves the field unset
+	}
+	mismatch := func() {
+		line := lineAt(v.data, int(offset))
+		v.problems = append(v.problems, configProblem{Path: v.path, Line: line,
+			Msg: fmt.Sprintf("%s: expected %s, got %s", keyName(key), typeName(t), tokenName(tok))})
+		if d, ok := tok.(json.Delim); ok {
+			v.skip(d)
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := tok.(string); !ok {
+			mismatch()
+		}
+	case reflect.Int, reflect.Int64:
+		n, ok := tok.(json.Number)
+		if !ok {
+			mismatch()
+		} else if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			v.problems = append(v.problems, configProblem{Path: v.path, Line: lineAt(v.data, int(offset)),
+				Msg: fmt.Sprintf("%s: expected a whole number, got %s", keyName(key), n)})
+		}
+	case reflect.Slice:
+		if tok != json.Delim('[') {
+			mismatch()
+			return
+		}
+		for i := 0; v.dec.More(); i++ {
+			v.value(t.Elem(), fmt.Sprintf("%s[%d]", key, i))
+		}
+		v.dec.Token()
+	case reflect.Map:
+		if tok != json.Delim('{') {
+			mismatch()
+			return
+		}
+		for v.dec.More() {
+			name, _ := v.dec.Token()
+			v.value(t.Elem(), fmt.Sprintf("%s[%q]", key, name))
+		}
+		v.dec.Token()
+	case reflect.Struct:
+		if tok != json.Delim('{') {
+			mismatch()
+			return
+		}
+		fields := jsonFields(t)
+		for v.dec.More() {
+			tok, _ := v.dec.Token()
+			name, _ := tok.(string)
+			field, ok := fields[name]
+			if !ok && key == "" && name == "$schema" {
+				// Points editors at the output of --dump-config-schema
+				v.skipValue()
+				continue
+			}
+			if !ok {
+				msg := fmt.Sprintf("unknown key %q", name)
+				if key != "" {
+					msg = fmt.Sprintf("%s: unknown key %q", keyName(key), name)
+				}
+				if best := suggest(name, sortedKeys(fields)); len(best) > 0 {
+					msg += fmt.Sprintf(" (did you mean %q?)", best[0])
+				}
+				v.report("%s", msg)
+				v.skipValue()
+				continue
+			}
+			if key != "" {
+				name = key + "." + name
+			}
+			v.value(field.Type, name)
+		}
+		v.dec.Token()
+	}
+}
+
+// skipValue skips the next value.
+func (v *configValidator) skipValue() {
+	tok, err := v.dec.Token()
+	if d, ok := tok.(json.Delim); ok && err == nil {
+		v.skip(d)
+	}
+}
+
+// skip skips the rest of the object or array opened by d.
+func (v *configValidator) skip(d json.Delim) {
+	if d != '{' && d != '[' {
+		return
+	}
+	for depth := 1; depth > 0; {
+		tok, err := v.dec.Token()
+		if err != nil {
+			return
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// lineAt returns the line of the first token at or after offset in data.
+func lineAt(data []byte, offset int) int {
+	offset = min(offset, len(data))
+	for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// jsonFields returns the fields of struct t by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func keyName(key string) string {
+	if key == "" {
+		return "the file"
+	}
+	return key
+}
+
+// typeName describes t for "expected ..." messages.
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Slice:
+		elem := strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ")
+		return "an array of " + elem + "s"
+	}
+	return "an object"
+}
+
+// tokenName describes a JSON token for "got ..." messages.
+func tokenName(tok json.Token) string {
+	switch tok := tok.(type) {
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case json.Delim:
+		if tok == '[' {
+			return "an array"
+		}
+	}
+	return "an object"
+}
+
+// configSchema returns a JSON Schema for config files
+// (--dump-config-schema), for editors to complete and check them with.
+func configSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(runConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "run configuration (config.json and .run.json)"
+	schema["properties"].(map[string]any)["$schema"] = map[string]any{"type": "string"}
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	}
+	properties := map[string]any{}
+	for name, field := range jsonFields(t) {
+		properties[name] = typeSchema(field.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// configValidate implements `run config validate [path]`: with no path it
+// checks the global config and the project config governing the current
+// directory.
+func configValidate(args []string) {
+	var paths []string
+	switch len(args) {
+	case 0:
+		if _, err := os.Stat(globalConfigPath()); err == nil {
+			paths = append(paths, globalConfigPath())
+		}
+		if project := findProjectConfig("."); project != "" {
+			paths = append(paths, project)
+		}
+		if len(paths) == 0 {
+			fmt.Println("No config files to validate.")
+			return
+		}
+	case 1:
+		paths = args
+	default:
+		fmt.Println("Usage: run config validate [path]")
+		os.Exit(exitUsage)
+	}
+
+	failed := false
+	for _, path := range paths {
+		problems, err := validateConfigFile(path)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			failed = true
+			continue
+		}
+		// What only applying the config finds, e.g. a bad audit pattern
+		config, err := loadConfigFile(path)
+		if err == nil && config != nil {
+			err = applyConfig(config, path)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK\n", path)
+	}
+	if failed {
+		os.Exit(exitUsage)
+	}
+}
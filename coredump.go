@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coreDump lets crashing programs write a core file, which run then locates
+// and, with gdb or lldb installed, prints a backtrace from (--core-dump).
+var coreDump bool
+
+// debugFlags are added to the compile command under --core-dump so that
+// backtraces show function names and line numbers.
+var debugFlags = map[string][]string{
+	".c": {"-g"}, ".cpp": {"-g"}, ".rs": {"-g"}, ".hs": {"-g"}, ".pas": {"-g"},
+	".nim": {"--debugger:native"},
+}
+
+// reportCoreDump tells the user where the core of the crashed process went
+// and prints a backtrace from it. exe is the program that crashed and dir the
+// working directory it ran in.
+func reportCoreDump(exe, dir string, pid int) {
+	if dir == "" {
+		dir = "."
+	}
+	core := findCoreFile(exe, dir, pid)
+	if core == "" {
+		fmt.Println("The program dumped core, but the core file could not be located.")
+		if runtime.GOOS == "linux" {
+			fmt.Printf("  kernel.core_pattern is %q\n", readTrimmed("/proc/sys/kernel/core_pattern"))
+		}
+		return
+	}
+	fmt.Printf("Core dump written to %s\n", core)
+	logEvent("core-dump", map[string]any{"path": core, "pid": pid})
+
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("gdb"); err == nil {
+		cmd = exec.Command("gdb", "-batch", "-ex", "bt", exe, core)
+	} else if _, err := exec.LookPath("lldb"); err == nil {
+		cmd = exec.Command("lldb", "--batch", "-c", core, "-o", "bt", exe)
+	} else {
+		fmt.Println("Install gdb or lldb to get a backtrace automatically.")
+		return
+	}
+	fmt.Println("\nBacktrace:")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	runCmd(cmd)
+}
+
+// findCoreFile returns the path of the core written for pid, or "" when it
+// can't be found.
+func findCoreFile(exe, dir string, pid int) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return existing(fmt.Sprintf("/cores/core.%d", pid))
+	case "linux":
+		pattern := readTrimmed("/proc/sys/kernel/core_pattern")
+		if handler, ok := strings.CutPrefix(pattern, "|"); ok {
+			// Piped to a crash handler; only systemd's can hand the core back
+			if strings.Contains(handler, "systemd-coredump") {
+				return extractCoredump(dir, pid)
+			}
+			return ""
+		}
+		if pattern == "" {
+			pattern = "core"
+		}
+		path := expandCorePattern(pattern, exe, pid)
+		if readTrimmed("/proc/sys/kernel/core_uses_pid") == "1" && !strings.Contains(pattern, "%p") {
+			path += "." + strconv.Itoa(pid)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if matches, _ := filepath.Glob(path); len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+// expandCorePattern fills in the kernel.core_pattern specifiers run knows
+// and turns the others (timestamps, uids, ...) into glob wildcards.
+func expandCorePattern(pattern, exe string, pid int) string {
+	comm := filepath.Base(exe)
+	if len(comm) > 15 {
+		comm = comm[:15] // The kernel's TASK_COMM_LEN
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'p', 'P', 'i', 'I':
+			b.WriteString(strconv.Itoa(pid))
+		case 'e':
+			b.WriteString(comm)
+		default:
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
+
+// extractCoredump copies the core stored by systemd-coredump into dir.
+// systemd processes the crash asynchronously, so it may take a moment to
+// appear in the journal.
+func extractCoredump(dir string, pid int) string {
+	if _, err := exec.LookPath("coredumpctl"); err != nil {
+		return ""
+	}
+	path := filepath.Join(dir, fmt.Sprintf("core.%d", pid))
+	for attempt := 0; attempt < 10; attempt++ {
+		cmd := exec.Command("coredumpctl", "--quiet", "dump", strconv.Itoa(pid), "-o", path)
+		if runCmd(cmd) == nil {
+			return existing(path)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+	return ""
+}
+
+func existing(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// useDaemon runs Java programs in the warm JVM of `run daemon start` and
+// builds C# with the MSBuild server (--use-daemon). Without a daemon run
+// falls back to starting a fresh JVM.
+var useDaemon bool
+
+// daemonState is what `run daemon start` records about the JVM it started.
+type daemonState struct {
+	PID     int       `json:"pid"`
+	Port    int       `json:"port"`
+	Token   string    `json:"token"` // Proves requests come from the same user
+	Started time.Time `json:"started"`
+	Dir     string    `json:"dir"` // Where relative paths of its programs resolve
+}
+
+func daemonDir() string {
+	return filepath.Join(dataDir(), "daemon")
+}
+
+func daemonStateFile() string {
+	return filepath.Join(daemonDir(), "java.json")
+}
+
+// javaDaemonSource is the harness the JVM daemon runs. Each request loads
+// the program's classes in a fresh class loader, so static state starts
+// over, while the JDK's own classes stay loaded and compiled. The program's
+// output comes back in frames: a kind byte ('o' stdout, 'e' stderr, 'x'
+// exit code), a length and the bytes.
+const javaDaemonSource = `import java.io.*;
+import java.lang.reflect.*;
+import java.net.*;
+import java.nio.charset.StandardCharsets;
+
+public class RunDaemon {
+    static String readString(DataInputStream in) throws IOException {
+        byte[] b = new byte[in.readInt()];
+        in.readFully(b);
+        return new String(b, StandardCharsets.UTF_8);
+    }
+
+    static class Frames extends OutputStream {
+        final DataOutputStream out;
+        final int kind;
+
+        Frames(DataOutputStream out, int kind) {
+            this.out = out;
+            this.kind = kind;
+        }
+
+        public void write(int b) throws IOException {
+            write(new byte[] {(byte) b}, 0, 1);
+        }
+
+        public void write(byte[] b, int off, int len) throws IOException {
+            synchronized (out) {
+                out.writeByte(kind);
+                out.writeInt(len);
+                out.write(b, off, len);
+                out.flush();
+            }
+        }
+    }
+
+    public static void main(String[] args) throws Exception {
+        String token = new BufferedReader(new InputStreamReader(System.in, StandardCharsets.UTF_8)).readLine();
+        ServerSocket server = new ServerSocket(0, 50, InetAddress.getLoopbackAddress());
+        System.out.println(server.getLocalPort());
+        System.out.flush();
+        PrintStream stdout = System.out, stderr = System.err;
+        InputStream stdin = System.in;
+        while (true) {
+            try (Socket socket = server.accept()) {
+                DataInputStream in = new DataInputStream(new BufferedInputStream(socket.getInputStream()));
+                DataOutputStream out = new DataOutputStream(new BufferedOutputStream(socket.getOutputStream()));
+                if (!readString(in).equals(token)) {
+                    continue;
+                }
+                String command = readString(in);
+                int code = 0;
+                if (command.equals("run")) {
+                    String dir = readString(in), className = readString(in);
+                    String[] programArgs = new String[in.readInt()];
+                    for (int i = 0; i < programArgs.length; i++) {
+                        programArgs[i] = readString(in);
+                    }
+                    System.setOut(new PrintStream(new Frames(out, 'o'), true, "UTF-8"));
+                    System.setErr(new PrintStream(new Frames(out, 'e'), true, "UTF-8"));
+                    System.setIn(new ByteArrayInputStream(new byte[0]));
+                    URL[] path = {new File(dir).toURI().toURL()};
+                    try (URLClassLoader loader = new URLClassLoader(path, ClassLoader.getSystemClassLoader().getParent())) {
+                        Method main = Class.forName(className, true, loader).getMethod("main", String[].class);
+                        main.setAccessible(true);
+                        main.invoke(null, (Object) programArgs);
+                    } catch (InvocationTargetException e) {
+                        e.getCause().printStackTrace();
+                        code = 1;
+                    } catch (ReflectiveOperationException e) {
+                        System.err.println("run daemon: cannot start " + className + ": " + e);
+                        code = 1;
+                    } catch (Throwable e) {
+                        // E.g. an exception in a static initializer
+                        e.printStackTrace();
+                        code = 1;
+                    } finally {
+                        System.out.flush();
+                        System.err.flush();
+                        System.setOut(stdout);
+                        System.setErr(stderr);
+                        System.setIn(stdin);
+                    }
+                }
+                synchronized (out) {
+                    out.writeByte('x');
+                    out.writeInt(code);
+                    out.flush();
+                }
+                if (command.equals("stop")) {
+                    System.exit(0);
+                }
+            } catch (IOException e) {
+                stderr.println("run daemon: " + e);
+            }
+        }
+    }
+}
+`
+
+// daemonCommand implements `run daemon start|stop|status`.
+func daemonCommand(args []string) error {
+	if len(args) != 1 {
+		return usageError("Usage: run daemon start|stop|status")
+	}
+	switch args[0] {
+	case "start":
+		return startDaemon()
+	case "stop":
+		stopDaemon()
+		return nil
+	case "status":
+		if state, ok := javaDaemon(); ok {
+			fmt.Printf("JVM daemon: running (pid %d, port %d, up %s, in %s)\n", state.PID, state.Port, formatDuration(time.Since(state.Started)), state.Dir)
+		} else {
+			fmt.Println("JVM daemon: not running")
+		}
+		return nil
+	}
+	return usageError("Unknown daemon command: %s\nUsage: run daemon start|stop|status", args[0])
+}
+
+// startDaemon compiles the harness and starts the JVM daemon, unless one is
+// already running.
+func startDaemon() error {
+	if state, ok := javaDaemon(); ok {
+		fmt.Printf("JVM daemon: already running (pid %d)\n", state.PID)
+		return nil
+	}
+	if !checkRuntime([]string{"javac", "-version"}) {
+		return newRunError("runtime-unavailable", exitRuntimeUnavailable, "The JVM daemon needs a JDK (javac and java). Install one first, e.g. by running a .java file.")
+	}
+	dir := daemonDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	source := filepath.Join(dir, "RunDaemon.java")
+	if err := os.WriteFile(source, []byte(javaDaemonSource), 0o600); err != nil {
+		return err
+	}
+	javac := exec.Command("javac", "-d", dir, source)
+	javac.Stderr = os.Stderr
+	if err := runCmd(javac); err != nil {
+		return newRunError("compile-failed", exitCompileFailed, "Cannot compile the JVM daemon: %v", err)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	state := daemonState{Token: hex.EncodeToString(token), Started: time.Now(), Dir: cwd}
+	logFile, err := os.OpenFile(filepath.Join(dir, "java.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	// The token goes in on stdin, where other users can't see it
+	cmd := exec.Command("java", "-cp", dir, "RunDaemon")
+	cmd.Env = childEnv()
+	cmd.Stdin = strings.NewReader(state.Token + "\n")
+	cmd.Stderr = logFile
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// Its own process group keeps Ctrl+C in this terminal from reaching it
+	ownProcessGroup(cmd)
+	traceCmd(cmd)
+	if err := cmd.Start(); err != nil {
+		return newRunError("runtime-unavailable", exitRuntimeUnavailable, "Cannot start the JVM daemon: %v", err)
+	}
+	line, _ := bufio.NewReader(stdout).ReadString('\n')
+	if state.Port, err = strconv.Atoi(strings.TrimSpace(line)); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("the JVM daemon did not start; see %s", logFile.Name())
+	}
+	state.PID = cmd.Process.Pid
+	cmd.Process.Release()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(daemonStateFile(), data, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("JVM daemon: started (pid %d, port %d)\n", state.PID, state.Port)
+	if checkRuntime([]string{"dotnet", "--version"}) {
+		fmt.Println(".NET: with --use-daemon, C# builds use the MSBuild server, which dotnet starts on the first build")
+	}
+	return nil
+}
+
+// stopDaemon stops the JVM daemon and dotnet's build servers.
+func stopDaemon() {
+	if state, ok := javaDaemon(); ok {
+		if _, err := daemonRequest(state, "stop", nil, io.Discard, io.Discard); err != nil {
+			warnf("cannot stop the JVM daemon: %v", err)
+		} else {
+			fmt.Printf("JVM daemon: stopped (pid %d)\n", state.PID)
+		}
+	} else {
+		fmt.Println("JVM daemon: not running")
+	}
+	os.Remove(daemonStateFile())
+	if checkRuntime([]string{"dotnet", "--version"}) {
+		cmd := exec.Command("dotnet", "build-server", "shutdown")
+		if err := runCmd(cmd); err == nil {
+			fmt.Println(".NET: build servers shut down")
+		}
+	}
+}
+
+// javaDaemon returns the running JVM daemon, if any.
+func javaDaemon() (daemonState, bool) {
+	var state daemonState
+	data, err := os.ReadFile(daemonStateFile())
+	if err != nil || json.Unmarshal(data, &state) != nil {
+		return state, false
+	}
+	_, err = daemonRequest(state, "ping", nil, io.Discard, io.Discard)
+	return state, err == nil
+}
+
+// daemonRequest sends command to the JVM daemon, with the class directory,
+// class name and arguments for "run", and copies the program's output to
+// stdout and stderr. It returns the program's exit code.
+func daemonRequest(state daemonState, command string, run []string, stdout, stderr io.Writer) (int, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(state.Port)), time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	writeString := func(s string) {
+		binary.Write(w, binary.BigEndian, int32(len(s)))
+		w.WriteString(s)
+	}
+	writeString(state.Token)
+	writeString(command)
+	if command == "run" {
+		writeString(run[0])
+		writeString(run[1])
+		binary.Write(w, binary.BigEndian, int32(len(run)-2))
+		for _, arg := range run[2:] {
+			writeString(arg)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, errors.New("the JVM daemon exited mid-run (did the program call System.exit?); start it again with run daemon start")
+			}
+			return 0, err
+		}
+		var n int32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return 0, err
+		}
+		switch kind {
+		case 'x':
+			return int(n), nil
+		case 'o':
+			_, err = io.CopyN(stdout, r, int64(n))
+		default:
+			_, err = io.CopyN(stderr, r, int64(n))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// javaExit matches a call that ends the JVM, which in the daemon would end
+// the daemon rather than the program.
+var javaExit = regexp.MustCompile(`\b(?:System|Runtime\.getRuntime\(\))\s*\.\s*(?:exit|halt)\s*\(`)
+
+// daemonFallback returns why the program of sourceFile shouldn't run in the
+// JVM daemon of state, or "" when it can. Its program can't leave the JVM
+// it shares, nor have a working directory of its own: the JVM resolves
+// relative paths against the one it started in.
+func daemonFallback(state daemonState, sourceFile string) string {
+	if content, err := os.ReadFile(sourceFile); err == nil && javaExit.Match(content) {
+		return filepath.Base(sourceFile) + " calls System.exit, which would end the daemon"
+	}
+	cwd, err := os.Getwd()
+	if err != nil || cwd != state.Dir {
+		where := state.Dir
+		if where == "" {
+			where = "another directory" // Started by an older run
+		}
+		return fmt.Sprintf("the daemon's programs run in %s, where it was started", where)
+	}
+	return ""
+}
+
+// runInDaemon runs the compiled Java class className from classDir in the
+// JVM daemon of state, as returned by javaDaemon.
+func runInDaemon(state daemonState, classDir, className string, args []string, stdout, stderr io.Writer) (int, error) {
+	dir, err := filepath.Abs(classDir)
+	if err != nil {
+		return 0, err
+	}
+	logEvent("daemon", map[string]any{"pid": state.PID, "class": className, "dir": dir})
+	return daemonRequest(state, "run", append([]string{dir, className}, args...), stdout, stderr)
+}
+
+// runJavaInDaemon is executeFile's run step for a Java program compiled by
+// plan, run in the JVM daemon of state. It has no stdin; daemonFallback has
+// checked that it can run there.
+func runJavaInDaemon(state daemonState, plan execPlan, config LanguageConfig, runName string) error {
+	infof("Running %s in the JVM daemon...\n", runName)
+	run := beginPhase("run")
+	class := config.ClassNameFn(filepath.Base(plan.SourceFile))
+	code, err := runInDaemon(state, plan.Executable, class, programArgs, os.Stdout, os.Stderr)
+	run.end(err)
+	if err != nil {
+		return newRunError("error", 1, "JVM daemon: %v", err)
+	}
+	if code != 0 {
+		return newRunError("program-failed", code, "Execution failed: exit status %d", code)
+	}
+	return nil
+}
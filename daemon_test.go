@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDaemonNotRunning checks status and stop when no daemon was started.
+func TestDaemonNotRunning(t *testing.T) {
+	env := newRunEnv(t)
+	for _, command := range []string{"status", "stop"} {
+		result := env.run(t, t.TempDir(), "daemon", command)
+		if result.Code != 0 {
+			t.Errorf("daemon %s exited with %d: %s", command, result.Code, result.Stderr)
+		}
+		if !strings.Contains(result.Stdout, "not running") {
+			t.Errorf("daemon %s printed %q, want it to say no daemon is running", command, result.Stdout)
+		}
+	}
+}
+
+// TestDaemonFallback checks which programs don't run in a running daemon.
+func TestDaemonFallback(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	plain := writeFile(t, dir, "Main.java", "public class Main { public static void main(String[] a) { System.out.println(1); } }\n")
+	exits := writeFile(t, dir, "Exit.java", "public class Exit { public static void main(String[] a) { System.exit(2); } }\n")
+	halts := writeFile(t, dir, "Halt.java", "public class Halt { public static void main(String[] a) { Runtime.getRuntime().halt(2); } }\n")
+
+	tests := []struct {
+		name   string
+		state  daemonState
+		source string
+		want   string
+	}{
+		{"same directory", daemonState{Dir: dir}, plain, ""},
+		{"System.exit", daemonState{Dir: dir}, exits, "System.exit"},
+		{"halt", daemonState{Dir: dir}, halts, "System.exit"},
+		{"other directory", daemonState{Dir: t.TempDir()}, plain, "where it was started"},
+		{"unknown directory", daemonState{}, plain, "another directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := daemonFallback(tt.state, tt.source)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("daemonFallback = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDaemonIgnoredForKotlin checks that --use-daemon warns for Kotlin,
+// whose programs don't run in the daemon.
+func TestDaemonIgnoredForKotlin(t *testing.T) {
+	env := newRunEnv(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "main.kt", "fun main() { println(1) }\n")
+	result := env.run(t, dir, "--use-daemon", "--dry-run", "main.kt")
+	if !strings.Contains(result.Stdout+result.Stderr, "start a JVM of their own") {
+		t.Errorf("no warning about --use-daemon for Kotlin:\n%s%s", result.Stdout, result.Stderr)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dataDeps are the files and directories the program reads, declared with
+// --with, e.g. a CSV or a config.json next to the script. Relative paths
+// are relative to the program's working directory; where the program runs
+// somewhere else, they are copied there at the same relative paths.
+var dataDeps []string
+
+// dataDepsBase returns the directory relative --with paths are relative to
+// for plan: the working directory of its program.
+func dataDepsBase(plan execPlan) string {
+	if plan.Dir != "" {
+		return plan.Dir
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
+
+// dataDepPath returns where the --with path dep is, for a program that runs
+// in base.
+func dataDepPath(base, dep string) string {
+	if filepath.IsAbs(dep) {
+		return dep
+	}
+	return filepath.Join(base, dep)
+}
+
+// checkDataDeps fails when a --with path doesn't exist for a program that
+// runs in base, before anything is built or run.
+func checkDataDeps(base string) error {
+	for _, dep := range dataDeps {
+		path := dataDepPath(base, dep)
+		if _, err := os.Stat(path); err != nil {
+			return newRunError("file-not-found", exitNoInput, "Data dependency not found: %s (--with, looked for %s)", dep, path)
+		}
+		if err := checkRestricted("--with file", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeDataDeps copies the relative --with paths from base into dir at the
+// same relative paths, for a program that runs in dir instead. Absolute
+// paths are left where they are, as the program finds them from anywhere.
+func placeDataDeps(base, dir string) error {
+	for _, dep := range dataDeps {
+		if filepath.IsAbs(dep) {
+			continue
+		}
+		if !filepath.IsLocal(dep) {
+			return usageError("--with %s is outside the working directory, so it can't be placed in %s.", dep, dir)
+		}
+		target := filepath.Join(dir, dep)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := copyTree(filepath.Join(base, dep), target); err != nil {
+			return fmt.Errorf("cannot copy %s (--with): %w", dep, err)
+		}
+	}
+	return nil
+}
+
+// printDataDeps lists the --with paths for --dry-run, and where the program
+// finds them.
+func printDataDeps(plan execPlan) {
+	if len(dataDeps) == 0 {
+		return
+	}
+	base := dataDepsBase(plan)
+	fmt.Println("\nData dependencies (--with):")
+	for _, dep := range dataDeps {
+		path := dataDepPath(base, dep)
+		if _, err := os.Stat(path); err != nil {
+			fmt.Printf("  ✗ %s: not found at %s\n", dep, path)
+		} else {
+			fmt.Printf("  ✓ %s: %s, used where it is\n", dep, path)
+		}
+	}
+}
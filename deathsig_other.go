@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// dieWithParent relies on Linux's parent-death signal; elsewhere a child
+// survives run being killed.
+func dieWithParent(cmd *exec.Cmd) {}
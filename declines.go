@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// declineMemory is how long a declined runtime installation is
+	// remembered by later invocations; the config sets it in days with
+	// rememberDeclines. Zero remembers it for the current invocation only.
+	declineMemory time.Duration
+	// declinedInstalls are the runtimes whose installation was declined in
+	// this invocation, so that it is not offered again for every file.
+	declinedInstalls = map[string]time.Time{}
+)
+
+// sessionDeclinesEnv names the file where the runs of one sequence share
+// their declines: each file runs in a run of its own, and one "no" is
+// meant to cover them all.
+const sessionDeclinesEnv = "RUN_SESSION_DECLINES"
+
+func declinesFile() string {
+	return filepath.Join(dataDir(), "declined-installs.json")
+}
+
+// readDeclines returns when the installation of each runtime was last
+// declined, as saved by earlier invocations.
+func readDeclines() map[string]time.Time {
+	return readDeclinesFrom(declinesFile())
+}
+
+func readDeclinesFrom(path string) map[string]time.Time {
+	declines := map[string]time.Time{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		json.Unmarshal(data, &declines)
+	}
+	return declines
+}
+
+// writeDeclines replaces the declines saved at path.
+func writeDeclines(path string, declines map[string]time.Time) {
+	data, err := json.MarshalIndent(declines, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+		os.Rename(tmp, path)
+	}
+}
+
+// recentDecline reports when installing runtime was declined, if that was in
+// this invocation, its sequence, or within declineMemory.
+func recentDecline(runtime string) (time.Time, bool) {
+	if when, ok := declinedInstalls[runtime]; ok {
+		return when, true
+	}
+	if session := os.Getenv(sessionDeclinesEnv); session != "" {
+		if when, ok := readDeclinesFrom(session)[runtime]; ok {
+			return when, true
+		}
+	}
+	if declineMemory <= 0 {
+		return time.Time{}, false
+	}
+	when, ok := readDeclines()[runtime]
+	if !ok || time.Since(when) > declineMemory {
+		return time.Time{}, false
+	}
+	return when, true
+}
+
+// recordDecline remembers that installing runtime was declined, for the rest
+// of this invocation and its sequence and, with declineMemory set, for later
+// ones. Expired entries are dropped on the way.
+func recordDecline(runtime string) {
+	now := time.Now()
+	declinedInstalls[runtime] = now
+	if session := os.Getenv(sessionDeclinesEnv); session != "" {
+		declines := readDeclinesFrom(session)
+		declines[runtime] = now
+		writeDeclines(session, declines)
+	}
+	if declineMemory <= 0 {
+		return
+	}
+	declines := readDeclines()
+	for name, when := range declines {
+		if now.Sub(when) > declineMemory {
+			delete(declines, name)
+		}
+	}
+	declines[runtime] = now
+	writeDeclines(declinesFile(), declines)
+}
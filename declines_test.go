@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSequenceSharesDeclines checks that the files of a sequence ask about
+// installing a missing runtime once between them, and are summed up as
+// skipped rather than failed.
+func TestSequenceSharesDeclines(t *testing.T) {
+	ext, tool, ok := missingRuntime()
+	if !ok {
+		t.Skip("every runtime is installed")
+	}
+	env := newRunEnv(t)
+	dir := t.TempDir()
+	files := []string{"a" + ext, "b" + ext, "c" + ext}
+	for _, name := range files {
+		writeFile(t, dir, name, "")
+	}
+	cmd := env.command(t, dir, files...)
+	cmd.Stdin = strings.NewReader("n\nn\nn\n")
+	out, _ := cmd.CombinedOutput()
+	if code := cmd.ProcessState.ExitCode(); code != exitRuntimeUnavailable {
+		t.Errorf("exit code %d, want %d", code, exitRuntimeUnavailable)
+	}
+	if n := strings.Count(string(out), "Go ahead?"); n > 1 {
+		t.Errorf("asked %d times to install %s:\n%s", n, tool, out)
+	}
+	for _, name := range files {
+		skipped := false
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, name) && strings.HasSuffix(line, "skipped: runtime missing") {
+				skipped = true
+			}
+		}
+		if !skipped {
+			t.Errorf("%s not summed up as skipped:\n%s", name, out)
+		}
+	}
+	if want := "3 files skipped because " + tool + " is not installed"; !strings.Contains(string(out), want) {
+		t.Errorf("no %q footer:\n%s", want, out)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// defaultFlagsEnv names the environment variable holding options that are
+// implicitly added in front of every file run, e.g. RUN_DEFAULT_FLAGS="--time".
+const defaultFlagsEnv = "RUN_DEFAULT_FLAGS"
+
+// defaultFlags returns the default flags and where they came from: the
+// shell-split contents of RUN_DEFAULT_FLAGS or else the global config's
+// defaults. It returns nil when there are none or args contain --no-defaults.
+// Positional arguments are not allowed there: defaults can't name the file to
+// run.
+func defaultFlags(args []string) ([]string, string, error) {
+	if slices.Contains(args, "--no-defaults") {
+		return nil, "", nil
+	}
+	var flags []string
+	source := defaultFlagsEnv
+	if value := os.Getenv(defaultFlagsEnv); value != "" {
+		var err error
+		if flags, err = shellSplit(value); err != nil {
+			return nil, "", fmt.Errorf("invalid %s: %v", defaultFlagsEnv, err)
+		}
+	} else if globalConfig != nil && len(globalConfig.Defaults) > 0 {
+		flags, source = globalConfig.Defaults, "global config"
+	} else {
+		return nil, "", nil
+	}
+	if err := checkFlagsOnly(flags, "defaults in "+source); err != nil {
+		return nil, "", err
+	}
+	return flags, source, nil
+}
+
+// checkFlagsOnly makes sure flags holds only flags and their values, as in
+// the defaults and profiles, which can't name the file to run.
+func checkFlagsOnly(flags []string, source string) error {
+	for i, flag := range flags {
+		takesValue := i > 0 && slices.Contains(valueFlags, flags[i-1])
+		if !takesValue && !isNumeric(flag) && (flag == "" || flag[0] != '-') {
+			return fmt.Errorf("invalid %s: %q is not a flag", source, flag)
+		}
+	}
+	return nil
+}
+
+// valueFlags are the flags whose value is the next argument.
+var valueFlags = []string{
+	"--profile", "--warmup", "--verify-with", "--bench-input", "--bench-stats", "--output-format", "--gen", "--gen-out", "--log-file", "--max-cpu-time", "--timeout", "--heartbeat", "--porcelain-fd", "--cwd", "--out", "-o", "--compiler-args", "--cflag", "--runtime-args", "--sources", "--entry", "--with", "--emit-script", "--restrict-root", "--lang", "--sha256", "--test-mode", "--test-run", "--error-format", "--shell", "--sh",
+	"--assert-max-mean", "--assert-max-median", "--assert-max-min", "--assert-max-max",
+	"--assert-max-stddev", "--assert-max-p50", "--assert-max-p90", "--assert-max-p95", "--assert-max-p99",
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	// updateDeps resolves a script's declared packages again and rewrites
+	// its lock (--update-deps).
+	updateDeps bool
+	// frozenDeps installs only what a script's lock records and fails when
+	// there is no lock or it no longer matches the declarations (--frozen).
+	frozenDeps bool
+)
+
+// depsLock records the packages a script's dependencies resolved to the
+// first time they were installed, so that later runs, on this machine or
+// another, get the same versions. It is one JSON file next to the script,
+// meant to be committed with it; Ecosystem says which fields apply.
+type depsLock struct {
+	Version   int               `json:"version"`
+	Ecosystem string            `json:"ecosystem"` // npm
+	Declared  []string          `json:"declared"`  // As written in the script
+	Packages  map[string]string `json:"packages"`  // Name to resolved version, dependencies included
+	Manifest  json.RawMessage   `json:"manifest,omitempty"`
+	Lockfile  json.RawMessage   `json:"lockfile,omitempty"`
+}
+
+// States of a script's lock against its declared dependencies.
+const (
+	depsLockMissing = "missing"
+	depsLockStale   = "stale"
+	depsLockCurrent = "current"
+)
+
+// depsLockPath returns the lock file of sourceFile, e.g. app.js.run.lock.
+func depsLockPath(sourceFile string) string {
+	return sourceFile + ".run.lock"
+}
+
+// readDepsLock returns the lock of sourceFile and its state against deps,
+// the dependencies the script declares now.
+func readDepsLock(sourceFile string, deps []string) (*depsLock, string, error) {
+	data, err := os.ReadFile(depsLockPath(sourceFile))
+	if os.IsNotExist(err) {
+		return nil, depsLockMissing, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var lock depsLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, "", newRunError("stale-lock", exitUnsupported, "Invalid lock %s: %v\nFix it, or remove it and run again to lock anew.", depsLockPath(sourceFile), err)
+	}
+	if lock.Ecosystem != "npm" || !slices.Equal(lock.Declared, deps) || len(lock.Lockfile) == 0 {
+		return &lock, depsLockStale, nil
+	}
+	return &lock, depsLockCurrent, nil
+}
+
+// prepareNodeDeps makes the packages deps, declared in sourceFile's
+// `// run:deps` comments, available to it: the locked versions when its
+// lock matches the declarations, or else freshly resolved ones, which are
+// then locked.
+func prepareNodeDeps(sourceFile string, deps []string) error {
+	lock, state, err := readDepsLock(sourceFile, deps)
+	if err != nil {
+		return err
+	}
+	dir := nodeDepsDir(sourceFile)
+	switch {
+	case updateDeps:
+	case state == depsLockCurrent:
+		return restoreNodeDeps(sourceFile, lock)
+	case frozenDeps:
+		return frozenError(sourceFile, state)
+	}
+	if state != depsLockMissing || updateDeps {
+		// Packages installed for other declarations would count as
+		// installed already
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if err := installNodeDeps(sourceFile, deps); err != nil {
+		return err
+	}
+	return writeNodeLock(sourceFile, deps)
+}
+
+// frozenError is the error of --frozen for a lock in state.
+func frozenError(sourceFile, state string) *runError {
+	what := "has no lock"
+	if state == depsLockStale {
+		what = "declares other packages than its lock records"
+	}
+	return newRunError("stale-lock", exitUnsupported, "%s %s (%s), and --frozen installs only locked packages.\nRun it once without --frozen, or with --update-deps, to lock them.",
+		sourceFile, what, depsLockPath(sourceFile))
+}
+
+// restoreNodeDeps installs the packages lock records with npm ci, unless the
+// script's package directory has them already.
+func restoreNodeDeps(sourceFile string, lock *depsLock) error {
+	dir := nodeDepsDir(sourceFile)
+	if current, err := os.ReadFile(filepath.Join(dir, "package-lock.json")); err == nil && sameJSON(current, lock.Lockfile) {
+		if _, err := os.Stat(filepath.Join(dir, "node_modules")); err == nil {
+			return nil
+		}
+	}
+	list := fmt.Sprintf("the %s locked in %s", countPackages(len(lock.Packages)), depsLockPath(sourceFile))
+	command := []string{"npm", "ci", "--no-audit", "--no-fund", "--prefix", dir}
+	if err := mayInstall(sourceFile, list, command); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	release, err := acquireLock(dir+".lock", "installing "+list)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), lock.Manifest, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), lock.Lockfile, 0o644); err != nil {
+		return err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = childEnv()
+	step := startStep("installing " + list)
+	cmd.Stdout = step
+	cmd.Stderr = step
+	err = runCmd(cmd)
+	step.Finish(err)
+	if err != nil {
+		return fmt.Errorf("npm ci failed: %w", err)
+	}
+	infof("Installed %s\n", list)
+	return nil
+}
+
+// writeNodeLock records what deps resolved to in the script's package
+// directory as the script's lock.
+func writeNodeLock(sourceFile string, deps []string) error {
+	dir := nodeDepsDir(sourceFile)
+	manifest, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return err
+	}
+	lockfile, err := os.ReadFile(filepath.Join(dir, "package-lock.json"))
+	if err != nil {
+		warnf("npm wrote no package-lock.json, so %s can't be locked.", sourceFile)
+		return nil
+	}
+	var npmLock struct {
+		Packages map[string]struct {
+			Version  string `json:"version"`
+			Link     bool   `json:"link"`
+			Resolved string `json:"resolved"`
+		} `json:"packages"`
+	}
+	json.Unmarshal(lockfile, &npmLock)
+	packages := map[string]string{}
+	for path, pkg := range npmLock.Packages {
+		// node_modules/a/node_modules/b is a second copy of b; the top
+		// level one is the one worth showing
+		if pkg.Link {
+			pkg.Version = "link:" + pkg.Resolved
+		}
+		i := strings.LastIndex(path, "node_modules/")
+		if i < 0 || pkg.Version == "" {
+			continue // The directory itself, or the target of a link
+		}
+		name := path[i+len("node_modules/"):]
+		if packages[name] != "" && strings.Count(path, "node_modules/") > 1 {
+			continue
+		}
+		packages[name] = pkg.Version
+	}
+	lock := depsLock{Version: 1, Ecosystem: "npm", Declared: deps, Packages: packages,
+		Manifest: compactJSON(manifest), Lockfile: compactJSON(lockfile)}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(depsLockPath(sourceFile), append(data, '\n'), 0o644); err != nil {
+		warnf("Cannot write %s: %v", depsLockPath(sourceFile), err)
+		return nil
+	}
+	infof("Locked %s in %s\n", countPackages(len(packages)), depsLockPath(sourceFile))
+	return nil
+}
+
+// describeNodeDeps says for --dry-run what would happen to the packages
+// deps declared in sourceFile.
+func describeNodeDeps(sourceFile string, deps []string) string {
+	lock, state, err := readDepsLock(sourceFile, deps)
+	list := strings.Join(deps, " ")
+	switch {
+	case err != nil:
+		return err.Error()
+	case updateDeps:
+		return fmt.Sprintf("Would resolve %s again and rewrite %s (--update-deps)", list, depsLockPath(sourceFile))
+	case state == depsLockCurrent:
+		return fmt.Sprintf("Would reuse the %s locked in %s", countPackages(len(lock.Packages)), depsLockPath(sourceFile))
+	case frozenDeps:
+		return frozenError(sourceFile, state).Msg
+	case state == depsLockStale:
+		return fmt.Sprintf("Would resolve %s again, as they changed since %s was written, and rewrite it", list, depsLockPath(sourceFile))
+	default:
+		return fmt.Sprintf("Would install %s and lock them in %s", list, depsLockPath(sourceFile))
+	}
+}
+
+// countPackages returns "1 package" or "n packages".
+func countPackages(n int) string {
+	if n == 1 {
+		return "1 package"
+	}
+	return fmt.Sprintf("%d packages", n)
+}
+
+// compactJSON returns data without insignificant whitespace, or data as it
+// is if it isn't JSON.
+func compactJSON(data []byte) json.RawMessage {
+	var buf bytes.Buffer
+	if json.Compact(&buf, data) != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// sameJSON reports whether a and b are the same JSON text, whitespace aside.
+func sameJSON(a, b []byte) bool {
+	return bytes.Equal(compactJSON(a), compactJSON(b))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// strictDetect makes a file whose content contradicts its extension an
+	// error instead of a warning (--strict-detect).
+	strictDetect bool
+	// noDetect skips comparing the content with the extension (--no-detect).
+	noDetect bool
+)
+
+// shebangInterpreters maps interpreter names found in `#!` lines to the
+// extension of the language they run.
+var shebangInterpreters = map[string]string{
+	"python": ".py", "python3": ".py",
+	"node": ".js", "nodejs": ".js",
+	"ruby": ".rb",
+	"bash": ".sh", "sh": ".sh", "dash": ".sh", "zsh": ".sh", "ksh": ".sh",
+	"perl":    ".pl",
+	"php":     ".php",
+	"ts-node": ".ts",
+	"lua":     ".lua",
+	"Rscript": ".r",
+	"swift":   ".swift",
+	"groovy":  ".groovy",
+	"elixir":  ".ex",
+	"dart":    ".dart",
+	"raku":    ".raku",
+	"tclsh":   ".tcl",
+	"julia":   ".jl",
+	"awk":     ".awk", "gawk": ".awk",
+}
+
+// shebangExt returns the extension of the language named in the file's `#!`
+// line, or "" when there is none or it is not recognized.
+func shebangExt(path string) string {
+	return interpreterExt(shebangLine(path))
+}
+
+// shebangLine returns the first line of path, where a `#!` line would be.
+// A pipe would lose that line to the read, so it has none.
+func shebangLine(path string) string {
+	if isStream(path) {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return line
+}
+
+// interpreterName returns the program named by a `#!` line such as
+// "#!/usr/bin/env -S python3 -u", or "" when line is not one.
+func interpreterName(line string) string {
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		// Skip env's own options, e.g. `env -S`
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				interpreter = filepath.Base(field)
+				break
+			}
+		}
+	}
+	return interpreter
+}
+
+// interpreterExt returns the extension of the language a `#!` line runs.
+func interpreterExt(line string) string {
+	interpreter := interpreterName(line)
+	if interpreter == "" {
+		return ""
+	}
+	if ext, ok := shebangInterpreters[interpreter]; ok {
+		return ext
+	}
+	// Versioned interpreters such as python3.12 or ruby3.2
+	if i := strings.IndexAny(interpreter, "0123456789"); i > 0 {
+		if ext, ok := shebangInterpreters[strings.TrimRight(interpreter[:i], ".-")]; ok {
+			return ext
+		}
+	}
+	return ""
+}
+
+// detectExt works out the language of path from its extension, falling back
+// to its shebang line.
+func detectExt(path string) (string, bool) {
+	ext := filepath.Ext(path)
+	if _, ok := languageConfigs[ext]; ok {
+		return ext, true
+	}
+	if ext := shebangExt(path); ext != "" {
+		return ext, true
+	}
+	return ext, false
+}
+
+// contentSignatures recognize a language from lines that are hard to mistake
+// for any other language. The first match wins.
+var contentSignatures = []struct {
+	Ext     string
+	Pattern *regexp.Regexp
+	Clue    string
+}{
+	{".java", regexp.MustCompile(`public\s+static\s+void\s+main\s*\(`), "public static void main"},
+	{".cs", regexp.MustCompile(`static\s+(async\s+)?(void|int|Task)\s+Main\s*\(`), "static void Main"},
+	{".go", regexp.MustCompile(`(?m)^func\s+main\s*\(\s*\)`), "func main()"},
+	{".rs", regexp.MustCompile(`(?m)^fn\s+main\s*\(\s*\)`), "fn main()"},
+	{".c", regexp.MustCompile(`(?m)^#include\s*[<"]`), "#include"},
+	{".py", regexp.MustCompile(`(?m)^if\s+__name__\s*==\s*['"]__main__['"]\s*:`), "if __name__ == '__main__'"},
+	{".php", regexp.MustCompile(`^<\?php`), "<?php"},
+}
+
+// languageFamilies group extensions whose sources legitimately share the
+// signatures above, e.g. C code in a .cpp file.
+var languageFamilies = [][]string{
+	{".c", ".cpp"},
+	{".js", ".ts"},
+	{".java", ".groovy"},
+}
+
+func sameFamily(a, b string) bool {
+	if a == b {
+		return true
+	}
+	for _, family := range languageFamilies {
+		if slices.Contains(family, a) && slices.Contains(family, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// suspectLanguage compares the start of path with the language its
+// extension ext names. When the content clearly belongs to another language,
+// it returns that language's extension and the line that gave it away.
+func suspectLanguage(path, ext string) (suspect, clue string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	head, _ := io.ReadAll(io.LimitReader(f, 64<<10))
+	content := string(head)
+
+	first, _, _ := strings.Cut(content, "\n")
+	if shebang := interpreterExt(first); shebang != "" {
+		if sameFamily(shebang, ext) {
+			return "", ""
+		}
+		return shebang, strings.TrimSpace(first)
+	}
+	for _, sig := range contentSignatures {
+		if sig.Pattern.MatchString(content) {
+			if sameFamily(sig.Ext, ext) {
+				return "", ""
+			}
+			return sig.Ext, sig.Clue
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// entryName is the file to run in a directory given to run (--entry),
+	// instead of looking for a conventional entry point.
+	entryName string
+	// entryDir is the absolute path of the directory given to run, where
+	// its entry point runs; empty when run was given a file.
+	entryDir string
+	// entryChoice says which entry point was chosen and why, for --dry-run.
+	entryChoice string
+)
+
+// entryFiles are the file names run looks for in a directory, in order of
+// priority: the first one found runs when there are several and no one to
+// ask.
+var entryFiles = []string{
+	"main.py", "app.py", "__main__.py",
+	"index.js", "main.js", "app.js", "server.js",
+	"index.ts", "main.ts",
+	"main.go",
+	"Main.java", "App.java",
+	"main.rs",
+	"main.c", "main.cpp",
+	"Program.cs",
+	"main.rb", "app.rb",
+	"index.php", "main.php",
+	"main.lua", "main.pl", "main.sh",
+}
+
+// findEntryFiles returns the conventional entry points in dir, in order of
+// priority.
+func findEntryFiles(dir string) []string {
+	var found []string
+	for _, name := range entryFiles {
+		if _, ok := languageConfigs[filepath.Ext(name)]; !ok {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Mode().IsRegular() {
+			found = append(found, filepath.Join(dir, name))
+		}
+	}
+	return found
+}
+
+// resolveEntry returns the file to run for the directory dir: the one named
+// with --entry, the only conventional entry point in it, or the one chosen
+// among several, by the user when there is a terminal to ask on and by
+// priority otherwise. It sets entryDir and entryChoice.
+func resolveEntry(dir string, dryRun bool) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	entryDir = abs
+	if entryName != "" {
+		path := filepath.Join(abs, entryName)
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return "", newRunError("file-not-found", exitNoInput, "File not found: %s (--entry in %s)", entryName, dir)
+		}
+		entryChoice = "named with --entry"
+		return path, nil
+	}
+
+	candidates := findEntryFiles(abs)
+	switch {
+	case len(candidates) == 0:
+		return "", newRunError("file-not-found", exitNoInput,
+			"%s is a directory without an entry point; run looks for %s.\nName the file to run with --entry <file>.", dir, strings.Join(entryFiles, ", "))
+	case len(candidates) == 1:
+		entryChoice = "the only entry point found"
+		return candidates[0], nil
+	}
+
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = filepath.Base(c)
+	}
+	if !dryRun && isTerminal(os.Stdin) {
+		fmt.Fprintf(os.Stderr, "%s has several entry points:\n", dir)
+		choice, err := pickFile(candidates)
+		if err != nil {
+			return "", usageError("%v", err)
+		}
+		entryChoice = "chosen among " + strings.Join(names, ", ")
+		return choice, nil
+	}
+	entryChoice = fmt.Sprintf("the first by priority of %s; --entry picks another", strings.Join(names, ", "))
+	if !dryRun {
+		infof("%s has several entry points; running %s, %s.\n", dir, names[0], entryChoice)
+	}
+	return candidates[0], nil
+}
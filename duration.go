@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// durationUnits are the units formatDuration picks from, smallest first.
+var durationUnits = []struct {
+	name string
+	size time.Duration
+}{
+	{"ns", time.Nanosecond},
+	{"µs", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+}
+
+// formatDuration renders d for people: three significant figures in the
+// largest unit that keeps the number at least 1, e.g. "1.23s", "456ms" or
+// "12.0µs", instead of %v's "1.234567891s". Without a UTF-8 locale µs is
+// written us, as some terminals garble µ. JSON output keeps nanoseconds.
+func formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d < time.Microsecond {
+		return fmt.Sprintf("%s%dns", sign, d)
+	}
+	i := len(durationUnits) - 1
+	for durationUnits[i].size > d {
+		i--
+	}
+	for {
+		v := float64(d) / float64(durationUnits[i].size)
+		digits := 2
+		switch {
+		case v >= 100:
+			digits = 0
+		case v >= 10:
+			digits = 1
+		}
+		// 999.6ms rounds to 1000ms, which is 1.00s
+		scale := math.Pow10(digits)
+		if next := i + 1; next < len(durationUnits) && math.Round(v*scale)/scale*float64(durationUnits[i].size) >= float64(durationUnits[next].size) {
+			i = next
+			continue
+		}
+		unit := durationUnits[i].name
+		if unit == "µs" && !hasUTF8Locale() {
+			unit = "us"
+		}
+		return fmt.Sprintf("%s%.*f%s", sign, digits, v, unit)
+	}
+}
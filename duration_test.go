@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	t.Setenv("LC_ALL", "C.UTF-8")
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0ns"},
+		{999 * time.Nanosecond, "999ns"},
+		{time.Microsecond, "1.00µs"},
+		{1500 * time.Nanosecond, "1.50µs"},
+		{12345 * time.Nanosecond, "12.3µs"},
+		{999499 * time.Nanosecond, "999µs"},
+		{999500 * time.Nanosecond, "1.00ms"},
+		{999950 * time.Nanosecond, "1.00ms"}, // Rounds up into the next unit
+		{12345 * time.Microsecond, "12.3ms"},
+		{999400 * time.Microsecond, "999ms"},
+		{999950 * time.Microsecond, "1.00s"},
+		{1234 * time.Millisecond, "1.23s"},
+		{59940 * time.Millisecond, "59.9s"},
+		{59990 * time.Millisecond, "1.00m"},
+		{90 * time.Second, "1.50m"},
+		{150 * time.Minute, "150m"},
+		{-1500 * time.Millisecond, "-1.50s"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%d) = %q, want %q", int64(tt.d), got, tt.want)
+		}
+	}
+
+	// Terminals without UTF-8 may garble µ
+	t.Setenv("LC_ALL", "C")
+	if got := formatDuration(1500 * time.Nanosecond); got != "1.50us" {
+		t.Errorf("formatDuration(1.5µs) without UTF-8 = %q, want %q", got, "1.50us")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// emitScript is where to write what run would do for a file as a shell
+// script, instead of doing it (--emit-script), or "-" for stdout.
+var emitScript string
+
+// scriptWriter renders the steps of a plan in one shell's syntax. Arguments
+// that contain the build directory refer to it through a variable set at
+// the top of the script, so that the script doesn't depend on the temporary
+// directory of the run that wrote it.
+type scriptWriter struct {
+	b        strings.Builder
+	buildDir string // The plan's build directory, replaced by the variable
+	ps       bool   // PowerShell rather than POSIX sh
+}
+
+func (w *scriptWriter) line(format string, a ...any) {
+	fmt.Fprintf(&w.b, format+"\n", a...)
+}
+
+// quote quotes one literal argument.
+func (w *scriptWriter) quote(s string) string {
+	if w.ps {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return posixQuote(s)
+}
+
+// arg quotes one argument, with the build directory in it as the variable.
+func (w *scriptWriter) arg(s string) string {
+	before, after, found := "", "", false
+	if w.buildDir != "" {
+		before, after, found = strings.Cut(s, w.buildDir)
+	}
+	if !found {
+		return w.quote(s)
+	}
+	if w.ps {
+		parts := []string{"$BuildDir"}
+		if before != "" {
+			parts = append([]string{w.quote(before)}, parts...)
+		}
+		if after != "" {
+			parts = append(parts, w.quote(after))
+		}
+		return "(" + strings.Join(parts, " + ") + ")"
+	}
+	out := `"$BUILD_DIR"`
+	if before != "" {
+		out = w.quote(before) + out
+	}
+	if after != "" {
+		out += w.quote(after)
+	}
+	return out
+}
+
+// command renders argv as a command line.
+func (w *scriptWriter) command(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = w.arg(a)
+	}
+	if w.ps {
+		return "& " + strings.Join(quoted, " ")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// addedEnv returns the variables a plan's commands get on top of run's own
+// environment: the UTF-8 locale and offline defaults, the modeline's and
+// the plan's.
+func addedEnv(plan execPlan) []string {
+	inherited := map[string]bool{}
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	var env []string
+	for _, kv := range childEnv() {
+		if !inherited[kv] {
+			env = append(env, kv)
+		}
+	}
+	return append(append(env, modelineEnv...), plan.Env...)
+}
+
+// notReproduced lists what the run would do that the script leaves out.
+func notReproduced(plan execPlan, ext string) []string {
+	var missing []string
+	if plan.Prepare != nil && ext != ".cs" {
+		missing = append(missing, "preparation: "+plan.PrepareDesc)
+	}
+	if plan.Compile != nil && strings.HasPrefix(shebangLine(plan.SourceFile), "#!") {
+		missing = append(missing, "building from a copy with the #! line blanked")
+	}
+	if fakeHome {
+		missing = append(missing, "the throwaway home of --fake-home")
+	}
+	if runTimeout > 0 {
+		missing = append(missing, "--timeout "+runTimeout.String())
+	}
+	if ext == ".js" && len(inlineNodeDeps(plan.SourceFile)) > 0 {
+		missing = append(missing, "installing the packages declared with // run:deps")
+	}
+	return missing
+}
+
+// writeEmittedScript writes the compile, run and cleanup steps of the plan
+// for sourceFile to emitScript as a standalone script: POSIX sh, or
+// PowerShell for a .ps1 file or with --shell pwsh or powershell. Running it
+// does what run would, with the program's stdin being the script's and its
+// arguments added to the program's.
+func writeEmittedScript(sourceFile string, config LanguageConfig, ext string) error {
+	plan := buildPlan(sourceFile, config, ext)
+	if ext == ".js" {
+		plan.Env = append(plan.Env, nodeEnv(sourceFile)...)
+	}
+	w := &scriptWriter{
+		buildDir: plan.BuildDir,
+		ps:       strings.EqualFold(filepath.Ext(emitScript), ".ps1") || slices.Contains([]string{"pwsh", "powershell"}, shellOverride),
+	}
+
+	// The command that wrote it, without --emit-script
+	var flags []string
+	for i := 1; i < len(os.Args); i++ {
+		if os.Args[i] == "--emit-script" {
+			i++
+			continue
+		}
+		flags = append(flags, os.Args[i])
+	}
+	if !w.ps {
+		w.line("#!/bin/sh")
+	}
+	w.line("# Written by run %s for:", version)
+	w.line("#   run %s", shellJoin(flags))
+	w.line("# It does what that would: the program reads this script's stdin, and")
+	w.line("# the script's arguments follow the program's own.")
+	for _, what := range notReproduced(plan, ext) {
+		w.line("# Not reproduced: %s", what)
+	}
+	w.line("")
+
+	steps := emitSteps(sourceFile, plan, ext)
+	if w.ps {
+		writePowerShell(w, plan, steps)
+	} else {
+		writePOSIX(w, plan, steps)
+	}
+
+	if emitScript == "-" {
+		fmt.Print(w.b.String())
+		return nil
+	}
+	if err := os.WriteFile(emitScript, []byte(w.b.String()), 0o755); err != nil {
+		return newRunError("error", 1, "Cannot write %s: %v", emitScript, err)
+	}
+	run := "sh " + shellQuote(emitScript)
+	if w.ps {
+		run = "pwsh -File " + shellQuote(emitScript)
+	}
+	infof("Wrote %s; run it with %s\n", emitScript, run)
+	return nil
+}
+
+// emitSteps returns the commands of the plan for sourceFile in order, the
+// last one being the program's unless --compile-only.
+func emitSteps(sourceFile string, plan execPlan, ext string) [][]string {
+	var steps [][]string
+	if plan.Prepare != nil && ext == ".cs" {
+		// What prepareDotnetProject does
+		projectDir := filepath.Dir(plan.SourceFile)
+		steps = append(steps, []string{"dotnet", "new", "console", "-o", projectDir})
+		steps = append(steps, []string{"cp", sourceFile, plan.SourceFile})
+	}
+	if plan.Compile != nil {
+		steps = append(steps, plan.Compile)
+	}
+	if !compileOnly {
+		steps = append(steps, plan.Run)
+	}
+	return steps
+}
+
+func writePOSIX(w *scriptWriter, plan execPlan, steps [][]string) {
+	w.line("set -e")
+	var cleanup []string
+	for _, path := range plan.Cleanup {
+		cleanup = append(cleanup, "rm -rf "+w.arg(path))
+	}
+	if plan.BuildDir != "" {
+		w.line("")
+		w.line("# Where the build goes: a temporary directory, or BUILD_DIR if set")
+		if keepBuild {
+			w.line(`BUILD_DIR=${BUILD_DIR:-$(mktemp -d "${TMPDIR:-/tmp}/run-XXXXXX")}`)
+		} else {
+			w.line(`REMOVE_BUILD_DIR=`)
+			w.line(`if [ -z "$BUILD_DIR" ]; then`)
+			w.line(`  BUILD_DIR=$(mktemp -d "${TMPDIR:-/tmp}/run-XXXXXX")`)
+			w.line(`  REMOVE_BUILD_DIR=1`)
+			w.line(`fi`)
+			cleanup = append(cleanup, `if [ -n "$REMOVE_BUILD_DIR" ]; then rm -rf "$BUILD_DIR"; fi`)
+		}
+		w.line(`mkdir -p "$BUILD_DIR"`)
+	}
+	if len(cleanup) > 0 {
+		w.line("trap %s EXIT", posixQuote(strings.Join(cleanup, "; ")))
+	}
+	if env := addedEnv(plan); len(env) > 0 {
+		w.line("")
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			w.line("export %s=%s", name, w.arg(value))
+		}
+	}
+	if maxCPUTime > 0 {
+		w.line("ulimit -t %d", max(int(maxCPUTime.Seconds()), 1))
+	}
+	if plan.Dir != "" {
+		w.line("cd %s", w.arg(plan.Dir))
+	}
+	w.line("")
+	for i, argv := range steps {
+		if i == len(steps)-1 && !compileOnly {
+			w.line("%s \"$@\"", w.command(argv))
+		} else {
+			w.line("%s", w.command(argv))
+		}
+	}
+}
+
+func writePowerShell(w *scriptWriter, plan execPlan, steps [][]string) {
+	w.line("$ErrorActionPreference = 'Stop'")
+	if plan.BuildDir != "" {
+		w.line("")
+		w.line("# Where the build goes: a temporary directory, or $env:BUILD_DIR if set")
+		w.line("$BuildDir = $env:BUILD_DIR")
+		if keepBuild {
+			w.line("$RemoveBuild = $false")
+		} else {
+			w.line("$RemoveBuild = -not $BuildDir")
+		}
+		w.line("if (-not $BuildDir) { $BuildDir = Join-Path ([IO.Path]::GetTempPath()) ('run-' + [guid]::NewGuid()) }")
+		w.line("New-Item -ItemType Directory -Force -Path $BuildDir | Out-Null")
+	}
+	if env := addedEnv(plan); len(env) > 0 {
+		w.line("")
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			w.line("$env:%s = %s", name, w.arg(value))
+		}
+	}
+	var cleanup []string
+	for _, path := range plan.Cleanup {
+		cleanup = append(cleanup, "Remove-Item -Recurse -Force -ErrorAction SilentlyContinue "+w.arg(path))
+	}
+	if plan.BuildDir != "" {
+		cleanup = append(cleanup, "if ($RemoveBuild) { Remove-Item -Recurse -Force -ErrorAction SilentlyContinue $BuildDir }")
+	}
+	indent := ""
+	w.line("")
+	w.line("$code = 0")
+	if len(cleanup) > 0 {
+		w.line("try {")
+		indent = "  "
+	}
+	if plan.Dir != "" {
+		w.line("%sSet-Location %s", indent, w.arg(plan.Dir))
+	}
+	for i, argv := range steps {
+		cmd := w.command(argv)
+		if i == len(steps)-1 && !compileOnly {
+			cmd += " @args"
+		}
+		if i > 0 {
+			w.line("%sif ($code -eq 0) { %s; $code = $LASTEXITCODE }", indent, cmd)
+		} else {
+			w.line("%s%s; $code = $LASTEXITCODE", indent, cmd)
+		}
+	}
+	if len(cleanup) > 0 {
+		w.line("} finally {")
+		for _, c := range cleanup {
+			w.line("  %s", c)
+		}
+		w.line("}")
+	}
+	w.line("exit $code")
+}
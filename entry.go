@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+var (
+	// assumeEntry skips the entry point check, for programs that start
+	// elsewhere, e.g. at _start (--assume-entry).
+	assumeEntry bool
+	// checkOnly compiles the file without running it (--check).
+	checkOnly bool
+	// compileOnly builds the program, keeps it and stops before running it
+	// (--compile-only).
+	compileOnly bool
+)
+
+// entryPoints match the line that declares the entry point of a program in
+// the languages that refuse to build without one. It's a cheap heuristic:
+// --assume-entry covers what it misses, such as a custom _start.
+var entryPoints = map[string]*regexp.Regexp{
+	".c":    regexp.MustCompile(`^\s*(?:[A-Za-z_]\w*[\s*]+)+(?:w?main|w?WinMain)\s*\(`),
+	".cpp":  regexp.MustCompile(`^\s*(?:[A-Za-z_][\w:]*[\s*&]+)+(?:w?main|w?WinMain)\s*\(`),
+	".rs":   regexp.MustCompile(`\bfn\s+main\s*\(|^\s*#!\[no_main\]`),
+	".go":   regexp.MustCompile(`^func\s+main\s*\(\s*\)`),
+	".java": regexp.MustCompile(`\bvoid\s+main\s*\(`),
+	".hs":   regexp.MustCompile(`^main\s*(::|=)`),
+	".zig":  regexp.MustCompile(`\bfn\s+main\s*\(`),
+}
+
+// syntaxCheckCmds check a file without building a program from it, for
+// --check. The file name is appended.
+var syntaxCheckCmds = map[string][]string{
+	".c":    {"gcc", "-fsyntax-only"},
+	".cpp":  {"g++", "-fsyntax-only"},
+	".rs":   {"rustc", "--crate-type", "lib", "--emit", "metadata", "-o", os.DevNull},
+	".go":   {"go", "vet"},
+	".java": {"javac", "-d", os.TempDir()},
+	".hs":   {"ghc", "-fno-code"},
+	".zig":  {"zig", "ast-check"},
+}
+
+// hasEntryPoint reports whether sourceFile declares an entry point, or true
+// when it can't tell.
+func hasEntryPoint(sourceFile, ext string) bool {
+	pattern, ok := entryPoints[ext]
+	if !ok {
+		return true
+	}
+	f, err := os.Open(sourceFile)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if pattern.MatchString(scanner.Text()) {
+			return true
+		}
+	}
+	return scanner.Err() != nil
+}
+
+// checkEntryPoint fails fast when sourceFile has no entry point, which would
+// otherwise surface as a cryptic linker error.
+func checkEntryPoint(sourceFile, ext string) error {
+	if assumeEntry || checkOnly || (ext == ".go" && isGoTest(sourceFile)) || hasEntryPoint(sourceFile, ext) {
+		return nil
+	}
+	if _, ok := projectPlan(sourceFile, ext); ok {
+		// The project's build tool knows where its program starts
+		return nil
+	}
+	question := "did you mean to pass the file containing main?"
+	if _, ok := syntaxCheckCmds[ext]; ok {
+		question = "did you mean to pass the file containing main, or use --check to only compile it?"
+	}
+	return newRunError("no-entry-point", exitUnsupported,
+		"No entry point found in %s — %s\nIf the program starts elsewhere (e.g. _start), pass --assume-entry.", sourceFile, question)
+}
+
+// syntaxCheckCommand returns the command that checks sourceFile for --check.
+func syntaxCheckCommand(sourceFile, ext string) []string {
+	return append(resolveRuntime(ext, syntaxCheckCmds[ext]), sourceFile)
+}
+
+// checkSyntax compiles sourceFile without building or running a program
+// (--check).
+func checkSyntax(sourceFile, ext string) error {
+	argv := syntaxCheckCommand(sourceFile, ext)
+	plan := execPlan{SourceFile: sourceFile, Run: argv}
+	cmd := plan.command(context.Background(), argv)
+	var stderr stderrCapture
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := runCmd(cmd); err != nil {
+		printSourceContext(stderr.String(), sourceFile)
+		return &runError{Status: "compile-failed", Code: exitCompileFailed,
+			Msg:         fmt.Sprintf("Check failed: %v\n  Command: %s", err, shellJoin(argv)),
+			Diagnostics: parseDiagnostics(stderr.String(), sourceFile, sourceFile)}
+	}
+	infof("%s compiles.\n", sourceFile)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// noContext disables the source snippets printed after compiler and runtime
+// errors (--no-context).
+var noContext bool
+
+// maxContextLocations caps how many error locations get a snippet.
+const maxContextLocations = 3
+
+// errorFormats are the error location formats of the common toolchains. Each
+// pattern captures the file, the line and, when the format has one, the
+// column. TabWidth is how the toolchain counts a tab in columns: gcc reports
+// display columns with 8-wide tabs, most others count bytes.
+var errorFormats = []struct {
+	Name     string
+	Pattern  *regexp.Regexp
+	TabWidth int
+}{
+	{"gcc", regexp.MustCompile(`(?m)^([^\s:][^:\n]*):(\d+):(\d+): (?:fatal )?(?:error|warning|note)`), 8}, // gcc, clang
+	{"go", regexp.MustCompile(`(?m)^([^\s:][^:\n]*\.go):(\d+):(\d+): `), 1},
+	{"rustc", regexp.MustCompile(`(?m)^\s*--> ([^:\n]+):(\d+):(\d+)`), 1},
+	{"javac", regexp.MustCompile(`(?m)^([^\s:][^:\n]*\.java):(\d+): `), 1},  // No column
+	{"tsc", regexp.MustCompile(`(?m)^([^\s(][^(\n]*)\((\d+),(\d+)\): `), 1}, // tsc, dotnet
+	{"python", regexp.MustCompile(`(?m)^\s*File "([^"]+)", line (\d+)`), 1},
+	{"protoc", regexp.MustCompile(`(?m)^([^\s:][^:\n]*\.proto):(\d+):(\d+): `), 1},
+}
+
+// errorLocation is one file:line[:col] found in a toolchain's output.
+type errorLocation struct {
+	File string
+	Line int
+	Col  int // Zero when the format has no column
+	Tab  int // Columns per tab, see errorFormats
+
+	Format string // Name of the errorFormats entry that matched
+	Pos    int    // Offset of the match in the output
+}
+
+// parseErrorLocations returns the distinct locations in output that refer
+// to a file named like source, in order of appearance.
+func parseErrorLocations(output, source string) []errorLocation {
+	var matches []errorLocation
+	for _, format := range errorFormats {
+		for _, m := range format.Pattern.FindAllStringSubmatchIndex(output, -1) {
+			loc := errorLocation{File: output[m[2]:m[3]], Tab: format.TabWidth, Format: format.Name, Pos: m[0]}
+			loc.Line, _ = strconv.Atoi(output[m[4]:m[5]])
+			if len(m) > 6 && m[6] >= 0 {
+				loc.Col, _ = strconv.Atoi(output[m[6]:m[7]])
+			}
+			if filepath.Base(loc.File) == filepath.Base(source) && loc.Line > 0 {
+				matches = append(matches, loc)
+			}
+		}
+	}
+	// Interleave the formats back into output order
+	for i := 1; i < len(matches); i++ {
+		for j := i; j > 0 && matches[j].Pos < matches[j-1].Pos; j-- {
+			matches[j], matches[j-1] = matches[j-1], matches[j]
+		}
+	}
+
+	var locations []errorLocation
+	seen := map[errorLocation]bool{}
+	for _, loc := range matches {
+		key := errorLocation{Line: loc.Line, Col: loc.Col, Tab: loc.Tab}
+		if !seen[key] {
+			seen[key] = true
+			locations = append(locations, loc)
+		}
+	}
+	return locations
+}
+
+// printSourceContext prints the lines of source around the error locations
+// found in output, with a caret under the column. It prints nothing when no
+// location is found. The raw output itself is never touched.
+func printSourceContext(output, source string) {
+	if noContext || errorFormat == "json" {
+		return // The JSON report carries the locations
+	}
+	locations := parseErrorLocations(output, source)
+	if len(locations) == 0 {
+		return
+	}
+	lines, err := readLines(source)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "\nSource context:")
+	for i, loc := range locations {
+		if i == maxContextLocations {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(locations)-i)
+			break
+		}
+		if loc.Line > len(lines) {
+			continue
+		}
+		if loc.Col > 0 {
+			fmt.Fprintf(os.Stderr, "  %s:%d:%d\n", source, loc.Line, loc.Col)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %s:%d\n", source, loc.Line)
+		}
+		width := len(strconv.Itoa(min(loc.Line+1, len(lines))))
+		for n := max(1, loc.Line-1); n <= min(loc.Line+1, len(lines)); n++ {
+			marker := " "
+			if n == loc.Line {
+				marker = ">"
+			}
+			fmt.Fprintf(os.Stderr, "  %s %*d | %s\n", marker, width, n, expandTabs(lines[n-1]))
+			if n == loc.Line && loc.Col > 0 {
+				fmt.Fprintf(os.Stderr, "    %*s | %s^\n", width, "", caretPadding(lines[n-1], loc.Col, loc.Tab))
+			}
+		}
+	}
+}
+
+// snippetTabWidth is how wide tabs are shown in snippets.
+const snippetTabWidth = 4
+
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", strings.Repeat(" ", snippetTabWidth))
+}
+
+// caretPadding returns the spaces that put a caret under column col of line
+// as shown by expandTabs, where the toolchain counted tabWidth columns per
+// tab.
+func caretPadding(line string, col, tabWidth int) string {
+	column := 1
+	for i, r := range line {
+		if column >= col {
+			return strings.Repeat(" ", len([]rune(expandTabs(line[:i]))))
+		}
+		if r == '\t' && tabWidth > 1 {
+			column += tabWidth - (column-1)%tabWidth
+		} else {
+			column += len(string(r))
+		}
+	}
+	return strings.Repeat(" ", len([]rune(expandTabs(line))))
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// stderrCapture keeps the start of a command's stderr for error parsing
+// while it is also shown to the user.
+type stderrCapture struct {
+	strings.Builder
+}
+
+// maxCapture bounds how much stderr is kept; error locations come first.
+const maxCapture = 64 << 10
+
+func (c *stderrCapture) Write(p []byte) (int, error) {
+	if room := maxCapture - c.Len(); room > 0 {
+		c.Builder.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readSample returns a toolchain output captured in testdata/errors. The
+// toolchains that weren't installed where the others were captured (javac,
+// tsc, Ruby, Lua, R and Julia) have samples written in the format they
+// print until testdata/errors/capture.sh replaces them with real output,
+// recording the versions in testdata/errors/VERSIONS.
+func readSample(t *testing.T, name string) string {
+	t.Helper()
+	out, err := os.ReadFile(filepath.Join("testdata", "errors", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestParseErrorLocations(t *testing.T) {
+	type pos struct{ Line, Col int }
+	tests := []struct {
+		sample, source string
+		want           []pos
+	}{
+		// The note repeating 5:20 is the same location
+		{"gcc", "bad.c", []pos{{4, 17}, {5, 20}}},
+		{"go", "bad.go", []pos{{6, 2}, {7, 14}}},
+		{"rustc", "bad.rs", []pos{{3, 20}, {2, 18}}},
+		{"javac", "Bad.java", []pos{{3, 0}, {2, 0}}},
+		{"tsc", "bad.ts", []pos{{2, 7}, {3, 13}}},
+		{"python", "bad.py", []pos{{4, 0}, {2, 0}}},
+		{"python_syntax", "syn.py", []pos{{1, 0}}},
+		// Only locations in the source file count, wherever it is
+		{"gcc", "/elsewhere/bad.c", []pos{{4, 17}, {5, 20}}},
+		{"gcc", "other.c", nil},
+		{"python", "other.py", nil},
+	}
+	for _, tt := range tests {
+		var got []pos
+		for _, loc := range parseErrorLocations(readSample(t, tt.sample), tt.source) {
+			got = append(got, pos{loc.Line, loc.Col})
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s output for %s: got locations %v, want %v", tt.sample, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrorLocationsFindsNothing(t *testing.T) {
+	for _, output := range []string{
+		"",
+		"Hello, world!\n",
+		"Segmentation fault (core dumped)\n",
+		"main.c:12: this is a program printing a colon-separated line\n",
+		"panic: runtime error: index out of range [3] with length 3\n",
+	} {
+		if got := parseErrorLocations(output, "main.c"); len(got) > 0 {
+			t.Errorf("found %v in %q", got, output)
+		}
+	}
+}
+
+func TestPrintSourceContext(t *testing.T) {
+	dir := t.TempDir()
+	source := writeFile(t, dir, "bad.c", "#include <stdio.h>\n\nint main(void) {\n\tint x = \"s\";\n    printf(\"%d\\n\", y);\n    return 0;\n}\n")
+	got := captureStderr(t, func() { printSourceContext(readSample(t, "gcc"), source) })
+	want := "\nSource context:\n" +
+		"  " + source + ":4:17\n" +
+		"    3 | int main(void) {\n" +
+		"  > 4 |     int x = \"s\";\n" + // The tab shows as 4 columns, and gcc's 17 counts it as 8
+		"      |             ^\n" +
+		"    5 |     printf(\"%d\\n\", y);\n" +
+		"  " + source + ":5:20\n" +
+		"    4 |     int x = \"s\";\n" +
+		"  > 5 |     printf(\"%d\\n\", y);\n" +
+		"      |                    ^\n" +
+		"    6 |     return 0;\n"
+	if got != want {
+		t.Errorf("printed:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := captureStderr(t, func() { printSourceContext("Segmentation fault\n", source) }); got != "" {
+		t.Errorf("printed context for output without locations:\n%s", got)
+	}
+}
+
+// captureStderr returns what fn writes to os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// errorFormat is how failures are reported (--error-format): "text" for
+// people, or "json" for editor plugins and other tools.
+var errorFormat = "text"
+
+// errorReportVersion is the version of the JSON error report. It changes
+// only when a field changes meaning or goes away.
+const errorReportVersion = 1
+
+// errorReport is the JSON object written to stderr for a failure under
+// --error-format json, in place of the status line.
+type errorReport struct {
+	Version     int          `json:"version"`
+	Kind        string       `json:"kind"` // The status of the status line, e.g. compile-failed
+	Message     string       `json:"message"`
+	File        string       `json:"file,omitempty"`
+	ExitCode    int          `json:"exitCode"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+// diagnostic is one compiler or runtime error in the source file.
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col,omitempty"`
+	Severity string `json:"severity"` // error, warning or note
+	Text     string `json:"text"`
+}
+
+var (
+	// locationPrefix matches the file:line:col: or file(line,col): that
+	// starts a diagnostic line.
+	locationPrefix = regexp.MustCompile(`^(?:[^:\n]*:\d+(?::\d+)?|[^(\n]*\(\d+,\d+\)):\s*`)
+	// severityPrefix matches the severity that most toolchains put before
+	// the message, e.g. "fatal error:", rustc's "error[E0308]:" or tsc's
+	// "error TS2322:".
+	severityPrefix = regexp.MustCompile(`^(?:fatal )?(error|warning|note)(?:\[\w+\]| [A-Z]+\d+)?:\s*`)
+)
+
+// parseDiagnostics returns the diagnostics in a toolchain's output that
+// refer to source, found with the same formats as the source context, and
+// reported against the file name the user gave.
+func parseDiagnostics(output, source, file string) []diagnostic {
+	diagnostics := []diagnostic{}
+	for _, loc := range parseErrorLocations(output, source) {
+		severity, text := diagnosticMessage(output, loc)
+		diagnostics = append(diagnostics, diagnostic{File: file, Line: loc.Line, Col: loc.Col, Severity: severity, Text: text})
+	}
+	return diagnostics
+}
+
+// diagnosticMessage finds the severity and message of the diagnostic at loc.
+// Most toolchains put them on the location's line; rustc puts them on the
+// line before, and Python's traceback ends with the exception.
+func diagnosticMessage(output string, loc errorLocation) (string, string) {
+	lines := strings.Split(output, "\n")
+	index := strings.Count(output[:loc.Pos], "\n")
+
+	var text string
+	switch loc.Format {
+	case "rustc":
+		for i := index - 1; i >= 0; i-- {
+			if severityPrefix.MatchString(lines[i]) {
+				text = lines[i]
+				break
+			}
+		}
+	case "python":
+		for i := len(lines) - 1; i > index; i-- {
+			if line := strings.TrimSpace(lines[i]); line != "" {
+				text = line
+				break
+			}
+		}
+	default:
+		text = locationPrefix.ReplaceAllString(lines[index], "")
+	}
+
+	severity := "error"
+	if m := severityPrefix.FindStringSubmatch(text); m != nil {
+		severity = m[1]
+		text = text[len(m[0]):]
+	}
+	return severity, strings.TrimSpace(text)
+}
+
+// reportJSON writes the JSON error report of re to stderr.
+func reportJSON(file string, re *runError) {
+	report := errorReport{
+		Version:     errorReportVersion,
+		Kind:        re.Status,
+		Message:     re.Msg,
+		File:        file,
+		ExitCode:    re.Code,
+		Diagnostics: re.Diagnostics,
+	}
+	if report.Message == "" {
+		report.Message = re.Summary
+	}
+	if report.Diagnostics == nil {
+		report.Diagnostics = []diagnostic{}
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		data = fmt.Appendf(nil, `{"version":%d,"kind":"error","message":%q}`, errorReportVersion, err.Error())
+	}
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+// parseErrorFormat validates an --error-format value.
+func parseErrorFormat(value string) (string, error) {
+	if value != "text" && value != "json" {
+		return "", fmt.Errorf("--error-format must be text or json, not %q", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		sample, source string
+		want           []diagnostic
+	}{
+		{"gcc", "bad.c", []diagnostic{
+			{"bad.c", 4, 17, "warning", "initialization of 'int' from 'char *' makes integer from pointer without a cast [-Wint-conversion]"},
+			{"bad.c", 5, 20, "error", "'y' undeclared (first use in this function)"},
+		}},
+		{"go", "bad.go", []diagnostic{
+			{"bad.go", 6, 2, "error", "declared and not used: x"},
+			{"bad.go", 7, 14, "error", "undefined: y"},
+		}},
+		{"rustc", "bad.rs", []diagnostic{
+			{"bad.rs", 3, 20, "error", "cannot find value `y` in this scope"},
+			{"bad.rs", 2, 18, "error", "mismatched types"},
+		}},
+		{"javac", "Bad.java", []diagnostic{
+			{"Bad.java", 3, 0, "error", "cannot find symbol"},
+			{"Bad.java", 2, 0, "warning", "[removal] Integer(int) in Integer has been deprecated and marked for removal"},
+		}},
+		{"tsc", "bad.ts", []diagnostic{
+			{"bad.ts", 2, 7, "error", "Type 'string' is not assignable to type 'number'."},
+			{"bad.ts", 3, 13, "error", "Cannot find name 'y'."},
+		}},
+		{"python", "bad.py", []diagnostic{
+			{"bad.py", 4, 0, "error", "ZeroDivisionError: division by zero"},
+			{"bad.py", 2, 0, "error", "ZeroDivisionError: division by zero"},
+		}},
+		{"python_syntax", "syn.py", []diagnostic{
+			{"syn.py", 1, 0, "error", "SyntaxError: invalid syntax"},
+		}},
+		{"gcc", "other.c", []diagnostic{}},
+	}
+	for _, tt := range tests {
+		got := parseDiagnostics(readSample(t, tt.sample), tt.source, tt.source)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s output for %s:\ngot  %+v\nwant %+v", tt.sample, tt.source, got, tt.want)
+		}
+	}
+}
+
+// TestErrorReportSchema runs a file that fails to compile with
+// --error-format json, and checks the report against version 1 of its
+// schema: the fields tools rely on may be added to, never renamed.
+func TestErrorReportSchema(t *testing.T) {
+	requireTools(t, "gcc")
+	dir := t.TempDir()
+	writeFile(t, dir, "bad.c", "int main(void) {\n    return y;\n}\n")
+	res := newRunEnv(t).run(t, dir, "--error-format", "json", "bad.c")
+	if res.Code != exitCompileFailed {
+		t.Fatalf("exit code %d, want %d\n%s", res.Code, exitCompileFailed, res.Stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(res.Stderr), "\n")
+	var report map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &report); err != nil {
+		t.Fatalf("the last line of stderr is not JSON: %v\n%s", err, res.Stderr)
+	}
+	want := map[string]any{"version": 1.0, "kind": "compile-failed", "file": "bad.c", "exitCode": float64(exitCompileFailed)}
+	for key, value := range want {
+		if report[key] != value {
+			t.Errorf("%s = %v, want %v", key, report[key], value)
+		}
+	}
+	if _, ok := report["message"].(string); !ok {
+		t.Errorf("message = %v, want a string", report["message"])
+	}
+	diagnostics, _ := report["diagnostics"].([]any)
+	if len(diagnostics) == 0 {
+		t.Fatalf("no diagnostics in %v", report)
+	}
+	first := diagnostics[0].(map[string]any)
+	for key, value := range map[string]any{"file": "bad.c", "line": 2.0, "col": 12.0, "severity": "error"} {
+		if first[key] != value {
+			t.Errorf("diagnostics[0].%s = %v, want %v", key, first[key], value)
+		}
+	}
+	if text, _ := first["text"].(string); !strings.Contains(text, "undeclared") {
+		t.Errorf("diagnostics[0].text = %q", text)
+	}
+}
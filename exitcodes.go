@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes of the file runner, following the sysexits.h conventions where
+// one fits. A program that fails exits run with its own exit code.
+const (
+	exitUsage              = 64  // Bad flags or no file given
+	exitUnsupported        = 65  // The file's language is not supported
+	exitNoInput            = 66  // The source file does not exist
+	exitRuntimeUnavailable = 69  // The runtime is missing and was not installed
+	exitCompileFailed      = 70  // Project preparation or compilation failed
+	exitAuditDeclined      = 77  // --audit found red flags and running was declined
+	exitBenchFailed        = 79  // A --assert-max-* threshold was exceeded, or no output verified
+	exitTimeout            = 124 // --max-cpu-time or --timeout was exceeded
+	exitRestricted         = 126 // A path is outside the --restrict-root
+)
+
+// runError is a failure of the file runner. It carries the exit code and the
+// category reported on the final status line.
+type runError struct {
+	Status string // e.g. "compile-failed"
+	Code   int
+	Msg    string // Shown to the user; empty when already printed
+
+	Summary     string       // Msg for --error-format json when Msg is empty
+	Diagnostics []diagnostic // Errors found in the toolchain's output
+}
+
+func (e *runError) Error() string {
+	if e.Msg == "" {
+		return e.Status
+	}
+	return e.Msg
+}
+
+func newRunError(status string, code int, format string, args ...any) *runError {
+	return &runError{Status: status, Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func usageError(format string, args ...any) *runError {
+	return newRunError("usage-error", exitUsage, format, args...)
+}
+
+// exitWith reports err and exits with its code. The last line written to
+// stderr is a greppable status line such as
+//
+//	run: status=compile-failed code=70 file=main.cpp
+//
+// or, with --error-format json, an errorReport on one line.
+func exitWith(file string, err error) {
+	re := &runError{Status: "error", Code: 1, Msg: err.Error()}
+	errors.As(err, &re)
+	porcelainSummary(file, re)
+	printRunProfile()
+	if asInterpreter && re.Status == "program-failed" {
+		// The script's own exit status, reported as if run had not been there
+		os.Exit(re.Code)
+	}
+	logEvent("exit", map[string]any{"status": re.Status, "code": re.Code, "file": file})
+	if errorFormat == "json" {
+		reportJSON(file, re)
+		os.Exit(re.Code)
+	}
+	if re.Msg != "" {
+		fmt.Fprintln(messageOut(), re.Msg)
+	}
+	status := fmt.Sprintf("run: status=%s code=%d", re.Status, re.Code)
+	if file != "" {
+		status += " file=" + shellQuote(file)
+	}
+	fmt.Fprintln(os.Stderr, status)
+	os.Exit(re.Code)
+}
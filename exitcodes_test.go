@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// exitCodeCase is a run that fails in one category.
+type exitCodeCase struct {
+	name   string
+	tools  []string          // Skip the case unless these are installed
+	files  map[string]string // Written to the directory it runs in
+	args   []string
+	code   int
+	status string
+}
+
+// TestExitCodes runs a failure of each category and checks run's exit code
+// and its final status line.
+func TestExitCodes(t *testing.T) {
+	env := newRunEnv(t)
+	tests := []exitCodeCase{
+		{"usage", nil, nil, []string{"--timee", "x.py"}, exitUsage, "usage-error"},
+		{"unsupported", nil, map[string]string{"notes.xyz": "hello\n"}, []string{"notes.xyz"}, exitUnsupported, "unsupported-language"},
+		{"not found", nil, nil, []string{"missing.py"}, exitNoInput, "file-not-found"},
+		{"compile failed", []string{"gcc"}, map[string]string{"bad.c": "int main(void) { return y; }\n"}, []string{"bad.c"}, exitCompileFailed, "compile-failed"},
+		{"program failed", []string{"python3"}, map[string]string{"fail.py": "import sys\nsys.exit(3)\n"}, []string{"fail.py"}, 3, "program-failed"},
+		{"timeout", []string{"python3"}, map[string]string{"slow.py": "import time\ntime.sleep(30)\n"}, []string{"--timeout", "300ms", "slow.py"}, exitTimeout, "timeout"},
+		{"bench assertion", []string{"python3"}, map[string]string{"fast.py": "print(1)\n"}, []string{"--bench", "2", "--assert-max-mean", "1ns", "fast.py"}, exitBenchFailed, "assertion-failed"},
+		{"restricted", []string{"python3"}, map[string]string{"outside.py": "print(1)\n", "jail/.keep": ""}, []string{"--restrict-root", "jail", "outside.py"}, exitRestricted, "restricted"},
+	}
+	if ext, tool, ok := missingRuntime(); ok {
+		tests = append(tests, exitCodeCase{"runtime missing (" + tool + ")", nil, map[string]string{"x" + ext: ""}, []string{"--no-install", "x" + ext}, exitRuntimeUnavailable, "runtime-unavailable"})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireTools(t, tt.tools...)
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			res := env.run(t, dir, tt.args...)
+			if res.Code != tt.code {
+				t.Errorf("exit code %d, want %d\n%s%s", res.Code, tt.code, res.Stdout, res.Stderr)
+			}
+			lines := strings.Split(strings.TrimSpace(res.Stderr), "\n")
+			want := fmt.Sprintf("run: status=%s code=%d", tt.status, tt.code)
+			if last := lines[len(lines)-1]; !strings.HasPrefix(last, want) {
+				t.Errorf("status line %q, want %q", last, want)
+			}
+		})
+	}
+}
+
+// missingRuntime returns a language whose runtime isn't installed, if any.
+func missingRuntime() (ext, tool string, ok bool) {
+	for _, ext := range supportedExtensions() {
+		check := languageConfigs[ext].CheckCmd
+		if len(check) == 0 || ext == ".tex" || ext == ".Rmd" || ext == ".qmd" {
+			continue
+		}
+		if _, err := exec.LookPath(check[0]); err != nil {
+			return ext, check[0], true
+		}
+	}
+	return "", "", false
+}
+
+// TestProgramExitCode checks that run exits with the program's own exit
+// code, compiled or not.
+func TestProgramExitCode(t *testing.T) {
+	env := newRunEnv(t)
+	tests := []struct {
+		tool, file, source string
+	}{
+		{"python3", "exit7.py", "import sys\nsys.exit(7)\n"},
+		{"node", "exit7.js", "process.exit(7);\n"},
+		{"gcc", "exit7.c", "int main(void) { return 7; }\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			requireTools(t, tt.tool)
+			dir := t.TempDir()
+			writeFile(t, dir, tt.file, tt.source)
+			if res := env.run(t, dir, tt.file); res.Code != 7 {
+				t.Errorf("exit code %d, want 7\n%s", res.Code, res.Stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+var (
+	// fakeHome runs the program with a throwaway home directory
+	// (--fake-home), so that it can't read or clobber the user's dotfiles
+	// through HOME or the XDG directories.
+	fakeHome bool
+	// showCreated lists the files the program wrote in its fake home
+	// (--show-created).
+	showCreated bool
+)
+
+// newFakeHome creates a throwaway home directory and returns it with the
+// variables that point the program at it. Anything that finds the home
+// another way, e.g. from /etc/passwd, still finds the real one.
+func newFakeHome() (string, []string, error) {
+	dir, err := os.MkdirTemp("", "run-home-")
+	if err != nil {
+		return "", nil, err
+	}
+	env := []string{
+		"HOME=" + dir,
+		"XDG_CONFIG_HOME=" + filepath.Join(dir, ".config"),
+		"XDG_CACHE_HOME=" + filepath.Join(dir, ".cache"),
+		"XDG_DATA_HOME=" + filepath.Join(dir, ".local", "share"),
+		"XDG_STATE_HOME=" + filepath.Join(dir, ".local", "state"),
+	}
+	if runtime.GOOS == "windows" {
+		env = append(env,
+			"USERPROFILE="+dir,
+			"APPDATA="+filepath.Join(dir, "AppData", "Roaming"),
+			"LOCALAPPDATA="+filepath.Join(dir, "AppData", "Local"))
+	}
+	logEvent("fake-home", map[string]any{"dir": dir})
+	return dir, env, nil
+}
+
+// addEnv adds env to cmd's environment, which is run's own when cmd.Env is
+// nil. Later entries win, so env overrides what was there.
+func addEnv(cmd *exec.Cmd, env []string) {
+	if len(env) == 0 {
+		return
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+}
+
+// finishFakeHome lists what the program wrote in the fake home dir with
+// --show-created and removes it, unless --keep keeps it for a look.
+func finishFakeHome(dir string) {
+	if showCreated {
+		var created []string
+		var total int64
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, path)
+			line := "  " + filepath.ToSlash(rel)
+			if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+				total += info.Size()
+				line += fmt.Sprintf(" (%s)", formatSize(info.Size()))
+			}
+			created = append(created, line)
+			return nil
+		})
+		if len(created) == 0 {
+			fmt.Fprintln(messageOut(), "The program wrote nothing in its fake home.")
+		} else {
+			files := "files"
+			if len(created) == 1 {
+				files = "file"
+			}
+			fmt.Fprintf(messageOut(), "The program wrote %d %s (%s) in its fake home:\n", len(created), files, formatSize(total))
+			for _, line := range created {
+				fmt.Fprintln(messageOut(), line)
+			}
+		}
+	}
+	if keepBuild {
+		fmt.Fprintf(messageOut(), "Keeping the fake home %s (--keep)\n", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		warnf("Cannot remove the fake home %s: %v", dir, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io/fs"
+	"maps"
+	"os"
+	"time"
+)
+
+// fileStamp is what a file held when it was looked at. ModTime and Size
+// change whenever it is written, Hash, of its content, only when what it
+// holds does: editors and git touch files without changing them, which is
+// no reason to build or run anything again.
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+// stampFile stamps the file at path, of which info is the current state.
+// It is only read and hashed again when its time or size differ from
+// previous, its stamp from the last look, if there was one.
+func stampFile(path string, info fs.FileInfo, previous fileStamp) (fileStamp, error) {
+	stamp := fileStamp{ModTime: info.ModTime(), Size: info.Size()}
+	if previous.ModTime.Equal(stamp.ModTime) && previous.Size == stamp.Size {
+		stamp.Hash = previous.Hash
+		return stamp, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	stamp.Hash = sha256.Sum256(content)
+	return stamp, nil
+}
+
+// sameContent reports whether two sets of stamps have the same files with
+// the same content, however their times differ.
+func sameContent(a, b map[string]fileStamp) bool {
+	return maps.EqualFunc(a, b, func(x, y fileStamp) bool { return x.Hash == y.Hash })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// firstRunMarker is written once the first-run report has been shown, so
+// that it is shown once per machine (or per data directory).
+func firstRunMarker() string {
+	return filepath.Join(dataDir(), "first-run")
+}
+
+// quietFlags are flags after which the first-run report would be noise in
+// output meant for a program.
+var quietFlags = map[string]bool{"--quiet": true, "-q": true, "--porcelain": true, "--json": true, "--output-format": true}
+
+// maybeFirstRunReport shows the first-run report if run has never run here
+// before, and only to a person: stdout must be a terminal. Failing to write
+// the marker, e.g. in a read-only data directory, only means the report is
+// shown again next time.
+func maybeFirstRunReport(args []string) {
+	if _, err := os.Stat(firstRunMarker()); err == nil || !isTerminal(os.Stdout) {
+		return
+	}
+	for _, arg := range args {
+		if quietFlags[arg] {
+			return
+		}
+	}
+	firstRunReport()
+	fmt.Println()
+	if err := os.MkdirAll(dataDir(), 0o755); err == nil {
+		os.WriteFile(firstRunMarker(), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o644)
+	}
+}
+
+// firstRunReport prints what run finds on this machine: the system, which
+// languages work already and where run keeps its files. The runtimes are
+// probed as `run doctor` does, in parallel and with its timeout.
+func firstRunReport() {
+	pm := systemPackageManager()
+	if pm == "" {
+		pm = "none found"
+	}
+	// Languages with several extensions count once
+	extensions := supportedExtensions()
+	versions := probeRuntimes(extensions)
+	all, ready := map[string]bool{}, map[string]bool{}
+	for _, ext := range extensions {
+		name := languageConfigs[ext].Name
+		all[name] = true
+		if _, ok := versions[ext]; ok {
+			ready[name] = true
+		}
+	}
+	names := sortedKeys(ready)
+
+	fmt.Println("Welcome to run! Here is what it found on this machine (run --first-run-report shows this again).")
+	fmt.Println()
+	fmt.Printf("  System:    %s/%s, package manager: %s\n", runtime.GOOS, runtime.GOARCH, pm)
+	fmt.Printf("  Languages: %d of %d ready", len(ready), len(all))
+	if len(names) > 0 {
+		fmt.Printf(" (%s)", strings.Join(names, ", "))
+	}
+	fmt.Println()
+	fmt.Printf("  Config:    %s%s\n", globalConfigPath(), missingNote(globalConfigPath()))
+	fmt.Printf("  Cache:     %s\n", cacheDir())
+	fmt.Printf("  Data:      %s\n", dataDir())
+	fmt.Println()
+	fmt.Println("  run doctor --matrix   What it takes to get the other languages working")
+	fmt.Println("  run config init       Create a config file for your defaults")
+}
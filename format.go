@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// fmtCheck reports whether the file is formatted before running it
+	// (--fmt-check).
+	fmtCheck bool
+	// fmtWrite formats the file in place before running it (--fmt).
+	fmtWrite bool
+	// noFmtWrite turns --fmt into --fmt-check, e.g. in RUN_DEFAULT_FLAGS to
+	// never have files rewritten (--no-fmt-write).
+	noFmtWrite bool
+)
+
+// formatSource checks or applies the formatting of sourceFile as asked by
+// --fmt-check and --fmt. Formatting never stops the run: a missing formatter
+// or one that fails is only a notice.
+func formatSource(sourceFile string, config LanguageConfig) {
+	if !fmtCheck && !fmtWrite {
+		return
+	}
+	write := fmtWrite && !noFmtWrite
+	argv := config.FormatCheckCmd
+	if write {
+		argv = config.FormatCmd
+	}
+	skipping := "not checking the formatting of " + sourceFile
+	if write {
+		skipping = "not formatting " + sourceFile
+	}
+	if len(argv) == 0 {
+		fmt.Fprintf(messageOut(), "No formatter is set up for %s; %s (see format and formatCheck in the config).\n", config.Name, skipping)
+		return
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		fmt.Fprintf(messageOut(), "%s not found; %s.\n", argv[0], skipping)
+		return
+	}
+
+	before, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(argv[0], append(argv[1:], sourceFile)...)
+	cmd.Env = childEnv()
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	runErr := runCmd(cmd)
+	logEvent("format", map[string]any{"file": sourceFile, "command": shellJoin(cmd.Args), "write": write})
+
+	if write {
+		if runErr != nil {
+			fmt.Fprintf(messageOut(), "%s could not format %s: %v\n", argv[0], sourceFile, runErr)
+			printFormatterOutput(output.String())
+			return
+		}
+		if after, err := os.ReadFile(sourceFile); err == nil && !bytes.Equal(before, after) {
+			fmt.Fprintf(messageOut(), "Formatted %s with %s: this modified your file.\n", sourceFile, argv[0])
+		}
+		return
+	}
+	if runErr != nil || strings.Contains(output.String(), filepath.Base(sourceFile)) {
+		fmt.Fprintf(messageOut(), "%s is not formatted according to %s (run with --fmt to format it).\n", sourceFile, argv[0])
+	}
+}
+
+// printFormatterOutput shows the first lines of what a failed formatter
+// printed, which usually name a syntax error.
+func printFormatterOutput(output string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > 5 {
+		lines = append(lines[:5], "...")
+	}
+	for _, line := range lines {
+		if line != "" {
+			fmt.Fprintf(messageOut(), "  %s\n", line)
+		}
+	}
+}
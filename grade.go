@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gradeDefaultTimeout is how long one case may run without --timeout.
+	gradeDefaultTimeout = 10 * time.Second
+	// gradeCompileTimeout bounds a submission's build, which can hang too,
+	// e.g. on runaway template instantiation.
+	gradeCompileTimeout = 2 * time.Minute
+	// gradeMaxOutput is how much of a case's output is kept; more is a
+	// failure, as no expected output is that long.
+	gradeMaxOutput = 16 << 20
+)
+
+// gradeCase is a test case: stdin from NAME.in, if there is one, and the
+// expected output in NAME.out.
+type gradeCase struct {
+	Name     string
+	Input    string // Empty for no input
+	Expected string
+}
+
+// gradeCaseResult is the outcome of one case for one submission.
+type gradeCaseResult struct {
+	Case       string `json:"case"`
+	Passed     bool   `json:"passed"`
+	DurationNs int64  `json:"durationNs"`
+	Failure    string `json:"failure,omitempty"`
+}
+
+// gradeResult is one submission's line of the report. Error is set when the
+// submission couldn't be run at all, e.g. when it doesn't compile; its cases
+// then all count as failed.
+type gradeResult struct {
+	Student    string            `json:"student"` // The submission's directory
+	File       string            `json:"file"`
+	Language   string            `json:"language"`
+	Passed     int               `json:"passed"`
+	Total      int               `json:"total"`
+	DurationNs int64             `json:"durationNs"`
+	Error      string            `json:"error,omitempty"`
+	Cases      []gradeCaseResult `json:"cases,omitempty"`
+}
+
+// failures lists what went wrong, for the table and CSV.
+func (r gradeResult) failures() []string {
+	if r.Error != "" {
+		return []string{r.Error}
+	}
+	var list []string
+	for _, c := range r.Cases {
+		if !c.Passed {
+			list = append(list, c.Case+": "+c.Failure)
+		}
+	}
+	return list
+}
+
+// gradeCommand implements `run grade --cases <dir> (--glob <pattern> | <files>...)
+// [--timeout d] [--max-cpu-time d] [--csv | --json]`: every submission runs
+// every case in a directory of its own, and one submission failing, hanging
+// or not compiling doesn't stop the others.
+func gradeCommand(args []string) error {
+	var casesDir, format string
+	var files []string
+	timeout, cpuLimit := gradeDefaultTimeout, time.Duration(0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--cases" || arg == "--glob":
+			if i+1 >= len(args) {
+				return usageError("Missing value for %s", arg)
+			}
+			if arg == "--cases" {
+				casesDir = args[i+1]
+			} else {
+				matches, err := filepath.Glob(args[i+1])
+				if err != nil {
+					return usageError("Invalid pattern for --glob: %s", args[i+1])
+				}
+				files = append(files, matches...)
+			}
+			i++
+		case arg == "--timeout" || arg == "--max-cpu-time":
+			if i+1 >= len(args) {
+				return usageError("Missing duration for %s (e.g. %s 5s)", arg, arg)
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return usageError("Invalid duration for %s: %s", arg, args[i+1])
+			}
+			if arg == "--timeout" {
+				timeout = d
+			} else {
+				cpuLimit = d
+			}
+			i++
+		case arg == "--csv" || arg == "--json":
+			format = strings.TrimPrefix(arg, "--")
+		case arg == "--with":
+			if i+1 >= len(args) {
+				return usageError("Missing value for --with (e.g. --with data.csv)")
+			}
+			dataDeps = append(dataDeps, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-"):
+			return usageError("Unknown grade option: %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if casesDir == "" || len(files) == 0 {
+		return usageError("Usage: run grade --cases <dir> (--glob <pattern> | <files>...) [--timeout d] [--max-cpu-time d] [--with path]... [--csv | --json]")
+	}
+	cases, err := loadGradeCases(casesDir)
+	if err != nil {
+		return err
+	}
+	// Every sandbox gets the --with paths of the current directory
+	if err := checkDataDeps("."); err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	// Progress goes to stderr when stdout carries the export
+	var out io.Writer = os.Stdout
+	if format != "" {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "Grading %d submissions against %d cases (timeout %s per case)...\n", len(files), len(cases), formatDuration(timeout))
+	results := make([]gradeResult, 0, len(files))
+	for _, file := range files {
+		r := gradeSubmission(file, cases, timeout, cpuLimit)
+		if re := interruptError(); re != nil {
+			return re
+		}
+		status := fmt.Sprintf("%d/%d", r.Passed, r.Total)
+		if r.Error != "" {
+			status = r.Error
+		}
+		fmt.Fprintf(out, "  %s (%s): %s\n", r.Student, r.Language, status)
+		results = append(results, r)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"student", "file", "language", "passed", "total", "time_ms", "failures"})
+		for _, r := range results {
+			w.Write([]string{r.Student, r.File, r.Language, strconv.Itoa(r.Passed), strconv.Itoa(r.Total),
+				strconv.FormatInt(time.Duration(r.DurationNs).Milliseconds(), 10), strings.Join(r.failures(), "; ")})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	printGradeReport(results)
+	return nil
+}
+
+// loadGradeCases reads the cases in dir: every NAME.out, with NAME.in as its
+// input if it exists, in natural order (2 before 10).
+func loadGradeCases(dir string) ([]gradeCase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, newRunError("file-not-found", exitNoInput, "Cannot read the cases: %v", err)
+	}
+	var cases []gradeCase
+	for _, entry := range entries {
+		name, ext := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())), filepath.Ext(entry.Name())
+		input := filepath.Join(dir, name+".in")
+		switch ext {
+		case ".out":
+			c := gradeCase{Name: name, Expected: filepath.Join(dir, entry.Name())}
+			if _, err := os.Stat(input); err == nil {
+				c.Input = input
+			}
+			cases = append(cases, c)
+		case ".in":
+			if _, err := os.Stat(filepath.Join(dir, name+".out")); err != nil {
+				warnf("%s has no %s.out with the expected output. Skipping it.", input, name)
+			}
+		}
+	}
+	if len(cases) == 0 {
+		return nil, usageError("No cases in %s: each needs NAME.out with the expected output and, for input, NAME.in.", dir)
+	}
+	sort.Slice(cases, func(i, j int) bool {
+		a, errA := strconv.Atoi(cases[i].Name)
+		b, errB := strconv.Atoi(cases[j].Name)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return cases[i].Name < cases[j].Name
+	})
+	return cases, nil
+}
+
+// gradeSubmission builds file in a directory of its own and runs every case
+// there.
+func gradeSubmission(file string, cases []gradeCase, timeout, cpuLimit time.Duration) gradeResult {
+	r := gradeResult{Student: filepath.Dir(file), File: file, Language: "?", Total: len(cases)}
+	ext, ok := detectExt(file)
+	config, supported := languageConfigs[ext]
+	if !ok || !supported {
+		r.Error = "unsupported file type"
+		return r
+	}
+	r.Language = config.Name
+	if !checkRuntime(config.CheckCmd) {
+		r.Error = fmt.Sprintf("runtime '%s' is not installed", config.CheckCmd[0])
+		return r
+	}
+
+	// A copy in a fresh directory, so that what one submission writes
+	// can't be seen by the next
+	sandbox, err := os.MkdirTemp("", "run-grade-")
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer os.RemoveAll(sandbox)
+	source := filepath.Join(sandbox, filepath.Base(file))
+	content, err := os.ReadFile(file)
+	if err == nil {
+		err = os.WriteFile(source, content, 0o600)
+	}
+	if err == nil {
+		err = placeDataDeps(".", sandbox)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	plan, removeCopy, err := shebangSafePlan(source, config, ext)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer removeCopy()
+	plan.Dir = sandbox
+	if err := plan.makeBuildDir(); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer plan.cleanup()
+	if err := buildSubmission(plan); err != nil {
+		// Messages name the submission, not its copy
+		r.Error = strings.ReplaceAll(err.Error(), source, file)
+		return r
+	}
+
+	for _, c := range cases {
+		result := runGradeCase(plan, c, timeout, cpuLimit)
+		if result.Passed {
+			r.Passed++
+		}
+		r.DurationNs += result.DurationNs
+		r.Cases = append(r.Cases, result)
+		if interruptError() != nil {
+			break
+		}
+	}
+	return r
+}
+
+// buildSubmission runs the preparation and compile steps of plan, keeping
+// their output for the error.
+func buildSubmission(plan execPlan) error {
+	var output cappedBuffer
+	output.limit = 64 << 10
+	ctx, cancel := context.WithTimeout(context.Background(), gradeCompileTimeout)
+	defer cancel()
+	if plan.Prepare != nil {
+		if err := plan.Prepare(&output); err != nil {
+			return fmt.Errorf("preparation failed: %v", err)
+		}
+	}
+	if plan.Compile == nil {
+		return nil
+	}
+	cmd := plan.command(ctx, plan.Compile)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := runCmd(cmd); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("compilation timed out after %s", formatDuration(gradeCompileTimeout))
+		}
+		// The first error says most; the lines before it are often context
+		// such as "In function 'main':"
+		lines := strings.Split(strings.TrimSpace(output.Buffer.String()), "\n")
+		first := lines[0]
+		for _, line := range lines {
+			if strings.Contains(strings.ToLower(line), "error") {
+				first = line
+				break
+			}
+		}
+		msg := "compilation failed"
+		if first = strings.TrimSpace(first); first != "" {
+			msg += ": " + clip(first, 100)
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// runGradeCase runs plan's program on c and compares its output.
+func runGradeCase(plan execPlan, c gradeCase, timeout, cpuLimit time.Duration) gradeCaseResult {
+	result := gradeCaseResult{Case: c.Name}
+	expected, err := os.ReadFile(c.Expected)
+	if err != nil {
+		result.Failure = err.Error()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := plan.command(ctx, plan.Run)
+	// The timeout must reach whatever the program started
+	ownProcessGroup(cmd)
+	if c.Input != "" {
+		input, err := os.Open(c.Input)
+		if err != nil {
+			result.Failure = err.Error()
+			return result
+		}
+		defer input.Close()
+		cmd.Stdin = input
+	}
+	stdout := &cappedBuffer{limit: gradeMaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = io.Discard
+	start := time.Now()
+	err = runProgram(cmd, childLimits{CPU: cpuLimit})
+	result.DurationNs = int64(time.Since(start))
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Failure = "timed out after " + formatDuration(timeout)
+	case err != nil && cpuLimit > 0 && cpuLimitExceeded(cmd.ProcessState, cpuLimit):
+		result.Failure = "CPU time limit exceeded"
+	case errors.As(err, &exitErr):
+		if sig, ok := killedBy(exitErr.ProcessState); ok {
+			result.Failure = fmt.Sprintf("killed by signal %d (%v)", int(sig), sig)
+			if known, ok := crashSignals[sig]; ok {
+				result.Failure = fmt.Sprintf("killed by %s (%v)", known.name, sig)
+			}
+		} else {
+			result.Failure = fmt.Sprintf("exit status %d", exitErr.ExitCode())
+		}
+	case err != nil:
+		result.Failure = err.Error()
+	case stdout.truncated:
+		result.Failure = fmt.Sprintf("output over %s", formatSize(gradeMaxOutput))
+	default:
+		result.Failure = compareOutput(stdout.Bytes(), expected)
+		result.Passed = result.Failure == ""
+	}
+	return result
+}
+
+// compareOutput describes the first difference between got and want, or
+// returns "" when they match. Line endings, trailing spaces and trailing
+// blank lines don't count, as they rarely matter to a grader and are easy
+// to get wrong.
+func compareOutput(got, want []byte) string {
+	g, w := outputLines(got), outputLines(want)
+	for i := 0; i < max(len(g), len(w)); i++ {
+		switch {
+		case i >= len(g):
+			return fmt.Sprintf("wrong answer: output ends at line %d, want %q", i+1, clip(w[i], 40))
+		case i >= len(w):
+			return fmt.Sprintf("wrong answer: extra output at line %d: %q", i+1, clip(g[i], 40))
+		case g[i] != w[i]:
+			return fmt.Sprintf("wrong answer at line %d: got %q, want %q", i+1, clip(g[i], 40), clip(w[i], 40))
+		}
+	}
+	return ""
+}
+
+func outputLines(b []byte) []string {
+	text := strings.TrimRight(strings.ReplaceAll(string(b), "\r\n", "\n"), " \t\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return lines
+}
+
+// clip shortens s to at most n runes for a one-line report.
+func clip(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func printGradeReport(results []gradeResult) {
+	width := len("Student")
+	for _, r := range results {
+		width = max(width, len(r.Student))
+	}
+	fmt.Println()
+	fmt.Printf("%-*s  %-12s %7s %8s  %s\n", width, "Student", "Language", "Passed", "Time", "Failures")
+	fmt.Println(strings.Repeat("-", width+50))
+	for _, r := range results {
+		failures := r.failures()
+		summary := ""
+		if len(failures) > 0 {
+			summary = failures[0]
+			if len(failures) > 1 {
+				summary += fmt.Sprintf(" (+%d more)", len(failures)-1)
+			}
+		}
+		elapsed := "-"
+		if r.Error == "" {
+			elapsed = formatDuration(time.Duration(r.DurationNs))
+		}
+		fmt.Printf("%-*s  %-12s %7s %8s  %s\n", width, r.Student, r.Language,
+			fmt.Sprintf("%d/%d", r.Passed, r.Total), elapsed, summary)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHealthEntries caps the health file; the languages seen longest ago
+	// are dropped first.
+	maxHealthEntries = 200
+	// maxVersionLength caps the version kept per language, as some check
+	// commands print a whole banner.
+	maxVersionLength = 80
+	// probeTimeout bounds a runtime's check command, so that a runtime that
+	// hangs, e.g. a shim waiting for input, counts as missing instead of
+	// stalling doctor.
+	probeTimeout = 5 * time.Second
+)
+
+// healthRecord is the last time a language's runtime check succeeded and
+// the version it reported then.
+type healthRecord struct {
+	LastOK  time.Time `json:"lastOK"`
+	Version string    `json:"version,omitempty"`
+}
+
+// languageHealth is the state of a language for `run doctor` and
+// `run --list --check`. Regressed means its check fails now but succeeded
+// before, typically after an OS or toolchain upgrade.
+type languageHealth struct {
+	Installed bool       `json:"installed"`
+	Regressed bool       `json:"regressed,omitempty"`
+	LastOK    *time.Time `json:"lastOK,omitempty"`
+	Version   string     `json:"version,omitempty"`
+}
+
+// versionPattern picks the version out of a check command's banner, e.g.
+// go1.22.1 from "go version go1.22.1 linux/amd64".
+var versionPattern = regexp.MustCompile(`[A-Za-z]*v?\d+(?:\.\d+)+[\w+-]*`)
+
+func healthFile() string {
+	return filepath.Join(dataDir(), "health.json")
+}
+
+// probeRuntime runs a language's check command and returns the version it
+// reported, as far as it can be told.
+func probeRuntime(cmdArgs []string) (string, bool) {
+	bin := cmdArgs[0]
+	if path := brewBinary(bin); path != "" {
+		bin = path
+	}
+	if path := windowsShim(bin); path != "" {
+		bin = path
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	var out strings.Builder
+	cmd := exec.CommandContext(ctx, bin, cmdArgs[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Don't wait on a child it left holding the output open either
+	cmd.WaitDelay = time.Second
+	if runCmd(cmd) != nil {
+		return "", false
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+	if v := versionPattern.FindString(line); v != "" {
+		line = v
+	}
+	if len(line) > maxVersionLength {
+		line = line[:maxVersionLength]
+	}
+	return strings.TrimSpace(line), true
+}
+
+// recordHealthy notes that the runtime of each language in versions, mapped
+// to the version it reported, was working just now. Failures are ignored
+// since the record is informational only.
+func recordHealthy(versions map[string]string) {
+	if len(versions) == 0 {
+		return
+	}
+	unlock, err := lockHealth()
+	if err != nil {
+		logEvent("health", map[string]any{"error": err.Error()})
+		return
+	}
+	defer unlock()
+
+	records := readHealth()
+	now := time.Now().UTC()
+	for ext, version := range versions {
+		records[ext] = healthRecord{LastOK: now, Version: version}
+	}
+	if len(records) > maxHealthEntries {
+		exts := make([]string, 0, len(records))
+		for ext := range records {
+			exts = append(exts, ext)
+		}
+		sort.Slice(exts, func(i, j int) bool { return records[exts[i]].LastOK.Before(records[exts[j]].LastOK) })
+		for _, ext := range exts[:len(exts)-maxHealthEntries] {
+			delete(records, ext)
+		}
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return
+	}
+	// Replace the file in one step so readers never see half of it
+	tmp, err := os.CreateTemp(dataDir(), "health-*.json")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	tmp.Close()
+	if err != nil || os.Rename(tmp.Name(), healthFile()) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// lockHealth serializes updates of the health file between concurrent runs
+// with a lock file. A lock older than a few seconds was left behind by a
+// run that died and is taken over.
+func lockHealth() (func(), error) {
+	if err := os.MkdirAll(dataDir(), 0o755); err != nil {
+		return nil, err
+	}
+	lock := healthFile() + ".lock"
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > 10*time.Second {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another run", lock)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// readHealth returns the recorded health of every language, keyed by
+// extension.
+func readHealth() map[string]healthRecord {
+	records := map[string]healthRecord{}
+	if data, err := os.ReadFile(healthFile()); err == nil {
+		json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+// probeRuntimes probes the runtimes of the given languages in parallel and
+// returns the versions of the ones that are installed.
+func probeRuntimes(extensions []string) map[string]string {
+	versions := make(map[string]string, len(extensions))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ext := range extensions {
+		wg.Add(1)
+		go func(ext string) {
+			defer wg.Done()
+			if version, ok := probeRuntime(languageConfigs[ext].CheckCmd); ok {
+				mu.Lock()
+				versions[ext] = version
+				mu.Unlock()
+			}
+		}(ext)
+	}
+	wg.Wait()
+	return versions
+}
+
+// checkHealth checks the runtimes of the given languages in parallel,
+// records the ones that work and compares the rest with their last good
+// state.
+func checkHealth(extensions []string) map[string]languageHealth {
+	previous := readHealth()
+	versions := probeRuntimes(extensions)
+	health := make(map[string]languageHealth, len(extensions))
+	working := map[string]string{}
+	for _, ext := range extensions {
+		version, ok := versions[ext]
+		h := languageHealth{Installed: ok, Version: version}
+		if ok {
+			now := time.Now().UTC()
+			h.LastOK = &now
+			working[ext] = version
+		} else if rec, seen := previous[ext]; seen {
+			h.Regressed = true
+			h.LastOK = &rec.LastOK
+			h.Version = rec.Version
+		}
+		health[ext] = h
+	}
+	recordHealthy(working)
+	return health
+}
+
+// describeHealth renders h for a table, e.g. "regressed, last OK 3 days ago
+// (go1.22.1)".
+func describeHealth(h languageHealth) string {
+	switch {
+	case h.Installed && h.Version != "":
+		return "ok (" + h.Version + ")"
+	case h.Installed:
+		return "ok"
+	case h.Regressed:
+		s := "regressed, last OK " + ago(*h.LastOK)
+		if h.Version != "" {
+			s += " (" + h.Version + ")"
+		}
+		return s
+	default:
+		return "not installed"
+	}
+}
+
+// ago describes how long ago t was in the largest whole unit.
+func ago(t time.Time) string {
+	d := time.Since(t)
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name + " ago"
+		}
+		return fmt.Sprintf("%d %ss ago", n, name)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return unit(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return unit(int(d/time.Hour), "hour")
+	default:
+		return unit(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// doctorEnvironment describes what, besides the runtimes, changes how run
+// runs programs here: offline mode and WSL.
+func doctorEnvironment() []string {
+	lines := []string{"Offline mode: off"}
+	if offline {
+		lines[0] = "Offline mode: on (no installs, downloads or module fetches)"
+	}
+	switch distro := wslDistro(); distro {
+	case "":
+		lines = append(lines, "WSL: not detected")
+	case "WSL":
+		lines = append(lines, "WSL: detected (distribution unknown)")
+	default:
+		lines = append(lines, "WSL: "+distro)
+	}
+	return lines
+}
+
+// doctorCommand implements `run doctor [--changed] [--json] [--offline] [exts...]`: the
+// health of every language's runtime, flagging the ones that stopped
+// working since they last did, and the environment run sees them in. It
+// exits with 1 when any regressed. With --matrix it shows what it takes to
+// get each language working instead.
+func doctorCommand(args []string) {
+	var changedOnly, asJSON, matrix bool
+	var extensions []string
+	for _, arg := range args {
+		switch arg {
+		case "--changed":
+			changedOnly = true
+		case "--json":
+			asJSON = true
+		case "--matrix":
+			matrix = true
+		case "--offline":
+			offline = true
+		default:
+			ext := normalizeExt(arg)
+			if _, ok := languageConfigs[ext]; !ok {
+				fmt.Printf("Unsupported language: %s\n", arg)
+				os.Exit(exitUsage)
+			}
+			extensions = append(extensions, ext)
+		}
+	}
+	if matrix {
+		matrixCommand(extensions, asJSON)
+	}
+	if len(extensions) == 0 {
+		extensions = supportedExtensions()
+	}
+
+	health := checkHealth(extensions)
+	var shown []string
+	regressed := 0
+	for _, ext := range extensions {
+		if health[ext].Regressed {
+			regressed++
+		}
+		if !changedOnly || health[ext].Regressed {
+			shown = append(shown, ext)
+		}
+	}
+
+	if asJSON {
+		out := make(map[string]languageHealth, len(shown))
+		for _, ext := range shown {
+			out[ext] = health[ext]
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	} else if len(shown) == 0 {
+		fmt.Println("No language stopped working since it last did.")
+	} else {
+		fmt.Printf("%-10s %-15s %s\n", "Extension", "Runtime", "Status")
+		fmt.Println(strings.Repeat("-", 70))
+		for _, ext := range shown {
+			status := describeHealth(health[ext])
+			if path := windowsToolchain(languageConfigs[ext].CheckCmd[0]); path != "" {
+				status += " (Windows toolchain: " + path + ")"
+			}
+			fmt.Printf("%-10s %-15s %s\n", ext, languageConfigs[ext].CheckCmd[0], status)
+		}
+		if regressed > 0 {
+			fmt.Printf("\n%d language(s) regressed: their runtime worked before but fails its check now.\n", regressed)
+		}
+	}
+	if !asJSON && !changedOnly {
+		fmt.Println()
+		for _, line := range doctorEnvironment() {
+			fmt.Println(line)
+		}
+	}
+	if regressed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestDoctorEnvironment checks that run doctor shows offline mode, from
+// RUN_OFFLINE and from --offline, and the result of WSL detection.
+func TestDoctorEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		want string
+	}{
+		{"online", []string{"RUN_OFFLINE="}, nil, "Offline mode: off"},
+		{"RUN_OFFLINE", []string{"RUN_OFFLINE=1"}, nil, "Offline mode: on"},
+		{"--offline", []string{"RUN_OFFLINE="}, []string{"--offline"}, "Offline mode: on"},
+		{"WSL", []string{"WSL_DISTRO_NAME=Ubuntu-22.04"}, nil, "WSL: Ubuntu-22.04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "WSL" && runtime.GOOS != "linux" {
+				t.Skip("WSL is only detected on Linux")
+			}
+			env := newRunEnv(t)
+			env.Env = append(env.Env, tt.env...)
+			args := append([]string{"doctor", ".py"}, tt.args...)
+			result := env.run(t, t.TempDir(), args...)
+			if !strings.Contains(result.Stdout, tt.want) {
+				t.Errorf("run %s printed no %q:\n%s", strings.Join(args, " "), tt.want, result.Stdout)
+			}
+			if !strings.Contains(result.Stdout, "WSL: ") {
+				t.Errorf("run %s shows no WSL detection:\n%s", strings.Join(args, " "), result.Stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// heartbeat is how often a status line is written while the program runs
+// (--heartbeat), so that CI watchdogs that kill silent jobs leave long runs
+// alone. Zero disables it.
+var heartbeat time.Duration
+
+// processStats is the resource use of a process and its descendants.
+type processStats struct {
+	RSS int64         // Bytes
+	CPU time.Duration // User and system time so far
+}
+
+// startHeartbeat writes a line to stderr every heartbeat interval with the
+// time pid has been running and, where the platform tells, its memory and
+// CPU use. The returned function stops it; no line is written after it
+// returns.
+func startHeartbeat(pid int) func() {
+	if heartbeat <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	ticker := time.NewTicker(heartbeat)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			// The leading newline keeps the line off a partial line of
+			// the program's output
+			line := fmt.Sprintf("\n[run] still running after %s", time.Since(start).Round(time.Second))
+			if stats, ok := readProcessStats(pid); ok {
+				line += fmt.Sprintf(", RSS %s, CPU %s", formatSize(stats.RSS), stats.CPU.Round(100*time.Millisecond))
+			}
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
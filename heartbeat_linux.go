@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat, USER_HZ,
+// which is 100 on every Linux architecture Go supports.
+const clockTicks = 100
+
+// readProcessStats sums the resident memory and CPU time of pid and all of
+// its descendants, as the program is often run through a driver such as
+// go run that starts the real process.
+func readProcessStats(pid int) (processStats, bool) {
+	paths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return processStats{}, false
+	}
+	type proc struct {
+		ppid  int
+		stats processStats
+	}
+	procs := map[int]proc{}
+	pageSize := int64(os.Getpagesize())
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain spaces; the fields
+		// after it start with the state (field 3)
+		s := string(data)
+		end := strings.LastIndexByte(s, ')')
+		if end < 0 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.Fields(s[:end])[0])
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(s[end+1:])
+		if len(fields) < 22 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		rss, _ := strconv.ParseInt(fields[21], 10, 64)
+		procs[id] = proc{ppid: ppid, stats: processStats{
+			RSS: rss * pageSize,
+			CPU: time.Duration(utime+stime) * time.Second / clockTicks,
+		}}
+	}
+	if _, ok := procs[pid]; !ok {
+		return processStats{}, false
+	}
+
+	var total processStats
+	for id, p := range procs {
+		// Walk up to see whether id descends from pid
+		for cur, depth := id, 0; depth < 64; depth++ {
+			if cur == pid {
+				total.RSS += p.stats.RSS
+				total.CPU += p.stats.CPU
+				break
+			}
+			next, ok := procs[cur]
+			if !ok || next.ppid == cur {
+				break
+			}
+			cur = next.ppid
+		}
+	}
+	return total, true
+}
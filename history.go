@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxHistoryEntries caps the history file so it never grows unbounded.
+const maxHistoryEntries = 100
+
+// dataDir returns the directory where run keeps persistent state such as the
+// run history, e.g. ~/.local/share/run on Linux.
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "run")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "run")
+	}
+	return filepath.Join(home, ".local", "share", "run")
+}
+
+func historyFile() string {
+	return filepath.Join(dataDir(), "history")
+}
+
+// recordHistory appends the absolute path of sourceFile to the history.
+// Failures are ignored since history is a convenience only.
+func recordHistory(sourceFile string) {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return
+	}
+	appendHistory(abs)
+}
+
+// appendHistory adds entry as the most recent run.
+func appendHistory(entry string) {
+	entries := readHistory()
+	entries = append(entries, entry)
+	if len(entries) > maxHistoryEntries {
+		entries = entries[len(entries)-maxHistoryEntries:]
+	}
+	if err := os.MkdirAll(dataDir(), 0o755); err != nil {
+		return
+	}
+	os.WriteFile(historyFile(), []byte(strings.Join(entries, "\n")+"\n"), 0o644)
+}
+
+// readHistory returns the recorded source files and --sh commands, oldest
+// first.
+func readHistory() []string {
+	data, err := os.ReadFile(historyFile())
+	if err != nil {
+		return nil
+	}
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// lastHistory returns the most recent entry, or "" if none.
+func lastHistory() string {
+	entries := readHistory()
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[len(entries)-1]
+}
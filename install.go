@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// installRecoveries are the states a package manager is commonly left in by
+// an interrupted or conflicting install, with the command that gets it
+// going again. A nil Fix means there is nothing to run, only advice.
+var installRecoveries = []struct {
+	Pattern *regexp.Regexp
+	Problem string
+	Fix     func(install []string) []string
+}{
+	{
+		regexp.MustCompile(`dpkg was interrupted`),
+		"An earlier package installation was interrupted and dpkg needs to finish configuring it.",
+		func([]string) []string { return []string{"sudo", "dpkg", "--configure", "-a"} },
+	},
+	{
+		regexp.MustCompile(`Unable to fetch some archives|404\s+Not Found`),
+		"The package lists are out of date.",
+		func([]string) []string { return []string{"sudo", "apt-get", "update"} },
+	},
+	{
+		regexp.MustCompile(`Could not get lock|is held by process`),
+		"Another package manager is running (or was killed while holding its lock). Wait for it to finish, then run again.",
+		nil,
+	},
+	{
+		regexp.MustCompile("The `brew link` step did not complete|Could not symlink"),
+		"Homebrew installed the package but could not link it, usually because of files left by another install.",
+		func(install []string) []string {
+			return []string{"brew", "link", "--overwrite", install[len(install)-1]}
+		},
+	},
+}
+
+// installRecovery recognizes a recoverable state in the output of a failed
+// install command and returns the command that fixes it, if any, and what
+// went wrong. The problem is empty when the failure is not recognized.
+func installRecovery(install []string, output string) ([]string, string) {
+	for _, r := range installRecoveries {
+		if !r.Pattern.MatchString(output) {
+			continue
+		}
+		logEvent("install-recovery", map[string]any{"install": install, "problem": r.Problem})
+		if r.Fix == nil {
+			return nil, r.Problem
+		}
+		return r.Fix(install), r.Problem
+	}
+	return nil, ""
+}
+
+// runInstaller runs an install command behind a progress step and returns
+// its combined output, which is also shown when it fails.
+func runInstaller(cmdArgs []string) (string, error) {
+	if cmdArgs[0] == "sudo" {
+		// Ask for the password now rather than underneath the spinner
+		sudo := exec.Command("sudo", "-v")
+		sudo.Stdin = os.Stdin
+		sudo.Stderr = os.Stderr
+		if err := runCmd(sudo); err != nil {
+			return "", err
+		}
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	step := startStep("installing " + cmdArgs[len(cmdArgs)-1])
+	cmd.Stdout = io.MultiWriter(step, &output)
+	cmd.Stderr = cmd.Stdout
+	err := runCmd(cmd)
+	step.Finish(err)
+	return output.String(), err
+}
+
+// saveInstallLog keeps the full output of a failed install for later
+// inspection and returns where, or "" if it can't be saved.
+func saveInstallLog(cmdArgs []string, output string, err error) string {
+	dir := filepath.Join(dataDir(), "logs")
+	if os.MkdirAll(dir, 0o755) != nil {
+		return ""
+	}
+	f, createErr := os.CreateTemp(dir, "install-"+time.Now().Format("20060102-150405")+"-*.log")
+	if createErr != nil {
+		return ""
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "$ %s\n# %v\n\n%s\n", shellJoin(cmdArgs), err, strings.TrimRight(output, "\n"))
+	return f.Name()
+}
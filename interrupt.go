@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	interruptMu sync.Mutex
+	// interruptedBy is the signal that interrupted run while the program
+	// ran, or nil.
+	interruptedBy os.Signal
+)
+
+// noteInterrupt records that sig was sent to run while the program ran.
+func noteInterrupt(sig os.Signal) {
+	interruptMu.Lock()
+	if interruptedBy == nil {
+		interruptedBy = sig
+	}
+	interruptMu.Unlock()
+}
+
+// interruptError is the error to stop with once the program has exited and
+// its artifacts are cleaned up, if run was interrupted: status interrupted
+// and the shell's exit code for the signal, 130 for Ctrl+C.
+func interruptError() *runError {
+	interruptMu.Lock()
+	sig := interruptedBy
+	interruptMu.Unlock()
+	if sig == nil {
+		return nil
+	}
+	code := 130
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	return newRunError("interrupted", code, "Interrupted (%v)", sig)
+}
+
+// watchInterrupts records SIGINT and SIGTERM instead of dying of them until
+// the returned function is called, for loops such as the benchmark's that
+// start many short commands and stop between two of them.
+func watchInterrupts() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				noteInterrupt(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "time"
+
+// maxCPUTime is the CPU-time budget of the executed program
+// (--max-cpu-time); zero means unlimited. Unlike a wall-clock timeout it
+// lets a program sleep or wait on I/O as long as it likes. The limit applies
+// to the direct child only, not to processes it detaches.
+var maxCPUTime time.Duration
+
+// childLimits are the resource limits applied to the executed program.
+type childLimits struct {
+	CPU    time.Duration // Zero means unlimited
+	Memory int64         // Bytes of data memory; zero means unlimited
+	Core   bool          // Allow the program to write a core dump (--core-dump)
+}
+
+func (l childLimits) any() bool {
+	return l.CPU > 0 || l.Memory > 0 || l.Core
+}
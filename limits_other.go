@@ -0,0 +1,42 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// rlimitsSupported reports whether childLimits are enforced by the kernel.
+// Elsewhere --max-cpu-time falls back to a wall-clock timeout and
+// --core-dump and memory limits have no effect.
+const rlimitsSupported = false
+
+func startLimited(cmd *exec.Cmd, limits childLimits) error {
+	return cmd.Start()
+}
+
+func cpuLimitExceeded(state *os.ProcessState, limit time.Duration) bool {
+	return false
+}
+
+func coreDumped(state *os.ProcessState) bool {
+	return false
+}
+
+func exitCode(state *os.ProcessState) int {
+	if state == nil || state.ExitCode() < 0 {
+		return 1
+	}
+	return state.ExitCode()
+}
+
+func killedBy(state *os.ProcessState) (syscall.Signal, bool) {
+	return 0, false
+}
+
+func peakMemory(state *os.ProcessState) int64 {
+	return 0
+}
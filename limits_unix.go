@@ -0,0 +1,149 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rlimitsSupported reports whether childLimits are enforced by the kernel.
+const rlimitsSupported = true
+
+var rlimitMu sync.Mutex
+
+// startLimited starts cmd with the given limits. Resource limits can't be
+// set for a child directly, so they are set on run itself just around the
+// fork, inherited by the child, and restored right after. Only the soft
+// limits are lowered: a process can't raise its hard limits again, and a
+// long-lived run such as run serve would end up bound by them itself. Where
+// the kernel allows it, the child's hard limits follow once it has started
+// (see hardenLimits).
+//
+// The CPU limit is rounded up to whole seconds; the kernel sends SIGXCPU at
+// the soft limit and SIGKILL one second later, at the hard one. The memory
+// limit is on the data segment rather than the address space, which the
+// JVM, .NET and V8 reserve far more of than they use; an allocation past it
+// fails, e.g. with Python's MemoryError. Linux counts memory from mmap too,
+// macOS only brk.
+func startLimited(cmd *exec.Cmd, limits childLimits) error {
+	rlimitMu.Lock()
+	defer rlimitMu.Unlock()
+
+	var restore []func()
+	defer func() {
+		for _, fn := range restore {
+			fn()
+		}
+	}()
+	set := func(resource int, limit syscall.Rlimit) error {
+		var saved syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &saved); err != nil {
+			return err
+		}
+		limit.Cur = min(limit.Cur, saved.Max)
+		limit.Max = saved.Max
+		if err := syscall.Setrlimit(resource, &limit); err != nil {
+			return err
+		}
+		restore = append(restore, func() { syscall.Setrlimit(resource, &saved) })
+		return nil
+	}
+
+	if limits.CPU > 0 {
+		seconds := uint64((limits.CPU + time.Second - 1) / time.Second)
+		if err := set(syscall.RLIMIT_CPU, syscall.Rlimit{Cur: seconds}); err != nil {
+			return err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := set(syscall.RLIMIT_DATA, syscall.Rlimit{Cur: uint64(limits.Memory)}); err != nil {
+			return err
+		}
+	}
+	if limits.Core {
+		// As large as the hard limit allows, i.e. unlimited when it is
+		unlimited := ^uint64(0)
+		if err := set(syscall.RLIMIT_CORE, syscall.Rlimit{Cur: unlimited, Max: unlimited}); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	hardenLimits(cmd.Process.Pid, limits)
+	return nil
+}
+
+// cpuLimitExceeded reports whether the process was killed for using up its
+// CPU budget: by SIGXCPU, or by SIGKILL once it had used the whole limit.
+func cpuLimitExceeded(state *os.ProcessState, limit time.Duration) bool {
+	if state == nil || limit <= 0 {
+		return false
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return false
+	}
+	switch ws.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		return state.UserTime()+state.SystemTime() >= limit
+	}
+	return false
+}
+
+// coreDumped reports whether the process died from a signal and dumped core.
+func coreDumped(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.CoreDump()
+}
+
+// exitCode is the shell-style exit status of a finished process: its exit
+// code, or 128 plus the signal that killed it. It is 1 when the process
+// never started.
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return 1
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// killedBy returns the signal that ended the process, if one did.
+func killedBy(state *os.ProcessState) (syscall.Signal, bool) {
+	if state == nil {
+		return 0, false
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return 0, false
+	}
+	return ws.Signal(), true
+}
+
+// peakMemory returns the most memory the process had resident, in bytes, or
+// 0 when that isn't known.
+func peakMemory(state *os.ProcessState) int64 {
+	if state == nil {
+		return 0
+	}
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return ru.Maxrss // Bytes there, kilobytes on Linux
+	}
+	return ru.Maxrss * 1024
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// noLocaleFix disables the UTF-8 environment defaults for child processes
+// (--no-locale-fix).
+var noLocaleFix bool
+
+var (
+	childEnvOnce sync.Once
+	childEnvVars []string
+	// localeFixed are the UTF-8 defaults childEnv added, for --verbose.
+	localeFixed []string
+)
+
+// hasUTF8Locale reports whether the effective character locale is UTF-8,
+// following the LC_ALL > LC_CTYPE > LANG precedence.
+func hasUTF8Locale() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			value = strings.ToLower(value)
+			return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+		}
+	}
+	return false
+}
+
+// childEnv returns the environment for executed programs, or nil to inherit
+// run's own unchanged. When no UTF-8 locale is configured (common in minimal
+// containers), it adds defaults so non-ASCII output works under Python, Java
+// and friends. In offline mode it also keeps toolchains off the network.
+func childEnv() []string {
+	childEnvOnce.Do(func() {
+		added := localeEnv()
+		if len(added) > 0 {
+			logEvent("locale-fix", map[string]any{"set": added})
+			localeFixed = added
+		}
+		added = append(added, offlineEnv()...)
+		if len(added) > 0 {
+			childEnvVars = append(os.Environ(), added...)
+		}
+	})
+	return childEnvVars
+}
+
+// localeEnv returns the UTF-8 defaults to add to the environment, if any.
+func localeEnv() []string {
+	if noLocaleFix || (runtime.GOOS != "windows" && hasUTF8Locale()) {
+		return nil
+	}
+
+	var added []string
+	set := func(name, value string) {
+		added = append(added, name+"="+value)
+	}
+
+	if runtime.GOOS == "windows" {
+		setConsoleUTF8()
+		if os.Getenv("PYTHONUTF8") == "" {
+			set("PYTHONUTF8", "1")
+		}
+	} else if os.Getenv("LC_ALL") != "" {
+		set("LC_ALL", "C.UTF-8")
+	} else {
+		set("LANG", "C.UTF-8")
+		if os.Getenv("LC_CTYPE") != "" {
+			set("LC_CTYPE", "C.UTF-8")
+		}
+	}
+	if os.Getenv("PYTHONIOENCODING") == "" {
+		set("PYTHONIOENCODING", "utf-8")
+	}
+	if opts := os.Getenv("JAVA_TOOL_OPTIONS"); !strings.Contains(opts, "-Dfile.encoding=") {
+		set("JAVA_TOOL_OPTIONS", strings.TrimSpace(opts+" -Dfile.encoding=UTF-8"))
+	}
+	return added
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// setConsoleUTF8 is only needed on Windows consoles.
+func setConsoleUTF8() {}
@@ -0,0 +1,10 @@
+package main
+
+import "syscall"
+
+// setConsoleUTF8 switches the console output code page to UTF-8 (65001) so
+// programs writing UTF-8 are displayed correctly.
+func setConsoleUTF8() {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	kernel32.NewProc("SetConsoleOutputCP").Call(65001)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// lockStale is how old a lock file must be to be taken over when it
+	// can't be told whether its owner is still running. It is shorter than
+	// lockWait, so that a waiter outlasts a lock left behind by a crash.
+	lockStale = 5 * time.Minute
+	// lockWait is how long to wait for another invocation's lock.
+	lockWait = 10 * time.Minute
+)
+
+// acquireLock takes the lock file path, which guards building what in a
+// directory that concurrent invocations share, such as a package cache. If
+// another invocation holds it, acquireLock waits for it to finish, so that
+// the caller can reuse its work instead of doing it again alongside. The
+// returned function releases the lock.
+func acquireLock(path, what string) (release func(), err error) {
+	deadline := time.Now().Add(lockWait)
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			mine, _ := f.Stat()
+			f.Close()
+			logEvent("lock", map[string]any{"path": path})
+			return func() {
+				// Unless it was taken over meanwhile, and is another's now
+				if info, err := os.Stat(path); err == nil && mine != nil && sameLock(info, mine) {
+					os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if stale, ok := lockAbandoned(path); ok {
+			logEvent("lock-stale", map[string]any{"path": path})
+			takeOverLock(path, stale)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("gave up waiting for another run %s (remove %s if none is running)", what, path)
+		}
+		if !waiting {
+			infof("Waiting for another run %s...\n", what)
+			waiting = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lockAbandoned reports whether the lock file path was left behind: the
+// process it names is gone, or it is older than lockStale. It returns the
+// file found, for takeOverLock.
+func lockAbandoned(path string) (os.FileInfo, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid > 0 && pid != os.Getpid() && !processAlive(pid) {
+			return info, true
+		}
+	}
+	return info, time.Since(info.ModTime()) > lockStale
+}
+
+// takeOverSeq tells apart the names takeOverLock moves locks to.
+var takeOverSeq atomic.Int64
+
+// takeOverLock removes the abandoned lock file stale from path. Several
+// waiters may find the same lock abandoned, and by the time one of them
+// removes it, another may have done so and taken the lock anew. So the
+// file is first moved aside, which only one of them can do to any file,
+// and removed only if it is the abandoned one; a fresh lock moved by
+// mistake is put back.
+func takeOverLock(path string, stale os.FileInfo) {
+	aside := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), takeOverSeq.Add(1))
+	if os.Rename(path, aside) != nil {
+		return // Taken over already
+	}
+	if info, err := os.Stat(aside); err == nil && !sameLock(info, stale) {
+		// Linked back rather than renamed, so that a lock taken in the
+		// meantime isn't replaced
+		if os.Link(aside, path) != nil {
+			logEvent("lock-lost", map[string]any{"path": path})
+		}
+	}
+	os.Remove(aside)
+}
+
+// sameLock reports whether a and b are the same lock file. A removed file's
+// inode may be reused by the next one at once, so its time must match too.
+func sameLock(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
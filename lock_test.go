@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLockOfDeadProcess checks that a lock left behind by a process that
+// is gone is taken over at once rather than waited out.
+func TestLockOfDeadProcess(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("whether a process is running is only known on Unix")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(self, "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "build.lock")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	release, err := acquireLock(path, "building")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %v for the lock of a process that is gone", waited)
+	}
+	if data, _ := os.ReadFile(path); string(data) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("lock file holds %q, not this process", data)
+	}
+}
+
+// TestConcurrentRuns runs the same compiled source several times at once:
+// every run succeeds, and only one of them compiles it.
+func TestConcurrentRuns(t *testing.T) {
+	requireTools(t, "gcc")
+	env := newRunEnv(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "main.c", "#include <stdio.h>\nint main(void) { puts(\"done\"); return 0; }\n")
+
+	// Started from here, since t.Fatal can't be called from the goroutines
+	cmds := make([]*exec.Cmd, 8)
+	outputs := make([][]byte, len(cmds))
+	for i := range cmds {
+		cmds[i] = env.command(t, dir, "main.c")
+	}
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], _ = cmd.CombinedOutput()
+		}()
+	}
+	wg.Wait()
+
+	compiled := 0
+	for i, cmd := range cmds {
+		out := string(outputs[i])
+		if code := cmd.ProcessState.ExitCode(); code != 0 || !strings.Contains(out, "done") {
+			t.Errorf("run %d: exit code %d\n%s", i, code, out)
+		}
+		if strings.Contains(out, "Compiling main.c") {
+			compiled++
+		}
+	}
+	if compiled != 1 {
+		t.Errorf("compiled %d times, want once", compiled)
+	}
+}
+
+// TestStaleLockContenders has two waiters find the same abandoned lock. The
+// first takes it over and locks anew; the second, taking over what it saw
+// late, must leave the new lock alone.
+func TestStaleLockContenders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.lock")
+	if err := os.WriteFile(path, []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	takeOverLock(path, stale) // The first waiter
+	release, err := acquireLock(path, "building")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	takeOverLock(path, stale) // The second, late
+	if info, err := os.Stat(path); err != nil || !os.SameFile(info, fresh) {
+		t.Fatalf("the second waiter removed the first one's lock: %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) > 0 {
+		t.Errorf("left behind %v", matches)
+	}
+
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock not released: %v", err)
+	}
+}
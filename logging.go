@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logFile receives structured diagnostic entries when --log-file or
+// RUN_LOG_FILE is set; nil disables logging.
+var (
+	logFile *os.File
+	logMu   sync.Mutex
+)
+
+// openLog starts appending JSON lines to path. Each entry is written with a
+// single write on an O_APPEND descriptor so concurrent runs sharing the file
+// never interleave within a line.
+func openLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	logFile = f
+	return nil
+}
+
+// logEvent writes one timestamped entry to the log file, if enabled.
+func logEvent(event string, fields map[string]any) {
+	if logFile == nil {
+		return
+	}
+	entry := make(map[string]any, len(fields)+3)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["event"] = event
+	entry["pid"] = os.Getpid()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	logMu.Lock()
+	logFile.Write(append(line, '\n'))
+	logMu.Unlock()
+}
+
+// traceCommands echoes every external command to stderr before it runs
+// (--trace-commands, or --verbose).
+var traceCommands bool
+
+// traceCmd prints cmd in `+ cmd arg1 arg2` shell-trace style, as
+// `+ (cd dir && cmd arg1 arg2)` when it runs in another directory.
+func traceCmd(cmd *exec.Cmd) {
+	if !traceCommands {
+		return
+	}
+	line := shellJoin(cmd.Args)
+	if cmd.Dir != "" {
+		cwd, _ := os.Getwd()
+		if dir, err := filepath.Abs(cmd.Dir); err != nil || dir != cwd {
+			line = fmt.Sprintf("(cd %s && %s)", shellQuote(cmd.Dir), line)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "+ %s\n", line)
+}
+
+// runCmd runs cmd and records it in the log. Every external command run
+// executes should go through here.
+func runCmd(cmd *exec.Cmd) error {
+	traceCmd(cmd)
+	start := time.Now()
+	err := cmd.Run()
+	logCmd(cmd, start, err)
+	return err
+}
+
+// outputCmd is the runCmd counterpart of cmd.Output.
+func outputCmd(cmd *exec.Cmd) ([]byte, error) {
+	traceCmd(cmd)
+	start := time.Now()
+	out, err := cmd.Output()
+	logCmd(cmd, start, err)
+	return out, err
+}
+
+func logCmd(cmd *exec.Cmd, start time.Time, err error) {
+	if logFile == nil {
+		return
+	}
+	fields := map[string]any{
+		"argv":       cmd.Args,
+		"cwd":        cmd.Dir,
+		"durationMs": time.Since(start).Milliseconds(),
+		"exitCode":   0,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fields["exitCode"] = exitErr.ExitCode()
+	} else if err != nil {
+		fields["exitCode"] = -1
+		fields["error"] = err.Error()
+	}
+	logEvent("exec", fields)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"testing"
+)
+
+// TestEveryCommandIsTraced checks that every external command is started in
+// a function that traces it (see traceCmd), so that --trace-commands and
+// the log see all of them. startLimited is exempt: its only caller,
+// runProgram, traces the command first.
+func TestEveryCommandIsTraced(t *testing.T) {
+	pkg, err := build.ImportDir(".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("main", fset, files, info); err != nil {
+		t.Fatal(err)
+	}
+
+	starts := map[string]bool{"Run": true, "Start": true, "Output": true, "CombinedOutput": true}
+	found := 0
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			traced := fn.Name.Name == "startLimited"
+			var started []*ast.SelectorExpr
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				switch fun := call.Fun.(type) {
+				case *ast.Ident:
+					if fun.Name == "traceCmd" {
+						traced = true
+					}
+				case *ast.SelectorExpr:
+					if starts[fun.Sel.Name] && isExecCmd(info.TypeOf(fun.X)) {
+						started = append(started, fun)
+					}
+				}
+				return true
+			})
+			found += len(started)
+			if traced {
+				continue
+			}
+			for _, sel := range started {
+				pos := fset.Position(sel.Pos())
+				t.Errorf("%s:%d: %s starts a command without traceCmd; use runCmd, outputCmd or runProgram",
+					filepath.Base(pos.Filename), pos.Line, fn.Name.Name)
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("found no command being started; the check is broken")
+	}
+}
+
+// isExecCmd reports whether typ is exec.Cmd or a pointer to one.
+func isExecCmd(typ types.Type) bool {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	return ok && named.Obj().Name() == "Cmd" && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "os/exec"
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The tests run run the way users do: the test binary acts as run when
+// started with runAsRunEnv set, so that exit codes, output and child
+// processes are the real ones.
+const runAsRunEnv = "RUN_TEST_AS_RUN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runAsRunEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runEnv is the environment of the runs of one test: configuration, caches
+// and history of its own, so that tests neither see the user's nor each
+// other's.
+type runEnv struct {
+	Env []string
+}
+
+func newRunEnv(t *testing.T) *runEnv {
+	t.Helper()
+	root := t.TempDir()
+	env := append(os.Environ(),
+		runAsRunEnv+"=1",
+		"XDG_CONFIG_HOME="+filepath.Join(root, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(root, "cache"),
+		"XDG_DATA_HOME="+filepath.Join(root, "data"),
+		"RUN_CONFIG=",
+		"RUN_LOG_FILE=",
+	)
+	return &runEnv{Env: env}
+}
+
+// command returns the command that runs run with args in dir.
+func (e *runEnv) command(t *testing.T, dir string, args ...string) *exec.Cmd {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Dir = dir
+	cmd.Env = e.Env
+	return cmd
+}
+
+// runResult is how a run ended.
+type runResult struct {
+	Stdout, Stderr string
+	Code           int
+}
+
+// run runs run with args in dir and waits for it.
+func (e *runEnv) run(t *testing.T, dir string, args ...string) runResult {
+	t.Helper()
+	cmd := e.command(t, dir, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("run %v: %v", args, err)
+	}
+	return runResult{stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()}
+}
+
+// requireTools skips the test unless all of tools are installed.
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+}
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestUnicodeOutput runs a script printing non-ASCII text and emoji without
+// a UTF-8 locale, and checks the bytes come out intact.
+func TestUnicodeOutput(t *testing.T) {
+	requireTools(t, "python3")
+	env := newRunEnv(t)
+	env.Env = append(env.Env, "LANG=", "LC_ALL=", "LC_CTYPE=", "PYTHONIOENCODING=", "PYTHONUTF8=")
+	dir := t.TempDir()
+	const text = "héllo wörld ✓ 日本語 🎉"
+	writeFile(t, dir, "hello.py", "print(\""+text+"\")\n")
+
+	res := env.run(t, dir, "--verbose", "hello.py")
+	if res.Code != 0 {
+		t.Fatalf("exit code %d\n%s%s", res.Code, res.Stdout, res.Stderr)
+	}
+	if !bytes.Contains([]byte(res.Stdout), []byte(text+"\n")) {
+		t.Errorf("output %q doesn't hold %q", res.Stdout, text)
+	}
+	if runtime.GOOS != "windows" && !strings.Contains(res.Stdout, "Locale: no UTF-8 locale configured") {
+		t.Errorf("--verbose doesn't note the locale fix:\n%s", res.Stdout)
+	}
+}
+
+// TestShebangedSources runs compiled sources that start with a #! line,
+// which their compilers would reject, and checks that diagnostics still
+// point at the right line.
+func TestShebangedSources(t *testing.T) {
+	env := newRunEnv(t)
+	tests := []struct {
+		name, tool, file, source string
+		code                     int
+		want                     string // In the output
+	}{
+		{"C", "gcc", "hello.c", "#!/usr/bin/env run\n#include <stdio.h>\nint main(void) { puts(\"hello from C\"); return 0; }\n", 0, "hello from C"},
+		{"Go", "go", "hello.go", "#!/usr/bin/env run\npackage main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hello from Go\") }\n", 0, "hello from Go"},
+		{"C error line", "gcc", "bad.c", "#!/usr/bin/env run\nint main(void) {\n    return undeclared;\n}\n", exitCompileFailed, "bad.c:3:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireTools(t, tt.tool)
+			dir := t.TempDir()
+			writeFile(t, dir, tt.file, tt.source)
+			res := env.run(t, dir, tt.file)
+			if res.Code != tt.code {
+				t.Fatalf("exit code %d, want %d\n%s%s", res.Code, tt.code, res.Stdout, res.Stderr)
+			}
+			if out := res.Stdout + res.Stderr; !strings.Contains(out, tt.want) {
+				t.Errorf("output doesn't hold %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Statuses of a language in the capability matrix.
+const (
+	matrixInstalled   = "installed"
+	matrixInstallable = "installable"
+	matrixManual      = "manual"
+)
+
+// matrixRow is one language of `run doctor --matrix`: whether it works
+// here, and if not, what would fix it.
+type matrixRow struct {
+	Extension string   `json:"extension"`
+	Language  string   `json:"language"`
+	Runtime   string   `json:"runtime"`
+	Status    string   `json:"status"`
+	Version   string   `json:"version,omitempty"`
+	Install   []string `json:"install,omitempty"` // What run would run to install it
+	Reason    string   `json:"reason,omitempty"`  // Why it must be installed by hand
+}
+
+// capabilityMatrix is the report of `run doctor --matrix`, with the machine
+// it was made on so that reports from many machines can be told apart.
+type capabilityMatrix struct {
+	Host           string      `json:"host"`
+	OS             string      `json:"os"`
+	Arch           string      `json:"arch"`
+	PackageManager string      `json:"packageManager,omitempty"`
+	Languages      []matrixRow `json:"languages"`
+}
+
+// packageManagers are looked for in this order to name the machine's.
+var packageManagers = []string{"apt", "dnf", "yum", "pacman", "zypper", "apk", "brew", "port", "winget", "choco", "scoop"}
+
+// systemPackageManager returns the first of packageManagers on PATH, or "".
+func systemPackageManager() string {
+	for _, pm := range packageManagers {
+		if _, err := exec.LookPath(pm); err == nil {
+			return pm
+		}
+		if pm == "brew" && brewBinary(pm) != "" {
+			return pm
+		}
+	}
+	return ""
+}
+
+// resolveInstall works out whether the install command of a language would
+// work on this machine, rather than trusting that there is one: it must not
+// be a message to install by hand, its tool and sudo must exist, and the
+// packages it names must be available from the package manager. Otherwise
+// it returns why not.
+func resolveInstall(install []string) (bool, string) {
+	if len(install) == 0 {
+		return false, "run has no install command for it on this system"
+	}
+	if install[0] == "echo" {
+		return false, strings.Join(install[1:], " ")
+	}
+	argv := install
+	if argv[0] == "sudo" {
+		if _, err := exec.LookPath("sudo"); err != nil {
+			return false, fmt.Sprintf("it needs sudo, which isn't installed; as root, run %s", shellJoin(argv[1:]))
+		}
+		argv = argv[1:]
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil && brewBinary(argv[0]) == "" {
+		return false, fmt.Sprintf("it is installed with %s, which isn't available here", argv[0])
+	}
+	var packages []string
+	for _, arg := range argv[1:] {
+		if !strings.HasPrefix(arg, "-") && arg != "install" {
+			packages = append(packages, arg)
+		}
+	}
+	switch argv[0] {
+	case "apt", "apt-get":
+		if missing := missingAptPackages(packages); len(missing) > 0 {
+			return false, fmt.Sprintf("apt has no package %s; add a source that has it or install it by hand", strings.Join(missing, ", "))
+		}
+	case "brew":
+		for _, pkg := range packages {
+			info := exec.Command(argv[0], "info", pkg)
+			if runCmd(info) != nil {
+				return false, fmt.Sprintf("Homebrew has no formula %s", pkg)
+			}
+		}
+	}
+	return true, ""
+}
+
+// missingAptPackages returns the packages apt has no candidate version of
+// in its configured sources.
+func missingAptPackages(packages []string) []string {
+	if len(packages) == 0 {
+		return nil
+	}
+	out, err := outputCmd(exec.Command("apt-cache", append([]string{"policy"}, packages...)...))
+	if err != nil {
+		return nil // Can't tell; let the install try
+	}
+	candidates := map[string]bool{}
+	current := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			current = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if version, ok := strings.CutPrefix(strings.TrimSpace(line), "Candidate:"); ok && current != "" {
+			candidates[current] = strings.TrimSpace(version) != "(none)"
+		}
+	}
+	var missing []string
+	for _, pkg := range packages {
+		if !candidates[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
+
+// buildMatrix checks the runtimes of extensions in parallel and resolves
+// the install command of each one that is missing.
+func buildMatrix(extensions []string) capabilityMatrix {
+	host, _ := os.Hostname()
+	m := capabilityMatrix{
+		Host:           host,
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		PackageManager: systemPackageManager(),
+	}
+	versions := probeRuntimes(extensions)
+	for _, ext := range extensions {
+		config := languageConfigs[ext]
+		row := matrixRow{Extension: ext, Language: config.Name, Runtime: config.CheckCmd[0]}
+		if version, ok := versions[ext]; ok {
+			row.Status, row.Version = matrixInstalled, version
+		} else {
+			install := config.InstallCmd()
+			if ok, reason := resolveInstall(install); ok {
+				row.Status, row.Install = matrixInstallable, install
+			} else {
+				row.Status, row.Reason = matrixManual, reason
+			}
+		}
+		m.Languages = append(m.Languages, row)
+	}
+	return m
+}
+
+// printMatrix shows m as one line per language, followed by what would fix
+// the languages that don't work yet.
+func printMatrix(m capabilityMatrix) {
+	pm := m.PackageManager
+	if pm == "" {
+		pm = "none found"
+	}
+	fmt.Printf("%s/%s, package manager: %s\n\n", m.OS, m.Arch, pm)
+	fmt.Printf("%-12s %-7s %s\n", "Language", "Ext", "Status")
+	fmt.Println(strings.Repeat("-", 60))
+	var gaps []matrixRow
+	for _, row := range m.Languages {
+		status := ""
+		switch row.Status {
+		case matrixInstalled:
+			status = "✓ " + row.Version
+		case matrixInstallable:
+			status = "installable automatically"
+			gaps = append(gaps, row)
+		default:
+			status = "✗ manual install required"
+			gaps = append(gaps, row)
+		}
+		fmt.Printf("%-12s %-7s %s\n", row.Language, row.Extension, status)
+	}
+	if len(gaps) == 0 {
+		return
+	}
+	fmt.Println("\nTo fix:")
+	for _, row := range gaps {
+		if row.Status == matrixInstallable {
+			fmt.Printf("  %-7s %s\n", row.Extension, shellJoin(row.Install))
+		} else {
+			fmt.Printf("  %-7s %s\n", row.Extension, row.Reason)
+		}
+	}
+}
+
+// matrixCommand implements `run doctor --matrix [--json] [exts...]`. It
+// exits with 1 unless every language is installed.
+func matrixCommand(extensions []string, asJSON bool) {
+	if len(extensions) == 0 {
+		extensions = supportedExtensions()
+	}
+	m := buildMatrix(extensions)
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(m)
+	} else {
+		printMatrix(m)
+	}
+	for _, row := range m.Languages {
+		if row.Status != matrixInstalled {
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// missingDep recognizes one language's error for a module that isn't
+// installed, and knows the command that installs it.
+type missingDep struct {
+	// Pattern matches the error; its first group is the name the program
+	// imported
+	Pattern *regexp.Regexp
+	// Package returns the package to install for the imported name, or ""
+	// when it isn't a package, e.g. a relative path
+	Package func(name string) string
+	Install func(pkg string) []string
+	// Offer says whether run offers to install it. Node scripts are offered
+	// it before this, by the retry of executeFile.
+	Offer bool
+}
+
+// missingDeps are the missing dependency errors run recognizes, by
+// language. They are checked only once the program has failed, and only
+// add a suggestion to its output.
+var missingDeps = map[string]missingDep{
+	".py": {
+		// ModuleNotFoundError: No module named 'requests.adapters', or
+		// Python 2's ImportError: No module named requests
+		Pattern: regexp.MustCompile(`(?m)^\w*Error: No module named '?([\w.]+)'?`),
+		Package: func(name string) string {
+			top, _, _ := strings.Cut(name, ".")
+			if strings.HasPrefix(top, "_") {
+				return "" // A part of Python built without it, e.g. _ctypes
+			}
+			return aliased(pythonPackages, top)
+		},
+		Install: func(pkg string) []string {
+			return append(resolveRuntime(".py", []string{"python3"}), "-m", "pip", "install", pkg)
+		},
+		Offer: true,
+	},
+	".rb": {
+		// cannot load such file -- nokogiri (LoadError)
+		Pattern: regexp.MustCompile(`cannot load such file -- (\S+)`),
+		Package: func(name string) string {
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "/") {
+				return ""
+			}
+			top, _, _ := strings.Cut(name, "/")
+			return aliased(rubyGems, top)
+		},
+		Install: func(pkg string) []string { return []string{"gem", "install", pkg} },
+		Offer:   true,
+	},
+	".js": {
+		Pattern: missingModulePattern,
+		Package: nodePackage,
+		Install: func(pkg string) []string { return []string{"npm", "install", pkg} },
+	},
+	".ts": {
+		Pattern: missingModulePattern,
+		Package: nodePackage,
+		Install: func(pkg string) []string { return []string{"npm", "install", pkg} },
+	},
+	".pl": {
+		// Can't locate JSON/XS.pm in @INC (you may need to install the
+		// JSON::XS module)
+		Pattern: regexp.MustCompile(`you may need to install the ([\w:]+) module`),
+		Package: func(name string) string { return name },
+		Install: func(pkg string) []string {
+			if _, err := exec.LookPath("cpanm"); err == nil {
+				return []string{"cpanm", pkg}
+			}
+			return []string{"cpan", pkg}
+		},
+		Offer: true,
+	},
+	".lua": {
+		// module 'socket.http' not found:
+		Pattern: regexp.MustCompile(`module '([\w.]+)' not found`),
+		Package: func(name string) string {
+			top, _, _ := strings.Cut(name, ".")
+			return aliased(luaRocks, top)
+		},
+		Install: func(pkg string) []string { return []string{"luarocks", "install", "--local", pkg} },
+		Offer:   true,
+	},
+	".r": {
+		// there is no package called ‘dplyr’
+		Pattern: regexp.MustCompile(`there is no package called [‘'"]([\w.]+)[’'"]`),
+		Package: func(name string) string { return name },
+		Install: func(pkg string) []string {
+			return []string{"Rscript", "-e", fmt.Sprintf(`install.packages("%s", repos = "https://cloud.r-project.org")`, pkg)}
+		},
+		Offer: true,
+	},
+	".jl": {
+		// ArgumentError: Package Foo not found in current path
+		Pattern: regexp.MustCompile(`Package (\w+) not found in current path`),
+		Package: func(name string) string { return name },
+		Install: func(pkg string) []string {
+			return []string{"julia", "-e", fmt.Sprintf(`using Pkg; Pkg.add("%s")`, pkg)}
+		},
+		Offer: true,
+	},
+}
+
+// pythonPackages, rubyGems and luaRocks name the packages whose import
+// name differs from the name they are installed by.
+var (
+	pythonPackages = map[string]string{
+		"cv2": "opencv-python", "yaml": "PyYAML", "PIL": "Pillow", "sklearn": "scikit-learn",
+		"bs4": "beautifulsoup4", "dateutil": "python-dateutil", "dotenv": "python-dotenv",
+		"attr": "attrs", "Crypto": "pycryptodome", "jwt": "PyJWT", "serial": "pyserial",
+		"magic": "python-magic", "docx": "python-docx", "OpenSSL": "pyOpenSSL", "gi": "PyGObject",
+	}
+	rubyGems = map[string]string{
+		"active_support": "activesupport", "active_record": "activerecord", "action_view": "actionview",
+	}
+	luaRocks = map[string]string{
+		"socket": "luasocket", "lfs": "luafilesystem", "cjson": "lua-cjson", "ssl": "luasec",
+	}
+)
+
+// aliased returns the package installing name according to aliases.
+func aliased(aliases map[string]string, name string) string {
+	if pkg, ok := aliases[name]; ok {
+		return pkg
+	}
+	return name
+}
+
+// suggestMissingDep tells how to install the dependency whose absence made
+// the program fail, going by its stderr, and offers to install it when
+// there is someone to ask and installing is allowed. Either way the program
+// has failed: nothing is run again, and the exit code stays the program's.
+func suggestMissingDep(sourceFile, ext, stderr string) {
+	dep, ok := missingDeps[ext]
+	if !ok {
+		return
+	}
+	m := dep.Pattern.FindStringSubmatch(stderr)
+	if m == nil {
+		return
+	}
+	pkg := dep.Package(m[1])
+	if pkg == "" {
+		return
+	}
+	install := dep.Install(pkg)
+	what := fmt.Sprintf("%s needs %s, which isn't installed.", sourceFile, m[1])
+	if !dep.Offer || noInstall || offline || !isTerminal(os.Stdin) {
+		fmt.Fprintf(messageOut(), "\n%s Install it with:\n  %s\n", what, shellJoin(install))
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+	if !assumeYes && !confirmInstall(what, install) {
+		return
+	}
+	cmd := exec.Command(install[0], install[1:]...)
+	cmd.Env = childEnv()
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := runCmd(cmd); err != nil {
+		fmt.Fprintf(messageOut(), "Installing %s failed: %v\n", pkg, err)
+		return
+	}
+	fmt.Fprintf(messageOut(), "Installed %s; run %s again.\n", pkg, sourceFile)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMissingDepPatterns(t *testing.T) {
+	tests := []struct {
+		sample, ext string
+		name, pkg   string
+		install     []string // The end of the install command
+	}{
+		{"missing_python", ".py", "requests", "requests", []string{"-m", "pip", "install", "requests"}},
+		{"missing_python_alias", ".py", "cv2", "opencv-python", []string{"-m", "pip", "install", "opencv-python"}},
+		{"missing_node", ".js", "lodash", "lodash", []string{"npm", "install", "lodash"}},
+		{"missing_node_esm", ".js", "chalk", "chalk", []string{"npm", "install", "chalk"}},
+		{"missing_ruby", ".rb", "nokogiri", "nokogiri", []string{"gem", "install", "nokogiri"}},
+		{"missing_perl", ".pl", "JSON::XS", "JSON::XS", []string{"JSON::XS"}},
+		{"missing_lua", ".lua", "socket.http", "luasocket", []string{"luarocks", "install", "--local", "luasocket"}},
+		{"missing_r", ".r", "dplyr", "dplyr", []string{"-e", `install.packages("dplyr", repos = "https://cloud.r-project.org")`}},
+		{"missing_julia", ".jl", "DataFrames", "DataFrames", []string{"julia", "-e", `using Pkg; Pkg.add("DataFrames")`}},
+	}
+	for _, tt := range tests {
+		dep := missingDeps[tt.ext]
+		m := dep.Pattern.FindStringSubmatch(readSample(t, tt.sample))
+		if m == nil {
+			t.Errorf("%s: no missing module found", tt.sample)
+			continue
+		}
+		if m[1] != tt.name {
+			t.Errorf("%s: found %q missing, want %q", tt.sample, m[1], tt.name)
+		}
+		pkg := dep.Package(m[1])
+		if pkg != tt.pkg {
+			t.Errorf("%s: package %q, want %q", tt.sample, pkg, tt.pkg)
+		}
+		if install := dep.Install(pkg); !slices.Equal(install[len(install)-len(tt.install):], tt.install) {
+			t.Errorf("%s: install with %q, want it to end in %q", tt.sample, install, tt.install)
+		}
+	}
+}
+
+// TestMissingDepNotAPackage checks that imports of the program's own files
+// and of parts of the runtime get no suggestion.
+func TestMissingDepNotAPackage(t *testing.T) {
+	tests := []struct{ ext, name string }{
+		{".py", "_ctypes"},
+		{".js", "./helpers"},
+		{".js", "../lib/util.js"},
+		{".js", "/abs/path.js"},
+		{".js", "node:missing"},
+		{".rb", "./lib/helper"},
+	}
+	for _, tt := range tests {
+		if pkg := missingDeps[tt.ext].Package(tt.name); pkg != "" {
+			t.Errorf("%s %s: suggested installing %q", tt.ext, tt.name, pkg)
+		}
+	}
+	for _, output := range []string{"", "Traceback (most recent call last):\nZeroDivisionError: division by zero\n", "TypeError: x is not a function\n"} {
+		for ext, dep := range missingDeps {
+			if m := dep.Pattern.FindStringSubmatch(output); m != nil {
+				t.Errorf("%s: found %q missing in %q", ext, m[1], output)
+			}
+		}
+	}
+}
+
+// TestMissingDepKeepsOutputAndExitCode runs a script importing a package
+// that doesn't exist: the suggestion comes after the program's own output,
+// which is unchanged, and run exits with the program's exit code.
+func TestMissingDepKeepsOutputAndExitCode(t *testing.T) {
+	requireTools(t, "python3")
+	dir := t.TempDir()
+	writeFile(t, dir, "imp.py", "print('before')\nimport run_test_no_such_module\n")
+	res := newRunEnv(t).run(t, dir, "--no-install", "imp.py")
+	if res.Code != 1 {
+		t.Errorf("exit code %d, want python's 1", res.Code)
+	}
+	if !strings.Contains(res.Stderr, "ModuleNotFoundError: No module named 'run_test_no_such_module'") {
+		t.Errorf("python's traceback is missing from stderr:\n%s", res.Stderr)
+	}
+	output := res.Stdout + res.Stderr
+	suggestion := strings.Index(output, "pip install run_test_no_such_module")
+	if suggestion < 0 || suggestion < strings.Index(output, "before") {
+		t.Errorf("no suggestion after the program's output:\n%s", output)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// modelineLines is how many lines from the top of a file are searched for a
+// modeline.
+const modelineLines = 5
+
+var (
+	// noModeline ignores modelines, e.g. for untrusted files (--no-modeline).
+	noModeline bool
+	// fileModeline is the modeline of the file being run, nil when it has
+	// none.
+	fileModeline *modeline
+	// modelineEnv holds the environment additions of the modeline in effect.
+	modelineEnv []string
+)
+
+// modelinePattern matches a modeline in any comment syntax, such as
+// `// run:lang=go` or vim-style `# run: lang=rust, args=--release`.
+// `// run:deps ...` has no key=value and is not one.
+var modelinePattern = regexp.MustCompile(`\brun:\s*(\w+=.*)$`)
+
+// modelineComment matches what closes a comment after a modeline.
+var modelineComment = regexp.MustCompile(`\s*(\*/|-->|--\]\]|#\}|%\}|:\))\s*$`)
+
+// modeline holds the settings pinned in a source file for files whose
+// extension can't tell or can't be changed.
+type modeline struct {
+	Line    int    // 1-based
+	Lang    string // Extension, e.g. .go
+	Args    []string
+	Timeout time.Duration
+	Env     []string
+	Set     []string // key=value of each honored setting, for --dry-run
+}
+
+// readModeline returns the modeline in the first lines of path, if any.
+// Settings that are not allowed or not valid are skipped with a warning
+// naming them.
+func readModeline(path string) (*modeline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; n <= modelineLines && scanner.Scan(); n++ {
+		m := modelinePattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		ml := &modeline{Line: n}
+		body := modelineComment.ReplaceAllString(m[1], "")
+		for _, setting := range strings.Split(body, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(setting), "=")
+			value = strings.TrimSpace(value)
+			if err := ml.set(key, value); err != nil {
+				warnf("%s:%d: %v", path, n, err)
+				continue
+			}
+			ml.Set = append(ml.Set, key+"="+value)
+		}
+		return ml, nil
+	}
+	return nil, scanner.Err()
+}
+
+// set applies one key=value setting of a modeline.
+func (ml *modeline) set(key, value string) error {
+	switch key {
+	case "lang":
+		ext := normalizeExt(value)
+		if _, ok := languageConfigs[ext]; !ok {
+			return fmt.Errorf("ignoring lang=%s in the modeline: unsupported language", value)
+		}
+		ml.Lang = ext
+	case "args":
+		ml.Args = strings.Fields(value)
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("ignoring timeout=%s in the modeline: not a duration such as 5s", value)
+		}
+		ml.Timeout = d
+	case "env":
+		if name, _, ok := strings.Cut(value, "="); !ok || name == "" {
+			return fmt.Errorf("ignoring env=%s in the modeline: expected env=NAME=value", value)
+		}
+		ml.Env = append(ml.Env, value)
+	default:
+		return fmt.Errorf("ignoring %q in the modeline: only lang, args, timeout and env can be set", key)
+	}
+	return nil
+}
+
+// applyModeline reads the modeline of sourceFile and applies its settings
+// that the command line leaves open: the program's arguments, the time
+// limit and the environment. The language is applied by the caller.
+func applyModeline(sourceFile string) {
+	if noModeline {
+		return
+	}
+	ml, err := readModeline(sourceFile)
+	if err != nil || ml == nil {
+		return
+	}
+	fileModeline = ml
+	logEvent("modeline", map[string]any{"file": sourceFile, "line": ml.Line, "set": ml.Set})
+	if len(programArgs) == 0 {
+		programArgs = ml.Args
+	}
+	if maxCPUTime == 0 {
+		maxCPUTime = ml.Timeout
+	}
+	modelineEnv = ml.Env
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// builtinTemplates holds one hello-world source per supported language, named
+// main<ext>.tmpl. Users can override them by placing a file with the same name in
+// the templates directory under configDir().
+//
+//go:embed templates
+var builtinTemplates embed.FS
+
+// configDir returns the directory holding run's user configuration,
+// e.g. ~/.config/run on Linux.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".", ".run")
+	}
+	return filepath.Join(dir, "run")
+}
+
+// cacheDir returns the directory for run's caches, e.g. ~/.cache/run on
+// Linux. Everything in it can be deleted at any time.
+func cacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(".", ".run", "cache")
+	}
+	return filepath.Join(dir, "run")
+}
+
+// newCommand implements `run new <lang> [name] [--force]`.
+func newCommand(args []string) {
+	var positional []string
+	force := false
+	for _, arg := range args {
+		switch arg {
+		case "--force", "-f":
+			force = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) < 1 || len(positional) > 2 {
+		fmt.Println("Usage: run new <lang> [name] [--force]   (e.g. run new cpp solver)")
+		os.Exit(1)
+	}
+
+	ext := normalizeExt(positional[0])
+	if _, ok := languageConfigs[ext]; !ok {
+		fmt.Printf("Unsupported language: %s\n", positional[0])
+		fmt.Println("Run 'run --list' to see supported languages.")
+		os.Exit(1)
+	}
+
+	name := "main"
+	if ext == ".java" {
+		// The public class has to match the file name
+		name = "Main"
+	}
+	if len(positional) == 2 {
+		name = strings.TrimSuffix(positional[1], ext)
+	}
+	fileName := name + ext
+
+	if _, err := os.Stat(fileName); err == nil && !force {
+		fmt.Printf("%s already exists. Use --force to overwrite it.\n", fileName)
+		os.Exit(1)
+	}
+
+	content, err := renderTemplate(ext, filepath.Base(name))
+	if err != nil {
+		fmt.Printf("Failed to render template: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		fmt.Printf("Failed to write %s: %v\n", fileName, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created %s\n", fileName)
+	fmt.Printf("Run it with: run %s\n", fileName)
+}
+
+// renderTemplate returns the scaffold for ext with the program name filled in,
+// preferring a user template over the built-in one.
+func renderTemplate(ext, name string) ([]byte, error) {
+	templateName := "main" + ext + ".tmpl"
+	raw, err := os.ReadFile(filepath.Join(configDir(), "templates", templateName))
+	if err != nil {
+		raw, err = builtinTemplates.ReadFile("templates/" + templateName)
+		if err != nil {
+			return nil, fmt.Errorf("no template for %s", ext)
+		}
+	}
+
+	tmpl, err := template.New(templateName).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Name string }{name}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTemplateForEveryLanguage(t *testing.T) {
+	for _, ext := range supportedExtensions() {
+		if _, err := fs.Stat(builtinTemplates, "templates/main"+ext+".tmpl"); err != nil {
+			t.Errorf("no template for %s", ext)
+		}
+	}
+}
+
+// TestNewThenDryRun scaffolds a file from each template and dry-runs it. A
+// language whose runtime isn't installed can only get as far as saying so.
+func TestNewThenDryRun(t *testing.T) {
+	env := newRunEnv(t)
+	for _, ext := range supportedExtensions() {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			res := env.run(t, dir, "new", strings.TrimPrefix(ext, "."), "hello")
+			if res.Code != 0 {
+				t.Fatalf("run new: exit code %d\n%s", res.Code, res.Stdout)
+			}
+			file := "hello" + ext
+			if !strings.Contains(res.Stdout, "Created "+file) || !strings.Contains(res.Stdout, "run "+file) {
+				t.Errorf("run new printed:\n%s", res.Stdout)
+			}
+
+			res = env.run(t, dir, "--dry-run", file)
+			if !slices.Contains([]int{0, exitRuntimeUnavailable}, res.Code) {
+				t.Fatalf("run --dry-run %s: exit code %d\n%s%s", file, res.Code, res.Stdout, res.Stderr)
+			}
+
+			res = env.run(t, dir, "new", strings.TrimPrefix(ext, "."), "hello")
+			if res.Code == 0 || !strings.Contains(res.Stdout, "--force") {
+				t.Errorf("run new overwrote %s without --force:\n%s", file, res.Stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// noInstall stops run from installing anything, be it a missing runtime or
+// a script's packages (--no-install).
+var noInstall bool
+
+// assumeYes answers run's own questions with yes (--yes).
+var assumeYes bool
+
+var (
+	// missingModulePattern matches Node's CommonJS and ES module errors for
+	// an unresolvable import.
+	missingModulePattern = regexp.MustCompile(`Cannot find (?:module|package) '([^']+)'`)
+	// nodeDepsPattern matches an inline declaration such as
+	// `// run:deps axios@1 lodash`.
+	nodeDepsPattern = regexp.MustCompile(`^\s*//\s*run:deps\s+(.+)$`)
+)
+
+// nodeDepsDir is the package directory kept for one script, keyed by its
+// absolute path so scripts with the same name don't share packages.
+func nodeDepsDir(sourceFile string) string {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		abs = sourceFile
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cacheDir(), "node", hex.EncodeToString(sum[:8]))
+}
+
+// nodeEnv points Node's CommonJS resolution at the script's package
+// directory. ES module imports don't consult NODE_PATH.
+func nodeEnv(sourceFile string) []string {
+	modules := filepath.Join(nodeDepsDir(sourceFile), "node_modules")
+	if _, err := os.Stat(modules); err != nil {
+		return nil
+	}
+	return []string{"NODE_PATH=" + modules}
+}
+
+// inlineNodeDeps returns the packages declared in `// run:deps` comments.
+func inlineNodeDeps(sourceFile string) []string {
+	f, err := os.Open(sourceFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var deps []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := nodeDepsPattern.FindStringSubmatch(scanner.Text()); m != nil {
+			deps = append(deps, strings.Fields(m[1])...)
+		}
+	}
+	return deps
+}
+
+// missingNodePackage returns the npm package behind a failed bare import in
+// Node's stderr, or "" when there is none.
+func missingNodePackage(stderr string) string {
+	m := missingModulePattern.FindStringSubmatch(stderr)
+	if m == nil {
+		return ""
+	}
+	return nodePackage(m[1])
+}
+
+// nodePackage returns the npm package an import specifier names, or "".
+// Relative, absolute and node: specifiers are not packages.
+func nodePackage(spec string) string {
+	if strings.HasPrefix(spec, ".") || strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "node:") || filepath.IsAbs(spec) {
+		return ""
+	}
+	// Keep the package of a subpath import: lodash/fp, @scope/pkg/sub
+	parts := strings.Split(spec, "/")
+	if strings.HasPrefix(spec, "@") && len(parts) > 1 {
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
+
+// mayInstall asks whether to run command, which installs list for
+// sourceFile, and returns why not when it mustn't: with --no-install, in
+// offline mode, when declined, or when there is no one to ask.
+func mayInstall(sourceFile, list string, command []string) error {
+	switch {
+	case noInstall:
+		return fmt.Errorf("%s needs %s; not installing (--no-install)", sourceFile, list)
+	case offline:
+		return offlineError("runtime-unavailable", exitRuntimeUnavailable, "Installing "+list,
+			"Install it yourself, e.g. "+shellJoin(command))
+	case !assumeYes && !isTerminal(os.Stdin):
+		return fmt.Errorf("%s needs %s; pass --yes to install it", sourceFile, list)
+	}
+	if !assumeYes && !confirmInstall(fmt.Sprintf("%s needs %s.", sourceFile, list), command) {
+		return fmt.Errorf("installation of %s declined", list)
+	}
+	return nil
+}
+
+// installNodeDeps installs pkgs into the script's package directory after
+// asking, unless they are already there. It refuses with --no-install, with
+// --frozen and in offline mode.
+func installNodeDeps(sourceFile string, pkgs []string) error {
+	dir := nodeDepsDir(sourceFile)
+	missingPkgs := func() []string {
+		var missing []string
+		for _, pkg := range pkgs {
+			name := pkg
+			if i := strings.LastIndex(pkg, "@"); i > 0 {
+				name = pkg[:i] // Drop a version: axios@1, @scope/pkg@2
+			}
+			if _, err := os.Stat(filepath.Join(dir, "node_modules", name)); err != nil {
+				missing = append(missing, pkg)
+			}
+		}
+		return missing
+	}
+	missing := missingPkgs()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	list := strings.Join(missing, " ")
+	if frozenDeps {
+		return fmt.Errorf("%s needs %s, which its lock doesn't record; not installing (--frozen)", sourceFile, list)
+	}
+	args := append([]string{"install", "--no-audit", "--no-fund", "--prefix", dir}, missing...)
+	if err := mayInstall(sourceFile, list, append([]string{"npm"}, args...)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Another run of the same script may be installing into the cache
+	release, err := acquireLock(dir+".lock", "installing "+list)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if missing = missingPkgs(); len(missing) == 0 {
+		return nil
+	}
+	list = strings.Join(missing, " ")
+	args = append([]string{"install", "--no-audit", "--no-fund", "--prefix", dir}, missing...)
+
+	manifest := filepath.Join(dir, "package.json")
+	if _, err := os.Stat(manifest); err != nil {
+		if err := os.WriteFile(manifest, []byte("{\"private\": true}\n"), 0o644); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command("npm", args...)
+	cmd.Env = childEnv()
+	step := startStep("installing " + list)
+	cmd.Stdout = step
+	cmd.Stderr = step
+	err = runCmd(cmd)
+	step.Finish(err)
+	if err != nil {
+		return fmt.Errorf("npm install %s failed: %w", list, err)
+	}
+	infof("Installed %s into %s\n", list, dir)
+	return nil
+}
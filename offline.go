@@ -0,0 +1,31 @@
+package main
+
+import "os"
+
+// offline forbids run itself from using the network (--offline or
+// RUN_OFFLINE=1): no runtime installs, no downloads, and toolchains started
+// by run are told not to fetch anything either. The executed program is not
+// restricted.
+var offline = os.Getenv("RUN_OFFLINE") == "1"
+
+// offlineEnv returns the variables that keep toolchains off the network in
+// offline mode.
+func offlineEnv() []string {
+	if !offline {
+		return nil
+	}
+	return []string{
+		"GOPROXY=off",       // go run: no module downloads
+		"GOTOOLCHAIN=local", // go: no toolchain downloads
+		"PIP_NO_INDEX=1",    // pip: local packages only
+		"npm_config_offline=true",
+		"DOTNET_CLI_TELEMETRY_OPTOUT=1",
+		"DOTNET_SKIP_FIRST_TIME_EXPERIENCE=1",
+	}
+}
+
+// offlineError is returned by features that need the network in offline
+// mode, saying what to do instead.
+func offlineError(status string, code int, what, instead string) *runError {
+	return newRunError(status, code, "%s skipped (offline mode). %s", what, instead)
+}
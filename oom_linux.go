@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// oomKills returns how many processes the kernel's out-of-memory killer has
+// ended in run's cgroup so far, from the oom_kill count of its memory.events
+// (cgroup v2), or -1 when that isn't available.
+func oomKills() int64 {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		path, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		f, err := os.Open(filepath.Join("/sys/fs/cgroup", path, "memory.events"))
+		if err != nil {
+			return -1
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if value, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return -1
+				}
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// oomLogged reports whether the kernel log that syslog keeps says the
+// out-of-memory killer ended pid. Reading it usually needs the adm group;
+// without it the answer is no.
+func oomLogged(pid int) bool {
+	needle := []byte("Killed process " + strconv.Itoa(pid) + " (")
+	for _, path := range []string{"/var/log/kern.log", "/var/log/syslog", "/var/log/messages"} {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		// The kill was just now, so it's near the end
+		if info, err := f.Stat(); err == nil && info.Size() > 256<<10 {
+			f.Seek(-256<<10, io.SeekEnd)
+		}
+		tail, _ := io.ReadAll(f)
+		f.Close()
+		if bytes.Contains(tail, needle) {
+			return true
+		}
+	}
+	return false
+}
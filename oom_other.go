@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+// oomKills needs cgroups; elsewhere an out-of-memory kill can't be confirmed.
+func oomKills() int64 {
+	return -1
+}
+
+func oomLogged(pid int) bool {
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// runProgram is runCmd for the executed program itself. It applies limits,
+// logs the child's PID so it can be cleaned up from outside should run die
+// without taking it along, and passes run's termination signals on to the
+// program (see forwardSignals) instead of dying of them, so that the caller
+// can clean up and report interruptError.
+func runProgram(cmd *exec.Cmd, limits childLimits) error {
+	traceCmd(cmd)
+	start := time.Now()
+	var err error
+	if limits.any() && rlimitsSupported {
+		err = startLimited(cmd, limits)
+	} else {
+		err = cmd.Start()
+	}
+	if err == nil {
+		logEvent("child", map[string]any{"pid": cmd.Process.Pid, "argv": cmd.Args})
+		stop := forwardSignals(cmd)
+		stopHeartbeat := startHeartbeat(cmd.Process.Pid)
+		err = cmd.Wait()
+		stopHeartbeat()
+		stop()
+	}
+	logCmd(cmd, start, err)
+	return err
+}
+
+// isolateProgram puts the executed program in its own process group when
+// nobody is at the terminal to signal it, as in CI, watch and serve modes,
+// so that stopping it reaches the processes it started too. In a terminal,
+// job control already signals run's whole group, and a separate group would
+// be stopped when the program reads from the terminal.
+func isolateProgram(cmd *exec.Cmd) {
+	if !isTerminal(os.Stdin) {
+		ownProcessGroup(cmd)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Process groups are Unix features. Elsewhere a child can outlive run if
+// run is killed; its PID is logged (see runProgram) so it can be cleaned up
+// externally.
+func ownProcessGroup(cmd *exec.Cmd) {}
+
+// terminateOnCancel gives a program grace before it is killed elsewhere,
+// where it can't be asked to exit with SIGTERM.
+func terminateOnCancel(cmd *exec.Cmd, grace time.Duration) {}
+
+// forwardSignals catches Ctrl+C and SIGTERM until the returned function is
+// called, so that run outlives the program and can clean up after it. The
+// program can't be signalled, so it is killed; the console sends Ctrl+C to
+// every process attached to it anyway.
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				noteInterrupt(sig)
+				cmd.Process.Kill()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// processAlive can't tell here, so a process is taken to be running.
+func processAlive(pid int) bool { return true }
@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestChildDiesWithRun kills run with SIGKILL while its program runs, and
+// checks that the program doesn't outlive it. Only Linux has the
+// parent-death signal this relies on.
+func TestChildDiesWithRun(t *testing.T) {
+	requireTools(t, "python3")
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	writeFile(t, dir, "sleeper.py", "import os, time\n"+
+		"open("+strconv.Quote(pidFile)+", 'w').write(str(os.getpid()))\n"+
+		"time.sleep(60)\n")
+
+	cmd := newRunEnv(t).command(t, dir, "sleeper.py")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	var pid int
+	for deadline := time.Now().Add(10 * time.Second); pid == 0; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the program did not start")
+		}
+		data, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(string(data))
+	}
+
+	cmd.Process.Signal(syscall.SIGKILL)
+	cmd.Wait()
+	for deadline := time.Now().Add(5 * time.Second); alive(pid); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("the program (pid %d) was still running 5s after run was killed", pid)
+		}
+	}
+}
+
+// alive reports whether pid is running; a zombie nobody has reaped yet
+// has exited.
+func alive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// pid (comm) state ...
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ownProcessGroup starts cmd in a new process group and makes cancelling
+// its context kill the whole group rather than just the direct child.
+func ownProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// terminateOnCancel makes cancelling cmd's context send SIGTERM, to its
+// process group if it has one, and gives it grace to exit before it is
+// killed.
+func terminateOnCancel(cmd *exec.Cmd, grace time.Duration) {
+	ownGroup := cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid
+	cmd.Cancel = func() error {
+		if ownGroup {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		}
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = grace
+}
+
+// forwardSignals catches SIGINT, SIGTERM and SIGHUP sent to run until the
+// returned function is called, so that run outlives the program and can
+// clean up after it. They are relayed to the process group of the started
+// cmd, if it has one of its own. Otherwise the program shares run's group,
+// where Ctrl+C already reached it from the terminal, so only the signals
+// that may have been meant for run alone are passed on to it.
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	ownGroup := cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				noteInterrupt(sig)
+				switch {
+				case ownGroup:
+					syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+				case sig != syscall.SIGINT:
+					cmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// processAlive reports whether a process with the given PID exists. One
+// run isn't allowed to signal still counts.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dockerImage says how to package a language in a container image. The
+// config file's dockerImages replace entries or add languages.
+type dockerImage struct {
+	// Build is the image the program is compiled in; empty for interpreted
+	// languages, which run straight from the source
+	Build string `json:"build,omitempty"`
+	// Image is the image the program runs in: a distroless or scratch image
+	// for compiled programs
+	Image string `json:"image"`
+	// Compile builds the program in the build stage; {file} is the source
+	// and {out} the executable to produce
+	Compile []string `json:"compile,omitempty"`
+	// Setup runs in the image before the source is copied, e.g. to install
+	// a runtime the base image lacks
+	Setup []string `json:"setup,omitempty"`
+	// Entrypoint starts the program, with the same placeholders; the
+	// language's run command on the source when empty
+	Entrypoint []string `json:"entrypoint,omitempty"`
+}
+
+// copyDataDeps writes the COPY instructions that put the --with paths in
+// the image at the same paths relative to the working directory.
+func copyDataDeps(b *strings.Builder) {
+	for _, dep := range dataDeps {
+		path := filepath.ToSlash(filepath.Clean(dep))
+		fmt.Fprintf(b, "COPY %s\n", dockerArgv([]string{path, path}))
+	}
+}
+
+// dockerImages are the built-in container recipes, keyed by extension.
+var dockerImages = map[string]dockerImage{
+	".py":  {Image: "python:3.12-slim"},
+	".js":  {Image: "node:22-slim"},
+	".ts":  {Image: "node:22-slim", Setup: []string{"npm install -g ts-node typescript"}},
+	".rb":  {Image: "ruby:3.3-slim"},
+	".php": {Image: "php:8.3-cli"},
+	".pl":  {Image: "perl:5-slim"},
+	".sh":  {Image: "bash:5"},
+	".go": {Build: "golang:1.24", Image: "gcr.io/distroless/static-debian12",
+		Compile: []string{"env", "CGO_ENABLED=0", "go", "build", "-o", "{out}", "{file}"}},
+	".c": {Build: "gcc:14", Image: "gcr.io/distroless/static-debian12",
+		Compile: []string{"gcc", "-O2", "-static", "-o", "{out}", "{file}"}},
+	".cpp": {Build: "gcc:14", Image: "gcr.io/distroless/static-debian12",
+		Compile: []string{"g++", "-O2", "-static", "-o", "{out}", "{file}"}},
+	".rs": {Build: "rust:1", Image: "gcr.io/distroless/cc-debian12",
+		Compile: []string{"rustc", "-O", "-o", "{out}", "{file}"}},
+	".java": {Build: "eclipse-temurin:21-jdk", Image: "gcr.io/distroless/java21-debian12",
+		Compile:    []string{"javac", "-d", "{out}", "{file}"},
+		Entrypoint: []string{"java", "-cp", "{out}", "{class}"}},
+}
+
+// dockerTagUnsafe matches what can't be part of an image name.
+var dockerTagUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// dockerfile returns the Dockerfile that packages sourceFile, a file of
+// language ext: a single stage that runs the source for interpreted
+// languages, or a build stage and a minimal final stage for compiled ones.
+func dockerfile(sourceFile, ext string) (string, error) {
+	recipe, ok := dockerImages[ext]
+	if !ok {
+		name := ext
+		if lang, ok := languageConfigs[ext]; ok {
+			name = lang.Name
+		}
+		return "", fmt.Errorf("no container recipe for %s; add one under dockerImages in the config", name)
+	}
+	base := filepath.Base(sourceFile)
+	lang := languageConfigs[ext]
+	class := strings.TrimSuffix(base, ext)
+	if lang.ClassNameFn != nil {
+		class = lang.ClassNameFn(base)
+	}
+	expand := func(argv []string, file, out string) []string {
+		r := strings.NewReplacer("{file}", file, "{out}", out, "{class}", class)
+		expanded := make([]string, len(argv))
+		for i, arg := range argv {
+			expanded[i] = r.Replace(arg)
+		}
+		return expanded
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by run %s for %s\n", version, base)
+	if recipe.Build == "" {
+		entrypoint := recipe.Entrypoint
+		if len(entrypoint) == 0 {
+			if len(lang.RunCmd) == 0 {
+				return "", fmt.Errorf("%s has no run command to start the container with; set an entrypoint under dockerImages", lang.Name)
+			}
+			entrypoint = append(append([]string{}, lang.RunCmd...), "{file}")
+		}
+		fmt.Fprintf(&b, "FROM %s\n", recipe.Image)
+		for _, setup := range recipe.Setup {
+			fmt.Fprintf(&b, "RUN %s\n", setup)
+		}
+		fmt.Fprintf(&b, "WORKDIR /app\nCOPY %s .\n", base)
+		copyDataDeps(&b)
+		fmt.Fprintf(&b, "ENTRYPOINT %s\n", dockerArgv(expand(entrypoint, base, "")))
+		return b.String(), nil
+	}
+
+	if len(recipe.Compile) == 0 {
+		return "", fmt.Errorf("the dockerImages entry for %s has a build image but no compile command", ext)
+	}
+	entrypoint := recipe.Entrypoint
+	if len(entrypoint) == 0 {
+		entrypoint = []string{"{out}"}
+	}
+	fmt.Fprintf(&b, "FROM %s AS build\n", recipe.Build)
+	for _, setup := range recipe.Setup {
+		fmt.Fprintf(&b, "RUN %s\n", setup)
+	}
+	fmt.Fprintf(&b, "WORKDIR /src\nCOPY %s .\n", base)
+	fmt.Fprintf(&b, "RUN %s\n\n", shellJoin(expand(recipe.Compile, base, "/out/app")))
+	fmt.Fprintf(&b, "FROM %s\n", recipe.Image)
+	fmt.Fprintf(&b, "COPY --from=build /out/app /app\n")
+	if len(dataDeps) > 0 {
+		// The program runs among its data, as it would next to the source
+		b.WriteString("WORKDIR /data\n")
+		copyDataDeps(&b)
+	}
+	fmt.Fprintf(&b, "ENTRYPOINT %s\n", dockerArgv(expand(entrypoint, base, "/app")))
+	return b.String(), nil
+}
+
+// dockerArgv renders argv in the exec form of ENTRYPOINT, a JSON array.
+func dockerArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = strconv.Quote(arg)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// packageCommand implements
+// `run package <file> --docker [-t image] [--with path]... [--dockerfile-only] [--force]`.
+func packageCommand(args []string) (string, error) {
+	var sourceFile, tag string
+	var docker, dockerfileOnly, overwrite bool
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--docker":
+			docker = true
+		case arg == "--dockerfile-only":
+			dockerfileOnly = true
+		case arg == "--force":
+			overwrite = true
+		case arg == "--with":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --with (e.g. --with data.csv)")
+			}
+			dataDeps = append(dataDeps, args[i+1])
+			i++
+		case arg == "-t" || arg == "--tag":
+			if i+1 >= len(args) {
+				return "", usageError("Missing image name for %s (e.g. -t myimage:dev)", arg)
+			}
+			tag = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "-") || sourceFile != "":
+			return "", usageError("Usage: run package <file> --docker [-t image] [--with path]... [--dockerfile-only] [--force]")
+		default:
+			sourceFile = arg
+		}
+	}
+	if sourceFile == "" || !docker {
+		return sourceFile, usageError("Usage: run package <file> --docker [-t image] [--with path]... [--dockerfile-only] [--force]")
+	}
+	if err := checkSourceFile(sourceFile); err != nil {
+		return sourceFile, err
+	}
+	if _, err := loadProjectConfig(sourceFile); err != nil {
+		return sourceFile, usageError("Invalid project config: %v", err)
+	}
+	// The --with paths go into the image from the build context, the
+	// file's directory
+	if err := checkDataDeps(filepath.Dir(sourceFile)); err != nil {
+		return sourceFile, err
+	}
+	for _, dep := range dataDeps {
+		if !filepath.IsLocal(dep) {
+			return sourceFile, usageError("--with %s must be a relative path within %s, the directory docker builds from.", dep, filepath.Dir(sourceFile))
+		}
+	}
+	ext, _ := detectExt(sourceFile)
+	content, err := dockerfile(sourceFile, ext)
+	if err != nil {
+		return sourceFile, newRunError("unsupported-language", exitUnsupported, "%v", err)
+	}
+
+	if dockerfileOnly {
+		path := filepath.Join(filepath.Dir(sourceFile), "Dockerfile")
+		if _, err := os.Stat(path); err == nil && !overwrite {
+			return sourceFile, usageError("%s already exists. Use --force to overwrite it.", path)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return sourceFile, err
+		}
+		fmt.Printf("Wrote %s; build it with: docker build -t %s %s\n", path, packageTag(sourceFile, tag), shellQuote(filepath.Dir(sourceFile)))
+		return sourceFile, nil
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+			"docker not found. Install Docker, or use --dockerfile-only to only write the Dockerfile.")
+	}
+	f, err := os.CreateTemp("", "run-Dockerfile-")
+	if err != nil {
+		return sourceFile, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return sourceFile, err
+	}
+	f.Close()
+
+	tag = packageTag(sourceFile, tag)
+	cmd := exec.Command("docker", "build", "-f", f.Name(), "-t", tag, filepath.Dir(sourceFile))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runCmd(cmd); err != nil {
+		return sourceFile, newRunError("compile-failed", exitCompileFailed, "docker build failed: %v\n  Command: %s", err, shellJoin(cmd.Args))
+	}
+	size := ""
+	if out, err := outputCmd(exec.Command("docker", "image", "inspect", "-f", "{{.Size}}", tag)); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err == nil {
+			size = " (" + formatSize(n) + ")"
+		}
+	}
+	fmt.Printf("Built image %s%s\n", tag, size)
+	return sourceFile, nil
+}
+
+// packageTag returns the image name to build: the one given with -t or one
+// derived from the file name.
+func packageTag(sourceFile, tag string) string {
+	if tag != "" {
+		return tag
+	}
+	name := strings.TrimSuffix(filepath.Base(sourceFile), filepath.Ext(sourceFile))
+	name = strings.Trim(dockerTagUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-._")
+	if name == "" {
+		name = "app"
+	}
+	return name + ":latest"
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errPickCancelled is returned when the user leaves the picker without choosing.
+var errPickCancelled = errors.New("no file selected")
+
+// pickerPageSize is the number of candidates shown at once by the picker.
+const pickerPageSize = 10
+
+// findCandidates returns the supported source files in dir, sorted by name.
+func findCandidates(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if _, ok := languageConfigs[filepath.Ext(entry.Name())]; ok {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// isTerminal reports whether f is attached to a terminal, i.e. a character
+// device other than the null device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// pickFile lets the user choose one of candidates. It uses the interactive
+// picker when possible, a numbered prompt on dumb terminals, and fails
+// outright when stdin is not a terminal.
+func pickFile(candidates []string) (string, error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("%d candidate files found; pass one explicitly", len(candidates))
+	}
+	if runtime.GOOS != "windows" && os.Getenv("TERM") != "dumb" {
+		if choice, ok, err := interactivePick(candidates); ok {
+			return choice, err
+		}
+	}
+	return numberedPick(candidates)
+}
+
+// candidateLabel describes a candidate file with its detected language.
+func candidateLabel(file string) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%-30s %-8s %s", file, ext, languageConfigs[ext].CheckCmd[0])
+}
+
+func numberedPick(candidates []string) (string, error) {
+	for i, file := range candidates {
+		fmt.Fprintf(os.Stderr, "%3d) %s\n", i+1, candidateLabel(file))
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Select a file [1-%d]: ", len(candidates))
+		input, err := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" && err != nil {
+			return "", errPickCancelled
+		}
+		n, convErr := strconv.Atoi(input)
+		if convErr == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1], nil
+		}
+		if input == "" {
+			return "", errPickCancelled
+		}
+		fmt.Fprintf(os.Stderr, "Invalid choice: %s\n", input)
+	}
+}
+
+// fuzzyMatch reports whether all characters of pattern appear in s in order,
+// ignoring case.
+func fuzzyMatch(pattern, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+// interactivePick runs the arrow-key picker. ok is false when the terminal
+// could not be switched to raw mode, in which case the caller should fall
+// back to the numbered prompt.
+func interactivePick(candidates []string) (choice string, ok bool, err error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return "", false, nil
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return "", false, nil
+	}
+	defer stty(strings.TrimSpace(saved))
+
+	reader := bufio.NewReader(os.Stdin)
+	filter := ""
+	selected := 0
+	drawn := 0
+
+	for {
+		var matches []string
+		for _, file := range candidates {
+			if fuzzyMatch(filter, file) {
+				matches = append(matches, file)
+			}
+		}
+		selected = max(0, min(selected, len(matches)-1))
+
+		// Redraw the picker in place
+		if drawn > 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[%dA", drawn)
+		}
+		fmt.Fprint(os.Stderr, "\r\033[J")
+		fmt.Fprintln(os.Stderr, "Select a file (type to filter, ↑/↓ to move, Enter to run, Ctrl-C to cancel)")
+		fmt.Fprintf(os.Stderr, "> %s\n", filter)
+		drawn = 2
+		start := max(0, selected-pickerPageSize+1)
+		for i := start; i < len(matches) && i < start+pickerPageSize; i++ {
+			marker := "  "
+			if i == selected {
+				marker = "\033[7m>"
+			}
+			fmt.Fprintf(os.Stderr, "%s %s\033[0m\n", marker, candidateLabel(matches[i]))
+			drawn++
+		}
+
+		b, readErr := reader.ReadByte()
+		if readErr != nil {
+			return "", true, errPickCancelled
+		}
+		switch b {
+		case 3, 4: // Ctrl-C, Ctrl-D
+			return "", true, errPickCancelled
+		case '\r', '\n':
+			if len(matches) > 0 {
+				return matches[selected], true, nil
+			}
+		case 127, 8: // Backspace
+			if filter != "" {
+				filter = filter[:len(filter)-1]
+			}
+		case 27: // Escape sequence, arrows are ESC [ A / ESC [ B
+			if next, _ := reader.ReadByte(); next != '[' {
+				return "", true, errPickCancelled
+			}
+			switch arrow, _ := reader.ReadByte(); arrow {
+			case 'A':
+				selected--
+			case 'B':
+				selected++
+			}
+		default:
+			if b >= 32 && b < 127 {
+				filter += string(b)
+				selected = 0
+			}
+		}
+	}
+}
+
+// stty runs stty against the controlling terminal on stdin.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := outputCmd(cmd)
+	return string(out), err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// porcelainVersion is the version of the --porcelain event schema. It
+// changes only when a field changes meaning or goes away; new fields and
+// events may appear without it changing.
+const porcelainVersion = 1
+
+var (
+	// porcelainOut receives the --porcelain events; nil disables them.
+	porcelainOut io.Writer
+	porcelainMu  sync.Mutex
+	// porcelainStart is when run started, for the summary's duration.
+	porcelainStart = time.Now()
+)
+
+// porcelainEvent is one line of the --porcelain stream: phase-started and
+// phase-finished around each phase (detect, check, install, prepare,
+// compile, run, cleanup), then one summary.
+type porcelainEvent struct {
+	Version    int    `json:"version"`
+	Event      string `json:"event"`
+	Time       string `json:"time"`
+	Phase      string `json:"phase,omitempty"`
+	Outcome    string `json:"outcome,omitempty"` // ok or failed
+	DurationMs *int64 `json:"durationMs,omitempty"`
+	Slow       bool   `json:"slow,omitempty"` // The phase took longer than it should
+	Message    string `json:"message,omitempty"`
+	File       string `json:"file,omitempty"`
+	Status     string `json:"status,omitempty"` // Summary: ok or the status of the status line
+	ExitCode   *int   `json:"exitCode,omitempty"`
+}
+
+// openPorcelain sends the events to the already open file descriptor fd,
+// or to stderr when fd is 2.
+func openPorcelain(fd int) error {
+	if fd == 2 {
+		porcelainOut = os.Stderr
+		return nil
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if f == nil {
+		return fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	porcelainOut = f
+	return nil
+}
+
+// emitPorcelain writes event as one line, if --porcelain is on.
+func emitPorcelain(event porcelainEvent) {
+	if porcelainOut == nil {
+		return
+	}
+	event.Version = porcelainVersion
+	event.Time = time.Now().Format(time.RFC3339Nano)
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	porcelainMu.Lock()
+	porcelainOut.Write(append(line, '\n'))
+	porcelainMu.Unlock()
+}
+
+// porcelainPhase is a phase in progress; see beginPhase.
+type porcelainPhase struct {
+	name  string
+	start time.Time
+	done  bool
+}
+
+// beginPhase reports that a phase started. Its end method reports that it
+// finished, once.
+func beginPhase(name string) *porcelainPhase {
+	emitPorcelain(porcelainEvent{Event: "phase-started", Phase: name})
+	return &porcelainPhase{name: name, start: time.Now()}
+}
+
+// end reports the phase finished, failed when err is not nil, and records
+// how long it took.
+func (p *porcelainPhase) end(err error) {
+	if p.done {
+		return
+	}
+	p.done = true
+	d := time.Since(p.start)
+	slow := recordPhase(p.name, d, err)
+	ms := d.Milliseconds()
+	event := porcelainEvent{Event: "phase-finished", Phase: p.name, Outcome: "ok", DurationMs: &ms, Slow: slow}
+	if err != nil {
+		event.Outcome, event.Message = "failed", err.Error()
+	}
+	emitPorcelain(event)
+}
+
+// porcelainSummary reports how the run ended: ok, or re's status and exit
+// code, the same as on the status line.
+func porcelainSummary(file string, re *runError) {
+	ms := time.Since(porcelainStart).Milliseconds()
+	event := porcelainEvent{Event: "summary", File: file, Status: "ok", DurationMs: &ms}
+	code := 0
+	if re != nil {
+		event.Status, code, event.Message = re.Status, re.Code, re.Msg
+		if event.Message == "" {
+			event.Message = re.Summary
+		}
+	}
+	event.ExitCode = &code
+	emitPorcelain(event)
+}
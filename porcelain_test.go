@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// porcelainV1Fields are the fields of version 1 of the --porcelain events.
+// Plugins rely on them: later versions of run may add fields, but a field
+// here may only go away or change meaning with a new porcelainVersion.
+var porcelainV1Fields = []string{
+	"version", "event", "time", "phase", "outcome", "durationMs", "slow", "message", "file", "status", "exitCode",
+}
+
+func TestPorcelainFieldsAreCompatible(t *testing.T) {
+	if porcelainVersion != 1 {
+		t.Fatalf("porcelainVersion is %d; update porcelainV1Fields to the new version's fields", porcelainVersion)
+	}
+	var fields []string
+	typ := reflect.TypeOf(porcelainEvent{})
+	for i := range typ.NumField() {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	for _, field := range porcelainV1Fields {
+		if !slices.Contains(fields, field) {
+			t.Errorf("the %q field of version 1 is gone", field)
+		}
+	}
+}
+
+// TestPorcelainStream runs a program with --porcelain on file descriptor 3
+// and checks the events, and that the program's output is untouched.
+func TestPorcelainStream(t *testing.T) {
+	requireTools(t, "python3")
+	env := newRunEnv(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "ok.py", "print('out')\n")
+	writeFile(t, dir, "fail.py", "import sys\nprint('err', file=sys.stderr)\nsys.exit(4)\n")
+
+	tests := []struct {
+		file           string
+		stdout, stderr string
+		status         string
+		code           int
+	}{
+		{"ok.py", "out\n", "", "ok", 0},
+		{"fail.py", "", "err\n", "program-failed", 4},
+	}
+	for _, tt := range tests {
+		events, stdout, stderr := runPorcelain(t, env, dir, "--quiet", "--porcelain", "--porcelain-fd", "3", tt.file)
+		if stdout != tt.stdout {
+			t.Errorf("%s: stdout %q, want %q", tt.file, stdout, tt.stdout)
+		}
+		if !strings.HasPrefix(stderr, tt.stderr) {
+			t.Errorf("%s: stderr %q, want it to start with %q", tt.file, stderr, tt.stderr)
+		}
+
+		var started, finished []string
+		for i, e := range events {
+			if e["version"] != 1.0 {
+				t.Errorf("%s: event %d has version %v", tt.file, i, e["version"])
+			}
+			if _, ok := e["time"].(string); !ok {
+				t.Errorf("%s: event %d has no time", tt.file, i)
+			}
+			switch e["event"] {
+			case "phase-started":
+				started = append(started, e["phase"].(string))
+			case "phase-finished":
+				finished = append(finished, e["phase"].(string))
+				if e["outcome"] != "ok" && e["outcome"] != "failed" {
+					t.Errorf("%s: phase %v finished with outcome %v", tt.file, e["phase"], e["outcome"])
+				}
+				if _, ok := e["durationMs"].(float64); !ok {
+					t.Errorf("%s: phase %v finished without durationMs", tt.file, e["phase"])
+				}
+			case "summary":
+				if i != len(events)-1 {
+					t.Errorf("%s: the summary is event %d of %d, not the last", tt.file, i, len(events))
+				}
+				if e["status"] != tt.status || e["exitCode"] != float64(tt.code) || e["file"] != tt.file {
+					t.Errorf("%s: summary %v, want status %s and exit code %d", tt.file, e, tt.status, tt.code)
+				}
+			default:
+				t.Errorf("%s: unknown event %v", tt.file, e["event"])
+			}
+		}
+		if !slices.Equal(started, finished) {
+			t.Errorf("%s: phases started %v but finished %v", tt.file, started, finished)
+		}
+		for _, phase := range []string{"detect", "check", "run"} {
+			if !slices.Contains(started, phase) {
+				t.Errorf("%s: no %s phase in %v", tt.file, phase, started)
+			}
+		}
+		if len(events) == 0 || events[len(events)-1]["event"] != "summary" {
+			t.Errorf("%s: no summary", tt.file)
+		}
+	}
+}
+
+// runPorcelain runs run with args, reading the events from file descriptor
+// 3.
+func runPorcelain(t *testing.T, env *runEnv, dir string, args ...string) (events []map[string]any, stdout, stderr string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cmd := env.command(t, dir, args...)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var event map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("not a JSON event: %q", scanner.Text())
+		}
+		events = append(events, event)
+	}
+	cmd.Wait()
+	return events, out.String(), errOut.String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// hardenLimits lowers the hard limits of the started process pid to its
+// CPU and memory limits, so that it can't raise the soft ones startLimited
+// gave it, and SIGKILL follows a SIGXCPU it ignores. It is best effort:
+// the soft limits are in place either way.
+func hardenLimits(pid int, limits childLimits) {
+	set := func(resource int, max uint64) {
+		var old syscall.Rlimit
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), 0, uintptr(unsafe.Pointer(&old)), 0, 0)
+		if errno != 0 || old.Max < max {
+			return // Already lower than the limit
+		}
+		limit := syscall.Rlimit{Cur: min(old.Cur, max), Max: max}
+		syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	}
+	if limits.CPU > 0 {
+		set(syscall.RLIMIT_CPU, uint64((limits.CPU+time.Second-1)/time.Second)+1)
+	}
+	if limits.Memory > 0 {
+		set(syscall.RLIMIT_DATA, uint64(limits.Memory))
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// hardenLimits relies on Linux's prlimit; elsewhere the child keeps run's
+// hard limits, and only the soft ones startLimited set apply.
+func hardenLimits(pid int, limits childLimits) {}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// runProfile is a named set of flags kept in a config file and selected
+// with --profile, e.g. a bench-ci profile holding
+// --bench 50 --warmup 3 --json --assert-max-mean 200ms.
+type runProfile struct {
+	Extends string   `json:"extends,omitempty"` // Profile whose flags go first
+	Flags   []string `json:"flags"`
+}
+
+// profileEntry is a profile as `run config profiles` reports it.
+type profileEntry struct {
+	Extends string   `json:"extends,omitempty"`
+	Flags   []string `json:"flags"` // Including those of the profiles it extends
+	Source  string   `json:"source"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// loadProfiles returns the profiles of the global config and of the project
+// config governing dir, which replaces global profiles of the same name, and
+// where each one is defined.
+func loadProfiles(dir string) (map[string]runProfile, map[string]string, error) {
+	profiles := map[string]runProfile{}
+	sources := map[string]string{}
+	if globalConfig != nil {
+		for name, profile := range globalConfig.Profiles {
+			profiles[name], sources[name] = profile, "global config"
+		}
+	}
+	if path := findProjectConfig(dir); path != "" {
+		config, err := loadConfigFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if config != nil {
+			for name, profile := range config.Profiles {
+				profiles[name], sources[name] = profile, "project config"
+			}
+		}
+	}
+	return profiles, sources, nil
+}
+
+// resolveProfile returns the flags of the profile name, preceded by those
+// of the profiles it extends.
+func resolveProfile(profiles map[string]runProfile, name string, chain []string) ([]string, error) {
+	if slices.Contains(chain, name) {
+		return nil, fmt.Errorf("profile %s extends itself: %s -> %s", name, strings.Join(chain, " -> "), name)
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		if len(chain) > 0 {
+			return nil, fmt.Errorf("profile %s extends unknown profile %q", chain[len(chain)-1], name)
+		}
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %q: no profiles are defined (see profiles in run config init)", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q; available: %s", name, strings.Join(profileNames(profiles), ", "))
+	}
+	var flags []string
+	if profile.Extends != "" {
+		base, err := resolveProfile(profiles, profile.Extends, append(chain, name))
+		if err != nil {
+			return nil, err
+		}
+		flags = base
+	}
+	flags = append(flags, profile.Flags...)
+	if err := checkFlagsOnly(flags, "profile "+name); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func profileNames(profiles map[string]runProfile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandProfiles takes the --profile options out of args and returns the
+// flags of the profiles they name, in order, and the remaining arguments.
+// The project config is looked up from the directory of the file to run.
+func expandProfiles(args []string) ([]string, []string, error) {
+	if !slices.Contains(args, "--profile") {
+		return nil, args, nil
+	}
+	dir := "."
+	if file := fileArgument(args); file != "" {
+		dir = filepath.Dir(file)
+	}
+	profiles, _, err := loadProfiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			// The program's arguments
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if args[i] != "--profile" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, nil, fmt.Errorf("missing name for --profile; available: %s", strings.Join(profileNames(profiles), ", "))
+		}
+		resolved, err := resolveProfile(profiles, args[i+1], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		flags = append(flags, resolved...)
+		i++
+	}
+	return flags, rest, nil
+}
+
+// fileArgument returns the first argument that isn't a flag or a flag's
+// value, which is the file to run.
+func fileArgument(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			return ""
+		case slices.Contains(valueFlags, arg):
+			i++
+		case arg == "--bench" || arg == "-b":
+			if i+1 < len(args) && isNumeric(args[i+1]) {
+				i++
+			}
+		case !strings.HasPrefix(arg, "-"):
+			return arg
+		}
+	}
+	return ""
+}
+
+// profilesCommand implements `run config profiles [--json]`, listing the
+// profiles that apply in the current directory with their effective flags.
+func profilesCommand(args []string) {
+	asJSON := len(args) > 0 && args[0] == "--json"
+	profiles, sources, err := loadProfiles(".")
+	if err != nil {
+		fmt.Printf("Invalid project config: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	entries := map[string]profileEntry{}
+	for _, name := range profileNames(profiles) {
+		entry := profileEntry{Extends: profiles[name].Extends, Source: sources[name]}
+		flags, err := resolveProfile(profiles, name, nil)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entry.Flags = flags
+		entries[name] = entry
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No profiles are defined. Add them under \"profiles\" in the global config or a .run.json.")
+		return
+	}
+	for _, name := range profileNames(profiles) {
+		entry := entries[name]
+		extends := ""
+		if entry.Extends != "" {
+			extends = " (extends " + entry.Extends + ")"
+		}
+		if entry.Error != "" {
+			fmt.Printf("%s%s  [%s]\n  invalid: %s\n", name, extends, entry.Source, entry.Error)
+			continue
+		}
+		fmt.Printf("%s%s  [%s]\n  %s\n", name, extends, entry.Source, shellJoin(entry.Flags))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// progressStreamAfter is how long a step runs quietly before its raw
+	// output is shown after all, so a stuck step can be diagnosed.
+	progressStreamAfter = 60 * time.Second
+	// progressLineEvery is how often a step reports it is still running
+	// when stderr is not a terminal.
+	progressLineEvery = 15 * time.Second
+)
+
+// verbose shows more of what run does, including the full output of
+// installation and compilation steps (--verbose).
+var verbose bool
+
+// quiet keeps run's own messages off stdout so that it carries the
+// program's output only, as when run is a script's interpreter.
+// Informational messages are dropped; warnings and errors go to stderr.
+var quiet bool
+
+// infof prints an informational message unless quiet.
+func infof(format string, a ...any) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// messageOut is where warnings and error messages go: stdout like the rest
+// of run's output, or stderr when quiet.
+func messageOut() io.Writer {
+	if quiet {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// warnf prints a warning.
+func warnf(format string, a ...any) {
+	fmt.Fprintf(messageOut(), "Warning: "+format+"\n", a...)
+}
+
+// jsonReport is set when a report is printed as JSON (--json).
+var jsonReport bool
+
+// spinnerOff reports whether steps go without a spinner or progress lines,
+// which would get in the way of machine-readable output: --porcelain events,
+// --error-format json and --json reports.
+func spinnerOff() bool {
+	return porcelainOut != nil || errorFormat == "json" || jsonReport
+}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressStep presents a long-running step such as an installation or a
+// compilation. It collects the step's output and shows a spinner with the
+// elapsed time instead; the output is streamed from the start with
+// --verbose, from progressStreamAfter on otherwise, and dumped if the step
+// fails. With spinnerOff, the step is only collected and dumped.
+type progressStep struct {
+	phase string
+	start time.Time
+	tty   bool
+	off   bool
+
+	mu        sync.Mutex
+	output    bytes.Buffer
+	streaming bool
+	done      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// startStep starts presenting a step described by phase, e.g. "compiling".
+func startStep(phase string) *progressStep {
+	s := &progressStep{
+		phase:     phase,
+		start:     time.Now(),
+		tty:       isTerminal(os.Stderr),
+		off:       spinnerOff(),
+		streaming: verbose,
+		done:      make(chan struct{}),
+	}
+	s.stopped.Add(1)
+	go s.present()
+	return s
+}
+
+// Write collects or streams the step's output; use the step as the
+// command's Stdout and Stderr.
+func (s *progressStep) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streaming {
+		return os.Stderr.Write(p)
+	}
+	return s.output.Write(p)
+}
+
+func (s *progressStep) present() {
+	defer s.stopped.Done()
+	if s.off {
+		<-s.done
+		return
+	}
+	interval := 100 * time.Millisecond
+	if !s.tty {
+		interval = progressLineEvery
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		elapsed := time.Since(s.start).Truncate(time.Second)
+
+		s.mu.Lock()
+		if !s.streaming && elapsed >= progressStreamAfter {
+			// Taking long: show what the step has been doing
+			s.clearLine()
+			fmt.Fprintf(os.Stderr, "Still %s after %v, showing its output:\n", s.phase, elapsed)
+			s.output.WriteTo(os.Stderr)
+			s.streaming = true
+		}
+		if !s.streaming {
+			if s.tty {
+				fmt.Fprintf(os.Stderr, "\r%s %s (%v)", spinnerFrames[frame%len(spinnerFrames)], s.phase, elapsed)
+			} else {
+				fmt.Fprintf(os.Stderr, "still %s (%v)...\n", s.phase, elapsed)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// clearLine erases the spinner; s.mu must be held.
+func (s *progressStep) clearLine() {
+	if s.tty && !s.off {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// Finish stops the presentation. When the step failed, the output that was
+// held back is shown.
+func (s *progressStep) Finish(err error) {
+	close(s.done)
+	s.stopped.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clearLine()
+	if err != nil && !s.streaming {
+		s.output.WriteTo(os.Stderr)
+	}
+	s.streaming = true // Late writes go straight through
+}
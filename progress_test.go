@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// TestSpinnerOff runs a step on a terminal, as it is after a minute, and
+// checks that nothing but its failure output reaches stderr while
+// machine-readable output is on.
+func TestSpinnerOff(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func()
+	}{
+		{"--porcelain", func() { porcelainOut = io.Discard }},
+		{"--error-format json", func() { errorFormat = "json" }},
+		{"--json", func() { jsonReport = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(out io.Writer, format string, report bool) {
+				porcelainOut, errorFormat, jsonReport = out, format, report
+			}(porcelainOut, errorFormat, jsonReport)
+			tt.set()
+
+			got := captureStderr(t, func() {
+				s := &progressStep{
+					phase: "compiling",
+					start: time.Now().Add(-2 * progressStreamAfter),
+					tty:   true,
+					off:   spinnerOff(),
+					done:  make(chan struct{}),
+				}
+				s.stopped.Add(1)
+				go s.present()
+				s.Write([]byte("error: bad\n"))
+				time.Sleep(300 * time.Millisecond)
+				s.Finish(io.ErrUnexpectedEOF)
+			})
+			if got != "error: bad\n" {
+				t.Errorf("stderr %q, want only the step's output", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// noProject runs a file on its own even when it belongs to a project
+// (--no-project).
+var noProject bool
+
+// projectKind is a kind of project run recognizes by the file at its root,
+// and runs with the project's own build tool.
+type projectKind struct {
+	Marker string // The file at the project root, e.g. go.mod
+	Name   string // e.g. "Go module"
+	Exts   []string
+	// Command returns the command that runs sourceFile's program from the
+	// project root, or nil when the file isn't one the tool can run.
+	Command func(root, sourceFile string) []string
+}
+
+// projectKinds are the projects run knows, in order of precedence when one
+// directory has the markers of several.
+var projectKinds = []projectKind{
+	{"go.mod", "Go module", []string{".go"}, goProjectCommand},
+	{"Cargo.toml", "Cargo package", []string{".rs"}, cargoProjectCommand},
+	{"package.json", "npm package", []string{".js", ".mjs", ".cjs", ".ts"}, npmProjectCommand},
+	{"pom.xml", "Maven project", []string{".java"}, mavenProjectCommand},
+	{"build.gradle", "Gradle project", []string{".java", ".kt", ".groovy"}, gradleProjectCommand},
+	{"build.gradle.kts", "Gradle project", []string{".java", ".kt", ".groovy"}, gradleProjectCommand},
+}
+
+// findProject returns the kind and root of the nearest project above
+// sourceFile for a file of ext. The search stops at the root of the
+// repository the file is in, if any, so that a stray package.json in the
+// home directory doesn't capture every script below it.
+func findProject(sourceFile, ext string) (projectKind, string, bool) {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return projectKind{}, "", false
+	}
+	for dir := filepath.Dir(abs); ; {
+		for _, kind := range projectKinds {
+			if !slices.Contains(kind.Exts, ext) {
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(dir, kind.Marker)); err == nil && info.Mode().IsRegular() {
+				return kind, dir, true
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return projectKind{}, "", false
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return projectKind{}, "", false
+		}
+		dir = parent
+	}
+}
+
+// projectPlan returns the plan that runs sourceFile with the build tool of
+// the project it belongs to, from the project root, or false when it should
+// run on its own: with --no-project, outside a project, and for what the
+// project's tool doesn't do, such as --check or --compile-only.
+func projectPlan(sourceFile, ext string) (execPlan, bool) {
+	if noProject || checkOnly || compileOnly || outPath != "" || filepath.Ext(sourceFile) != ext {
+		return execPlan{}, false
+	}
+	kind, root, ok := findProject(sourceFile, ext)
+	if !ok {
+		return execPlan{}, false
+	}
+	argv := kind.Command(root, sourceFile)
+	if argv == nil {
+		return execPlan{}, false
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		// e.g. rustc without cargo: the file still runs on its own
+		return execPlan{}, false
+	}
+	return execPlan{
+		SourceFile: sourceFile,
+		Run:        argv,
+		Dir:        root,
+		Project:    fmt.Sprintf("%s in %s (%s)", kind.Name, root, kind.Marker),
+	}, true
+}
+
+// projectRel returns the path of sourceFile's directory relative to root,
+// in the ./dir form go run takes.
+func projectRel(root, sourceFile string) string {
+	abs, _ := filepath.Abs(sourceFile)
+	rel, err := filepath.Rel(root, filepath.Dir(abs))
+	if err != nil || rel == "." {
+		return "."
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+// goProjectCommand runs the package sourceFile is in: go run . at the
+// module root, go run ./cmd/tool for a file in cmd/tool.
+func goProjectCommand(root, sourceFile string) []string {
+	argv := resolveRuntime(".go", []string{"go"})
+	argv = append(argv, "run", projectRel(root, sourceFile))
+	return append(argv, programArgs...)
+}
+
+// cargoProjectCommand runs the package's binary with cargo run, or the
+// binary or example sourceFile is, for a file in src/bin or examples.
+func cargoProjectCommand(root, sourceFile string) []string {
+	argv := []string{"cargo", "run", "--quiet"}
+	name := strings.TrimSuffix(filepath.Base(sourceFile), ".rs")
+	switch projectRel(root, sourceFile) {
+	case "./src/bin":
+		argv = append(argv, "--bin", name)
+	case "./examples":
+		argv = append(argv, "--example", name)
+	}
+	if len(programArgs) > 0 {
+		argv = append(argv, "--")
+	}
+	return append(argv, programArgs...)
+}
+
+// npmProjectCommand runs the package's entry point (its "main", or
+// index.js) with npm start when the package has a start script and with
+// node . otherwise. Other files of the package run on their own.
+func npmProjectCommand(root, sourceFile string) []string {
+	var pkg struct {
+		Main    string            `json:"main"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := os.ReadFile(filepath.Join(root, "package.json"))
+	if err != nil || json.Unmarshal(data, &pkg) != nil {
+		return nil
+	}
+	main := pkg.Main
+	if main == "" {
+		main = "index.js"
+	}
+	if !sameFile(sourceFile, filepath.Join(root, main)) {
+		return nil
+	}
+	if _, ok := pkg.Scripts["start"]; ok {
+		argv := []string{"npm", "start", "--silent"}
+		if len(programArgs) > 0 {
+			argv = append(argv, "--")
+		}
+		return append(argv, programArgs...)
+	}
+	if filepath.Ext(sourceFile) == ".ts" {
+		return nil
+	}
+	argv := resolveRuntime(".js", []string{"node"})
+	return append(append(argv, "."), programArgs...)
+}
+
+// javaPackage matches the package declaration of a Java file.
+var javaPackage = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;`)
+
+// mavenProjectCommand compiles the project and runs sourceFile's class with
+// the exec plugin, with the wrapper script when the project has one.
+func mavenProjectCommand(root, sourceFile string) []string {
+	content, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return nil
+	}
+	class := strings.TrimSuffix(filepath.Base(sourceFile), ".java")
+	if m := javaPackage.FindSubmatch(content); m != nil {
+		class = string(m[1]) + "." + class
+	}
+	argv := []string{wrapperOr(root, "mvnw", "mvn"), "-q", "compile", "exec:java", "-Dexec.mainClass=" + class}
+	if len(programArgs) > 0 {
+		argv = append(argv, "-Dexec.args="+shellJoin(programArgs))
+	}
+	return argv
+}
+
+// gradleMainClass matches the application's main class in a Gradle build
+// script: mainClass = "...", mainClass.set("...") or the older
+// mainClassName = '...'.
+var gradleMainClass = regexp.MustCompile(`(?m)^\s*(?:application\.)?mainClass(?:Name)?\s*(?:=|\.set\()\s*["']([\w.$]+)["']`)
+
+// jvmPackage matches the package declaration of a Java, Kotlin or Groovy
+// file, whose semicolon only Java requires.
+var jvmPackage = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;?\s*$`)
+
+// gradleProjectCommand runs the project's application with gradle run, with
+// the wrapper script when the project has one, when sourceFile holds its
+// main class. Other files of the project run on their own.
+func gradleProjectCommand(root, sourceFile string) []string {
+	var mainClass string
+	for _, script := range []string{"build.gradle", "build.gradle.kts"} {
+		data, err := os.ReadFile(filepath.Join(root, script))
+		if err != nil {
+			continue
+		}
+		if m := gradleMainClass.FindSubmatch(data); m != nil {
+			mainClass = string(m[1])
+			break
+		}
+	}
+	content, err := os.ReadFile(sourceFile)
+	if mainClass == "" || err != nil {
+		return nil
+	}
+	ext := filepath.Ext(sourceFile)
+	class := strings.TrimSuffix(filepath.Base(sourceFile), ext)
+	if m := jvmPackage.FindSubmatch(content); m != nil {
+		class = string(m[1]) + "." + class
+	}
+	// Kotlin compiles the top-level main of App.kt into the class AppKt
+	if mainClass != class && !(ext == ".kt" && mainClass == class+"Kt") {
+		return nil
+	}
+	argv := []string{wrapperOr(root, "gradlew", "gradle"), "-q", "run"}
+	if len(programArgs) > 0 {
+		argv = append(argv, "--args="+shellJoin(programArgs))
+	}
+	return argv
+}
+
+// wrapperOr returns the path of the project's wrapper script, e.g. ./mvnw,
+// or tool when there is none.
+func wrapperOr(root, wrapper, tool string) string {
+	path := filepath.Join(root, wrapper)
+	if runtime.GOOS == "windows" {
+		path += ".cmd"
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return tool
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+// TestGradleProjectCommand checks that only the file holding the
+// application's main class runs with gradle run.
+func TestGradleProjectCommand(t *testing.T) {
+	tests := []struct {
+		name, script, build, file, source string
+		run                               bool
+	}{
+		{"main class", "build.gradle", "application {\n    mainClass = 'com.example.App'\n}\n",
+			"src/main/java/com/example/App.java", "package com.example;\npublic class App {}\n", true},
+		{"other class", "build.gradle", "application {\n    mainClass = 'com.example.App'\n}\n",
+			"src/main/java/com/example/Util.java", "package com.example;\nclass Util {}\n", false},
+		{"set in Kotlin DSL", "build.gradle.kts", "application {\n    mainClass.set(\"com.example.AppKt\")\n}\n",
+			"src/main/kotlin/com/example/App.kt", "package com.example\n\nfun main() {}\n", true},
+		{"mainClassName", "build.gradle", "mainClassName = \"Main\"\n",
+			"src/main/java/Main.java", "public class Main {}\n", true},
+		{"no main class", "build.gradle", "plugins { id 'java' }\n",
+			"src/main/java/Main.java", "public class Main {}\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFile(t, root, tt.script, tt.build)
+			file := writeFile(t, root, tt.file, tt.source)
+			argv := gradleProjectCommand(root, file)
+			if (argv != nil) != tt.run {
+				t.Errorf("gradleProjectCommand = %q, want a command: %v", argv, tt.run)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// promptTimeout is how long run waits for an answer to an install prompt
+// before taking it as "no", so that editor plugins and scripts that forgot
+// --yes or --no-install don't hang. Zero or less waits indefinitely; the
+// config file sets it with promptTimeout (seconds).
+var promptTimeout = 60 * time.Second
+
+// confirmInstall shows the command run is about to execute and asks whether
+// to go ahead, on stderr so the question is seen even with stdout
+// redirected. Answers other than yes or no are asked again; end of input and
+// the timeout count as no.
+func confirmInstall(what string, command []string) bool {
+	fmt.Fprintf(os.Stderr, "%s\nThis will run: %s\n", what, shellJoin(command))
+	return askGoAhead("not installing")
+}
+
+// askGoAhead asks "Go ahead?" on stderr until it gets a yes or no. Running
+// out of time or input counts as no, in which case declined says what run
+// won't do.
+func askGoAhead(declined string) bool {
+	for {
+		if promptTimeout > 0 {
+			fmt.Fprintf(os.Stderr, "Go ahead? [y/n] (no in %s): ", promptTimeout)
+		} else {
+			fmt.Fprint(os.Stderr, "Go ahead? [y/n]: ")
+		}
+		answer, err := readAnswer(promptTimeout)
+		if errors.Is(err, errPromptTimeout) {
+			fmt.Fprintf(os.Stderr, "\nNo answer within %s; %s.\n", promptTimeout, declined)
+			return false
+		}
+		if err != nil && answer == "" {
+			fmt.Fprintln(os.Stderr)
+			return false
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "Please answer y or n, not %q.\n", answer)
+	}
+}
+
+// errPromptTimeout is returned by readAnswer when no answer came in time.
+var errPromptTimeout = errors.New("no answer in time")
+
+// readAnswer reads one line from stdin, waiting at most timeout when it is
+// positive. Stdin is read a byte at a time so that nothing meant for the
+// program is consumed past the answer.
+func readAnswer(timeout time.Duration) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var line []byte
+		b := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(b)
+			if n == 1 && b[0] == '\n' {
+				done <- result{strings.TrimSpace(string(line)), nil}
+				return
+			}
+			if n == 1 {
+				line = append(line, b[0])
+			}
+			if err != nil {
+				done <- result{strings.TrimSpace(string(line)), err}
+				return
+			}
+		}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-expired:
+		return "", errPromptTimeout
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// protoGen is the language to generate code for from a .proto file
+	// (--gen), empty to only check it.
+	protoGen string
+	// protoGenOut is where generated code goes (--gen-out); a temporary
+	// directory when empty.
+	protoGenOut string
+)
+
+// protoGenerators maps the languages of --gen to protoc's output flag and
+// the plugin it needs, if any: Python and Java are built into protoc.
+var protoGenerators = map[string]struct {
+	Flag    string
+	Plugin  string
+	Install string
+}{
+	"go":     {"--go_out", "protoc-gen-go", "go install google.golang.org/protobuf/cmd/protoc-gen-go@latest"},
+	"python": {"--python_out", "", ""},
+	"java":   {"--java_out", "", ""},
+}
+
+// protoRootMarkers identify the root of the project a .proto file belongs
+// to, which its imports are relative to.
+var protoRootMarkers = []string{"buf.yaml", "buf.work.yaml", "go.mod", ".git"}
+
+// protoRoot returns the import root of sourceFile: the nearest directory
+// above it with a project marker, or its own directory.
+func protoRoot(sourceFile string) string {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return filepath.Dir(sourceFile)
+	}
+	for dir := filepath.Dir(abs); ; {
+		for _, marker := range protoRootMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Dir(abs)
+		}
+		dir = parent
+	}
+}
+
+// protoPlan returns the plan that checks sourceFile, or generates code from
+// it into outDir with --gen. protoc runs from the file's directory with the
+// project root on the import path.
+func protoPlan(sourceFile, outDir string) execPlan {
+	abs, _ := filepath.Abs(sourceFile)
+	argv := resolveRuntime(".proto", []string{"protoc"})
+	argv = append(argv, "-I", protoRoot(sourceFile))
+	if protoGen == "" {
+		argv = append(argv, "--descriptor_set_out="+os.DevNull)
+	} else {
+		argv = append(argv, protoGenerators[protoGen].Flag+"="+outDir)
+	}
+	argv = append(argv, abs)
+	return execPlan{SourceFile: sourceFile, Run: argv, Dir: filepath.Dir(sourceFile)}
+}
+
+// compileProto checks sourceFile with protoc or, with --gen, generates code
+// from it and lists what was generated.
+func compileProto(sourceFile string) error {
+	outDir := protoGenOut
+	if protoGen != "" {
+		gen := protoGenerators[protoGen]
+		if gen.Plugin != "" {
+			if _, err := exec.LookPath(gen.Plugin); err != nil {
+				return newRunError("runtime-unavailable", exitRuntimeUnavailable,
+					"%s not found; protoc needs it for --gen %s. Install it with: %s", gen.Plugin, protoGen, gen.Install)
+			}
+		}
+		if outDir == "" {
+			dir, err := os.MkdirTemp("", "run-proto-")
+			if err != nil {
+				return err
+			}
+			outDir = dir
+		} else if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return err
+		}
+		outDir, _ = filepath.Abs(outDir)
+	}
+
+	plan := protoPlan(sourceFile, outDir)
+	cmd := plan.command(context.Background(), plan.Run)
+	var stderr stderrCapture
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := runCmd(cmd); err != nil {
+		printSourceContext(stderr.String(), sourceFile)
+		return &runError{Status: "compile-failed", Code: exitCompileFailed,
+			Msg:         fmt.Sprintf("protoc failed: %v\n  Command: %s", err, shellJoin(plan.Run)),
+			Diagnostics: parseDiagnostics(stderr.String(), sourceFile, sourceFile)}
+	}
+	if protoGen == "" {
+		infof("%s is valid.\n", sourceFile)
+		return nil
+	}
+
+	var generated []string
+	filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			generated = append(generated, path)
+		}
+		return nil
+	})
+	sort.Strings(generated)
+	fmt.Printf("Generated %d %s file(s) in %s:\n", len(generated), protoGen, outDir)
+	for _, path := range generated {
+		rel, _ := filepath.Rel(outDir, path)
+		fmt.Printf("  %s\n", rel)
+	}
+	return nil
+}
+
+// parseProtoGen validates a --gen value.
+func parseProtoGen(value string) (string, error) {
+	if _, ok := protoGenerators[value]; !ok {
+		names := make([]string, 0, len(protoGenerators))
+		for name := range protoGenerators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("--gen must be one of %s, not %q", strings.Join(names, ", "), value)
+	}
+	return value, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// nastyStrings are arguments that break naive quoting.
+var nastyStrings = []string{
+	"", " ", "plain", "my file.py", "it's", `"double"`, `back\slash`, `trailing\`,
+	"$HOME", "${PATH}", "$(id)", "`id`", "a;b", "a&&b", "a|b", "a>b", "<in", "*", "?", "[ab]",
+	"~", "~user", "#comment", "!", "!!", "tab\there", "new\nline", "'", "''", `\'`, `'\''`,
+	"{a,b}", "a=b", "--flag=value with space", "-", "--", "%PATH%", "^", "é ü 漢字", "emoji 🎉",
+	"\x01\x7f", "  leading", "trailing  ", `\\"\\`,
+}
+
+// roundTrip has sh print the arguments of the command line shellJoin made
+// of argv, and returns them.
+func roundTrip(t *testing.T, argv []string) []string {
+	t.Helper()
+	out, err := exec.Command("sh", "-c", "printf '%s\\0' "+shellJoin(argv)).Output()
+	if err != nil {
+		t.Fatalf("sh -c failed for %q: %v", argv, err)
+	}
+	got := strings.Split(string(out), "\x00")
+	return got[:len(got)-1]
+}
+
+func TestQuoteRoundTripsThroughSh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX quoting")
+	}
+	requireTools(t, "sh")
+	for _, s := range nastyStrings {
+		if got := roundTrip(t, []string{s}); !slices.Equal(got, []string{s}) {
+			t.Errorf("%q came back from sh as %q (quoted as %s)", s, got, shellQuote(s))
+		}
+	}
+	if got := roundTrip(t, nastyStrings); !slices.Equal(got, nastyStrings) {
+		t.Errorf("the whole corpus came back from sh as %q", got)
+	}
+
+	// Random strings of the characters that matter to a shell
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []rune(" \t\n'\"\\$`!*?[]{}()<>|&;#~=%^,.:/-_aZ9é")
+	for range 200 {
+		argv := make([]string, 1+rng.Intn(3))
+		for i := range argv {
+			word := make([]rune, rng.Intn(12))
+			for j := range word {
+				word[j] = alphabet[rng.Intn(len(alphabet))]
+			}
+			argv[i] = string(word)
+		}
+		if got := roundTrip(t, argv); !slices.Equal(got, argv) {
+			t.Errorf("%q came back from sh as %q (quoted as %s)", argv, got, shellJoin(argv))
+		}
+	}
+}
+
+func TestShellSplitUndoesPosixQuote(t *testing.T) {
+	quoted := make([]string, len(nastyStrings))
+	for i, s := range nastyStrings {
+		quoted[i] = posixQuote(s)
+	}
+	got, err := shellSplit(strings.Join(quoted, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, nastyStrings) {
+		t.Errorf("shellSplit(posixQuote) = %q, want %q", got, nastyStrings)
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", `""`},
+		{"plain", "plain"},
+		{`C:\dir\file.py`, `C:\dir\file.py`},
+		{"my file.py", `"my file.py"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\my dir\`, `"C:\my dir\\"`},
+		{`a\"b c`, `"a\\\"b c"`},
+		{"a&b", `"a&b"`},
+		{"100%", `"100%"`},
+	}
+	for _, tt := range tests {
+		if got := windowsQuote(tt.in); got != tt.want {
+			t.Errorf("windowsQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRemoteSize caps the size of a downloaded source file.
+	maxRemoteSize = 10 << 20
+	// previewLines is how much of a fetched source the confirmation shows.
+	previewLines = 20
+)
+
+// remoteOptions are the integrity settings for running a URL.
+type remoteOptions struct {
+	SHA256 string // Expected digest (--sha256); empty to skip the check
+	Yes    bool   // Skip the confirmation prompt (--yes)
+}
+
+// isRemote reports whether arg names a file to download rather than a path.
+func isRemote(arg string) bool {
+	return strings.HasPrefix(arg, "https://") || strings.HasPrefix(arg, "http://")
+}
+
+// rawURL rewrites gist pages to their raw content.
+func rawURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host != "gist.github.com" {
+		return rawurl
+	}
+	u.Host = "gist.githubusercontent.com"
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/raw"
+	return u.String()
+}
+
+// fetchRemote downloads source from rawurl, checks its integrity and asks
+// for confirmation, then stores it in a temporary directory under the URL's
+// file name so the language can be detected. The returned function removes
+// the download.
+func fetchRemote(rawurl string, opts remoteOptions) (string, func(), error) {
+	content, err := download(rawURL(rawurl))
+	if err != nil {
+		return "", nil, newRunError("file-not-found", exitNoInput, "Cannot fetch %s: %v", rawurl, err)
+	}
+
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	if opts.SHA256 != "" && !strings.EqualFold(opts.SHA256, digest) {
+		return "", nil, newRunError("checksum-mismatch", exitNoInput,
+			"Refusing to run %s: SHA-256 mismatch\n  expected %s\n  got      %s", rawurl, opts.SHA256, digest)
+	}
+	if err := confirmRemote(rawurl, content, digest, opts); err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "run-remote-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	name := "script"
+	if u, err := url.Parse(rawurl); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	logEvent("remote", map[string]any{"url": rawurl, "sha256": digest, "path": file})
+	return file, cleanup, nil
+}
+
+func download(rawurl string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxRemoteSize {
+		return nil, fmt.Errorf("larger than %d MB", maxRemoteSize>>20)
+	}
+	return content, nil
+}
+
+// confirmRemote shows the start of the fetched source with its digest and
+// asks before running it. Trusted URL and digest pairs and --yes skip the
+// question; without a terminal to ask on, running is refused.
+func confirmRemote(rawurl string, content []byte, digest string, opts remoteOptions) error {
+	trusted := loadTrusted()
+	if trusted[rawurl] == digest {
+		infof("Running trusted %s (sha256 %s)\n", rawurl, digest)
+		return nil
+	}
+	if opts.Yes {
+		fmt.Fprintf(messageOut(), "Running %s (sha256 %s) without confirmation (--yes)\n", rawurl, digest)
+		return nil
+	}
+
+	// The preview and question are shown even with --quiet, on stderr then
+	out := messageOut()
+	fmt.Fprintf(out, "About to run code from %s\n", rawurl)
+	fmt.Fprintf(out, "SHA-256: %s\n", digest)
+	if previous, ok := trusted[rawurl]; ok {
+		fmt.Fprintf(out, "⚠  The content changed since you trusted it (was %s).\n", previous)
+	}
+	fmt.Fprintln(out, strings.Repeat("-", 50))
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i, line := range lines {
+		if i == previewLines {
+			fmt.Fprintf(out, "... (%d more lines)\n", len(lines)-i)
+			break
+		}
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintln(out, strings.Repeat("-", 50))
+
+	if !isTerminal(os.Stdin) {
+		return newRunError("not-confirmed", exitUsage, "Refusing to run remote code without confirmation; pass --yes to allow it.")
+	}
+	fmt.Fprint(out, "Run it? [y]es / [n]o / [a]lways for this URL and content: ")
+	input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "y", "yes":
+		return nil
+	case "a", "always":
+		trusted[rawurl] = digest
+		if err := saveTrusted(trusted); err != nil {
+			warnf("cannot save trust store: %v", err)
+		}
+		return nil
+	}
+	return newRunError("not-confirmed", exitUsage, "Not running %s.", rawurl)
+}
+
+// trustedFile maps URLs to the SHA-256 of the content the user allowed to
+// run without asking.
+func trustedFile() string {
+	return filepath.Join(configDir(), "trusted.json")
+}
+
+func loadTrusted() map[string]string {
+	trusted := map[string]string{}
+	data, err := os.ReadFile(trustedFile())
+	if err == nil {
+		json.Unmarshal(data, &trusted)
+	}
+	return trusted
+}
+
+func saveTrusted(trusted map[string]string) error {
+	data, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(trustedFile()), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(trustedFile(), append(data, '\n'), 0o600)
+}
+
+func parseSHA256(value string) (string, error) {
+	if _, err := hex.DecodeString(value); err != nil || len(value) != 64 {
+		return "", errors.New("--sha256 needs a 64-character hex digest")
+	}
+	return strings.ToLower(value), nil
+}
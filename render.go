@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// outputFormat is the format documents are rendered to (--output-format),
+// empty for the document's own default.
+var outputFormat string
+
+// documentExts are the languages that are rendered into a document rather
+// than run.
+var documentExts = map[string]bool{".Rmd": true, ".qmd": true, ".tex": true}
+
+// outputCreatedPattern matches the line both rmarkdown and Quarto print for
+// the document they produced.
+var outputCreatedPattern = regexp.MustCompile(`(?m)^Output created: (.+?)\s*$`)
+
+// renderPlan returns the plan that renders a document, or false when
+// sourceFile is not one.
+func renderPlan(sourceFile, ext string) (execPlan, bool) {
+	switch ext {
+	case ".Rmd":
+		call := "rmarkdown::render(" + rString(sourceFile)
+		if outputFormat != "" {
+			call += ", output_format = " + rString(outputFormat+"_document")
+		}
+		argv := resolveRuntime(ext, []string{"Rscript"})
+		return execPlan{SourceFile: sourceFile, Run: append(argv, "-e", call+")")}, true
+	case ".qmd":
+		argv := append(resolveRuntime(ext, []string{"quarto"}), "render", sourceFile)
+		if outputFormat != "" {
+			argv = append(argv, "--to", outputFormat)
+		}
+		return execPlan{SourceFile: sourceFile, Run: argv}, true
+	case ".tex":
+		// Only for --dry-run; compileTeX does the build
+		commands := texCommands(sourceFile)
+		plan := execPlan{SourceFile: sourceFile, Dir: filepath.Dir(sourceFile), Run: commands[len(commands)-1]}
+		if len(commands) > 1 {
+			plan.Compile = commands[0]
+		}
+		if !keepArtifacts {
+			base := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
+			for _, ext := range texAuxExts {
+				plan.Cleanup = append(plan.Cleanup, base+ext)
+			}
+		}
+		return plan, true
+	}
+	return execPlan{}, false
+}
+
+// rString quotes s as an R string literal.
+func rString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// renderedDocument returns the document rendered from sourceFile, as
+// reported in the renderer's output or else where it is put by default.
+// It returns "" when there is no such file.
+func renderedDocument(sourceFile, output string) string {
+	var path string
+	if m := outputCreatedPattern.FindStringSubmatch(output); m != nil {
+		path = m[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(sourceFile), path)
+		}
+	} else {
+		format := outputFormat
+		if format == "" {
+			format = "html"
+		}
+		path = strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)) + "." + format
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// parseOutputFormat validates an --output-format value.
+func parseOutputFormat(value string) (string, error) {
+	if value != "html" && value != "pdf" {
+		return "", fmt.Errorf("--output-format must be html or pdf, not %q", value)
+	}
+	return value, nil
+}
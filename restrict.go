@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// restrictRoot confines run to a directory tree (--restrict-root or
+	// RUN_RESTRICT_ROOT): the source file, the files it reads on run's
+	// behalf and the directories it writes to or runs in must be inside.
+	restrictRoot = os.Getenv("RUN_RESTRICT_ROOT")
+	// restricted is the --restricted lockdown preset.
+	restricted bool
+)
+
+// restrictedCPUTime is the CPU time limit of --restricted when
+// --max-cpu-time doesn't set one.
+const restrictedCPUTime = time.Minute
+
+// applyRestricted turns on what --restricted stands for: no installs, no
+// network, a CPU time limit, a throwaway home, and the current directory as
+// the root unless another one is given.
+func applyRestricted() error {
+	if !restricted {
+		return nil
+	}
+	noInstall = true
+	offline = true
+	fakeHome = true
+	if maxCPUTime == 0 {
+		maxCPUTime = restrictedCPUTime
+	}
+	if restrictRoot == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		restrictRoot = dir
+	}
+	return nil
+}
+
+// checkRestricted returns an error naming path, which is what, unless it is
+// inside restrictRoot once symbolic links are resolved, so that a link in
+// the tree can't lead out of it. A path that doesn't exist yet is judged by
+// its nearest existing parent.
+func checkRestricted(what, path string) error {
+	if restrictRoot == "" || path == "" {
+		return nil
+	}
+	root, err := resolvePath(restrictRoot)
+	if err != nil {
+		return newRunError("usage-error", exitUsage, "Invalid restrict root %s: %v", restrictRoot, err)
+	}
+	resolved, err := resolvePath(path)
+	if err == nil {
+		rel, relErr := filepath.Rel(root, resolved)
+		if relErr == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+		err = fmt.Errorf("it resolves to %s", resolved)
+	}
+	return newRunError("restricted", exitRestricted, "Refusing %s %s: it is outside the restrict root %s (%v)", what, path, root, err)
+}
+
+// resolvePath returns the absolute path of path with symbolic links
+// resolved, for as much of it as exists.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,20 +28,27 @@ import (
 		commands for installation and execution.
 */
 
-const version = "1.0.0"
-
 // LanguageConfig holds configuration for each supported language
 type LanguageConfig struct {
+	Name        string // Human-readable language name
 	CheckCmd    []string
 	InstallCmd  func() []string // Function to return OS-specific install commands
 	RunCmd      []string
 	CompileCmd  []string // For compiled languages
 	IsCompiled  bool
 	ClassNameFn func(string) string // For Java, to get class name from file name
+	ReplCmd     []string            // Interactive shell; nil falls back to the RunCmd binary, empty means none
+
+	// The file name is appended to both formatter commands. FormatCheckCmd
+	// reports a file that isn't formatted by failing or, like gofmt -l, by
+	// listing it.
+	FormatCmd      []string // Formats the file in place (--fmt)
+	FormatCheckCmd []string // Checks the formatting without changing the file (--fmt-check)
 }
 
 var languageConfigs = map[string]LanguageConfig{
 	".py": {
+		Name:     "Python",
 		CheckCmd: []string{"python3", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -50,9 +62,12 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Python installation."}
 			}
 		},
-		RunCmd: []string{"python3"},
+		RunCmd:         []string{"python3"},
+		FormatCmd:      []string{"black", "-q"},
+		FormatCheckCmd: []string{"black", "--check", "-q"},
 	},
 	".go": {
+		Name:     "Go",
 		CheckCmd: []string{"go", "version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -66,9 +81,13 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Go installation."}
 			}
 		},
-		RunCmd: []string{"go", "run"},
+		RunCmd:         []string{"go", "run"},
+		ReplCmd:        []string{},
+		FormatCmd:      []string{"gofmt", "-w"},
+		FormatCheckCmd: []string{"gofmt", "-l"},
 	},
 	".js": {
+		Name:     "JavaScript",
 		CheckCmd: []string{"node", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -82,9 +101,12 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Node.js installation."}
 			}
 		},
-		RunCmd: []string{"node"},
+		RunCmd:         []string{"node"},
+		FormatCmd:      []string{"prettier", "--write"},
+		FormatCheckCmd: []string{"prettier", "--check"},
 	},
 	".rb": {
+		Name:     "Ruby",
 		CheckCmd: []string{"ruby", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -98,9 +120,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Ruby installation."}
 			}
 		},
-		RunCmd: []string{"ruby"},
+		RunCmd:  []string{"ruby"},
+		ReplCmd: []string{"irb"},
 	},
 	".java": {
+		Name:     "Java",
 		CheckCmd: []string{"java", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -120,8 +144,10 @@ var languageConfigs = map[string]LanguageConfig{
 		ClassNameFn: func(filename string) string {
 			return strings.TrimSuffix(filename, filepath.Ext(filename))
 		},
+		ReplCmd: []string{"jshell"},
 	},
 	".cpp": {
+		Name:     "C++",
 		CheckCmd: []string{"g++", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -135,10 +161,13 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic C++ installation."}
 			}
 		},
-		CompileCmd: []string{"g++"},
-		IsCompiled: true,
+		CompileCmd:     []string{"g++"},
+		IsCompiled:     true,
+		FormatCmd:      []string{"clang-format", "-i"},
+		FormatCheckCmd: []string{"clang-format", "--dry-run", "--Werror"},
 	},
 	".c": {
+		Name:     "C",
 		CheckCmd: []string{"gcc", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -152,19 +181,25 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic C installation."}
 			}
 		},
-		CompileCmd: []string{"gcc"},
-		IsCompiled: true,
+		CompileCmd:     []string{"gcc"},
+		IsCompiled:     true,
+		FormatCmd:      []string{"clang-format", "-i"},
+		FormatCheckCmd: []string{"clang-format", "--dry-run", "--Werror"},
 	},
 	".rs": {
+		Name:     "Rust",
 		CheckCmd: []string{"rustc", "--version"},
 		InstallCmd: func() []string {
 			return []string{"echo", "Please install Rust from https://rustup.rs/ by running: curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh"}
 		},
-		CompileCmd: []string{"rustc"},
-		RunCmd:     []string{},
-		IsCompiled: true,
+		CompileCmd:     []string{"rustc"},
+		RunCmd:         []string{},
+		IsCompiled:     true,
+		FormatCmd:      []string{"rustfmt"},
+		FormatCheckCmd: []string{"rustfmt", "--check"},
 	},
 	".cs": {
+		Name:     "C#",
 		CheckCmd: []string{"dotnet", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -181,8 +216,10 @@ var languageConfigs = map[string]LanguageConfig{
 		CompileCmd: []string{"dotnet", "build"},
 		RunCmd:     []string{"dotnet", "run"},
 		IsCompiled: true,
+		ReplCmd:    []string{"csharp"},
 	},
 	".sh": {
+		Name:     "Shell",
 		CheckCmd: []string{"bash", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -199,6 +236,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"bash"},
 	},
 	".pl": {
+		Name:     "Perl",
 		CheckCmd: []string{"perl", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -212,9 +250,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Perl installation."}
 			}
 		},
-		RunCmd: []string{"perl"},
+		RunCmd:  []string{"perl"},
+		ReplCmd: []string{"perl", "-de0"},
 	},
 	".php": {
+		Name:     "PHP",
 		CheckCmd: []string{"php", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -228,16 +268,21 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic PHP installation."}
 			}
 		},
-		RunCmd: []string{"php"},
+		RunCmd:  []string{"php"},
+		ReplCmd: []string{"php", "-a"},
 	},
 	".ts": {
+		Name:     "TypeScript",
 		CheckCmd: []string{"ts-node", "--version"},
 		InstallCmd: func() []string {
 			return []string{"echo", "Please install Node.js and then run: npm install -g ts-node typescript"}
 		},
-		RunCmd: []string{"ts-node"},
+		RunCmd:         []string{"ts-node"},
+		FormatCmd:      []string{"prettier", "--write"},
+		FormatCheckCmd: []string{"prettier", "--check"},
 	},
 	".lua": {
+		Name:     "Lua",
 		CheckCmd: []string{"lua", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -254,6 +299,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"lua"},
 	},
 	".r": {
+		Name:     "R",
 		CheckCmd: []string{"Rscript", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -267,9 +313,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic R installation."}
 			}
 		},
-		RunCmd: []string{"Rscript"},
+		RunCmd:  []string{"Rscript"},
+		ReplCmd: []string{"R"},
 	},
 	".hs": {
+		Name:     "Haskell",
 		CheckCmd: []string{"ghc", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -285,8 +333,10 @@ var languageConfigs = map[string]LanguageConfig{
 		},
 		CompileCmd: []string{"ghc"},
 		IsCompiled: true,
+		ReplCmd:    []string{"ghci"},
 	},
 	".swift": {
+		Name:     "Swift",
 		CheckCmd: []string{"swift", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -303,6 +353,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"swift"},
 	},
 	".groovy": {
+		Name:     "Groovy",
 		CheckCmd: []string{"groovy", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -316,9 +367,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Groovy installation."}
 			}
 		},
-		RunCmd: []string{"groovy"},
+		RunCmd:  []string{"groovy"},
+		ReplCmd: []string{"groovysh"},
 	},
 	".kt": {
+		Name:     "Kotlin",
 		CheckCmd: []string{"kotlinc", "-version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -332,9 +385,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Kotlin installation."}
 			}
 		},
-		RunCmd: []string{"kotlinc", "-script"},
+		RunCmd:  []string{"kotlinc", "-script"},
+		ReplCmd: []string{"kotlinc"},
 	},
 	".ex": {
+		Name:     "Elixir",
 		CheckCmd: []string{"elixir", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -348,9 +403,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Elixir installation."}
 			}
 		},
-		RunCmd: []string{"elixir"},
+		RunCmd:  []string{"elixir"},
+		ReplCmd: []string{"iex"},
 	},
 	".ml": {
+		Name:     "OCaml",
 		CheckCmd: []string{"ocamlc", "-version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -366,8 +423,10 @@ var languageConfigs = map[string]LanguageConfig{
 		},
 		CompileCmd: []string{"ocamlc"},
 		IsCompiled: true,
+		ReplCmd:    []string{"ocaml"},
 	},
 	".nim": {
+		Name:     "Nim",
 		CheckCmd: []string{"nim", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -385,6 +444,7 @@ var languageConfigs = map[string]LanguageConfig{
 		IsCompiled: true,
 	},
 	".dart": {
+		Name:     "Dart",
 		CheckCmd: []string{"dart", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -398,9 +458,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Dart installation."}
 			}
 		},
-		RunCmd: []string{"dart"},
+		RunCmd:  []string{"dart"},
+		ReplCmd: []string{},
 	},
 	".raku": {
+		Name:     "Raku",
 		CheckCmd: []string{"raku", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -417,6 +479,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"raku"},
 	},
 	".tcl": {
+		Name:     "Tcl",
 		CheckCmd: []string{"tclsh"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -433,6 +496,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"tclsh"},
 	},
 	".vb": {
+		Name:     "VB.NET",
 		CheckCmd: []string{"vbc", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -450,6 +514,7 @@ var languageConfigs = map[string]LanguageConfig{
 		IsCompiled: true,
 	},
 	".fs": {
+		Name:     "F#",
 		CheckCmd: []string{"fsharpc", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -465,8 +530,10 @@ var languageConfigs = map[string]LanguageConfig{
 		},
 		CompileCmd: []string{"fsharpc"},
 		IsCompiled: true,
+		ReplCmd:    []string{"fsharpi"},
 	},
 	".pas": {
+		Name:     "Pascal",
 		CheckCmd: []string{"fpc", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -484,6 +551,7 @@ var languageConfigs = map[string]LanguageConfig{
 		IsCompiled: true,
 	},
 	".jl": {
+		Name:     "Julia",
 		CheckCmd: []string{"julia", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -500,6 +568,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"julia"},
 	},
 	".scm": {
+		Name:     "Scheme",
 		CheckCmd: []string{"scheme", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -516,6 +585,7 @@ var languageConfigs = map[string]LanguageConfig{
 		RunCmd: []string{"scheme"},
 	},
 	".awk": {
+		Name:     "AWK",
 		CheckCmd: []string{"awk", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -529,9 +599,11 @@ var languageConfigs = map[string]LanguageConfig{
 				return []string{"echo", "Unsupported OS for automatic Awk installation."}
 			}
 		},
-		RunCmd: []string{"awk", "-f"},
+		RunCmd:  []string{"awk", "-f"},
+		ReplCmd: []string{},
 	},
 	".asm": {
+		Name:     "Assembly",
 		CheckCmd: []string{"nasm", "--version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -549,6 +621,7 @@ var languageConfigs = map[string]LanguageConfig{
 		IsCompiled: true,
 	},
 	".zig": {
+		Name:     "Zig",
 		CheckCmd: []string{"zig", "version"},
 		InstallCmd: func() []string {
 			switch runtime.GOOS {
@@ -565,9 +638,115 @@ var languageConfigs = map[string]LanguageConfig{
 		CompileCmd: []string{"zig", "build-exe"},
 		IsCompiled: true,
 	},
+	".Rmd": {
+		Name: "R Markdown",
+		// Fails both without R and without the rmarkdown package
+		CheckCmd: []string{"Rscript", "-e", "library(rmarkdown)"},
+		InstallCmd: func() []string {
+			if _, err := exec.LookPath("Rscript"); err == nil {
+				return []string{"Rscript", "-e", "install.packages('rmarkdown', repos = 'https://cloud.r-project.org')"}
+			}
+			switch runtime.GOOS {
+			case "linux":
+				return []string{"sudo", "apt", "install", "-y", "r-base", "r-cran-rmarkdown"}
+			case "darwin":
+				return []string{"brew", "install", "r"}
+			default:
+				return []string{"echo", "Please install R from https://cran.r-project.org/, then the rmarkdown package."}
+			}
+		},
+		RunCmd: []string{"Rscript", "-e", "rmarkdown::render"},
+	},
+	".qmd": {
+		Name:     "Quarto",
+		CheckCmd: []string{"quarto", "--version"},
+		InstallCmd: func() []string {
+			switch runtime.GOOS {
+			case "darwin":
+				return []string{"brew", "install", "--cask", "quarto"}
+			default:
+				return []string{"echo", "Please install Quarto from https://quarto.org/docs/get-started/"}
+			}
+		},
+		RunCmd: []string{"quarto", "render"},
+	},
+	".tex": {
+		Name:     "LaTeX",
+		CheckCmd: []string{"pdflatex", "--version"},
+		InstallCmd: func() []string {
+			switch runtime.GOOS {
+			case "linux":
+				return []string{"sudo", "apt", "install", "-y", "texlive-latex-recommended", "latexmk"}
+			case "darwin":
+				return []string{"brew", "install", "--cask", "mactex-no-gui"}
+			case "windows":
+				return []string{"echo", "Please install MiKTeX from https://miktex.org/download"}
+			default:
+				return []string{"echo", "Please install a TeX distribution such as TeX Live."}
+			}
+		},
+		CompileCmd: []string{"latexmk", "-pdf", "-interaction=nonstopmode"},
+		IsCompiled: true,
+	},
+	".proto": {
+		Name:     "Protocol Buffers",
+		CheckCmd: []string{"protoc", "--version"},
+		InstallCmd: func() []string {
+			switch runtime.GOOS {
+			case "linux":
+				return []string{"sudo", "apt", "install", "-y", "protobuf-compiler"}
+			case "darwin":
+				return []string{"brew", "install", "protobuf"}
+			case "windows":
+				return []string{"choco", "install", "-y", "protoc"}
+			default:
+				return []string{"echo", "Please install protoc from https://github.com/protocolbuffers/protobuf/releases"}
+			}
+		},
+		RunCmd: []string{"protoc", "--descriptor_set_out=" + os.DevNull},
+	},
+}
+
+// knownFlags lists every option accepted by the file runner. It backs the
+// did-you-mean suggestions for mistyped flags.
+var knownFlags = []string{
+	"--version", "-v", "--list", "-l", "--help", "-h", "--first-run-report",
+	"--dry-run", "-d", "--time", "-t", "--bench", "-b",
+	"--pick", "--last", "--watch", "--lang", "--log-file", "--trace-commands", "--strict-config", "--profile-run", "--keep", "-k",
+	"--no-locale-fix", "--max-cpu-time", "--timeout", "--core-dump", "--no-context", "--no-modeline",
+	"--force", "--test-mode", "--test-run", "--error-format", "--strict-detect", "--no-detect",
+	"--shell", "--sh", "--posix", "--no-defaults", "--verbose", "-V", "--quiet", "-q",
+	"--sha256", "--yes", "-y", "--no-install", "--offline",
+	"--warmup", "--json", "--bench-isolate", "--verify-with", "--verify-first", "--bench-input", "--bench-stats",
+	"--output-format", "--keep-artifacts", "--open", "--gen", "--gen-out",
+	"--fmt", "--fmt-check", "--no-fmt-write", "--audit", "--profile", "--check",
+	"--compile-only", "-c", "--out", "-o", "--compiler-args", "--cflag", "--runtime-args",
+	"--sources", "--entry", "--no-project", "--with", "--emit-script", "--fix-crlf", "--keep-going",
+	"--update-deps", "--frozen", "--no-cache", "--no-store-source", "--assume-entry",
+	"--heartbeat", "--porcelain", "--porcelain-fd", "--show-created", "--use-daemon",
+	"--cwd", "--restrict-root", "--restricted", "--fake-home",
+	"--assert-max-mean", "--assert-max-median", "--assert-max-p95", "--assert-max-p99",
 }
 
 func main() {
+	if path := os.Getenv("RUN_LOG_FILE"); path != "" {
+		if err := openLog(path); err != nil {
+			fmt.Printf("Warning: cannot open log file: %v\n", err)
+		}
+	}
+	logEvent("start", map[string]any{"args": os.Args[1:], "version": version})
+	loadGlobalConfig()
+
+	if i := scriptIndex(os.Args[1:]); i >= 0 {
+		runScript(os.Args[1:], i)
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "--first-run-report" {
+		firstRunReport()
+		os.Exit(0)
+	}
+	maybeFirstRunReport(os.Args[1:])
 
 	// Handle flags
 	if len(os.Args) > 1 {
@@ -576,39 +755,578 @@ func main() {
 			fmt.Printf("run version %s\n", version)
 			os.Exit(0)
 		case "--list", "-l":
-			listLanguages()
+			listCommand(os.Args[2:])
+			os.Exit(0)
+		case "--dump-config-schema":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(configSchema())
+			os.Exit(0)
+		case "--list-extensions":
+			for _, ext := range supportedExtensions() {
+				fmt.Println(ext)
+			}
+			os.Exit(0)
+		case "config":
+			configCommand(os.Args[2:])
+			os.Exit(0)
+		case "version":
+			versionCommand(os.Args[2:])
 			os.Exit(0)
+		case "supports":
+			supportsCommand(os.Args[2:])
+		case "new":
+			newCommand(os.Args[2:])
+			os.Exit(0)
+		case "repl":
+			replCommand(os.Args[2:])
+		case "serve":
+			serveCommand(os.Args[2:])
+			os.Exit(0)
+		case "clean":
+			cleanCommand(os.Args[2:])
+			os.Exit(0)
+		case "cache":
+			// Anything else is a file that happens to be called cache
+			if len(os.Args) > 2 {
+				cacheCommand(os.Args[2:])
+				os.Exit(0)
+			}
+		case "grade":
+			// Anything else is a file that happens to be called grade
+			if len(os.Args) > 2 {
+				if err := gradeCommand(os.Args[2:]); err != nil {
+					exitWith("", err)
+				}
+				os.Exit(0)
+			}
+		case "doctor":
+			doctorCommand(os.Args[2:])
+		case "package":
+			// Anything else is a file that happens to be called package
+			if len(os.Args) > 2 {
+				if file, err := packageCommand(os.Args[2:]); err != nil {
+					exitWith(file, err)
+				}
+				os.Exit(0)
+			}
+		case "daemon", "--daemon":
+			// Anything else is a file that happens to be called daemon
+			if len(os.Args) > 2 {
+				if err := daemonCommand(os.Args[2:]); err != nil {
+					exitWith("", err)
+				}
+				os.Exit(0)
+			}
+		case "bench":
+			// Anything else is a file that happens to be called bench
+			if len(os.Args) > 2 && os.Args[2] == "ab" {
+				if err := benchABCommand(os.Args[3:]); err != nil {
+					exitWith("", err)
+				}
+				os.Exit(0)
+			}
 		case "--help", "-h":
 			printHelp()
 			os.Exit(0)
 		}
 	}
 
-	// Parse flags and file
-	var dryRun, timeExec, bench bool
-	var sourceFile string
-	benchRuns := 10 // Default number of benchmark runs
+	sourceFile, err := runFile(os.Args[1:])
+	if err != nil {
+		exitWith(sourceFile, err)
+	}
+	porcelainSummary(sourceFile, nil)
+	printRunProfile()
+}
 
-	for i := 1; i < len(os.Args); i++ {
-		arg := os.Args[i]
+// runFile parses the file runner's arguments and runs the file. It returns
+// the file it worked on, if it got that far, for the status line.
+func runFile(args []string) (string, error) {
+	// RUN_DEFAULT_FLAGS go first so that explicit flags override them
+	defaults, defaultsSource, err := defaultFlags(args)
+	if err != nil {
+		return "", usageError("%v", err)
+	}
+	// A profile's flags go in front of the flags that named it
+	defaultsProfile, defaults, err := expandProfiles(defaults)
+	if err != nil {
+		return "", usageError("%v", err)
+	}
+	profile, args, err := expandProfiles(args)
+	if err != nil {
+		return "", usageError("%v", err)
+	}
+	defaults = append(defaultsProfile, defaults...)
+	args = append(defaults, append(profile, args...)...)
+
+	// Parse flags and file
+	var dryRun, timeExec, bench, pick, last bool
+	var sourceFile, langOverride, shCommand string
+	var files []string
+	var fileAt []int // Where files are in args
+	programAt := -1  // Where -- is in args, if it is
+	var remoteOpts remoteOptions
+	benchOpts := benchOptions{Runs: 10} // Default number of benchmark runs
+	explicit := map[string]bool{}       // Mode flags given on the command line rather than by defaults
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		fromDefaults := i < len(defaults)
 		switch {
+		case arg == "--" && !fromDefaults:
+			// Everything after it goes to the program
+			programArgs = append(programArgs, args[i+1:]...)
+			programAt = i
+			i = len(args)
 		case arg == "--dry-run" || arg == "-d":
 			dryRun = true
+			explicit["dry-run"] = explicit["dry-run"] || !fromDefaults
+		case arg == "--emit-script":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --emit-script (e.g. --emit-script build.sh, or - for stdout)")
+			}
+			// Nothing runs, as with --dry-run
+			emitScript = args[i+1]
+			dryRun = true
+			explicit["dry-run"] = explicit["dry-run"] || !fromDefaults
+			i++
 		case arg == "--time" || arg == "-t":
 			timeExec = true
+			explicit["time"] = explicit["time"] || !fromDefaults
 		case arg == "--bench" || arg == "-b":
 			bench = true
+			explicit["bench"] = explicit["bench"] || !fromDefaults
 			// Check if next arg is a number for bench runs
-			if i+1 < len(os.Args) && isNumeric(os.Args[i+1]) {
-				fmt.Sscanf(os.Args[i+1], "%d", &benchRuns)
+			if i+1 < len(args) && isNumeric(args[i+1]) {
+				fmt.Sscanf(args[i+1], "%d", &benchOpts.Runs)
 				i++
 			}
-		case !strings.HasPrefix(arg, "--"):
+		case arg == "--warmup":
+			if i+1 >= len(args) || !isNumeric(args[i+1]) {
+				return "", usageError("--warmup requires a number of iterations")
+			}
+			fmt.Sscanf(args[i+1], "%d", &benchOpts.Warmup)
+			i++
+		case arg == "--json":
+			benchOpts.JSON = true
+			jsonReport = true
+		case arg == "--bench-isolate":
+			benchOpts.Isolate = true
+		case arg == "--verify-with":
+			if i+1 >= len(args) {
+				return "", usageError("Missing file for --verify-with")
+			}
+			benchOpts.VerifyWith = args[i+1]
+			i++
+		case arg == "--bench-input":
+			if i+1 >= len(args) {
+				return "", usageError("Missing file for --bench-input")
+			}
+			benchOpts.Input = args[i+1]
+			i++
+		case arg == "--bench-stats":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --bench-stats (e.g. mean,median,p95)")
+			}
+			stats, err := parseBenchStats(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			benchOpts.Stats = stats
+			i++
+		case arg == "--output-format":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --output-format (html or pdf)")
+			}
+			format, err := parseOutputFormat(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			outputFormat = format
+			i++
+		case arg == "--gen":
+			if i+1 >= len(args) {
+				return "", usageError("Missing language for --gen (go, python or java)")
+			}
+			lang, err := parseProtoGen(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			protoGen = lang
+			i++
+		case arg == "--out" || arg == "-o":
+			if i+1 >= len(args) {
+				return "", usageError("Missing path for %s (e.g. %s bin/app)", arg, arg)
+			}
+			outPath = args[i+1]
+			i++
+		case arg == "--compiler-args" || arg == "--cflag":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for %s (e.g. %s -O2)", arg, arg)
+			}
+			if arg == "--cflag" {
+				compilerArgs = append(compilerArgs, args[i+1])
+			} else {
+				words, err := shellSplit(args[i+1])
+				if err != nil {
+					return "", usageError("Invalid --compiler-args %q: %v", args[i+1], err)
+				}
+				compilerArgs = append(compilerArgs, words...)
+			}
+			i++
+		case arg == "--runtime-args":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --runtime-args (e.g. --runtime-args \"-u\")")
+			}
+			words, err := shellSplit(args[i+1])
+			if err != nil {
+				return "", usageError("Invalid --runtime-args %q: %v", args[i+1], err)
+			}
+			runtimeArgs = append(runtimeArgs, words...)
+			i++
+		case arg == "--entry":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --entry (e.g. --entry server.py)")
+			}
+			entryName = args[i+1]
+			i++
+		case arg == "--no-project":
+			noProject = true
+		case arg == "--with":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --with (e.g. --with data.csv)")
+			}
+			dataDeps = append(dataDeps, args[i+1])
+			i++
+		case arg == "--sources":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --sources (e.g. --sources \"util.c other.c\")")
+			}
+			words, err := shellSplit(args[i+1])
+			if err != nil {
+				return "", usageError("Invalid --sources %q: %v", args[i+1], err)
+			}
+			extraSources = append(extraSources, words...)
+			i++
+		case arg == "--keep-going":
+			keepGoing = true
+		case arg == "--update-deps":
+			updateDeps = true
+		case arg == "--frozen":
+			frozenDeps = true
+		case arg == "--fix-crlf":
+			fixCRLF = true
+		case arg == "--no-cache":
+			noBuildCache = true
+		case arg == "--no-store-source":
+			storeSources = false
+		case arg == "--gen-out":
+			if i+1 >= len(args) {
+				return "", usageError("Missing directory for --gen-out")
+			}
+			protoGenOut = args[i+1]
+			i++
+		case arg == "--keep-artifacts":
+			keepArtifacts = true
+		case arg == "--check":
+			checkOnly = true
+		case arg == "--compile-only" || arg == "-c":
+			compileOnly = true
+		case arg == "--assume-entry":
+			assumeEntry = true
+		case arg == "--audit":
+			auditFile = true
+		case arg == "--fmt":
+			fmtWrite = true
+		case arg == "--fmt-check":
+			fmtCheck = true
+		case arg == "--no-fmt-write":
+			noFmtWrite = true
+		case arg == "--open":
+			openOutput = true
+		case arg == "--verify-first":
+			benchOpts.VerifyFirst = true
+		case strings.HasPrefix(arg, "--assert-max-"):
+			if i+1 >= len(args) {
+				return "", usageError("Missing duration for %s (e.g. %s 150ms)", arg, arg)
+			}
+			assertion, err := parseAssertion(arg, args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			benchOpts.Assertions = append(benchOpts.Assertions, assertion)
+			i++
+		case arg == "--watch":
+			watchMode = true
+		case arg == "--pick":
+			pick = true
+		case arg == "--last":
+			last = true
+		case arg == "--log-file":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --log-file")
+			}
+			if err := openLog(args[i+1]); err != nil {
+				warnf("cannot open log file: %v", err)
+			}
+			logEvent("start", map[string]any{"args": args, "version": version})
+			i++
+		case arg == "--trace-commands":
+			traceCommands = true
+		case arg == "--strict-config":
+			strictConfig = true
+		case arg == "--profile-run":
+			profileRun = true
+		case arg == "--keep" || arg == "-k":
+			keepBuild = true
+		case arg == "--max-cpu-time":
+			if i+1 >= len(args) {
+				return "", usageError("Missing duration for --max-cpu-time (e.g. --max-cpu-time 10s)")
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return "", usageError("Invalid duration for --max-cpu-time: %s", args[i+1])
+			}
+			maxCPUTime = d
+			i++
+		case arg == "--timeout":
+			if i+1 >= len(args) {
+				return "", usageError("Missing duration for --timeout (e.g. --timeout 30s)")
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return "", usageError("Invalid duration for --timeout: %s", args[i+1])
+			}
+			runTimeout = d
+			i++
+		case arg == "--quiet" || arg == "-q":
+			quiet = true
+		case arg == "--porcelain":
+			if porcelainOut == nil {
+				openPorcelain(2)
+			}
+			quiet = true
+		case arg == "--porcelain-fd":
+			if i+1 >= len(args) {
+				return "", usageError("Missing file descriptor for --porcelain-fd (e.g. --porcelain-fd 3)")
+			}
+			fd, err := strconv.Atoi(args[i+1])
+			if err != nil || fd < 1 {
+				return "", usageError("Invalid file descriptor for --porcelain-fd: %s", args[i+1])
+			}
+			if err := openPorcelain(fd); err != nil {
+				return "", usageError("--porcelain-fd: %v", err)
+			}
+			quiet = true
+			i++
+		case arg == "--heartbeat":
+			if i+1 >= len(args) {
+				return "", usageError("Missing interval for --heartbeat (e.g. --heartbeat 60s)")
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return "", usageError("Invalid interval for --heartbeat: %s", args[i+1])
+			}
+			heartbeat = d
+			i++
+		case arg == "--core-dump":
+			coreDump = true
+		case arg == "--no-modeline":
+			noModeline = true
+		case arg == "--no-context":
+			noContext = true
+		case arg == "--force":
+			force = true
+		case arg == "--shell":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --shell (e.g. --shell dash)")
+			}
+			shell, err := parseShell(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			shellOverride = shell
+			i++
+		case arg == "--sh":
+			if i+1 >= len(args) {
+				return "", usageError("Missing command for --sh (e.g. --sh 'sort data.txt | uniq -c')")
+			}
+			shCommand = args[i+1]
+			i++
+		case arg == "--posix":
+			posixShell = true
+		case arg == "--strict-detect":
+			strictDetect = true
+		case arg == "--no-detect":
+			noDetect = true
+		case arg == "--test-mode":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --test-mode (auto or off)")
+			}
+			mode, err := parseTestMode(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			testMode = mode
+			i++
+		case arg == "--error-format":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --error-format (text or json)")
+			}
+			format, err := parseErrorFormat(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			errorFormat = format
+			i++
+		case arg == "--test-run":
+			if i+1 >= len(args) {
+				return "", usageError("Missing pattern for --test-run")
+			}
+			testPattern = args[i+1]
+			i++
+		case arg == "--sha256":
+			if i+1 >= len(args) {
+				return "", usageError("Missing digest for --sha256")
+			}
+			digest, err := parseSHA256(args[i+1])
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			remoteOpts.SHA256 = digest
+			i++
+		case arg == "--yes" || arg == "-y":
+			assumeYes = true
+		case arg == "--no-install":
+			noInstall = true
+		case arg == "--offline":
+			offline = true
+		case arg == "--restrict-root":
+			if i+1 >= len(args) {
+				return "", usageError("Missing directory for --restrict-root")
+			}
+			if info, err := os.Stat(args[i+1]); err != nil || !info.IsDir() {
+				return "", usageError("--restrict-root: %s is not a directory", args[i+1])
+			}
+			restrictRoot = args[i+1]
+			i++
+		case arg == "--restricted":
+			restricted = true
+		case arg == "--fake-home":
+			fakeHome = true
+		case arg == "--show-created":
+			showCreated = true
+		case arg == "--use-daemon":
+			useDaemon = true
+		case arg == "--no-defaults":
+			// Handled by defaultFlags
+		case arg == "--verbose" || arg == "-V":
+			verbose = true
+			traceCommands = true
+		case arg == "--no-locale-fix":
+			noLocaleFix = true
+		case arg == "--cwd":
+			if i+1 >= len(args) {
+				return "", usageError("Missing directory for --cwd (e.g. --cwd .)")
+			}
+			if info, err := os.Stat(args[i+1]); err != nil || !info.IsDir() {
+				return "", usageError("--cwd: %s is not a directory", args[i+1])
+			}
+			workDir = args[i+1]
+			i++
+		case arg == "--lang":
+			if i+1 >= len(args) {
+				return "", usageError("Missing value for --lang (e.g. --lang py)")
+			}
+			langOverride = normalizeExt(args[i+1])
+			i++
+		case arg == "-":
+			// The code comes from stdin
+			sourceFile = arg
+			files = append(files, arg)
+			fileAt = append(fileAt, i)
+		case strings.HasPrefix(arg, "-"):
+			msg := fmt.Sprintf("Unknown flag: %s\n", arg)
+			if fromDefaults {
+				msg = fmt.Sprintf("Unknown flag in %s: %s\n", defaultsSource, arg)
+			}
+			if matches := suggest(arg, knownFlags); len(matches) > 0 {
+				msg += fmt.Sprintf("Did you mean %s?\n", strings.Join(matches, " or "))
+			}
+			return "", usageError("%sRun 'run --help' to see available options.", msg)
+		default:
 			sourceFile = arg
+			files = append(files, arg)
+			fileAt = append(fileAt, i)
+		}
+	}
+
+	if len(files) > 1 && !pick && !last && shCommand == "" {
+		if watchMode {
+			return "", usageError("--watch watches a single file; run it once per file.")
+		}
+		if outPath != "" {
+			return "", usageError("--out names a single output, so it can't be used with several files.")
+		}
+		if emitScript != "" {
+			return "", usageError("--emit-script writes the script of a single file, so it can't be used with several files.")
+		}
+		// Each file runs with the flags as given; the defaults are its
+		// run's own to apply
+		return "", runSequence(files, func(file string) []string {
+			var fileArgs []string
+			for i := len(defaults); i < len(args); i++ {
+				switch {
+				case i == fileAt[0]:
+					fileArgs = append(fileArgs, file)
+				case !slices.Contains(fileAt, i):
+					fileArgs = append(fileArgs, args[i])
+				}
+			}
+			return fileArgs
+		})
+	}
+
+	if err := applyRestricted(); err != nil {
+		return "", err
+	}
+	if showCreated && !fakeHome {
+		warnf("--show-created only applies with --fake-home. Ignoring it.")
+		showCreated = false
+	}
+
+	if last {
+		sourceFile = lastHistory()
+		if sourceFile == "" {
+			return "", usageError("No previous run recorded.")
+		}
+		if command, ok := strings.CutPrefix(sourceFile, shHistoryPrefix); ok {
+			sourceFile, shCommand = "", command
+		}
+		if entry, ok := strings.CutPrefix(sourceFile, snippetHistoryPrefix); ok {
+			stored, err := resolveSnippetEntry(entry)
+			if err != nil {
+				return "", err
+			}
+			sourceFile = stored
+		}
+	} else if shCommand == "" && (pick || sourceFile == "") {
+		// Without an explicit file, pick among the supported files in the current directory
+		candidates := files
+		if len(candidates) == 0 {
+			candidates = findCandidates(".")
+		}
+		if len(candidates) > 0 {
+			choice, err := pickFile(candidates)
+			if err != nil {
+				return "", usageError("%v", err)
+			}
+			sourceFile = choice
 		}
 	}
 
-	if sourceFile == "" {
+	if sourceFile == "" && shCommand == "" {
 		fmt.Println("Usage: run [options] <source_file>")
 		fmt.Println("\nOptions:")
 		fmt.Println("  --version, -v        Show version")
@@ -617,69 +1335,479 @@ func main() {
 		fmt.Println("  --time, -t               Measure and display execution time")
 		fmt.Println("  --bench [n], -b [n]          Run benchmark (default: 10 runs)")
 		fmt.Println("  --help, -h           Show this help message")
-		os.Exit(1)
+		return "", &runError{Status: "usage-error", Code: exitUsage}
 	}
 
-	// Validate conflicting flags
+	if watchMode {
+		switch {
+		case shCommand != "" || sourceFile == "-":
+			return sourceFile, usageError("--watch needs a file to watch.")
+		case bench || dryRun:
+			return sourceFile, usageError("--watch can't be combined with --bench or --dry-run.")
+		}
+		ext := langOverride
+		if ext == "" {
+			ext = strings.ToLower(filepath.Ext(sourceFile))
+		}
+		// Every run gets the flags of this one, defaults included, and the
+		// file chosen with --pick or --last
+		end := len(args)
+		if programAt >= 0 {
+			end = programAt
+		}
+		runArgs := []string{"--no-defaults"}
+		for i := 0; i < end; i++ {
+			if !slices.Contains(fileAt, i) && !slices.Contains([]string{"--watch", "--pick", "--last"}, args[i]) {
+				runArgs = append(runArgs, args[i])
+			}
+		}
+		runArgs = append(runArgs, sourceFile)
+		if programAt >= 0 {
+			runArgs = append(append(runArgs, "--"), programArgs...)
+		}
+		return sourceFile, watchFile(sourceFile, ext, runArgs)
+	}
+
+	if distro := wslDistro(); verbose && distro != "" {
+		fmt.Printf("Platform: WSL (%s)\n", distro)
+	}
+	if verbose && offline {
+		fmt.Println("Offline mode: on")
+	}
+	if verbose {
+		childEnv() // Settles localeFixed
+		if len(localeFixed) > 0 {
+			fmt.Printf("Locale: no UTF-8 locale configured; programs get %s\n", strings.Join(localeFixed, " "))
+		}
+	}
+	if verbose && len(defaults) > 0 {
+		fmt.Printf("Default flags from %s: %s\n", defaultsSource, shellJoin(defaults))
+	}
+
+	// Validate conflicting flags. When a default conflicts with an explicit
+	// flag, the command line wins without a warning.
+	if dryRun && !explicit["dry-run"] && (explicit["time"] || explicit["bench"]) {
+		dryRun = false
+	}
+	if bench && !explicit["bench"] && explicit["time"] {
+		bench = false
+	}
+	if bench && benchOpts.Runs < 1 {
+		return sourceFile, usageError("--bench needs at least 1 run.")
+	}
 	if bench && timeExec {
-		fmt.Println("Warning: --bench already includes timing. Ignoring --time flag.")
+		if explicit["time"] {
+			warnf("--bench already includes timing. Ignoring --time flag.")
+		}
 		timeExec = false
 	}
+	if len(benchOpts.Assertions) > 0 && !bench {
+		warnf("--assert-max-* only applies to --bench. Ignoring assertions.")
+	}
+	if benchOpts.Stats != nil && !bench {
+		warnf("--bench-stats only applies to --bench. Ignoring it.")
+	}
+	if (benchOpts.VerifyWith != "" || benchOpts.VerifyFirst) && !bench {
+		warnf("--verify-with and --verify-first only apply to --bench. Ignoring them.")
+	}
+	if benchOpts.Input != "" {
+		if !bench {
+			warnf("--bench-input only applies to --bench; the program reads run's stdin otherwise. Ignoring it.")
+		} else if _, err := os.Stat(benchOpts.Input); err != nil {
+			return sourceFile, usageError("Cannot read the benchmark input: %v", err)
+		}
+	}
+	if benchOpts.VerifyWith != "" && benchOpts.VerifyFirst {
+		return sourceFile, usageError("--verify-with and --verify-first can't be combined.")
+	}
+	if updateDeps && frozenDeps {
+		return sourceFile, usageError("--update-deps rewrites the lock that --frozen keeps; don't pass both.")
+	}
+	if coreDump && !rlimitsSupported {
+		warnf("--core-dump is not supported on %s. Ignoring it.", runtime.GOOS)
+		coreDump = false
+	}
+	if maxCPUTime > 0 && !rlimitsSupported {
+		warnf("CPU time limits are not available on %s; --max-cpu-time acts as a wall-clock timeout.", runtime.GOOS)
+	}
 	if dryRun && (timeExec || bench) {
-		fmt.Println("Warning: --dry-run cannot be used with --time or --bench. Ignoring timing flags.")
+		if explicit["time"] || explicit["bench"] {
+			warnf("--dry-run cannot be used with --time or --bench. Ignoring timing flags.")
+		}
 		timeExec = false
 		bench = false
 	}
 
-	ext := filepath.Ext(sourceFile)
+	if restrictRoot != "" {
+		if shCommand != "" {
+			return "", newRunError("restricted", exitRestricted, "--sh can't be confined to a directory; it is not available with --restrict-root.")
+		}
+		if isRemote(sourceFile) {
+			return sourceFile, newRunError("restricted", exitRestricted, "Refusing %s: URLs can't be run with --restrict-root.", sourceFile)
+		}
+		for _, p := range []struct{ what, path string }{
+			{"source file", sourceFile},
+			{"--verify-with file", benchOpts.VerifyWith},
+			{"--bench-input file", benchOpts.Input},
+			{"--gen-out directory", protoGenOut},
+			{"--out path", outPath},
+			{"--cwd directory", workDir},
+		} {
+			if err := checkRestricted(p.what, p.path); err != nil {
+				return sourceFile, err
+			}
+		}
+	}
+
+	if shCommand != "" {
+		if sourceFile != "" {
+			return sourceFile, usageError("--sh runs a command instead of a file; don't pass both.")
+		}
+		if bench {
+			return "", usageError("--bench can't be used with --sh.")
+		}
+		return "", runShellCommand(shCommand, dryRun, timeExec)
+	}
+
+	remote := isRemote(sourceFile)
+	if remoteOpts.SHA256 != "" && !remote {
+		warnf("--sha256 only applies to URLs. Ignoring it.")
+	}
+	if remote && !dryRun {
+		if offline {
+			return sourceFile, offlineError("file-not-found", exitNoInput, "Downloading "+sourceFile,
+				"Download the file yourself and run the local copy.")
+		}
+		remoteOpts.Yes = assumeYes
+		local, cleanup, err := fetchRemote(sourceFile, remoteOpts)
+		if err != nil {
+			return sourceFile, err
+		}
+		defer cleanup()
+		sourceFile = local
+	} else if remote {
+		fmt.Printf("Would download %s, verify it and ask before running it.\n", sourceFile)
+		return sourceFile, nil
+	}
+
+	if info, err := os.Stat(sourceFile); sourceFile == "-" {
+		// Read from stdin below
+	} else if err != nil {
+		return sourceFile, newRunError("file-not-found", exitNoInput, "File not found: %s", sourceFile)
+	} else if info.IsDir() {
+		entry, err := resolveEntry(sourceFile, dryRun)
+		if err != nil {
+			return sourceFile, err
+		}
+		if verbose {
+			fmt.Printf("Entry point: %s (%s)\n", entry, entryChoice)
+		}
+		sourceFile = entry
+	} else if entryName != "" {
+		warnf("--entry only applies when run is given a directory. Ignoring it.")
+	}
+
+	// Process substitution and named pipes can't be re-read or compiled in
+	// place, so run a regular copy instead
+	stream := sourceFile == "-" || isStream(sourceFile)
+	if stream {
+		buffer := bufferStream
+		if sourceFile == "-" {
+			buffer = func(_, ext string) (string, func(), error) { return bufferStdin(ext) }
+		}
+		buffered, cleanup, err := buffer(sourceFile, langOverride)
+		if err != nil {
+			return sourceFile, err
+		}
+		defer cleanup()
+		if verbose || dryRun {
+			from := sourceFile
+			if from == "-" {
+				from = "stdin"
+			}
+			fmt.Printf("Read %s into %s\n", from, buffered)
+		}
+		sourceFile = buffered
+	}
+	if remote || stream {
+		// A stable name for the code, whose copy above is gone after this run
+		if stored, ok := storeSnippet(sourceFile); ok {
+			sourceFile = stored
+		}
+	}
+	snippet := isStoredSnippet(sourceFile)
+	keepWorkDir = remote || stream || asInterpreter || snippet
+
+	if err := checkSourceFile(sourceFile); err != nil {
+		return sourceFile, err
+	}
+
+	// A broken .run.json up the tree shouldn't stop every run below it
+	projectConfig, err := loadProjectConfig(sourceFile)
+	if err != nil {
+		if strictConfig {
+			return sourceFile, usageError("Invalid project config: %v", err)
+		}
+		warnf("ignoring project config %v", err)
+	}
+	if verbose && projectConfig != "" {
+		fmt.Printf("Project config: %s\n", projectConfig)
+	}
+
+	detect := beginPhase("detect")
+	applyModeline(sourceFile)
+	ext, _ := detectExt(sourceFile)
+	detectedBy := "extension"
+	if langOverride != "" {
+		ext = langOverride
+		detectedBy = "--lang"
+	} else if fileModeline != nil && fileModeline.Lang != "" {
+		ext = fileModeline.Lang
+		detectedBy = fmt.Sprintf("modeline on line %d", fileModeline.Line)
+	} else if ext != filepath.Ext(sourceFile) {
+		detectedBy = "shebang"
+	} else if _, ok := languageConfigs[ext]; !ok && asInterpreter {
+		if guess := contentLanguage(sourceFile); guess != "" {
+			ext, detectedBy = guess, "content"
+		}
+	}
 
 	config, ok := languageConfigs[ext]
+	if verbose {
+		fmt.Printf("Language: %s (detected by %s)\n", ext, detectedBy)
+	}
+	logEvent("detect", map[string]any{"file": sourceFile, "ext": ext, "supported": ok, "via": detectedBy, "offline": offline})
 
 	if !ok {
-		fmt.Printf("Unsupported file type: %s\n", ext)
-		fmt.Println("Run 'run --list' to see supported languages.")
-		os.Exit(1)
+		detect.end(fmt.Errorf("unsupported file type %s", ext))
+	} else {
+		detect.end(nil)
+	}
+	if !ok && asInterpreter {
+		return sourceFile, newRunError("unsupported-language", exitUnsupported,
+			"Cannot tell the language of %s.\nName it in a modeline below the #! line, e.g. # run:lang=py.", sourceFile)
+	}
+	if !ok {
+		msg := fmt.Sprintf("Unsupported file type: %s\n", ext)
+		if matches := suggest(ext, supportedExtensions()); len(matches) > 0 {
+			msg += fmt.Sprintf("Did you mean %s?\n", strings.Join(matches, " or "))
+		}
+		return sourceFile, newRunError("unsupported-language", exitUnsupported,
+			"%sUse --lang <ext> to pick the language explicitly, or run 'run --list' to see supported languages.", msg)
+	}
+
+	if crlfSensitive[ext] && hasCRLF(sourceFile) && !fixCRLF && !dryRun {
+		return sourceFile, crlfError(sourceFile)
+	}
+	if useDaemon && (ext == ".kt" || ext == ".scala") {
+		warnf("--use-daemon runs only Java in the warm JVM; %s programs start a JVM of their own. Ignoring it.", config.Name)
+		useDaemon = false
+	} else if useDaemon && ext != ".java" && ext != ".cs" {
+		warnf("--use-daemon only applies to Java and C#. Ignoring it.")
+		useDaemon = false
+	}
+	if workDir != "" && config.IsCompiled {
+		warnf("--cwd only applies to interpreted languages. Ignoring it.")
+	}
+	if len(compilerArgs) > 0 && (len(config.CompileCmd) == 0 || documentExts[ext] || ext == ".proto") {
+		warnf("--compiler-args only applies to languages with a compile step. Ignoring it.")
+		compilerArgs = nil
+	}
+	if len(runtimeArgs) > 0 && ((config.IsCompiled && ext != ".java") || len(config.RunCmd) == 0 || documentExts[ext] || ext == ".proto") {
+		warnf("--runtime-args only applies to interpreted languages and Java. Ignoring it.")
+		runtimeArgs = nil
+	}
+	if err := checkSources(sourceFile, ext); err != nil {
+		return sourceFile, err
+	}
+	if fakeHome && useDaemon {
+		warnf("--fake-home can't change the home of the JVM the daemon already started. Ignoring --use-daemon.")
+		useDaemon = false
+	}
+	if len(runtimeArgs) > 0 && useDaemon {
+		warnf("--runtime-args can't change the JVM the daemon already started. Ignoring --use-daemon.")
+		useDaemon = false
+	}
+	if (runTimeout > 0 || maxCPUTime > 0) && useDaemon && ext == ".java" {
+		// The program is a thread of the daemon's JVM, which can't be
+		// stopped or limited on its own
+		warnf("--timeout and --max-cpu-time can't stop a program in the daemon's JVM. Ignoring --use-daemon.")
+		useDaemon = false
+	}
+	if outPath != "" && (!config.IsCompiled || documentExts[ext] || ext == ".proto") {
+		warnf("--out only applies to compiled languages. Ignoring it.")
+		outPath = ""
+	}
+	if outPath != "" && !dryRun {
+		if err := checkOutPath(append([]string{sourceFile}, extraSources...)); err != nil {
+			return sourceFile, err
+		}
+	}
+	if compileOnly && bench {
+		return sourceFile, usageError("--compile-only doesn't run the program, so there is nothing to benchmark.")
+	}
+	if compileOnly && len(config.CompileCmd) == 0 && ext != ".proto" {
+		if _, ok := syntaxCheckCmds[ext]; !ok {
+			return sourceFile, usageError("%s files have no compile step, so --compile-only has nothing to do.", config.Name)
+		}
+		// e.g. Go, which go run builds on the fly: checking it is the closest
+		infof("%s has no separate compile step; checking %s instead.\n", config.Name, sourceFile)
+		compileOnly, checkOnly = false, true
+	}
+	if compileOnly {
+		// What was built is the result
+		keepBuild = true
+	}
+	if _, ok := syntaxCheckCmds[ext]; checkOnly && !ok {
+		return sourceFile, usageError("--check is not available for %s files.", config.Name)
+	}
+	if err := checkEntryPoint(sourceFile, ext); err != nil {
+		return sourceFile, err
+	}
+
+	if bench && documentExts[ext] {
+		return sourceFile, usageError("%s files are rendered, not run; rendering is not a meaningful benchmark target.", config.Name)
+	}
+
+	if ext == ".sh" {
+		if slices.Contains(commandShells, shellOverride) {
+			return sourceFile, usageError("--shell %s only applies to --sh; .sh files need a Unix shell.", shellOverride)
+		}
+		var chosenBy string
+		config, chosenBy = shellConfig(config, sourceFile)
+		if verbose {
+			fmt.Printf("Shell: %s (%s)\n", shellJoin(config.RunCmd), chosenBy)
+		}
+	}
+
+	if detectedBy == "extension" && !noDetect {
+		if suspect, clue := suspectLanguage(sourceFile, ext); suspect != "" {
+			name := suspect
+			if other, ok := languageConfigs[suspect]; ok {
+				name = other.Name
+			}
+			msg := fmt.Sprintf("%s looks like %s (%s), not %s. Use --lang %s if so.",
+				sourceFile, name, clue, config.Name, strings.TrimPrefix(suspect, "."))
+			if strictDetect {
+				return sourceFile, newRunError("language-mismatch", exitUnsupported, "%s", msg)
+			}
+			warnf("%s", msg)
+		}
+	}
+
+	if auditFile {
+		if err := confirmAudit(sourceFile, ext, dryRun); err != nil {
+			return sourceFile, err
+		}
 	}
 
 	installCmd := config.InstallCmd()
 
-	if !checkRuntime(config.CheckCmd) {
+	check := beginPhase("check")
+	toolVersion, found := probeRuntime(config.CheckCmd)
+	if found {
+		check.end(nil)
+	} else {
+		check.end(fmt.Errorf("%s not found", config.CheckCmd[0]))
+	}
+	if !found {
 		if dryRun {
-			fmt.Printf("✗ Runtime '%s' not found (would prompt for installation)\n", config.CheckCmd[0])
-			os.Exit(1)
-		}
-		fmt.Printf("%s not found. Do you want to install it? (y/n): ", config.CheckCmd[0])
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		if strings.ToLower(strings.TrimSpace(input)) == "y" {
-			if installCmd[0] == "echo" {
-				fmt.Println(installCmd[1])
-				fmt.Println("Please install the runtime manually and re-run the command.")
-				os.Exit(1)
+			return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+				"✗ Runtime '%s' not found (would prompt for installation)", config.CheckCmd[0])
+		}
+		if offline {
+			return sourceFile, offlineError("runtime-unavailable", exitRuntimeUnavailable,
+				fmt.Sprintf("%s not found. Installation", config.CheckCmd[0]),
+				fmt.Sprintf("Install it manually (%s) and re-run the command.", shellJoin(installCmd)))
+		}
+		if noInstall {
+			return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+				"%s not found; not installing it (--no-install). Install it with: %s", config.CheckCmd[0], shellJoin(installCmd))
+		}
+		if installCmd[0] == "echo" {
+			fmt.Fprintf(messageOut(), "%s not found. %s\n", config.CheckCmd[0], installCmd[1])
+			return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+				"Please install the runtime manually and re-run the command.")
+		}
+		if when, ok := recentDecline(config.CheckCmd[0]); ok {
+			return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+				"%s not found; skipped, as installing it was declined %s. Install it with: %s",
+				config.CheckCmd[0], ago(when), shellJoin(installCmd))
+		}
+		install := beginPhase("install")
+		if confirmInstall(config.CheckCmd[0]+" not found.", installCmd) {
+			if installCmd[0] == "xcode-select" {
+				err := installCommandLineTools()
+				install.end(err)
+				return sourceFile, err
 			}
 			if !installRuntime(installCmd) {
-				fmt.Println("Installation failed. Exiting.")
-				os.Exit(1)
+				install.end(errors.New("installation failed"))
+				return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable, "Installation failed. Exiting.")
 			}
 			// Re-check after installation
-			if !checkRuntime(config.CheckCmd) {
-				fmt.Println("Runtime still not found after installation. Exiting.")
-				os.Exit(1)
+			if toolVersion, found = probeRuntime(config.CheckCmd); !found {
+				install.end(errors.New("runtime still not found after installation"))
+				return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable,
+					"Runtime still not found after installation. Exiting.")
 			}
+			install.end(nil)
 		} else {
-			fmt.Println("Installation declined. Exiting.")
-			os.Exit(1)
+			install.end(errors.New("installation declined"))
+			recordDecline(config.CheckCmd[0])
+			return sourceFile, newRunError("runtime-unavailable", exitRuntimeUnavailable, "Installation declined. Exiting.")
 		}
 	}
 
+	if path := windowsToolchain(config.CheckCmd[0]); path != "" {
+		warnf("%s is the Windows program %s, which may not work from WSL.", config.CheckCmd[0], path)
+		fmt.Fprintf(messageOut(), "  Install the Linux toolchain instead: %s\n", shellJoin(installCmd))
+	}
+
+	if emitScript != "" {
+		return sourceFile, writeEmittedScript(sourceFile, config, ext)
+	}
+	if checkOnly {
+		if dryRun {
+			fmt.Printf("Would check %s without running it: %s\n", sourceFile, shellJoin(syntaxCheckCommand(sourceFile, ext)))
+			return sourceFile, nil
+		}
+		return sourceFile, checkSyntax(sourceFile, ext)
+	}
+	if compileOnly && dryRun {
+		plan := buildPlan(sourceFile, config, ext)
+		if plan.PrepareDesc != "" {
+			fmt.Println(plan.PrepareDesc)
+		}
+		build := plan.Compile
+		if build == nil {
+			build = plan.Run // protoc's run is the build
+		}
+		fmt.Printf("Would compile %s without running it: %s\n", sourceFile, shellJoin(build))
+		return sourceFile, nil
+	}
 	if dryRun {
 		performDryRun(sourceFile, config, ext)
-		os.Exit(0)
+		return sourceFile, nil
+	}
+
+	if snippet {
+		appendHistory(snippetHistoryEntry(sourceFile))
+	} else if !remote && !stream && !asInterpreter {
+		recordHistory(sourceFile)
 	}
+	recordHealthy(map[string]string{ext: toolVersion})
+	formatSource(sourceFile, config)
 
+	if bench && ext == ".go" && isGoTest(sourceFile) {
+		// Go test files bring their own benchmarks
+		infof("Running the benchmarks with go test -bench instead of timing repeated runs.\n")
+		testBench = true
+		bench = false
+	}
 	if bench {
-		performBenchmark(sourceFile, config, ext, benchRuns)
-		os.Exit(0)
+		heartbeat = 0 // Status lines would only get in the way of the measurements
+		return sourceFile, performBenchmark(sourceFile, config, ext, benchOpts)
 	}
 
 	// Normal execution with optional timing
@@ -688,55 +1816,263 @@ func main() {
 		start = time.Now()
 	}
 
-	executeFile(sourceFile, config, ext)
+	if err := executeFile(sourceFile, config, ext); err != nil {
+		return sourceFile, err
+	}
 
 	if timeExec {
 		elapsed := time.Since(start)
-		fmt.Printf("\n⏱  Execution time: %v\n", elapsed)
+		fmt.Fprintf(messageOut(), "\n⏱  Execution time: %s\n", formatDuration(elapsed))
 	}
 
-	fmt.Println()
+	infof("\n")
+	return sourceFile, nil
 }
 
-func listLanguages() {
-	fmt.Println("Supported Languages:")
-	fmt.Println("--------------------")
+// languageInfo is the machine-readable description of a supported language
+// used by `--list --json` and the serve mode. The command fields are only
+// filled in for the detailed view (`--list .py`).
+type languageInfo struct {
+	Extension      string `json:"extension"`
+	Runtime        string `json:"runtime"`
+	Type           string `json:"type"`
+	Command        string `json:"command"`
+	CheckCommand   string `json:"checkCommand,omitempty"`
+	InstallCommand string `json:"installCommand,omitempty"`
+	CompileCommand string `json:"compileCommand,omitempty"`
+	RunCommand     string `json:"runCommand,omitempty"`
+	Source         string `json:"source,omitempty"`
+
+	// Health is the state of the runtime, only with --check
+	Health *languageHealth `json:"health,omitempty"`
+}
 
-	// Sort extensions for consistent output
+// supportedExtensions returns the known extensions in sorted order.
+func supportedExtensions() []string {
 	extensions := make([]string, 0, len(languageConfigs))
 	for ext := range languageConfigs {
 		extensions = append(extensions, ext)
 	}
 	sort.Strings(extensions)
+	return extensions
+}
+
+// describeLanguage builds the summary row for ext.
+func describeLanguage(ext string) languageInfo {
+	config := languageConfigs[ext]
+	info := languageInfo{
+		Extension: ext,
+		Runtime:   config.CheckCmd[0],
+		Type:      "interpreted",
+		Command:   strings.Join(config.RunCmd, " "),
+	}
+	if config.IsCompiled {
+		info.Type = "compiled"
+		if len(config.CompileCmd) > 0 {
+			info.Command = strings.Join(config.CompileCmd, " ")
+		}
+	}
+	return info
+}
+
+// describeLanguageDetail adds the full command set to the summary row.
+func describeLanguageDetail(ext string) languageInfo {
+	config := languageConfigs[ext]
+	info := describeLanguage(ext)
+	info.CheckCommand = strings.Join(config.CheckCmd, " ")
+	info.InstallCommand = strings.Join(config.InstallCmd(), " ")
+	info.CompileCommand = strings.Join(config.CompileCmd, " ")
+	info.RunCommand = strings.Join(config.RunCmd, " ")
+	info.Source = fieldSource(ext, "language")
+	return info
+}
+
+// supportedLanguages returns the language table sorted by extension.
+func supportedLanguages() []languageInfo {
+	extensions := supportedExtensions()
+	infos := make([]languageInfo, 0, len(extensions))
+	for _, ext := range extensions {
+		infos = append(infos, describeLanguage(ext))
+	}
+	return infos
+}
+
+// checkRuntimes checks the runtimes of the given languages in parallel and
+// reports which ones are installed.
+func checkRuntimes(extensions []string) map[string]bool {
+	installed := make(map[string]bool, len(extensions))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ext := range extensions {
+		wg.Add(1)
+		go func(ext string) {
+			defer wg.Done()
+			ok := checkRuntime(languageConfigs[ext].CheckCmd)
+			mu.Lock()
+			installed[ext] = ok
+			mu.Unlock()
+		}(ext)
+	}
+	wg.Wait()
+	return installed
+}
+
+// listCommand implements `run --list [--json] [--installed] [--check] [--compiled|--interpreted] [exts...]`.
+func listCommand(args []string) {
+	var asJSON, installedOnly, withHealth, compiledOnly, interpretedOnly bool
+	var selected []string
+	for _, arg := range args {
+		switch arg {
+		case "--json":
+			asJSON = true
+		case "--installed":
+			installedOnly = true
+		case "--check":
+			withHealth = true
+		case "--compiled":
+			compiledOnly = true
+		case "--interpreted":
+			interpretedOnly = true
+		default:
+			ext := normalizeExt(arg)
+			if _, ok := languageConfigs[ext]; !ok {
+				fmt.Printf("Unsupported language: %s\n", arg)
+				os.Exit(1)
+			}
+			selected = append(selected, ext)
+		}
+	}
+	if compiledOnly && interpretedOnly {
+		warnf("--compiled and --interpreted together match nothing. Ignoring both.")
+		compiledOnly, interpretedOnly = false, false
+	}
+
+	extensions := selected
+	if len(extensions) == 0 {
+		extensions = supportedExtensions()
+	}
+
+	var filtered []string
+	for _, ext := range extensions {
+		isCompiled := languageConfigs[ext].IsCompiled
+		if (compiledOnly && !isCompiled) || (interpretedOnly && isCompiled) {
+			continue
+		}
+		filtered = append(filtered, ext)
+	}
+	var health map[string]languageHealth
+	if withHealth {
+		health = checkHealth(filtered)
+	}
+	if installedOnly {
+		installed := make(map[string]bool, len(health))
+		for ext, h := range health {
+			installed[ext] = h.Installed
+		}
+		if health == nil {
+			installed = checkRuntimes(filtered)
+		}
+		kept := filtered[:0]
+		for _, ext := range filtered {
+			if installed[ext] {
+				kept = append(kept, ext)
+			}
+		}
+		filtered = kept
+	}
+
+	detailed := len(selected) > 0
+	if asJSON {
+		infos := make([]languageInfo, 0, len(filtered))
+		for _, ext := range filtered {
+			info := describeLanguage(ext)
+			if detailed {
+				info = describeLanguageDetail(ext)
+			}
+			if h, ok := health[ext]; ok {
+				info.Health = &h
+			}
+			infos = append(infos, info)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(infos)
+		return
+	}
+
+	if detailed {
+		listLanguageDetails(filtered, health)
+	} else {
+		listLanguages(filtered, health)
+	}
+}
+
+// listLanguages prints the table of languages, with the state of their
+// runtime when health is given (--check).
+func listLanguages(extensions []string, health map[string]languageHealth) {
+	fmt.Println("Supported Languages:")
+	fmt.Println("--------------------")
 
 	fmt.Printf("%-10s %-15s %-12s %s\n", "Extension", "Runtime", "Type", "Command")
 	fmt.Println(strings.Repeat("-", 70))
 
 	for _, ext := range extensions {
-		config := languageConfigs[ext]
-		runtime := config.CheckCmd[0]
+		info := describeLanguage(ext)
 		langType := "Interpreted"
-		if config.IsCompiled {
+		if info.Type == "compiled" {
 			langType = "Compiled"
 		}
-
-		cmdStr := strings.Join(config.RunCmd, " ")
-		if config.IsCompiled && len(config.CompileCmd) > 0 {
-			cmdStr = strings.Join(config.CompileCmd, " ")
+		fmt.Printf("%-10s %-15s %-12s %s\n", ext, info.Runtime, langType, info.Command)
+		if h, ok := health[ext]; ok {
+			fmt.Printf("%-10s %s\n", "", describeHealth(h))
 		}
+	}
 
-		fmt.Printf("%-10s %-15s %-12s %s\n", ext, runtime, langType, cmdStr)
+	if len(extensions) == len(languageConfigs) {
+		fmt.Printf("\nTotal: %d languages supported\n", len(languageConfigs))
+	} else {
+		fmt.Printf("\nShowing %d of %d supported languages\n", len(extensions), len(languageConfigs))
 	}
+}
 
-	fmt.Printf("\nTotal: %d languages supported\n", len(languageConfigs))
+func listLanguageDetails(extensions []string, health map[string]languageHealth) {
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	for i, ext := range extensions {
+		if i > 0 {
+			fmt.Println()
+		}
+		info := describeLanguageDetail(ext)
+		fmt.Println(ext)
+		fmt.Printf("  Runtime:  %s\n", info.Runtime)
+		fmt.Printf("  Type:     %s\n", info.Type)
+		fmt.Printf("  Check:    %s\n", info.CheckCommand)
+		fmt.Printf("  Install:  %s\n", info.InstallCommand)
+		fmt.Printf("  Compile:  %s\n", orNone(info.CompileCommand))
+		fmt.Printf("  Run:      %s\n", orNone(info.RunCommand))
+		fmt.Printf("  Source:   %s\n", info.Source)
+		if h, ok := health[ext]; ok {
+			fmt.Printf("  Status:   %s\n", describeHealth(h))
+		}
+	}
 }
 
 func performDryRun(sourceFile string, config LanguageConfig, ext string) {
 	fmt.Println(" Dry Run Mode - No execution will occur")
 	fmt.Println("=========================================")
 	fmt.Printf("File: %s\n", sourceFile)
+	if entryDir != "" {
+		fmt.Printf("Entry point: %s in %s (%s)\n", filepath.Base(sourceFile), entryDir, entryChoice)
+	}
 	fmt.Printf("Language: %s\n", ext)
 	fmt.Printf("Runtime: %s\n", config.CheckCmd[0])
+	if fileModeline != nil {
+		fmt.Printf("Modeline: line %d sets %s\n", fileModeline.Line, strings.Join(fileModeline.Set, ", "))
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(sourceFile); os.IsNotExist(err) {
@@ -754,275 +2090,618 @@ func performDryRun(sourceFile string, config LanguageConfig, ext string) {
 		return
 	}
 
-	if config.IsCompiled {
-		fmt.Println("\nCompilation step:")
-		executableName := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
-		compileArgs := []string{}
+	plan := buildPlan(sourceFile, config, ext)
 
-		if ext == ".rs" {
-			compileArgs = append(config.CompileCmd[1:], sourceFile)
-		} else if ext == ".cs" {
-			fmt.Printf("  Would create .NET project and compile\n")
-		} else {
-			compileArgs = append(config.CompileCmd[1:], sourceFile, "-o", executableName)
+	if plan.Project != "" {
+		fmt.Printf("Project: %s; --no-project runs the file on its own\n", plan.Project)
+	}
+	printDataDeps(plan)
+	if plan.PrepareDesc != "" {
+		fmt.Println("\nPreparation step:")
+		fmt.Printf("  %s\n", plan.PrepareDesc)
+	}
+	if stripsShebang(config, ext) && plan.Prepare == nil && plan.Project == "" {
+		if strings.HasPrefix(shebangLine(sourceFile), "#!") {
+			fmt.Println("\nPreparation step:")
+			fmt.Printf("  Would copy %s to a temporary directory with its #! line blanked and build that\n", shellQuote(sourceFile))
+		} else if filepath.Ext(sourceFile) != ext {
+			fmt.Println("\nPreparation step:")
+			fmt.Printf("  Would copy %s to a temporary directory as a %s file and build that\n", shellQuote(sourceFile), ext)
 		}
+	}
 
-		if len(compileArgs) > 0 {
-			fmt.Printf("  Command: %s %s\n", config.CompileCmd[0], strings.Join(compileArgs, " "))
+	if plan.Compile != nil {
+		fmt.Println("\nCompilation step:")
+		if plan.Dir != "" {
+			fmt.Printf("  Directory: %s\n", plan.Dir)
+		}
+		if hasPlaceholders(config.CompileCmd) {
+			fmt.Printf("  Template: %s\n", shellJoin(config.CompileCmd))
 		}
+		fmt.Printf("  Command: %s\n", shellJoin(plan.Compile))
+	}
 
-		fmt.Println("\nExecution step:")
-		if ext == ".java" {
-			fmt.Printf("  Command: %s %s\n", config.RunCmd[0], config.ClassNameFn(filepath.Base(sourceFile)))
-		} else if ext == ".cs" {
-			fmt.Printf("  Command: dotnet run\n")
-		} else {
-			fmt.Printf("  Command: ./%s\n", executableName)
+	fmt.Println("\nExecution step:")
+	if plan.Dir != "" && plan.Compile == nil {
+		fmt.Printf("  Directory: %s\n", plan.Dir)
+	}
+	if hasPlaceholders(config.RunCmd) {
+		fmt.Printf("  Template: %s\n", shellJoin(config.RunCmd))
+	}
+	fmt.Printf("  Command: %s\n", shellJoin(plan.Run))
+	if fakeHome {
+		fmt.Println("  Home: a throwaway directory, removed afterwards unless --keep (--fake-home)")
+	}
+	if ext == ".js" {
+		if deps := inlineNodeDeps(sourceFile); len(deps) > 0 {
+			fmt.Printf("  Dependencies: %s\n", describeNodeDeps(sourceFile, deps))
 		}
+	}
 
+	if len(plan.Cleanup) > 0 || plan.BuildDir != "" {
 		fmt.Println("\nCleanup step:")
-		fmt.Printf("  Would remove: %s\n", executableName)
-	} else {
-		fmt.Println("\nExecution step:")
-		runArgs := append(config.RunCmd[1:], sourceFile)
-		fmt.Printf("  Command: %s %s\n", config.RunCmd[0], strings.Join(runArgs, " "))
+		for _, path := range plan.Cleanup {
+			fmt.Printf("  Would remove: %s\n", shellQuote(path))
+		}
+		if plan.BuildDir != "" && keepBuild {
+			fmt.Printf("  Would keep: %s (--keep)\n", shellQuote(plan.BuildDir))
+		} else if plan.BuildDir != "" {
+			fmt.Printf("  Would remove: %s (the build directory)\n", shellQuote(plan.BuildDir))
+		}
 	}
 
 	fmt.Println("\n✓ Dry run complete")
 }
 
-func performBenchmark(sourceFile string, config LanguageConfig, ext string, runs int) {
-	fmt.Printf("🔥  Running benchmark with %d iterations...\n", runs)
-	fmt.Println(strings.Repeat("=", 50))
+// force overrides run's safety checks (--force): files that look binary
+// are run anyway.
+var force bool
+
+// execPlan describes the steps needed to run a source file: an optional
+// preparation hook, an optional compile command and the run command.
+type execPlan struct {
+	SourceFile  string // The source as the toolchain sees it (Program.cs for C#)
+	Executable  string // Compiled artifact, empty for interpreted languages
+	Prepare     func(out io.Writer) error
+	PrepareDesc string   // What Prepare does, for --dry-run
+	Compile     []string // nil for interpreted languages
+	Sources     []string // More translation units in Compile (--sources)
+	Run         []string
+	Dir         string   // Working directory for compile and run, empty for current
+	Env         []string // Added to the environment of compile and run
+	Cleanup     []string // Files removed after execution
+	BuildDir    string   // Where the compile step writes, removed after execution
+	Project     string   // The project run with its build tool, for --dry-run
+}
 
-	times := make([]time.Duration, runs)
-	var totalTime time.Duration
+// buildPlan works out the compile and run commands for sourceFile.
+func buildPlan(sourceFile string, config LanguageConfig, ext string) execPlan {
+	if plan, ok := testPlan(sourceFile, ext); ok {
+		return plan
+	}
+	if plan, ok := renderPlan(sourceFile, ext); ok {
+		return plan
+	}
+	if plan, ok := projectPlan(sourceFile, ext); ok {
+		return plan
+	}
+	if ext == ".proto" {
+		outDir := protoGenOut
+		if outDir == "" {
+			outDir = "<temporary directory>"
+		}
+		return protoPlan(sourceFile, outDir)
+	}
 
-	// Compile once if needed
-	var executableName string
-	var compiledForBench bool
+	plan := execPlan{SourceFile: sourceFile}
+
+	if !config.IsCompiled {
+		plan.Run = append(resolveRuntime(ext, config.RunCmd), runtimeArgs...)
+		plan.Run = append(plan.Run, sourceFile)
+		applyWorkDir(&plan, ext)
+		if hasPlaceholders(config.RunCmd) {
+			// applyWorkDir made the source path absolute if the program
+			// runs elsewhere
+			expandTemplates(&plan, config, ext, plan.Run[len(plan.Run)-1])
+			return plan
+		}
+		plan.Run = append(plan.Run, programArgs...)
+		return plan
+	}
 
-	if config.IsCompiled {
-		executableName = strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
-		fmt.Printf("Compiling %s...\n", sourceFile)
-
-		var compileArgs []string
-		if ext == ".rs" {
-			compileArgs = append(config.CompileCmd[1:], sourceFile)
-		} else if ext == ".cs" {
-			// Handle .NET compilation
-			projectDir := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
-			if _, err := os.Stat(projectDir); os.IsNotExist(err) {
-				cmd := exec.Command("dotnet", "new", "console", "-o", projectDir)
-				cmd.Stdout = nil
-				cmd.Stderr = os.Stderr
-				cmd.Run()
-				os.Rename(sourceFile, filepath.Join(projectDir, "Program.cs"))
-			}
-			os.Chdir(projectDir)
-			compileArgs = config.CompileCmd[1:]
-		} else {
-			compileArgs = append(config.CompileCmd[1:], sourceFile, "-o", executableName)
+	// Nothing is written next to the source, which may be read-only or in
+	// use by another run
+	plan.BuildDir = newBuildDir(sourceFile)
+	name := strings.TrimSuffix(filepath.Base(sourceFile), filepath.Ext(sourceFile))
+	executableName := filepath.Join(plan.BuildDir, name)
+	if outPath != "" {
+		// Absolute, so that exec never searches PATH for it
+		executableName, _ = filepath.Abs(outPath)
+	}
+	plan.Executable = executableName
+
+	switch ext {
+	case ".java":
+		// java loads the class javac wrote to the class directory by name
+		classDir := plan.BuildDir
+		if outPath != "" {
+			classDir = executableName
+		}
+		plan.Executable = classDir
+		plan.Compile = append(resolveRuntime(ext, config.CompileCmd), compilerArgs...)
+		plan.Compile = append(plan.Compile, "-d", classDir, sourceFile)
+		plan.Run = append(append([]string{}, config.RunCmd...), runtimeArgs...)
+		plan.Run = append(plan.Run, "-cp", classDir, config.ClassNameFn(filepath.Base(sourceFile)))
+	case ".cs":
+		// For C#, we need to create a project first, then build and run inside it
+		projectDir := filepath.Join(plan.BuildDir, name)
+		plan.Prepare = func(out io.Writer) error {
+			return prepareDotnetProject(sourceFile, projectDir, out)
 		}
+		plan.PrepareDesc = fmt.Sprintf("Would create a .NET project in %s and copy %s into it as Program.cs",
+			shellQuote(projectDir), shellQuote(sourceFile))
+		plan.SourceFile = filepath.Join(projectDir, "Program.cs")
+		plan.Compile = append(append(resolveRuntime(ext, config.CompileCmd), compilerArgs...), projectDir)
+		if outPath != "" {
+			// The project goes with the build directory; the build output
+			// stays and runs on its own
+			plan.Compile = append(plan.Compile, "-o", executableName)
+			plan.Run = []string{"dotnet", filepath.Join(executableName, name+".dll")}
+			break
+		}
+		plan.Executable = projectDir
+		// The program runs in the current directory, like any other
+		plan.Run = append(append([]string{}, config.RunCmd...), "--project", projectDir)
+		// dotnet run builds again unless it's asked for the same build, e.g.
+		// with the same -c Release
+		plan.Run = append(plan.Run, compilerArgs...)
+		if useDaemon {
+			// The MSBuild server stays up between builds, and dotnet run
+			// needn't build again what the compile step just built
+			plan.Env = append(plan.Env, "DOTNET_CLI_USE_MSBUILD_SERVER=1")
+			plan.Run = append(plan.Run, "--no-build")
+		}
+		if len(programArgs) > 0 {
+			plan.Run = append(plan.Run, "--") // Separates them from dotnet run's own
+		}
+	default:
+		plan.Compile = resolveRuntime(ext, config.CompileCmd)
+		if coreDump {
+			plan.Compile = append(plan.Compile, debugFlags[ext]...)
+		}
+		plan.Compile = append(plan.Compile, compilerArgs...)
+		plan.Compile = append(plan.Compile, buildDirFlags(ext, plan.BuildDir)...)
+		if _, ok := unitExts[ext]; ok {
+			plan.Sources = extraSources
+		}
+		plan.Compile = append(plan.Compile, outputFlags(ext, append([]string{sourceFile}, plan.Sources...), executableName)...)
+		plan.Run = []string{longPath(executableName)}
+	}
+	if entryDir != "" {
+		// A directory's program runs in it, with its source and build
+		// given as absolute paths
+		plan.Dir = entryDir
+	}
+
+	plan.Run = append(plan.Run, programArgs...)
+	expandTemplates(&plan, config, ext, sourceFile)
+	return plan
+}
+
+// prepareDotnetProject creates the console project projectDir in the build
+// directory for a single .cs file and copies the source into it as
+// Program.cs. It never changes run's own working directory.
+func prepareDotnetProject(sourceFile, projectDir string, out io.Writer) error {
+	content, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Creating .NET project in %s...\n", projectDir)
+	cmd := exec.Command("dotnet", "new", "console", "-o", projectDir)
+	cmd.Env = childEnv()
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := runCmd(cmd); err != nil {
+		return fmt.Errorf("failed to create .NET project: %w", err)
+	}
+	return os.WriteFile(filepath.Join(projectDir, "Program.cs"), content, 0o600)
+}
+
+// command builds an exec.Cmd for one of the plan's steps.
+func (p execPlan) command(ctx context.Context, argv []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = p.Dir
+	cmd.Env = append(append(childEnv(), modelineEnv...), p.Env...)
+	dieWithParent(cmd)
+	return cmd
+}
 
-		cmd := exec.Command(config.CompileCmd[0], compileArgs...)
-		cmd.Stdout = nil
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
+// cleanup removes the artifacts produced by the compile step.
+func (p execPlan) cleanup() {
+	if len(p.Cleanup) == 0 && (p.BuildDir == "" || keepBuild) {
+		return
+	}
+	phase := beginPhase("cleanup")
+	var failed error
+	remove := func(path string, remove func(string) error) {
+		err := remove(path)
+		fields := map[string]any{"path": path}
 		if err != nil {
-			fmt.Printf("Compilation failed: %v\n", err)
-			os.Exit(1)
+			fields["error"] = err.Error()
+			failed = err
 		}
-		fmt.Println("✓ Compilation successful\n")
-		compiledForBench = true
+		logEvent("cleanup", fields)
+	}
+	for _, path := range p.Cleanup {
+		remove(path, os.Remove)
+	}
+	if p.BuildDir != "" && !keepBuild {
+		remove(p.BuildDir, os.RemoveAll)
 	}
+	phase.end(failed)
+}
 
-	// Run benchmark iterations
-	for i := 0; i < runs; i++ {
-		fmt.Printf("Run %d/%d... ", i+1, runs)
+// localPath makes a relative executable path explicit so that exec does not
+// search PATH for it.
+func localPath(path string) string {
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "."+string(filepath.Separator)) {
+		return path
+	}
+	return "." + string(filepath.Separator) + path
+}
 
-		start := time.Now()
+func executeFile(sourceFile string, config LanguageConfig, ext string) error {
+	if ext == ".tex" || ext == ".proto" {
+		build := compileTeX
+		if ext == ".proto" {
+			build = compileProto
+		}
+		compile := beginPhase("compile")
+		err := build(sourceFile)
+		compile.end(err)
+		return err
+	}
+	plan, removeCopy, err := shebangSafePlan(sourceFile, config, ext)
+	if err != nil {
+		return newRunError("compile-failed", exitCompileFailed, "Cannot strip the #! line: %v", err)
+	}
+	defer removeCopy()
+	if err := checkDataDeps(dataDepsBase(plan)); err != nil {
+		return err
+	}
+	logEvent("resolve", map[string]any{"compile": plan.Compile, "run": plan.Run, "dir": plan.Dir})
+	if verbose && plan.Dir != "" {
+		fmt.Printf("Working directory: %s\n", plan.Dir)
+	}
+	if err := plan.makeBuildDir(); err != nil {
+		return newRunError("compile-failed", exitCompileFailed, "Cannot create the build directory: %v", err)
+	}
+	// Whichever way the run ends, e.g. with the program crashing
+	defer func() { plan.cleanup() }()
+
+	if plan.Prepare != nil {
+		prepare := beginPhase("prepare")
+		step := startStep("preparing " + sourceFile)
+		err := plan.Prepare(step)
+		step.Finish(err)
+		prepare.end(err)
+		if err != nil {
+			return newRunError("compile-failed", exitCompileFailed, "Preparation failed: %v", err)
+		}
+	}
 
-		var cmd *exec.Cmd
-		if config.IsCompiled {
-			if ext == ".java" {
-				cmd = exec.Command(config.RunCmd[0], config.ClassNameFn(filepath.Base(sourceFile)))
-			} else if ext == ".cs" {
-				cmd = exec.Command(config.RunCmd[0], config.RunCmd[1:]...)
-			} else if ext == ".rs" {
-				cmd = exec.Command("./" + executableName)
-			} else {
-				cmd = exec.Command(executableName)
+	if ext == ".js" {
+		if deps := inlineNodeDeps(sourceFile); len(deps) > 0 {
+			if err := prepareNodeDeps(sourceFile, deps); err != nil {
+				var re *runError
+				if errors.As(err, &re) {
+					return re
+				}
+				return newRunError("runtime-unavailable", exitRuntimeUnavailable, "%v", err)
 			}
+		}
+		plan.Env = nodeEnv(sourceFile)
+	}
+
+	runName := sourceFile
+	if plan.Compile != nil {
+		// Builds are kept by content, so an unchanged source runs at once
+		cacheKey := plan.buildCacheKey()
+		releaseBuild := func() {}
+		if cacheKey != "" {
+			releaseBuild = lockBuild(cacheKey, sourceFile)
+		}
+		if cacheKey != "" && !noBuildCache && plan.restoreBuild(cacheKey) {
+			infof("Using the cached build of %s.\n", sourceFile)
 		} else {
-			runArgs := append(config.RunCmd[1:], sourceFile)
-			cmd = exec.Command(config.RunCmd[0], runArgs...)
+			if err := compileProgram(plan, sourceFile); err != nil {
+				releaseBuild()
+				return err
+			}
+			if cacheKey != "" {
+				plan.saveBuild(cacheKey, sourceFile, ext)
+			}
+			if outPath != "" {
+				recordOutPath()
+			}
+		}
+		releaseBuild()
+		if compileOnly {
+			fmt.Fprintf(messageOut(), "Built %s\n", plan.keptArtifact())
+			return nil
 		}
+		if keepBuild {
+			fmt.Fprintf(messageOut(), "Keeping %s (--keep)\n", plan.keptArtifact())
+		}
+		runName = strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
+	}
 
-		cmd.Stdout = nil // Suppress output during benchmark
-		cmd.Stderr = nil
-		err := cmd.Run()
+	if ext == ".java" && useDaemon {
+		state, ok := javaDaemon()
+		if !ok {
+			infof("No JVM daemon is running (start one with run daemon start); using a fresh JVM.\n")
+		} else if reason := daemonFallback(state, sourceFile); reason != "" {
+			infof("Not using the JVM daemon: %s. Using a fresh JVM.\n", reason)
+		} else {
+			return runJavaInDaemon(state, plan, config, runName)
+		}
+	}
 
-		elapsed := time.Since(start)
-		times[i] = elapsed
-		totalTime += elapsed
+	// Without kernel support, --max-cpu-time degrades to a wall-clock timeout
+	ctx := context.Background()
+	if maxCPUTime > 0 && !rlimitsSupported {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxCPUTime)
+		defer cancel()
+	}
+	runCtx, cancelRun := withRunTimeout(ctx)
+	defer cancelRun()
 
+	var homeEnv []string
+	if fakeHome {
+		home, env, err := newFakeHome()
 		if err != nil {
-			fmt.Printf("✗ Failed (%v)\n", err)
-		} else {
-			fmt.Printf("✓ %v\r", elapsed)
+			return newRunError("error", 1, "Cannot create the fake home: %v", err)
 		}
+		defer finishFakeHome(home)
+		homeEnv = env
+		infof("Using the throwaway home directory %s (--fake-home).\n", home)
 	}
 
-	// Clean up if compiled
-	if compiledForBench {
-		if ext == ".cpp" || ext == ".c" || ext == ".rs" || ext == ".nim" || ext == ".zig" || ext == ".hs" || ext == ".pas" || ext == ".fs" || ext == ".ml" {
-			os.Remove(executableName)
-			if runtime.GOOS == "windows" {
-				os.Remove(executableName + ".exe")
-			}
+	var cmd *exec.Cmd
+	var stderr stderrCapture
+	oomBefore := oomKills()
+	run := beginPhase("run")
+	for attempt := 1; ; attempt++ {
+		cmd = plan.command(runCtx, plan.Run)
+		addEnv(cmd, homeEnv)
+		stderr.Reset()
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		infof("Running %s...\n", runName)
+		isolateProgram(cmd)
+		applyTimeout(cmd)
+		err = runProgram(cmd, childLimits{CPU: maxCPUTime, Core: coreDump})
+
+		// A script importing a package that isn't installed gets it and
+		// one more try
+		if err == nil || attempt > 1 || ext != ".js" {
+			break
+		}
+		pkg := missingNodePackage(stderr.String())
+		if pkg == "" {
+			break
 		}
+		if installErr := installNodeDeps(sourceFile, []string{pkg}); installErr != nil {
+			fmt.Fprintln(messageOut(), installErr)
+			break
+		}
+		plan.Env = nodeEnv(sourceFile)
+	}
+	run.end(err)
+	if coreDump && coreDumped(cmd.ProcessState) {
+		fmt.Fprintln(messageOut(), executionFailure(err, cmd.ProcessState, oomBefore))
+		reportCoreDump(cmd.Path, plan.Dir, cmd.ProcessState.Pid())
+		return &runError{Status: "program-failed", Code: exitCode(cmd.ProcessState)}
 	}
 
-	// Calculate statistics
-	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
-
-	min := times[0]
-	max := times[len(times)-1]
-	avg := totalTime / time.Duration(runs)
-	median := times[len(times)/2]
+	if re := interruptError(); re != nil {
+		return re
+	}
 
-	var sumSquaredDiffs float64
-	for _, t := range times {
-		diff := float64(t - avg)
-		sumSquaredDiffs += diff * diff
+	if err != nil && maxCPUTime > 0 {
+		if cpuLimitExceeded(cmd.ProcessState, maxCPUTime) {
+			return newRunError("timeout", exitTimeout, "CPU time limit exceeded (%v)", maxCPUTime)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return newRunError("timeout", exitTimeout, "Time limit exceeded (%v wall clock)", maxCPUTime)
+		}
+	}
+	if err != nil && timedOut(runCtx) {
+		return timeoutError()
+	}
+	if err != nil {
+		// Interpreted languages report compile and runtime errors here
+		printSourceContext(stderr.String(), plan.SourceFile)
+		suggestMissingDep(sourceFile, ext, stderr.String())
+		re := newRunError("program-failed", exitCode(cmd.ProcessState), "%s", executionFailure(err, cmd.ProcessState, oomBefore))
+		re.Diagnostics = parseDiagnostics(stderr.String(), plan.SourceFile, sourceFile)
+		return re
+	}
+	if documentExts[ext] {
+		if doc := renderedDocument(sourceFile, stderr.String()); doc != "" {
+			infof("Rendered %s\n", doc)
+		}
 	}
+	return nil
+}
 
-	// Standard deviation is the square root of variance
-	stdDev := time.Duration(math.Sqrt(sumSquaredDiffs / float64(len(times))))
+// compileProgram runs the compile step of plan, reporting a failure with
+// the source lines it points at.
+func compileProgram(plan execPlan, sourceFile string) error {
+	cmd := plan.command(context.Background(), plan.Compile)
+	var stderr stderrCapture
+	infof("Compiling %s...\n", sourceFile)
+	compile := beginPhase("compile")
+	step := startStep("compiling " + sourceFile)
+	cmd.Stdout = step
+	cmd.Stderr = io.MultiWriter(step, &stderr)
+	err := runCmd(cmd)
+	step.Finish(err)
+	compile.end(err)
+	if err != nil {
+		fmt.Fprintf(messageOut(), "Compilation failed: %v\n", err)
+		fmt.Fprintf(messageOut(), "  Command: %s\n", shellJoin(plan.Compile))
+		printSourceContext(stderr.String(), plan.SourceFile)
+		return &runError{Status: "compile-failed", Code: exitCompileFailed,
+			Summary:     fmt.Sprintf("Compilation failed: %v", err),
+			Diagnostics: parseDiagnostics(stderr.String(), plan.SourceFile, sourceFile)}
+	}
+	if size := plan.artifactSize(); size >= 0 {
+		infof("Compilation successful (%s).\n", formatSize(size))
+	} else {
+		infof("Compilation successful.\n")
+	}
+	return nil
+}
 
-	// Print results
-	fmt.Println("\n" + strings.Repeat("=", 50))
-	fmt.Println("  Benchmark Results:")
-	fmt.Println(strings.Repeat("-", 50))
-	fmt.Printf("Runs:         %d\n", runs)
-	fmt.Printf("Total time:   %v\n", totalTime)
-	fmt.Printf("Average:      %v\n", avg)
-	fmt.Printf("Median:       %v\n", median)
-	fmt.Printf("Min:          %v\n", min)
-	fmt.Printf("Max:          %v\n", max)
-	fmt.Printf("Std Dev:      %v\n", stdDev)
-	fmt.Println(strings.Repeat("=", 50))
+// resolveRuntime returns argv with its binary replaced by the one run would
+// actually use for ext: an explicit RUN_<EXT>_BIN override (e.g. RUN_PY_BIN)
+// wins, then the active Python virtualenv, then argv[0] as found on PATH or,
+// on macOS, in the Homebrew prefix.
+func resolveRuntime(ext string, argv []string) []string {
+	resolved, _ := resolveRuntimeSource(ext, argv)
+	return resolved
 }
 
-func executeFile(sourceFile string, config LanguageConfig, ext string) {
-	if config.IsCompiled {
-		executableName := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
-		compileArgs := []string{}
-		if ext == ".rs" {
-			compileArgs = append(config.CompileCmd[1:], sourceFile)
-		} else if ext == ".cs" {
-			// For C#, we need to create a project first, then build
-			projectDir := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
-			if _, err := os.Stat(projectDir); os.IsNotExist(err) {
-				fmt.Printf("Creating .NET project in %s...\n", projectDir)
-				cmd := exec.Command("dotnet", "new", "console", "-o", projectDir)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				err = cmd.Run()
-				if err != nil {
-					fmt.Printf("Failed to create .NET project: %v\n", err)
-					os.Exit(1)
-				}
-				// Move the source file into the project directory
-				fmt.Printf("Moving %s to %s...\n", sourceFile, filepath.Join(projectDir, "Program.cs"))
-				os.Rename(sourceFile, filepath.Join(projectDir, "Program.cs"))
+// resolveRuntimeSource is resolveRuntime that also names the environment
+// variable that changed the binary, if any.
+func resolveRuntimeSource(ext string, argv []string) ([]string, string) {
+	if len(argv) == 0 {
+		return argv, ""
+	}
+	resolved := append([]string{}, argv...)
+
+	envName := "RUN_" + strings.ToUpper(strings.TrimPrefix(ext, ".")) + "_BIN"
+	if bin := os.Getenv(envName); bin != "" {
+		resolved[0] = bin
+		return resolved, envName
+	}
+
+	if ext == ".py" {
+		if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
+			python := filepath.Join(venv, "bin", "python")
+			if runtime.GOOS == "windows" {
+				python = filepath.Join(venv, "Scripts", "python.exe")
+			}
+			if _, err := os.Stat(python); err == nil {
+				resolved[0] = python
+				return resolved, "VIRTUAL_ENV"
 			}
-			// Change directory to projectDir for dotnet build and run
-			os.Chdir(projectDir)
-			compileArgs = append(config.CompileCmd[1:])
-		} else {
-			compileArgs = append(config.CompileCmd[1:], sourceFile, "-o", executableName)
 		}
+	}
+	if path := brewBinary(resolved[0]); path != "" {
+		resolved[0] = path
+		return resolved, "Homebrew"
+	}
+	if path := windowsShim(resolved[0]); path != "" {
+		resolved[0] = path
+	}
+	return resolved, ""
+}
 
-		cmd := exec.Command(config.CompileCmd[0], compileArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Printf("Compiling %s...\n", sourceFile)
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf("Compilation failed: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Println("Compilation successful.")
-
-		runArgs := []string{executableName}
-		if ext == ".java" {
-			// For Java, the executable is the class name
-			runArgs = []string{config.ClassNameFn(filepath.Base(sourceFile))}
-		} else if ext == ".cs" {
-			// For C#, dotnet run handles execution from the project directory
-			runArgs = config.RunCmd[1:]
-			cmd = exec.Command(config.RunCmd[0], runArgs...)
-		} else if ext == ".rs" || ext == ".cpp" || ext == ".c" || ext == ".nim" || ext == ".zig" || ext == ".hs" || ext == ".pas" || ext == ".fs" || ext == ".ml" {
-			// For compiled programs, the executable is in the current directory
-			cmd = exec.Command("./" + executableName)
-		} else {
-			cmd = exec.Command(runArgs[0], runArgs[1:]...)
-		}
+// supportsCommand implements `run supports <file>`: it exits 0 and prints
+// the language name when the file would be runnable, 1 otherwise.
+func supportsCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: run supports <file>")
+		os.Exit(1)
+	}
+	ext, ok := detectExt(args[0])
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println(languageConfigs[ext].Name)
+	os.Exit(0)
+}
 
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Printf("Running %s...\n", executableName)
-		err = cmd.Run()
-		if err != nil {
-			fmt.Printf("Execution failed: %v\n", err)
-			os.Exit(1)
-		}
+// replCommand implements `run repl <lang>`.
+func replCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: run repl <lang>   (e.g. run repl py)")
+		os.Exit(1)
+	}
 
-		// Clean up compiled executable for C/C++/Rust/...
-		if ext == ".cpp" || ext == ".c" || ext == ".rs" || ext == ".nim" || ext == ".zig" || ext == ".hs" || ext == ".pas" || ext == ".fs" || ext == ".ml" {
-			if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
-				os.Remove(executableName)
-			} else if runtime.GOOS == "windows" {
-				os.Remove(executableName + ".exe")
-			}
-		}
+	ext := normalizeExt(args[0])
+	config, ok := languageConfigs[ext]
+	if !ok {
+		fmt.Printf("Unsupported language: %s\n", args[0])
+		fmt.Println("Run 'run --list' to see supported languages.")
+		os.Exit(1)
+	}
 
-	} else {
-		runArgs := append(config.RunCmd[1:], sourceFile)
-		cmd := exec.Command(config.RunCmd[0], runArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Printf("Running %s...\n", sourceFile)
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf("Execution failed: %v\n", err)
-			os.Exit(1)
-		}
+	replCmd := config.ReplCmd
+	if replCmd == nil && !config.IsCompiled && len(config.RunCmd) > 0 {
+		replCmd = config.RunCmd[:1]
+	}
+	if len(replCmd) == 0 {
+		fmt.Printf("%s has no interactive shell.\n", ext)
+		os.Exit(1)
 	}
+	replCmd = resolveRuntime(ext, replCmd)
+
+	cmd := exec.Command(replCmd[0], replCmd[1:]...)
+	cmd.Env = childEnv()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := runCmd(cmd)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		fmt.Printf("Failed to start %s: %v\n", shellJoin(replCmd), err)
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
 
 func checkRuntime(cmdArgs []string) bool {
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	err := cmd.Run()
-	return err == nil
+	_, ok := probeRuntime(cmdArgs)
+	return ok
 }
 
 func installRuntime(cmdArgs []string) bool {
 	if len(cmdArgs) == 0 || (len(cmdArgs) == 2 && cmdArgs[0] == "echo" &&
 		strings.Contains(cmdArgs[1], "Please install")) {
-		fmt.Println(strings.Join(cmdArgs, " "))
+		fmt.Fprintln(messageOut(), strings.Join(cmdArgs, " "))
 		return false // Indicate that automatic installation is not supported or user needs to manually install
 	}
-	fmt.Printf("Attempting to install %s...\n", cmdArgs[0])
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	return err == nil
+	infof("Attempting to install %s...\n", cmdArgs[0])
+	output, err := runInstaller(cmdArgs)
+	if err == nil {
+		return true
+	}
+
+	// An earlier install that was interrupted or left things half done
+	// often has a known fix; offer it and try once more
+	if fix, problem := installRecovery(cmdArgs, output); problem != "" {
+		if fix == nil {
+			fmt.Fprintln(os.Stderr, problem)
+		} else if confirmInstall(problem, fix) {
+			if _, fixErr := runInstaller(fix); fixErr == nil {
+				infof("Retrying: %s\n", shellJoin(cmdArgs))
+				if output, err = runInstaller(cmdArgs); err == nil {
+					return true
+				}
+			}
+		}
+	}
+	if path := saveInstallLog(cmdArgs, output, err); path != "" {
+		fmt.Fprintf(os.Stderr, "Installation failed (%v). The full output is in %s\n", err, path)
+	}
+	return false
 }
 
 func isNumeric(s string) bool {
@@ -1037,14 +2716,128 @@ func isNumeric(s string) bool {
 func printHelp() {
 	fmt.Println("run - Universal script runner")
 	fmt.Println("\nUsage:")
-	fmt.Println("  run [options] <source_file>")
+	fmt.Println("  run [options] <source_file> [-- program arguments...]")
+	fmt.Println("  run [options] <file> <file>...   (runs them one after the other)")
 	fmt.Println("\nOptions:")
 	fmt.Println("  --version, -v        Show version information")
 	fmt.Println("  --list, -l           List all supported languages")
+	fmt.Println("                         [--installed] [--check] [--compiled|--interpreted] [--json] [.ext ...]")
 	fmt.Println("  --dry-run, -d            Show what would be executed without running")
 	fmt.Println("  --time, -t               Measure and display execution time")
 	fmt.Println("  --bench [n], -b [n]          Run benchmark (default: 10 iterations)")
+	fmt.Println("  --warmup <n>         Untimed benchmark iterations before measuring")
+	fmt.Println("  --json               Print the benchmark report as JSON")
+	fmt.Println("  --bench-isolate      Run each benchmark iteration in a fresh empty directory")
+	fmt.Println("  --verify-with <file> Leave benchmark iterations whose output differs from file out of the statistics")
+	fmt.Println("  --verify-first       Same, comparing with the first iteration's output")
+	fmt.Println("  --bench-input <file> Feed file to every benchmark iteration as its stdin (default: none)")
+	fmt.Println("  --bench-stats <list> Statistics to report, e.g. mean,median,p95")
+	fmt.Println("                       (default: total, mean, median, min, max, stddev)")
+	fmt.Println("  --assert-max-<stat> <duration>")
+	fmt.Println("                       Fail the benchmark (exit 79) if stat exceeds duration;")
+	fmt.Println("                       stat is mean, median, min, max, stddev, p50, p90, p95 or p99")
+	fmt.Println("  --lang <ext>         Treat the file as the given language (e.g. --lang py)")
+	fmt.Println("                       With - as the file, run reads the code from stdin: cat x | run - --lang py")
+	fmt.Println("  --cwd <dir>          Run interpreted programs in dir (default: the source file's directory)")
+	fmt.Println("  --entry <file>       The file to run in a directory given to run, instead of main.py, index.js...")
+	fmt.Println("  --no-project         Run the file on its own, not with its project's build tool (go run ., cargo run...)")
+	fmt.Println("  --with <path>        A file or directory the program reads, checked before it runs and copied")
+	fmt.Println("                       where it runs elsewhere (--bench-isolate); repeatable")
+	fmt.Println("  --pick               Choose the file interactively (among the given files or the current directory)")
+	fmt.Println("  --watch              Run the file again whenever it (or its project) changes, until Ctrl+C")
+	fmt.Println("  --keep-going         With several files, run the rest after one fails")
+	fmt.Println("  --last               Run the most recently run file again")
+	fmt.Println("  --list-extensions    Print the supported extensions, one per line")
+	fmt.Println("  --first-run-report   Show the report of the first run again: system, ready languages, file locations")
+	fmt.Println("  --dump-config-schema Print a JSON Schema of the config files, for editors")
+	fmt.Println("  --strict-config      Fail on unknown keys or wrong types in .run.json instead of warning")
+	fmt.Println("  --max-cpu-time <d>   Kill the program once it has used d of CPU time (e.g. 10s)")
+	fmt.Println("  --timeout <d>        Stop the program after d by the clock (e.g. 30s); each iteration with --bench")
+	fmt.Println("  --use-daemon         Run Java in the warm JVM of run daemon start, build C# with the MSBuild server")
+	fmt.Println("  --heartbeat <d>      While the program runs, print elapsed time, memory and CPU time to stderr every d")
+	fmt.Println("                       (e.g. 60s) for CI jobs that kill silent steps; not used with --bench")
+	fmt.Println("  --core-dump          Keep a core file when the program crashes and print its backtrace")
+	fmt.Println("  --no-context         Don't show the source lines around compiler errors")
+	fmt.Println("  --output-format <f>  Render .Rmd and .qmd documents as html or pdf")
+	fmt.Println("  --keep, -k           Keep the compiled program and print where it is")
+	fmt.Println("  --out, -o <path>     Compile the program to path and leave it there")
+	fmt.Println("  --compiler-args <s>  Pass flags to the compiler, e.g. --compiler-args \"-O2 -std=c++20\"")
+	fmt.Println("  --cflag <flag>       Pass one flag to the compiler; repeatable")
+	fmt.Println("  --runtime-args \"<s>\" Pass flags to the interpreter or JVM, e.g. \"-u\" or \"-Xmx2g\"")
+	fmt.Println("  --emit-script <path> Write the steps run would take as a shell script (.ps1 for PowerShell) instead")
+	fmt.Println("  --sources \"<files>\" Compile more C or C++ files into the program, e.g. --sources \"util.c\"")
+	fmt.Println("  --no-cache           Compile even if the build is cached, replacing the cached build")
+	fmt.Println("  --fix-crlf           Run a shell script with Windows line endings from a converted copy")
+	fmt.Println("  --no-store-source    Don't keep code from a URL or a pipe for --last and the build cache")
+	fmt.Println("  --keep-artifacts     Keep the auxiliary files of a LaTeX build (.aux, .log, ...)")
+	fmt.Println("  --open               Open the PDF built from a .tex file in the system viewer")
+	fmt.Println("  --gen <lang>         Generate go, python or java code from a .proto file instead of checking it")
+	fmt.Println("  --gen-out <dir>      Where --gen puts the code (default: a new temporary directory)")
+	fmt.Println("  --check              Only compile the file (syntax and type check), don't run it")
+	fmt.Println("  --compile-only, -c   Only build the program, keep it and print where it is")
+	fmt.Println("  --assume-entry       Don't check that a compiled file has a main function (e.g. for _start)")
+	fmt.Println("  --profile <name>     Add the flags of a profile from the config (see run config profiles)")
+	fmt.Println("  --audit              Point out red flags (sudo, curl | sh, rm -rf $var, ...) and ask before running;")
+	fmt.Println("                       a quick lint for unfamiliar scripts, not a sandbox")
+	fmt.Println("  --fmt-check          Report whether the formatter (gofmt, black, ...) would change the file, then run it")
+	fmt.Println("  --fmt                Format the file in place before running it")
+	fmt.Println("  --no-fmt-write       Never let --fmt modify the file; it only checks like --fmt-check")
+	fmt.Println("  --no-modeline        Ignore run: settings in the first lines of the file, e.g. for untrusted files")
+	fmt.Println("  --porcelain          Write progress events as JSON lines to stderr, for editor plugins")
+	fmt.Println("  --porcelain-fd <n>   Same, to the already open file descriptor n")
+	fmt.Println("  --error-format <fmt> Report failures as text or as one JSON object on stderr (json)")
+	fmt.Println("  --force              Run files that look binary anyway")
+	fmt.Println("  --shell <shell>      Run .sh files under sh, dash, bash, zsh or ksh (default: #! line, bash);")
+	fmt.Println("                       --sh also accepts cmd, pwsh and powershell")
+	fmt.Println("  --sh <command>       Run a command line with sh -c (cmd /C on Windows, see --shell)")
+	fmt.Println("                       instead of a file. The shell interprets it as is: quote it")
+	fmt.Println("                       carefully and never build it from untrusted input")
+	fmt.Println("  --posix              Run .sh files under dash (or bash --posix) to check portability")
+	fmt.Println("  --strict-detect      Refuse files whose content contradicts their extension")
+	fmt.Println("  --no-detect          Don't compare the content with the extension")
+	fmt.Println("  --test-mode <mode>   auto: run *_test.go with go test and test_*.py with pytest; off")
+	fmt.Println("  --test-run <pattern> Run only the matching tests (go test -run, pytest -k)")
+	fmt.Println("  --no-locale-fix      Don't add UTF-8 locale defaults to the program's environment")
+	fmt.Println("  --trace-commands     Print each external command to stderr before running it")
+	fmt.Println("  --profile-run        Print how long each of run's own steps took, flagging slow ones")
+	fmt.Println("  --log-file <path>    Append structured JSON log entries to path (or set RUN_LOG_FILE)")
+	fmt.Println("  --sha256 <hex>       Only run a URL if its content has this SHA-256 digest")
+	fmt.Println("  --yes, -y            Run a URL or install a script's packages without asking")
+	fmt.Println("  --no-install         Never install runtimes or packages; fail instead")
+	fmt.Println("  --update-deps        Resolve a script's declared packages again and rewrite its .run.lock")
+	fmt.Println("  --frozen             Install only the packages in a script's .run.lock; fail if it is missing or stale")
+	fmt.Println("  --offline            Never use the network: no installs, downloads or module fetches (or RUN_OFFLINE=1)")
+	fmt.Println("  --restrict-root <dir>")
+	fmt.Println("                       Refuse sources, input files and directories outside dir, symlinks resolved")
+	fmt.Println("                       (or RUN_RESTRICT_ROOT)")
+	fmt.Println("  --restricted         Lock down: --no-install, --offline, --max-cpu-time 1m, --restrict-root . and --fake-home")
+	fmt.Println("  --fake-home          Run the program with a throwaway HOME and XDG directories")
+	fmt.Println("  --show-created       With --fake-home, list the files the program wrote there")
+	fmt.Println("  --no-defaults        Ignore the flags in RUN_DEFAULT_FLAGS")
+	fmt.Println("  --quiet, -q          Print only the program's output; run's own messages go to stderr or nowhere")
+	fmt.Println("  --verbose, -V        Show the default flags in effect, how the language was detected and each")
+	fmt.Println("                       command run executes (as --trace-commands)")
 	fmt.Println("  --help, -h           Show this help message")
+	fmt.Println("\nCommands:")
+	fmt.Println("  config [--file f] [--json]           Show the effective configuration and where each value comes from")
+	fmt.Println("  config path | config init            Show the config file locations, or create a starter config")
+	fmt.Println("  config validate [path]               Check config files for unknown keys and wrong types")
+	fmt.Println("  version [--json]                     Show version and build information")
+	fmt.Println("  supports <file>                      Exit 0 if the file can be run, printing its language")
+	fmt.Println("  new <lang> [name] [--force]          Create a hello-world source file")
+	fmt.Println("  repl <lang>                          Start the language's interactive shell")
+	fmt.Println("  serve [--port n] [--allow-dir dir]   Start a local HTTP execution endpoint")
+	fmt.Println("  bench ab <a> <b> [--runs n] [--json] Compare two files with interleaved runs and a t-test")
+	fmt.Println("  grade --cases dir --glob pattern [--timeout d] [--with path] [--csv|--json]")
+	fmt.Println("                                       Run every submission against the cases and report the scores")
+	fmt.Println("  doctor [--changed] [--json] [.ext]   Check every runtime and flag the ones that stopped working")
+	fmt.Println("  doctor --matrix [--json] [.ext]      Show which languages work here and what would fix the rest")
+	fmt.Println("  package <file> --docker [-t image] [--with path] [--dockerfile-only]")
+	fmt.Println("                                       Build a container image that runs the file")
+	fmt.Println("  daemon start|stop|status             Manage the warm JVM that --use-daemon runs Java in")
+	fmt.Println("  clean [--dry-run]                    Remove stored sources from URLs and pipes and their cached builds")
+	fmt.Println("  cache list | clear                   Show or empty the cache of compiled builds")
+	fmt.Println("  cache prune --older-than 30d         Remove cached builds not used in that long")
 	fmt.Println("\nExamples:")
 	fmt.Println("  run script.py                 # Run Python script")
 	fmt.Println("  run --time app.js             # Run with execution time")
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// profileRun prints where run itself spent its time when it finishes
+// (--profile-run).
+var profileRun bool
+
+// phaseTiming is how long one of run's phases took.
+type phaseTiming struct {
+	Phase      string `json:"phase"`
+	DurationNs int64  `json:"durationNs"`
+	Outcome    string `json:"outcome"` // ok or failed
+	// Slow is set when the phase took longer than it should, see slowPhases
+	Slow bool `json:"slow,omitempty"`
+}
+
+var (
+	phaseMu      sync.Mutex
+	phaseTimings []phaseTiming
+)
+
+// slowPhases are how long the phases that don't depend on the program
+// normally take at most, with the usual cause when they take longer.
+// Installing, compiling and running have no such limit.
+var slowPhases = map[string]struct {
+	limit time.Duration
+	cause string
+}{
+	"detect":  {100 * time.Millisecond, "reading the source is slow, e.g. on a network drive"},
+	"check":   {500 * time.Millisecond, "the runtime's version probe is slow, e.g. a shim that starts a runtime"},
+	"prepare": {10 * time.Second, "creating the project or fetching packages is slow"},
+	"cleanup": {500 * time.Millisecond, "removing the build output is slow"},
+}
+
+// recordPhase notes that phase took d, for --profile-run, the log and
+// benchmark reports. It reports whether that was slow.
+func recordPhase(phase string, d time.Duration, err error) bool {
+	timing := phaseTiming{Phase: phase, DurationNs: int64(d), Outcome: "ok"}
+	if err != nil {
+		timing.Outcome = "failed"
+	}
+	if slow, ok := slowPhases[phase]; ok && d > slow.limit {
+		timing.Slow = true
+	}
+	phaseMu.Lock()
+	phaseTimings = append(phaseTimings, timing)
+	phaseMu.Unlock()
+	logEvent("phase", map[string]any{"phase": phase, "durationMs": d.Milliseconds(), "outcome": timing.Outcome, "slow": timing.Slow})
+	return timing.Slow
+}
+
+// recordedPhases returns the phases finished so far, in order.
+func recordedPhases() []phaseTiming {
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+	return append([]phaseTiming{}, phaseTimings...)
+}
+
+// printRunProfile prints the --profile-run breakdown to stderr, where it
+// stays apart from the program's output.
+func printRunProfile() {
+	if !profileRun {
+		return
+	}
+	total := time.Since(porcelainStart)
+	own := total
+	fmt.Fprintln(os.Stderr, "Run profile:")
+	for _, p := range recordedPhases() {
+		d := time.Duration(p.DurationNs)
+		if p.Phase == "run" {
+			own -= d
+		}
+		line := fmt.Sprintf("  %-10s %8s", p.Phase, formatDuration(d))
+		if p.Outcome != "ok" {
+			line += "  (failed)"
+		}
+		if p.Slow {
+			slow := slowPhases[p.Phase]
+			line += fmt.Sprintf("  slow: over %s; %s", formatDuration(slow.limit), slow.cause)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	fmt.Fprintf(os.Stderr, "  %-10s %8s  (%s outside the program)\n", "total", formatDuration(total), formatDuration(own))
+}
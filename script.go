@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	// asInterpreter is set when run was started by the kernel for a script
+	// whose #! line names run, e.g. `#!/usr/bin/env run`.
+	asInterpreter bool
+	// programArgs are passed on to the program being run.
+	programArgs []string
+)
+
+// scriptIndex recognizes the argument list the kernel builds for a script
+// with a run #! line: run's own flags from that line (`#!/usr/bin/env -S
+// run --time`), the script's path, then the arguments the script was given.
+// It returns the index of the script, or -1 for a normal invocation.
+func scriptIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		if slices.Contains(valueFlags, args[i]) {
+			i++
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		if interpreterName(shebangLine(args[i])) == "run" {
+			return i
+		}
+		return -1
+	}
+	return -1
+}
+
+// runScript runs the script args[i] as its interpreter: quietly, so that
+// stdout carries the script's output only, passing the rest of args on
+// to it. Without an extension, the language comes from the modeline or the
+// content.
+func runScript(args []string, i int) {
+	script := args[i]
+	asInterpreter = true
+	quiet = true
+	programArgs = args[i+1:]
+
+	if _, err := runFile(append(args[:i:i], script)); err != nil {
+		exitWith(script, err)
+	}
+	porcelainSummary(script, nil)
+}
+
+// contentLanguage guesses the language of a script run through its #! line
+// whose extension and modeline don't tell, from its content. It returns ""
+// when nothing gives it away.
+func contentLanguage(script string) string {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return ""
+	}
+	for _, sig := range contentSignatures {
+		if sig.Pattern.MatchString(string(data)) {
+			return sig.Ext
+		}
+	}
+	return ""
+}
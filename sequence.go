@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// keepGoing runs the rest of the files after one fails (--keep-going)
+// instead of stopping there.
+var keepGoing bool
+
+// sequenceResult is the outcome of one file of a sequence.
+type sequenceResult struct {
+	File     string
+	Code     int // -1 when it didn't run
+	Duration time.Duration
+	Missing  string // The runtime it was skipped for, not being installed
+}
+
+// runSequence runs files one after the other, each in a run of its own
+// with the same flags, so that every file gets its language's settings
+// from scratch: argsFor returns the arguments of that run for a file. It
+// stops at the first failure unless --keep-going, prints a summary, and
+// fails with the exit code of the first file that failed. A file whose
+// runtime is missing is skipped rather than failed, and the runs share
+// their declines, so installing the runtime is offered once.
+func runSequence(files []string, argsFor func(string) []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return newRunError("error", 1, "Cannot find run's own executable: %v", err)
+	}
+	env := os.Environ()
+	if os.Getenv(sessionDeclinesEnv) == "" {
+		// In a directory of its own, which no one else can plant files in
+		dir, err := os.MkdirTemp("", "run-declines-")
+		if err != nil {
+			return newRunError("error", 1, "Cannot create a temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		env = append(env, sessionDeclinesEnv+"="+filepath.Join(dir, "declined-installs.json"))
+	}
+	// Ctrl+C reaches the running file, and stops the sequence after it
+	stop := watchInterrupts()
+	defer stop()
+
+	results := make([]sequenceResult, len(files))
+	for i, file := range files {
+		results[i] = sequenceResult{File: file, Code: -1}
+	}
+	last := 0
+	for i, file := range files {
+		last = i
+		infof("==> %s (%d/%d)\n", file, i+1, len(files))
+		cmd := exec.Command(self, argsFor(file)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = env
+		start := time.Now()
+		err := runCmd(cmd)
+		results[i].Duration = time.Since(start)
+		results[i].Code = 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			results[i].Code = exitCode(exitErr.ProcessState)
+		} else if err != nil {
+			fmt.Fprintf(messageOut(), "Cannot run %s: %v\n", file, err)
+			results[i].Code = 1
+		}
+		if results[i].Code == exitRuntimeUnavailable {
+			results[i].Missing = skippedRuntime(file)
+		}
+		if interruptError() != nil || (results[i].Code != 0 && results[i].Missing == "" && !keepGoing) {
+			break
+		}
+	}
+
+	// A run that succeeded ended with a blank line already
+	if results[last].Code != 0 {
+		infof("\n")
+	}
+	infof("Summary:\n")
+	failed, firstCode := 0, 0
+	var skipped []string // The missing runtimes, once per file
+	for _, r := range results {
+		switch {
+		case r.Code < 0:
+			infof("  - %-30s not run\n", r.File)
+		case r.Missing != "":
+			infof("  - %-30s skipped: runtime missing\n", r.File)
+			skipped = append(skipped, r.Missing)
+		case r.Code == 0:
+			infof("  ✓ %-30s %s\n", r.File, formatDuration(r.Duration))
+		default:
+			infof("  ✗ %-30s %s (exit code %d)\n", r.File, formatDuration(r.Duration), r.Code)
+			if failed == 0 {
+				firstCode = r.Code
+			}
+			failed++
+		}
+	}
+	printSkipped(skipped)
+	if re := interruptError(); re != nil {
+		return re
+	}
+	if failed > 0 {
+		msg := fmt.Sprintf("%d of %d files failed.", failed, len(files))
+		if !keepGoing && failed == 1 && results[len(results)-1].Code < 0 {
+			msg += " Stopped at the first failure; --keep-going runs the rest anyway."
+		}
+		return newRunError("program-failed", firstCode, "%s", msg)
+	}
+	if len(skipped) > 0 {
+		return newRunError("runtime-unavailable", exitRuntimeUnavailable,
+			"%d of %d files skipped.", len(skipped), len(files))
+	}
+	return nil
+}
+
+// skippedRuntime returns the runtime of file when it isn't installed, the
+// reason its run would have ended with exitRuntimeUnavailable.
+func skippedRuntime(file string) string {
+	ext, _ := detectExt(file)
+	config, ok := languageConfigs[ext]
+	if !ok || len(config.CheckCmd) == 0 {
+		return ""
+	}
+	if _, found := probeRuntime(config.CheckCmd); found {
+		return ""
+	}
+	return config.CheckCmd[0]
+}
+
+// printSkipped prints, for each runtime in skipped, how many files were
+// skipped for it and how to install it.
+func printSkipped(skipped []string) {
+	counts := map[string]int{}
+	var runtimes []string
+	for _, runtime := range skipped {
+		if counts[runtime] == 0 {
+			runtimes = append(runtimes, runtime)
+		}
+		counts[runtime]++
+	}
+	for _, runtime := range runtimes {
+		files := "files"
+		if counts[runtime] == 1 {
+			files = "file"
+		}
+		infof("%d %s skipped because %s is not installed (%s)\n",
+			counts[runtime], files, runtime, installHint(runtime))
+	}
+}
+
+// installHint says how to install runtime.
+func installHint(runtime string) string {
+	for _, ext := range supportedExtensions() {
+		config := languageConfigs[ext]
+		if config.CheckCmd[0] != runtime || config.InstallCmd == nil {
+			continue
+		}
+		if install := config.InstallCmd(); len(install) > 0 {
+			if install[0] == "echo" {
+				return strings.Join(install[1:], " ")
+			}
+			return "install it with: " + shellJoin(install)
+		}
+	}
+	return "install it, then run the files again"
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Serve mode exposes run as a small HTTP execution endpoint, meant for local
+		playgrounds. Every request runs in its own temporary directory with a
+		mandatory timeout, capped output and a global concurrency limit.
+*/
+
+const (
+	serveDefaultTimeout = 10 * time.Second
+	serveMaxTimeout     = 60 * time.Second
+	serveMaxBodyBytes   = 1 << 20 // 1 MiB of request JSON
+	serveMaxOutputBytes = 1 << 20 // 1 MiB per output stream
+	serveMaxMemory      = 1 << 30 // 1 GiB of data memory per program
+	// serveWaitDelay is how long a finished or killed program's output
+	// pipes may stay open, e.g. held by a process it left behind.
+	serveWaitDelay = time.Second
+)
+
+// serveConfig holds the options of `run serve`.
+type serveConfig struct {
+	Host          string
+	Port          int
+	AllowDir      string
+	InsecureBind  bool
+	MaxConcurrent int
+}
+
+// runRequest is the body accepted by POST /run. Either Code or Path must be
+// set; Timeout is a Go duration string such as "5s".
+type runRequest struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+	Path     string `json:"path"`
+	Stdin    string `json:"stdin"`
+	Timeout  string `json:"timeout"`
+}
+
+// runResponse is the body returned by POST /run.
+type runResponse struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	TimedOut   bool   `json:"timedOut,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func serveCommand(args []string) {
+	cfg := serveConfig{Host: "127.0.0.1", Port: 8080, MaxConcurrent: 4}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--port", "--host", "--allow-dir", "--max-concurrent":
+			if i+1 >= len(args) {
+				fmt.Printf("Missing value for %s\n", arg)
+				os.Exit(1)
+			}
+			i++
+			switch arg {
+			case "--port":
+				port, err := strconv.Atoi(args[i])
+				if err != nil || port < 0 || port > 65535 {
+					fmt.Printf("Invalid port: %s\n", args[i])
+					os.Exit(1)
+				}
+				cfg.Port = port
+			case "--host":
+				cfg.Host = args[i]
+			case "--allow-dir":
+				cfg.AllowDir = args[i]
+			case "--max-concurrent":
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 1 {
+					fmt.Printf("Invalid --max-concurrent value: %s\n", args[i])
+					os.Exit(1)
+				}
+				cfg.MaxConcurrent = n
+			}
+		case "--insecure-bind":
+			cfg.InsecureBind = true
+		default:
+			fmt.Printf("Unknown serve option: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	if !isLoopbackHost(cfg.Host) && !cfg.InsecureBind {
+		fmt.Printf("Refusing to bind to non-loopback address %s.\n", cfg.Host)
+		fmt.Println("Serve mode executes arbitrary code; pass --insecure-bind if you really want this.")
+		os.Exit(1)
+	}
+
+	if cfg.AllowDir != "" {
+		dir, err := filepath.Abs(cfg.AllowDir)
+		if err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		if err != nil {
+			fmt.Printf("Invalid --allow-dir: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.AllowDir = dir
+	}
+
+	srv := newServer(cfg)
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	fmt.Printf("run serve listening on http://%s\n", addr)
+	if cfg.AllowDir != "" {
+		fmt.Printf("Path-based requests restricted to %s\n", cfg.AllowDir)
+	}
+	if err := http.ListenAndServe(addr, srv); err != nil {
+		fmt.Printf("Server failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type server struct {
+	cfg   serveConfig
+	slots chan struct{}
+}
+
+func newServer(cfg serveConfig) http.Handler {
+	s := &server{cfg: cfg, slots: make(chan struct{}, cfg.MaxConcurrent)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", s.handleRun)
+	mux.HandleFunc("/languages", s.handleLanguages)
+	return s.guard(mux)
+}
+
+// guard only lets through requests addressed to the server itself. A web
+// page in the user's browser can POST to a loopback address, and DNS
+// rebinding can give its own name a loopback address, so the Host must be
+// a loopback one (unless --insecure-bind) and a browser's Origin must be
+// the server's.
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.InsecureBind && !isLoopbackHost(hostOnly(r.Host)) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || u.Host != r.Host {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostOnly returns the host of a Host header, without the port.
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
+
+func (s *server) handleLanguages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, supportedLanguages())
+}
+
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// A form or a fetch in no-cors mode can't send JSON, only text/plain
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, runResponse{Error: "Content-Type must be application/json"})
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, runResponse{Error: "too many concurrent requests"})
+		return
+	}
+
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxBodyBytes)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, runResponse{Error: "invalid request body: " + err.Error()})
+		return
+	}
+
+	resp, status := s.execute(r.Context(), req)
+	writeJSON(w, status, resp)
+}
+
+// execute runs a single request inside a throwaway directory.
+func (s *server) execute(ctx context.Context, req runRequest) (runResponse, int) {
+	timeout := serveDefaultTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 {
+			return runResponse{Error: "invalid timeout: " + req.Timeout}, http.StatusBadRequest
+		}
+		timeout = min(d, serveMaxTimeout)
+	}
+
+	if (req.Code == "") == (req.Path == "") {
+		return runResponse{Error: "exactly one of code or path must be set"}, http.StatusBadRequest
+	}
+
+	code := []byte(req.Code)
+	ext := normalizeExt(req.Language)
+	fileName := "Main" + ext
+	if req.Path != "" {
+		path, err := s.resolvePath(req.Path)
+		if err != nil {
+			return runResponse{Error: err.Error()}, http.StatusForbidden
+		}
+		if ext == "" {
+			ext = filepath.Ext(path)
+		}
+		fileName = filepath.Base(path)
+		if code, err = os.ReadFile(path); err != nil {
+			return runResponse{Error: "cannot read file: " + err.Error()}, http.StatusNotFound
+		}
+	}
+
+	config, ok := languageConfigs[ext]
+	if !ok {
+		return runResponse{Error: fmt.Sprintf("unsupported language: %q", req.Language)}, http.StatusBadRequest
+	}
+	if !checkRuntime(config.CheckCmd) {
+		return runResponse{Error: fmt.Sprintf("runtime '%s' is not installed", config.CheckCmd[0])}, http.StatusUnprocessableEntity
+	}
+
+	workDir, err := os.MkdirTemp("", "run-serve-")
+	if err != nil {
+		return runResponse{Error: err.Error()}, http.StatusInternalServerError
+	}
+	defer os.RemoveAll(workDir)
+
+	sourceFile := filepath.Join(workDir, fileName)
+	if err := os.WriteFile(sourceFile, code, 0o600); err != nil {
+		return runResponse{Error: err.Error()}, http.StatusInternalServerError
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	plan, removeCopy, err := shebangSafePlan(sourceFile, config, ext)
+	if err != nil {
+		return runResponse{Error: err.Error()}, http.StatusInternalServerError
+	}
+	defer removeCopy()
+	if err := plan.makeBuildDir(); err != nil {
+		return runResponse{Error: err.Error()}, http.StatusInternalServerError
+	}
+	defer plan.cleanup()
+	stdout := &cappedBuffer{limit: serveMaxOutputBytes}
+	stderr := &cappedBuffer{limit: serveMaxOutputBytes}
+	start := time.Now()
+
+	err = func() error {
+		if plan.Prepare != nil {
+			if err := plan.Prepare(stdout); err != nil {
+				return err
+			}
+		}
+		if plan.Compile != nil {
+			cmd := plan.command(ctx, plan.Compile)
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			cmd.WaitDelay = serveWaitDelay
+			if err := runCmd(cmd); err != nil {
+				return err
+			}
+		}
+		cmd := plan.command(ctx, plan.Run)
+		ownProcessGroup(cmd) // The timeout must reach whatever the program started
+		cmd.Stdin = strings.NewReader(req.Stdin)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.WaitDelay = serveWaitDelay
+		return runProgram(cmd, childLimits{CPU: timeout, Memory: serveMaxMemory})
+	}()
+
+	resp := runResponse{
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		resp.TimedOut = true
+		resp.ExitCode = -1
+		resp.Error = fmt.Sprintf("timed out after %v", timeout)
+	case errors.As(err, &exitErr) && cpuLimitExceeded(exitErr.ProcessState, timeout):
+		resp.ExitCode = exitErr.ExitCode()
+		resp.Error = "CPU time limit exceeded"
+	case errors.As(err, &exitErr):
+		resp.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		resp.ExitCode = -1
+		resp.Error = err.Error()
+	}
+	return resp, http.StatusOK
+}
+
+// resolvePath maps a request path onto the allowed directory, rejecting
+// anything (including symlinks) that escapes it.
+func (s *server) resolvePath(path string) (string, error) {
+	if s.cfg.AllowDir == "" {
+		return "", errors.New("path-based requests are disabled; start the server with --allow-dir")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.cfg.AllowDir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve path: %w", err)
+	}
+	rel, err := filepath.Rel(s.cfg.AllowDir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the allowed directory", path)
+	}
+	return resolved, nil
+}
+
+// normalizeExt accepts both "py" and ".py" forms of a language extension.
+func normalizeExt(lang string) string {
+	if lang == "" || strings.HasPrefix(lang, ".") {
+		return lang
+	}
+	return "." + lang
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// cappedBuffer collects output up to limit bytes and silently drops the rest,
+// so chatty programs cannot exhaust the server's memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "\n[output truncated]"
+	}
+	return b.Buffer.String()
+}
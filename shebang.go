@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stripsShebang reports whether the toolchain of ext rejects a `#!` first
+// line. Interpreters skip it, but compilers and `go run` see a syntax error.
+func stripsShebang(config LanguageConfig, ext string) bool {
+	return config.IsCompiled || ext == ".go"
+}
+
+// shebangSafePlan is buildPlan for sources that may start with a `#!` line,
+// e.g. C files made executable for tcc, or that lack the extension their
+// toolchain insists on, e.g. Go in a template picked by --lang or a
+// modeline. Such a source is compiled from a copy in a temporary directory,
+// named with the language's extension and with the `#!` line blanked so that
+// diagnostics keep their line numbers; artifacts end up there too. Scripts
+// with CRLF line endings are run from such a copy too with --fix-crlf,
+// converted to LF. The returned function removes the copy.
+func shebangSafePlan(sourceFile string, config LanguageConfig, ext string) (execPlan, func(), error) {
+	plan := buildPlan(sourceFile, config, ext)
+	hasShebang := strings.HasPrefix(shebangLine(sourceFile), "#!") && stripsShebang(config, ext)
+	crlf := fixCRLF && crlfSensitive[ext] && hasCRLF(sourceFile)
+	if plan.Project != "" {
+		// The build tool reads the project's files where they are
+		return plan, func() {}, nil
+	}
+	if !crlf && (!stripsShebang(config, ext) || plan.Prepare != nil || (!hasShebang && filepath.Ext(sourceFile) == ext)) {
+		return plan, func() {}, nil
+	}
+
+	content, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return plan, nil, err
+	}
+	if hasShebang {
+		content = stripShebang(content)
+	}
+	if crlf {
+		content = convertCRLF(content)
+	}
+	dir, err := os.MkdirTemp("", "run-shebang-")
+	if err != nil {
+		return plan, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	name := filepath.Base(sourceFile)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	copied := filepath.Join(dir, name)
+	if err := os.WriteFile(copied, content, 0o600); err != nil {
+		cleanup()
+		return plan, nil, err
+	}
+	logEvent("shebang", map[string]any{"file": sourceFile, "copy": copied, "crlf": crlf})
+	copyPlan := buildPlan(copied, config, ext)
+	if !config.IsCompiled {
+		// Run the copy where the original would have run
+		copyPlan.Dir = plan.Dir
+	}
+	return copyPlan, cleanup, nil
+}
+
+// stripShebang blanks the first line of content, keeping its line break so
+// that the lines after it keep their numbers.
+func stripShebang(content []byte) []byte {
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		return content[i:]
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStripShebang(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"env run", "#!/usr/bin/env run\nint main(void) { return 0; }\n", "\nint main(void) { return 0; }\n"},
+		{"polyglot", "//usr/bin/env run \"$0\"; exit\npackage main\n\nfunc main() {}\n", "\npackage main\n\nfunc main() {}\n"},
+		{"CRLF", "#!/usr/bin/tcc -run\r\nint x;\r\n", "\nint x;\r\n"},
+		{"only the shebang", "#!/usr/bin/env run", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stripShebang([]byte(tt.in))
+			if string(got) != tt.want {
+				t.Errorf("stripShebang(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			// Every line after the first keeps its number
+			if want := bytes.Count([]byte(tt.in), []byte("\n")); bytes.Count(got, []byte("\n")) != want {
+				t.Errorf("stripShebang(%q) has %d line breaks, want %d", tt.in, bytes.Count(got, []byte("\n")), want)
+			}
+		})
+	}
+}
+
+// TestEnvRunScript makes a script starting with #!/usr/bin/env run
+// executable and runs it directly, with run on PATH.
+func TestEnvRunScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't run #! lines")
+	}
+	requireTools(t, "python3")
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	if err := os.Symlink(self, filepath.Join(bin, "run")); err != nil {
+		t.Fatal(err)
+	}
+	env := newRunEnv(t)
+	env.Env = append(env.Env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	script := writeFile(t, dir, "greet", "#!/usr/bin/env run\n# run: lang=py\nimport sys\n"+
+		"name = sys.stdin.readline().strip()\nprint(f'hello {name} from', ' '.join(sys.argv[1:]))\nsys.exit(len(sys.argv) - 1)\n")
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(script, "the", "script")
+	cmd.Dir = dir
+	cmd.Env = env.Env
+	cmd.Stdin = strings.NewReader("world\n")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Run()
+	// Nothing but the program's own output, and its exit code
+	if got, want := stdout.String(), "hello world from the script\n"; got != want {
+		t.Errorf("stdout %q, want %q", got, want)
+	}
+	if code := cmd.ProcessState.ExitCode(); code != 2 {
+		t.Errorf("exit code %d, want 2", code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+)
+
+var (
+	// shellOverride is the shell .sh files run under (--shell), empty to
+	// follow the script's `#!` line.
+	shellOverride string
+	// posixShell runs .sh files under a strict POSIX shell to check their
+	// portability (--posix).
+	posixShell bool
+)
+
+// knownShells are the shells a .sh file can ask for or be run under.
+var knownShells = []string{"sh", "dash", "bash", "zsh", "ksh"}
+
+// selectShell returns the command .sh file sourceFile runs under and what
+// chose it: --shell, --posix, the script's `#!` line, or bash by default.
+func selectShell(sourceFile string) ([]string, string) {
+	if shellOverride != "" {
+		return []string{shellOverride}, "--shell"
+	}
+	if posixShell {
+		if checkRuntime([]string{"dash", "-c", ":"}) {
+			return []string{"dash"}, "--posix"
+		}
+		return []string{"bash", "--posix"}, "--posix (dash not found)"
+	}
+	if shell := interpreterName(shebangLine(sourceFile)); slices.Contains(knownShells, shell) {
+		return []string{shell}, "#! line"
+	}
+	return []string{"bash"}, "default"
+}
+
+// shellConfig adapts the .sh language to the shell selected for
+// sourceFile, so that the runtime check and installation concern that
+// shell rather than always bash.
+func shellConfig(config LanguageConfig, sourceFile string) (LanguageConfig, string) {
+	argv, source := selectShell(sourceFile)
+	shell := argv[0]
+	config.RunCmd = argv
+	if shell == "bash" {
+		return config, source
+	}
+	// dash and POSIX sh have no --version
+	config.CheckCmd = []string{shell, "-c", ":"}
+	config.InstallCmd = func() []string {
+		switch {
+		case shell == "sh":
+			return []string{"echo", "Please install a POSIX shell as /bin/sh."}
+		case runtime.GOOS == "linux":
+			return []string{"sudo", "apt", "install", "-y", shell}
+		case runtime.GOOS == "darwin":
+			return []string{"brew", "install", shell}
+		default:
+			return []string{"echo", fmt.Sprintf("Please install %s manually.", shell)}
+		}
+	}
+	return config, source
+}
+
+// parseShell validates a --shell value.
+func parseShell(value string) (string, error) {
+	if !slices.Contains(knownShells, value) && !slices.Contains(commandShells, value) {
+		return "", fmt.Errorf("unknown shell %q for --shell (choose from %v or, with --sh, %v)", value, knownShells, commandShells)
+	}
+	return value, nil
+}
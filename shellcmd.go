@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// shHistoryPrefix marks history entries that are --sh commands, not files.
+const shHistoryPrefix = "sh:"
+
+// commandShells are the shells --sh can run a command line with, on top of
+// the Unix shells .sh files can use.
+var commandShells = []string{"cmd", "pwsh", "powershell"}
+
+// shellCommandArgv returns how the platform shell, or the one chosen with
+// --shell, runs command.
+func shellCommandArgv(command string) []string {
+	shell := shellOverride
+	if shell == "" {
+		shell = "sh"
+		if runtime.GOOS == "windows" {
+			shell = "cmd"
+		}
+	}
+	switch shell {
+	case "cmd":
+		return []string{"cmd", "/C", command}
+	case "pwsh", "powershell":
+		return []string{shell, "-NoProfile", "-Command", command}
+	}
+	return []string{shell, "-c", command}
+}
+
+// runShellCommand implements --sh: command is handed to a shell verbatim,
+// with no language detection, and otherwise treated like a run program:
+// it gets the usual environment, limits, timing and history.
+func runShellCommand(command string, dryRun, timeExec bool) error {
+	argv := shellCommandArgv(command)
+	if dryRun {
+		fmt.Println(" Dry Run Mode - No execution will occur")
+		fmt.Println("=========================================")
+		fmt.Printf("Shell command: %s\n", command)
+		fmt.Printf("  Command: %s\n", shellJoin(argv))
+		fmt.Println("\n✓ Dry run complete")
+		return nil
+	}
+	if !strings.Contains(command, "\n") {
+		appendHistory(shHistoryPrefix + command)
+	}
+
+	ctx := context.Background()
+	if maxCPUTime > 0 && !rlimitsSupported {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxCPUTime)
+		defer cancel()
+	}
+	runCtx, cancelRun := withRunTimeout(ctx)
+	defer cancelRun()
+	plan := execPlan{Run: argv}
+	cmd := plan.command(runCtx, argv)
+	if fakeHome {
+		home, env, err := newFakeHome()
+		if err != nil {
+			return newRunError("error", 1, "Cannot create the fake home: %v", err)
+		}
+		defer finishFakeHome(home)
+		addEnv(cmd, env)
+		infof("Using the throwaway home directory %s (--fake-home).\n", home)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	isolateProgram(cmd)
+	applyTimeout(cmd)
+
+	start := time.Now()
+	oomBefore := oomKills()
+	err := runProgram(cmd, childLimits{CPU: maxCPUTime})
+	elapsed := time.Since(start)
+
+	if re := interruptError(); re != nil {
+		return re
+	}
+	if err != nil && maxCPUTime > 0 {
+		if cpuLimitExceeded(cmd.ProcessState, maxCPUTime) {
+			return newRunError("timeout", exitTimeout, "CPU time limit exceeded (%v)", maxCPUTime)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return newRunError("timeout", exitTimeout, "Time limit exceeded (%v wall clock)", maxCPUTime)
+		}
+	}
+	if err != nil && timedOut(runCtx) {
+		return timeoutError()
+	}
+	if err != nil {
+		return newRunError("program-failed", exitCode(cmd.ProcessState), "%s", executionFailure(err, cmd.ProcessState, oomBefore))
+	}
+	if timeExec {
+		fmt.Printf("\n⏱  Execution time: %s\n", formatDuration(elapsed))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// crashSignals name the signals that usually end a crashing program and say
+// what each one usually means.
+var crashSignals = map[syscall.Signal]struct{ name, meaning string }{
+	syscall.SIGSEGV: {"SIGSEGV", "An invalid memory access: a null or dangling pointer, an index past the end of an array, or a stack overflow from deep recursion."},
+	syscall.SIGBUS:  {"SIGBUS", "A misaligned or invalid memory access, e.g. to a memory-mapped file that was truncated."},
+	syscall.SIGABRT: {"SIGABRT", "The program aborted itself: a failed assert(), a call to abort(), an uncaught C++ exception, or heap corruption caught by the allocator, e.g. a double free."},
+	syscall.SIGFPE:  {"SIGFPE", "An arithmetic error, most likely an integer division by zero."},
+	syscall.SIGILL:  {"SIGILL", "An illegal instruction: a trap such as __builtin_trap(), code built for another CPU, or a corrupted function pointer."},
+	syscall.SIGTRAP: {"SIGTRAP", "A breakpoint or trap instruction was hit outside a debugger."},
+	syscall.SIGPIPE: {"SIGPIPE", "A write to a pipe nobody reads any more, e.g. after | head exited."},
+	syscall.SIGTERM: {"SIGTERM", "Asked to stop from outside: kill, a supervisor, or a container shutting down."},
+	syscall.SIGKILL: {"SIGKILL", "Killed outright from outside: kill -9, a supervisor, or the kernel's out-of-memory killer."},
+}
+
+// executionFailure describes how the program failed. When a signal ended
+// it, that is the signal's name and what it usually means rather than
+// Go's "signal: segmentation fault". For SIGKILL it says whether the
+// out-of-memory killer was behind it, comparing with oomBefore, the
+// oomKills from before the program started.
+func executionFailure(err error, state *os.ProcessState, oomBefore int64) string {
+	sig, ok := killedBy(state)
+	if !ok {
+		return fmt.Sprintf("Execution failed: %v", err)
+	}
+	known, ok := crashSignals[sig]
+	if !ok {
+		return fmt.Sprintf("Execution failed: killed by signal %d (%v)", int(sig), sig)
+	}
+	msg := fmt.Sprintf("Execution failed: killed by %s (%v)\n  %s", known.name, sig, known.meaning)
+	if sig != syscall.SIGKILL {
+		return msg
+	}
+	peak := ""
+	if bytes := peakMemory(state); bytes > 0 {
+		peak = fmt.Sprintf("\n  At its peak it used %s of memory.", formatSize(bytes))
+	}
+	if after := oomKills(); (oomBefore >= 0 && after > oomBefore) || oomLogged(state.Pid()) {
+		return fmt.Sprintf("Execution failed: killed by SIGKILL (%v)\n  The kernel's out-of-memory killer ended it; use less memory or raise the limit.%s", sig, peak)
+	}
+	return msg + peak + "\n  If it may have run out of memory, check dmesg for \"Out of memory\"."
+}
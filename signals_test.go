@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestCrashSignals runs small C programs that die of each common signal,
+// and checks that run names the signal, says what it means and exits with
+// 128 plus its number.
+func TestCrashSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on Windows")
+	}
+	requireTools(t, "gcc")
+	env := newRunEnv(t)
+	type crash struct {
+		name string
+		sig  syscall.Signal
+		body string // Of main
+	}
+	tests := []crash{
+		{"segfault", syscall.SIGSEGV, "volatile int *p = 0; *p = 1;"},
+		{"abort", syscall.SIGABRT, "abort();"},
+		// Dividing by zero only traps on some CPUs; ARM returns 0
+		{"arithmetic error", syscall.SIGFPE, "raise(SIGFPE);"},
+		{"killed", syscall.SIGKILL, "raise(SIGKILL);"},
+		{"broken pipe", syscall.SIGPIPE, "raise(SIGPIPE);"},
+	}
+	if runtime.GOARCH == "amd64" {
+		// ud2, which is SIGTRAP on some other CPUs
+		tests = append(tests, crash{"trap", syscall.SIGILL, "__builtin_trap();"})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "crash.c", "#include <signal.h>\n#include <stdio.h>\n#include <stdlib.h>\n\n"+
+				"int main(void) {\n    "+tt.body+"\n    return 0;\n}\n")
+			res := env.run(t, dir, "crash.c")
+			if want := 128 + int(tt.sig); res.Code != want {
+				t.Errorf("exit code %d, want %d\n%s", res.Code, want, res.Stderr)
+			}
+			known := crashSignals[tt.sig]
+			if want := fmt.Sprintf("killed by %s (%v)", known.name, tt.sig); !strings.Contains(res.Stderr+res.Stdout, want) {
+				t.Errorf("output doesn't say %q:\n%s%s", want, res.Stdout, res.Stderr)
+			}
+			if !strings.Contains(res.Stderr+res.Stdout, known.meaning) {
+				t.Errorf("output doesn't say what %s means:\n%s%s", known.name, res.Stdout, res.Stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storeSources keeps a copy of the code run from a URL or a pipe, under a
+// name derived from its content, so that --last can run it again and its
+// build can be cached. --no-store-source turns it off for code that
+// shouldn't be written to disk.
+var storeSources = true
+
+// maxStoredSource is the largest source that is stored; bigger ones run
+// from a temporary copy as if --no-store-source was given.
+const maxStoredSource = 256 << 10
+
+// snippetHistoryPrefix marks history entries that are stored sources:
+// snippet:<hash>/<name> followed by the start of the code.
+const snippetHistoryPrefix = "snippet:"
+
+// snippetsDir returns where stored sources are kept, one directory per
+// content hash.
+func snippetsDir() string {
+	return filepath.Join(dataDir(), "snippets")
+}
+
+// storeSnippet copies the source at path, a temporary copy of a URL or a
+// pipe, into the store and returns the stored file. The file keeps its name,
+// which Java needs to match the class. It returns false when the source
+// isn't stored.
+func storeSnippet(path string) (string, bool) {
+	if !storeSources {
+		return "", false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) > maxStoredSource {
+		return "", false
+	}
+	sum := sha256.Sum256(content)
+	dir := filepath.Join(snippetsDir(), hex.EncodeToString(sum[:8]))
+	stored := filepath.Join(dir, filepath.Base(path))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", false
+	}
+	if _, err := os.Stat(stored); err != nil {
+		if err := os.WriteFile(stored, content, 0o600); err != nil {
+			return "", false
+		}
+	}
+	logEvent("snippet", map[string]any{"path": stored, "bytes": len(content)})
+	return stored, true
+}
+
+// isStoredSnippet reports whether path is in the store.
+func isStoredSnippet(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(snippetsDir(), abs)
+	return err == nil && !strings.HasPrefix(rel, "..") && filepath.Base(filepath.Dir(rel)) != "."
+}
+
+// snippetHistoryEntry is the history entry for the stored source at path:
+// its place in the store and a preview of the code, which is all a file
+// called script.py would say about it.
+func snippetHistoryEntry(path string) string {
+	abs, _ := filepath.Abs(path)
+	rel, _ := filepath.Rel(snippetsDir(), abs)
+	entry := snippetHistoryPrefix + filepath.ToSlash(rel)
+	if preview := snippetPreview(path); preview != "" {
+		entry += " " + preview
+	}
+	return entry
+}
+
+// snippetPreview returns the first line of code in path, shortened.
+func snippetPreview(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#!") {
+			return clip(line, 40)
+		}
+	}
+	return ""
+}
+
+// resolveSnippetEntry returns the stored file a snippet history entry, less
+// its prefix, refers to.
+func resolveSnippetEntry(entry string) (string, error) {
+	rel, _, _ := strings.Cut(entry, " ")
+	path := filepath.Join(snippetsDir(), filepath.FromSlash(rel))
+	if _, err := os.Stat(path); err != nil {
+		return "", usageError("The last run was code from a URL or a pipe that is no longer stored (removed by run clean?).")
+	}
+	return path, nil
+}
+
+// cleanCommand implements `run clean [--dry-run]`: it removes the stored
+// sources and the cached builds.
+func cleanCommand(args []string) {
+	dryRun := false
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		default:
+			fmt.Println("Usage: run clean [--dry-run]")
+			os.Exit(exitUsage)
+		}
+	}
+	for _, target := range []struct{ what, dir string }{
+		{"stored sources", snippetsDir()},
+		{"cached builds", buildCacheDir()},
+	} {
+		entries, _ := os.ReadDir(target.dir)
+		size := max(dirSize(target.dir), 0)
+		switch {
+		case len(entries) == 0:
+			fmt.Printf("No %s.\n", target.what)
+		case dryRun:
+			fmt.Printf("Would remove %d %s (%s) from %s\n", len(entries), target.what, formatSize(size), target.dir)
+		default:
+			if err := os.RemoveAll(target.dir); err != nil {
+				fmt.Printf("Cannot remove %s: %v\n", target.dir, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Removed %d %s (%s)\n", len(entries), target.what, formatSize(size))
+		}
+	}
+}
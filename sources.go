@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// extraSources are more translation units compiled into a C or C++ program
+// along with its file (--sources), e.g. util.c. The program is still named
+// after its file.
+var extraSources []string
+
+// unitExts are the extensions of the translation units --sources takes for
+// each language that can be built from several.
+var unitExts = map[string][]string{
+	".c":   {".c"},
+	".cpp": {".cpp", ".cc", ".cxx", ".c++"},
+}
+
+// headerExts are left out of the compile command: the units that need a
+// header #include it, and compiling one on its own produces a precompiled
+// header, not code.
+var headerExts = []string{".h", ".hh", ".hpp", ".hxx", ".h++", ".inc", ".tpp"}
+
+// checkSources validates --sources for a program of ext and drops the
+// headers among them. sourceFile itself is dropped too, so that a shell
+// glob such as --sources "$(echo *.c)" can include it.
+func checkSources(sourceFile, ext string) error {
+	if len(extraSources) == 0 {
+		return nil
+	}
+	allowed, ok := unitExts[ext]
+	if !ok {
+		return usageError("--sources only applies to C and C++ programs, not %s files.", ext)
+	}
+	var units []string
+	for _, path := range extraSources {
+		unitExt := strings.ToLower(filepath.Ext(path))
+		if !slices.Contains(allowed, unitExt) && !slices.Contains(headerExts, unitExt) {
+			return usageError("--sources: %s is not a %s source (%s).", path, languageConfigs[ext].Name, strings.Join(allowed, ", "))
+		}
+		if _, err := os.Stat(path); err != nil {
+			return newRunError("file-not-found", exitNoInput, "File not found: %s (--sources)", path)
+		}
+		if err := checkRestricted("--sources file", path); err != nil {
+			return err
+		}
+		if slices.Contains(headerExts, unitExt) || sameFile(path, sourceFile) {
+			continue
+		}
+		if entryDir != "" {
+			// The compiler runs in the directory given to run
+			path, _ = filepath.Abs(path)
+		}
+		units = append(units, path)
+	}
+	extraSources = units
+	return nil
+}
+
+// sameFile reports whether a and b are paths of the same existing file.
+func sameFile(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	return err == nil && os.SameFile(ia, ib)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// isStream reports whether path is a pipe or device rather than a regular
+// file, such as the /dev/fd/63 that the shell passes for `run <(gen.sh)`.
+// Streams can be read only once and carry no extension.
+func isStream(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.Mode().IsRegular() && !info.IsDir()
+}
+
+// bufferStream reads the stream at path into a temporary file so it can be
+// compiled and run like any other source. The language is ext (from --lang)
+// or, when that is empty, taken from the content's `#!` line. The returned
+// function removes the copy.
+func bufferStream(path, ext string) (string, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, newRunError("file-not-found", exitNoInput, "Cannot read %s: %v", path, err)
+	}
+	defer f.Close()
+	return bufferSource(path, f, ext)
+}
+
+// bufferStdin is bufferStream for the code piped to run, as in
+// `cat snippet | run - --lang py`. The program can't read the pipe run
+// read its code from, so its stdin becomes the terminal, if there is one;
+// otherwise it reads the end of the pipe.
+func bufferStdin(ext string) (string, func(), error) {
+	file, cleanup, err := bufferSource("stdin", os.Stdin, ext)
+	if err != nil {
+		return "", nil, err
+	}
+	tty := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		tty = "CONIN$"
+	}
+	if !isTerminal(os.Stdin) {
+		if f, err := os.Open(tty); err == nil {
+			os.Stdin = f
+		}
+	}
+	return file, cleanup, nil
+}
+
+// bufferSource reads the code in r, named name in messages, into a
+// temporary file of the language ext, or of its `#!` line's.
+func bufferSource(name string, r io.Reader, ext string) (string, func(), error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, newRunError("file-not-found", exitNoInput, "Cannot read %s: %v", name, err)
+	}
+
+	if ext == "" {
+		line, _ := bufio.NewReader(bytes.NewReader(content)).ReadString('\n')
+		ext = interpreterExt(line)
+	}
+	if ext == "" {
+		example := "run --lang py <(gen.sh)"
+		if name == "stdin" {
+			example = "cat snippet | run - --lang py"
+		}
+		return "", nil, newRunError("unsupported-language", exitUnsupported,
+			"Cannot tell the language of %s: it is a pipe without a #! line.\nUse --lang <ext> to pick the language, e.g. %s.", name, example)
+	}
+
+	dir, err := os.MkdirTemp("", "run-stream-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	base := "script"
+	if ext == ".java" {
+		base = "Main" // javac wants the file named after the public class
+	}
+	file := filepath.Join(dir, base+ext)
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	logEvent("stream", map[string]any{"path": name, "ext": ext, "copy": file, "bytes": len(content)})
+	return file, cleanup, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import "sort"
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// suggest returns the candidates closest to word, best first. Candidates
+// further than two edits away, or that would change most of word, are never
+// suggested.
+func suggest(word string, candidates []string) []string {
+	limit := min(2, (len([]rune(word))-1)/2)
+	type match struct {
+		candidate string
+		distance  int
+	}
+	var matches []match
+	for _, c := range candidates {
+		if d := levenshtein(word, c); d <= limit && c != word {
+			matches = append(matches, match{c, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	best := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if m.distance > matches[0].distance {
+			break
+		}
+		best = append(best, m.candidate)
+	}
+	return best
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{".py", ".pyy", 1},
+		{"--timee", "--time", 1},
+		{"--vebrose", "--verbose", 2},
+		{"héllo", "hello", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	exts := supportedExtensions()
+	tests := []struct {
+		word       string
+		candidates []string
+		want       []string
+	}{
+		// Extensions
+		{".pyy", exts, []string{".py"}},
+		{".jss", exts, []string{".js"}},
+		{".rss", exts, []string{".rs"}},
+		{".jav", exts, []string{".java"}},
+		{".cppp", exts, []string{".cpp"}},
+		// Flags
+		{"--timee", knownFlags, []string{"--time"}},
+		{"--dryrun", knownFlags, []string{"--dry-run"}},
+		{"--benchh", knownFlags, []string{"--bench"}},
+		{"--no-instal", knownFlags, []string{"--no-install"}},
+		{"--vebrose", knownFlags, []string{"--verbose"}},
+		// Ties are all suggested, in the order of the candidates
+		{"ab", []string{"a", "b", "abc"}, nil},
+		{"abcd", []string{"abce", "abcf"}, []string{"abce", "abcf"}},
+		// The exact word is not a suggestion
+		{"--time", []string{"--time"}, nil},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.word, tt.candidates); !slices.Equal(got, tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+			t.Errorf("suggest(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+// TestSuggestNothingTooFar checks that nothing is suggested for words too
+// far from every candidate, or for short words whose every letter would
+// change.
+func TestSuggestNothingTooFar(t *testing.T) {
+	exts := supportedExtensions()
+	for _, word := range []string{".xyz", ".docx", ".q", ".", "--frobnicate", "--x", "-z", "--timeout-seconds"} {
+		candidates := append(slices.Clone(exts), knownFlags...)
+		if got := suggest(word, candidates); len(got) > 0 {
+			t.Errorf("suggest(%q) = %q, want nothing", word, got)
+		}
+	}
+	// Three edits is always too many, however long the word
+	if got := suggest("--abcdefghijkl", []string{"--abcdefghixyz"}); len(got) > 0 {
+		t.Errorf("suggested %q three edits away", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// placeholderPattern matches a {name} placeholder in a configured command,
+// or a {{name}} escape for a literal {name}, e.g. in an awk program.
+var placeholderPattern = regexp.MustCompile(`\{\{(\w+)\}\}|\{(\w+)\}`)
+
+// placeholders are the names a configured compile or run command can use,
+// with what each stands for.
+var placeholders = map[string]string{
+	"file":     "the source file",
+	"fileBase": "the source file's name without directory and extension",
+	"fileDir":  "the source file's directory",
+	"exe":      "the program the compile step builds, in the build directory",
+	"tmpdir":   "the build directory, created for the run and removed after it",
+	"args":     "the program's arguments, one element each; must be an element of its own",
+}
+
+// hasPlaceholders reports whether argv is a template. A template is used as
+// written: run doesn't add the source file or the program's arguments.
+func hasPlaceholders(argv []string) bool {
+	for _, arg := range argv {
+		if placeholderPattern.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesPlaceholder reports whether argv uses the placeholder name.
+func usesPlaceholder(argv []string, name string) bool {
+	for _, arg := range argv {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(arg, -1) {
+			if m[2] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkPlaceholders returns an error for an unknown placeholder in argv, or
+// an {args} sharing its element with other text.
+func checkPlaceholders(argv []string) error {
+	for _, arg := range argv {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(arg, -1) {
+			name := m[2]
+			if name == "" {
+				continue // An escape
+			}
+			if _, ok := placeholders[name]; !ok {
+				msg := fmt.Sprintf("unknown placeholder {%s}", name)
+				if best := suggest(name, sortedKeys(placeholders)); len(best) > 0 {
+					msg += fmt.Sprintf(" (did you mean {%s}?)", best[0])
+				}
+				return fmt.Errorf("%s; write {{%s}} for a literal {%s}", msg, name, name)
+			}
+			if name == "args" && arg != "{args}" {
+				return fmt.Errorf("{args} must be an element of its own, not part of %q", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// expandCommand substitutes vars into each element of argv. An {args}
+// element becomes as many elements as there are arguments; nothing is ever
+// split or joined the way a shell would.
+func expandCommand(argv []string, vars map[string][]string) []string {
+	expanded := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		if arg == "{args}" {
+			expanded = append(expanded, vars["args"]...)
+			continue
+		}
+		expanded = append(expanded, placeholderPattern.ReplaceAllStringFunc(arg, func(m string) string {
+			if strings.HasPrefix(m, "{{") {
+				return m[1 : len(m)-1]
+			}
+			return strings.Join(vars[m[1:len(m)-1]], " ")
+		}))
+	}
+	return expanded
+}
+
+// expandTemplates replaces the commands buildPlan put together with the
+// expansion of config's templated ones, if any. file is the source as the
+// commands see it.
+func expandTemplates(plan *execPlan, config LanguageConfig, ext, file string) {
+	if !hasPlaceholders(config.CompileCmd) && !hasPlaceholders(config.RunCmd) {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if plan.BuildDir == "" && (usesPlaceholder(config.RunCmd, "tmpdir") || usesPlaceholder(config.RunCmd, "exe")) {
+		plan.BuildDir = newBuildDir(file)
+	}
+	exe := plan.Executable
+	if exe == "" && plan.BuildDir != "" {
+		exe = filepath.Join(plan.BuildDir, name)
+	}
+	vars := map[string][]string{
+		"file":     {file},
+		"fileBase": {name},
+		"fileDir":  {filepath.Dir(file)},
+		"exe":      {exe},
+		"tmpdir":   {plan.BuildDir},
+		"args":     programArgs,
+	}
+	if hasPlaceholders(config.CompileCmd) {
+		// --compiler-args go before the source here too, or last without
+		// a {file} of its own
+		argv := resolveRuntime(ext, config.CompileCmd)
+		at := slices.Index(argv, "{file}")
+		if at < 0 {
+			at = len(argv)
+		}
+		plan.Compile = expandCommand(argv[:at], vars)
+		plan.Compile = append(plan.Compile, compilerArgs...)
+		plan.Compile = append(plan.Compile, expandCommand(argv[at:], vars)...)
+	}
+	if hasPlaceholders(config.RunCmd) {
+		// As do --runtime-args
+		argv := resolveRuntime(ext, config.RunCmd)
+		at := slices.Index(argv, "{file}")
+		if at < 0 {
+			at = len(argv)
+		}
+		plan.Run = expandCommand(argv[:at], vars)
+		plan.Run = append(plan.Run, runtimeArgs...)
+		plan.Run = append(plan.Run, expandCommand(argv[at:], vars)...)
+	}
+}
@@ -0,0 +1,75 @@
+#!/bin/bash
+# Captures the samples of the toolchains named on the command line from
+# the toolchains themselves, and records their versions in VERSIONS.
+#
+#   testdata/errors/capture.sh javac tsc missing_ruby missing_lua missing_r missing_julia
+set -e
+
+HERE="$(cd "$(dirname "$0")" && pwd)"
+WORK="$(mktemp -d)"
+trap 'rm -rf "$WORK"' EXIT
+cd "$WORK"
+
+# record writes the sample name from stdin and its toolchain's version
+record() {
+  cat > "$HERE/$1.txt"
+  grep -v "^$1:" "$HERE/VERSIONS" > VERSIONS.new 2>/dev/null || true
+  echo "$1: $2" >> VERSIONS.new
+  sort VERSIONS.new > "$HERE/VERSIONS"
+  echo "Captured $1 ($2)"
+}
+
+need() {
+  if ! command -v "$1" > /dev/null; then
+    echo "$1 is not installed" >&2
+    exit 1
+  fi
+}
+
+for sample in "$@"; do
+  case "$sample" in
+    javac)
+      need javac
+      cat > Bad.java << 'EOF'
+public class Bad { public static void main(String[] args) {
+        Integer x = new Integer(1);
+        System.out.println(y);
+} }
+EOF
+      javac Bad.java 2>&1 | record javac "$(javac -version 2>&1)"
+      ;;
+    tsc)
+      need tsc
+      cat > bad.ts << 'EOF'
+// Both lines are wrong
+const x: number = "s";
+console.log(y);
+EOF
+      tsc --pretty false bad.ts | record tsc "tsc $(tsc --version)"
+      ;;
+    missing_ruby)
+      need ruby
+      echo "require 'nokogiri'" > imp.rb
+      ruby imp.rb 2>&1 | record missing_ruby "$(ruby --version)"
+      ;;
+    missing_lua)
+      need lua
+      echo 'local http = require("socket.http")' > imp.lua
+      lua imp.lua 2>&1 | record missing_lua "$(lua -v 2>&1)"
+      ;;
+    missing_r)
+      need Rscript
+      echo 'library(dplyr)' > imp.r
+      Rscript imp.r 2>&1 | record missing_r "$(Rscript --version 2>&1)"
+      ;;
+    missing_julia)
+      need julia
+      echo 'using DataFrames' > imp.jl
+      julia imp.jl 2>&1 | record missing_julia "$(julia --version)"
+      ;;
+    *)
+      echo "No recipe for $sample" >&2
+      exit 1
+      ;;
+  esac
+done
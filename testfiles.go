@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// testMode decides whether test files run under their test runner
+	// ("auto") or like any other source ("off"), set with --test-mode.
+	testMode = "auto"
+	// testPattern selects the tests to run (--test-run), passed to
+	// `go test -run` or `pytest -k`.
+	testPattern string
+	// testBench runs a Go test file's benchmarks instead of its tests; it
+	// is what --bench means for such files.
+	testBench bool
+)
+
+// isGoTest reports whether sourceFile holds Go tests, which `go run`
+// refuses to run.
+func isGoTest(sourceFile string) bool {
+	return testMode == "auto" && strings.HasSuffix(sourceFile, "_test.go")
+}
+
+// isPytest reports whether sourceFile is named like a pytest module.
+func isPytest(sourceFile string) bool {
+	base := filepath.Base(sourceFile)
+	return testMode == "auto" && strings.HasSuffix(base, ".py") &&
+		(strings.HasPrefix(base, "test_") || strings.HasSuffix(base, "_test.py"))
+}
+
+// testPlan returns the plan that runs sourceFile under its test runner, or
+// false when it is not a test file or the runner is unavailable.
+func testPlan(sourceFile, ext string) (execPlan, bool) {
+	switch {
+	case ext == ".go" && isGoTest(sourceFile):
+		return goTestPlan(sourceFile), true
+	case ext == ".py" && isPytest(sourceFile):
+		python := resolveRuntime(ext, []string{"python3"})
+		check := exec.Command(python[0], "-c", "import pytest")
+		check.Env = childEnv()
+		if runCmd(check) != nil {
+			return execPlan{}, false
+		}
+		argv := append(append([]string{}, python...), "-m", "pytest", sourceFile)
+		if testPattern != "" {
+			argv = append(argv, "-k", testPattern)
+		}
+		return execPlan{SourceFile: sourceFile, Run: argv}, true
+	}
+	return execPlan{}, false
+}
+
+// goTestPlan runs the tests of sourceFile's package from the package
+// directory. Outside a module the package can't be named, so only the file
+// itself is tested.
+func goTestPlan(sourceFile string) execPlan {
+	argv := resolveRuntime(".go", []string{"go"})
+	argv = append(argv, "test", "-v")
+	if testBench {
+		pattern := testPattern
+		if pattern == "" {
+			pattern = "."
+		}
+		argv = append(argv, "-run", "^$", "-bench", pattern)
+	} else if testPattern != "" {
+		argv = append(argv, "-run", testPattern)
+	} else {
+		argv = append(argv, "-run", ".")
+	}
+
+	dir := filepath.Dir(sourceFile)
+	if inGoModule(dir) {
+		argv = append(argv, ".")
+	} else {
+		argv = append(argv, filepath.Base(sourceFile))
+	}
+	return execPlan{SourceFile: sourceFile, Run: argv, Dir: dir}
+}
+
+// inGoModule reports whether dir is inside a Go module.
+func inGoModule(dir string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// parseTestMode validates a --test-mode value.
+func parseTestMode(value string) (string, error) {
+	if value != "auto" && value != "off" {
+		return "", fmt.Errorf("--test-mode must be auto or off, not %q", value)
+	}
+	return value, nil
+}
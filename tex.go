@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// keepArtifacts keeps the auxiliary files of a TeX build (--keep-artifacts).
+	keepArtifacts bool
+	// openOutput opens the produced document in the system viewer (--open).
+	openOutput bool
+)
+
+// texAuxExts are the auxiliary files a TeX build leaves next to the source.
+var texAuxExts = []string{".aux", ".log", ".toc", ".out", ".fls", ".fdb_latexmk", ".nav", ".snm"}
+
+// maxTeXErrors caps how many errors are quoted from the .log file.
+const maxTeXErrors = 3
+
+// texLineNumber matches the l.<n> line where TeX shows the input that
+// caused an error.
+var texLineNumber = regexp.MustCompile(`^l\.(\d+)\s?(.*)$`)
+
+// texCommands returns the commands that build a PDF from sourceFile, run from
+// its directory: latexmk when it is installed, which reruns as often as
+// needed, or else two pdflatex passes so that references resolve.
+func texCommands(sourceFile string) [][]string {
+	name := filepath.Base(sourceFile)
+	if _, err := exec.LookPath("latexmk"); err == nil {
+		return [][]string{{"latexmk", "-pdf", "-interaction=nonstopmode", name}}
+	}
+	pass := []string{"pdflatex", "-interaction=nonstopmode", "-halt-on-error", name}
+	return [][]string{pass, pass}
+}
+
+// compileTeX builds the PDF of sourceFile. The TeX output is held back, as it
+// runs to thousands of lines; on failure the errors are quoted from the .log
+// file instead, which is then kept.
+func compileTeX(sourceFile string) error {
+	dir := filepath.Dir(sourceFile)
+	base := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile))
+	logFile := base + ".log"
+
+	infof("Compiling %s...\n", sourceFile)
+	for i, argv := range texCommands(sourceFile) {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Dir = dir
+		cmd.Env = childEnv()
+		phase := "compiling " + sourceFile
+		if i > 0 {
+			phase = "compiling " + sourceFile + " again for references"
+		}
+		step := startStep(phase)
+		cmd.Stdout = step
+		cmd.Stderr = step
+		err := runCmd(cmd)
+		step.Finish(nil) // The .log file says it better
+		if err != nil {
+			diagnostics := printTeXErrors(logFile, sourceFile)
+			removeTeXAux(base, false)
+			return &runError{Status: "compile-failed", Code: exitCompileFailed,
+				Msg:         fmt.Sprintf("Compilation failed: %v\n  Command: %s\n  Full log: %s", err, shellJoin(argv), logFile),
+				Diagnostics: diagnostics}
+		}
+	}
+	if !keepArtifacts {
+		removeTeXAux(base, true)
+	}
+
+	pdf := base + ".pdf"
+	if info, err := os.Stat(pdf); err == nil {
+		infof("Compiled %s (%s)\n", pdf, formatSize(info.Size()))
+	}
+	if openOutput {
+		if err := openFile(pdf); err != nil {
+			warnf("cannot open %s: %v", pdf, err)
+		}
+	}
+	return nil
+}
+
+// removeTeXAux removes the auxiliary files of the build of base; the .log
+// file only when withLog is set.
+func removeTeXAux(base string, withLog bool) {
+	for _, ext := range texAuxExts {
+		if ext != ".log" || withLog {
+			os.Remove(base + ext)
+		}
+	}
+}
+
+// printTeXErrors prints the errors recorded in a TeX .log file, each from
+// its "!" line down to the l.<n> line that shows the offending input, and
+// returns them as diagnostics against sourceFile.
+func printTeXErrors(logFile, sourceFile string) []diagnostic {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var diagnostics []diagnostic
+	var current []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && len(diagnostics) < maxTeXErrors {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "! "):
+			current = []string{line}
+		case current == nil:
+			continue
+		case len(current) >= 8:
+			current = nil // Not the usual shape; don't quote the whole log
+		default:
+			current = append(current, line)
+			if m := texLineNumber.FindStringSubmatch(line); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				if len(diagnostics) == 0 {
+					fmt.Fprintf(os.Stderr, "\nErrors from %s:\n", logFile)
+				}
+				for _, l := range current {
+					fmt.Fprintf(os.Stderr, "  %s\n", l)
+				}
+				diagnostics = append(diagnostics, diagnostic{File: sourceFile, Line: n, Severity: "error",
+					Text: strings.TrimPrefix(current[0], "! ")})
+				current = nil
+			}
+		}
+	}
+	return diagnostics
+}
+
+// openFile opens path with the system's default application.
+func openFile(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	traceCmd(cmd)
+	return cmd.Start()
+}
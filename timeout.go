@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// runTimeout stops the program once it has run this long by the wall clock
+// (--timeout); zero means no limit. Compilation doesn't count.
+var runTimeout time.Duration
+
+// timeoutGrace is how long a program that timed out has to exit after
+// SIGTERM before it is killed.
+const timeoutGrace = 5 * time.Second
+
+// withRunTimeout returns ctx limited to runTimeout, if set.
+func withRunTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if runTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, runTimeout)
+}
+
+// timedOut reports whether cmd, started with ctx from withRunTimeout, was
+// stopped by --timeout.
+func timedOut(ctx context.Context) bool {
+	return runTimeout > 0 && ctx.Err() == context.DeadlineExceeded
+}
+
+// timeoutError is the error of a program stopped by --timeout.
+func timeoutError() *runError {
+	return newRunError("timeout", exitTimeout, "Timed out after %v", runTimeout)
+}
+
+// applyTimeout makes the end of cmd's context stop it gently: SIGTERM
+// first, then SIGKILL after timeoutGrace.
+func applyTimeout(cmd *exec.Cmd) {
+	if runTimeout > 0 {
+		terminateOnCancel(cmd, timeoutGrace)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// releaseVersion is the version of this source, for builds that aren't
+// given another.
+const releaseVersion = "1.0.0"
+
+// Build metadata, injected at release time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// Plain `go build` and `go install` builds fill them in from the module
+// build info instead, see init, and otherwise report releaseVersion.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		// A build of a checkout with no release tag behind it is stamped
+		// v0.0.0-<time>-<commit>, which says less than releaseVersion
+		if v := info.Main.Version; version == "" && v != "" && v != "(devel)" && !strings.HasPrefix(v, "v0.0.0-") {
+			version = strings.TrimPrefix(v, "v")
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = setting.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			case "vcs.modified":
+				if setting.Value == "true" && commit != "" && !strings.HasSuffix(commit, "-dirty") {
+					commit += "-dirty"
+				}
+			}
+		}
+	}
+	if version == "" {
+		version = releaseVersion
+	}
+}
+
+// versionInfo is the output of `run version --json`.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// versionCommand implements `run version [--json]`.
+func versionCommand(args []string) {
+	asJSON := false
+	for _, arg := range args {
+		if arg != "--json" {
+			fmt.Println("Usage: run version [--json]")
+			os.Exit(exitUsage)
+		}
+		asJSON = true
+	}
+
+	info := versionInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return
+	}
+
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	fmt.Printf("run version %s\n", info.Version)
+	fmt.Printf("  Commit:   %s\n", orUnknown(info.Commit))
+	fmt.Printf("  Built:    %s\n", orUnknown(info.Date))
+	fmt.Printf("  Go:       %s\n", info.GoVersion)
+	fmt.Printf("  Platform: %s/%s\n", info.OS, info.Arch)
+}
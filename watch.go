@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchMode runs the file again whenever it changes (--watch).
+var watchMode bool
+
+const (
+	// watchPoll is how often the watched files are checked for changes.
+	watchPoll = 250 * time.Millisecond
+	// watchDebounce is how long the files must stay unchanged before the
+	// next run, so that an editor saving in several writes runs it once.
+	watchDebounce = 200 * time.Millisecond
+	// watchGrace is how long a run that is still going when the files
+	// change gets to exit on SIGTERM and clean up before it is killed.
+	watchGrace = 3 * time.Second
+)
+
+// watchSkipDirs are directories left out when a whole directory is
+// watched: dependencies and build output change without the user editing
+// anything.
+var watchSkipDirs = map[string]bool{"node_modules": true, "target": true, "build": true, "dist": true, "__pycache__": true, "vendor": true}
+
+// watchPaths returns what to watch for sourceFile: the directory given to
+// run, the root of the project the file belongs to (see projectPlan), or
+// the file and its --sources.
+func watchPaths(sourceFile, ext string) []string {
+	if info, err := os.Stat(sourceFile); err == nil && info.IsDir() {
+		return []string{sourceFile}
+	}
+	if !noProject {
+		if _, root, ok := findProject(sourceFile, ext); ok {
+			return []string{root}
+		}
+	}
+	return append([]string{sourceFile}, extraSources...)
+}
+
+// snapshot stamps the files under paths, hashing only those whose time or
+// size differ from their stamp in previous. Files that can't be read are
+// left out, so that one being replaced counts as a change once it is back.
+func snapshot(paths []string, previous map[string]fileStamp) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && (strings.HasPrefix(d.Name(), ".") || watchSkipDirs[d.Name()]) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if stamp, err := stampFile(path, info, previous[path]); err == nil {
+				stamps[path] = stamp
+			}
+			return nil
+		})
+	}
+	return stamps
+}
+
+// watchFile runs sourceFile with args, the arguments of this run without
+// --watch, in a run of its own, and again each time the watched files
+// change. A change is one of content: files touched but holding what they
+// held when the run started don't run it again. A run still going then is
+// stopped first, and one that fails doesn't stop the watch. Ctrl+C stops both; the run cleans up its build
+// as any run does, and the watch ends without error.
+func watchFile(sourceFile, ext string, args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return newRunError("error", 1, "Cannot find run's own executable: %v", err)
+	}
+	// Ctrl+C reaches the running program, and ends the watch
+	stop := watchInterrupts()
+	defer stop()
+
+	paths := watchPaths(sourceFile, ext)
+	stamps := snapshot(paths, nil)
+	for n := 1; ; n++ {
+		ran := stamps
+		if n > 1 {
+			infof("\n")
+		}
+		infof("==> %s %s (run %d; watching %s)\n", time.Now().Format("2006-01-02 15:04:05"), sourceFile, n, strings.Join(paths, ", "))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cmd := exec.CommandContext(ctx, self, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		terminateOnCancel(cmd, watchGrace)
+		traceCmd(cmd)
+		if err := cmd.Start(); err != nil {
+			cancel()
+			return newRunError("error", 1, "Cannot run %s: %v", sourceFile, err)
+		}
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		changed, running := false, true
+		for !changed {
+			select {
+			case err := <-exited:
+				running = false
+				reportWatchedRun(err)
+			case <-time.After(watchPoll):
+			}
+			if interruptError() != nil {
+				break
+			}
+			current := snapshot(paths, stamps)
+			touched := !maps.Equal(current, stamps)
+			// An exited run waits for the next change; a running one
+			// only stops for one
+			for touched {
+				time.Sleep(watchDebounce)
+				settled := snapshot(paths, current)
+				if maps.Equal(settled, current) {
+					break
+				}
+				current = settled
+			}
+			stamps = current
+			changed = touched && !sameContent(current, ran)
+			if touched && !changed {
+				infof("(no content change, skipped)\n")
+			}
+		}
+		if running {
+			if changed && interruptError() == nil {
+				infof("\n%s changed; stopping the run still going.\n", sourceFile)
+			}
+			cancel()
+			<-exited
+		}
+		cancel()
+		if interruptError() != nil {
+			infof("\nStopped watching %s.\n", sourceFile)
+			return nil
+		}
+	}
+}
+
+// reportWatchedRun says how a run of the watch ended, and that the watch
+// goes on.
+func reportWatchedRun(err error) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		infof("✓ Waiting for changes...\n")
+	case errors.As(err, &exitErr):
+		infof("✗ Exit code %d. Waiting for changes...\n", exitCode(exitErr.ProcessState))
+	default:
+		infof("✗ %v. Waiting for changes...\n", err)
+	}
+}
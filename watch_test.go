@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSnapshotHashesContent(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "main.py", "print(1)\n")
+	before := snapshot([]string{dir}, nil)
+
+	// Touched: newer, same content
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	touched := snapshot([]string{dir}, before)
+	if touched[file].ModTime.Equal(before[file].ModTime) {
+		t.Fatal("the touch didn't change the time")
+	}
+	if !sameContent(before, touched) {
+		t.Error("a touch counts as a change of content")
+	}
+
+	// Changed, then changed back
+	writeFile(t, dir, "main.py", "print(2)\n")
+	changed := snapshot([]string{dir}, touched)
+	if sameContent(before, changed) {
+		t.Error("an edit doesn't count as a change of content")
+	}
+	writeFile(t, dir, "main.py", "print(1)\n")
+	if reverted := snapshot([]string{dir}, changed); !sameContent(before, reverted) {
+		t.Error("an edit undone counts as a change of content")
+	}
+
+	// A new file and a removed one are changes
+	other := writeFile(t, dir, "util.py", "")
+	if sameContent(before, snapshot([]string{dir}, before)) {
+		t.Error("a new file doesn't count as a change")
+	}
+	os.Remove(other)
+	os.Remove(file)
+	if sameContent(before, snapshot([]string{dir}, before)) {
+		t.Error("a removed file doesn't count as a change")
+	}
+}
+
+// TestWatchSkipsUnchangedContent watches a script through a touch and an
+// edit undone, which don't run it again, and an edit, which does.
+func TestWatchSkipsUnchangedContent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stops the watch with SIGINT")
+	}
+	requireTools(t, "python3")
+	dir := t.TempDir()
+	script := writeFile(t, dir, "count.py", "open('runs.txt', 'a').write('run\\n')\n")
+	runs := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "runs.txt"))
+		return strings.Count(string(data), "run\n")
+	}
+
+	cmd := newRunEnv(t).command(t, dir, "--watch", "count.py")
+	var output lockedBuffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	waitFor(t, "the first run", func() bool { return runs() == 1 })
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(script, later, later)
+	waitFor(t, "the touch to be skipped", func() bool { return strings.Count(output.String(), "(no content change, skipped)") == 1 })
+
+	content, _ := os.ReadFile(script)
+	os.WriteFile(script, []byte("# an edit\n"), 0o644)
+	os.WriteFile(script, content, 0o644)
+	waitFor(t, "the edit undone to be skipped", func() bool { return strings.Count(output.String(), "(no content change, skipped)") == 2 })
+	if n := runs(); n != 1 {
+		t.Fatalf("ran %d times for unchanged content\n%s", n, output.String())
+	}
+
+	os.WriteFile(script, append(content, "# an edit\n"...), 0o644)
+	waitFor(t, "the second run", func() bool { return runs() == 2 })
+
+	cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("the watch ended with %v\n%s", err, output.String())
+	}
+}
+
+// waitFor waits up to 10 seconds for done.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer a command can write to while the test
+// reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fixCRLF runs a copy of a script with CRLF line endings converted to LF
+// (--fix-crlf) instead of refusing it.
+var fixCRLF bool
+
+// crlfSensitive are the languages whose interpreter reads a carriage return
+// as part of each line: bash reports `$'\r': command not found`, or
+// `/bin/bash^M: bad interpreter` when the script is run directly.
+var crlfSensitive = map[string]bool{".sh": true}
+
+// shimSuffixes are what Windows launchers end in. npm and pyenv-win install
+// node, tsc or python as .cmd or .bat shims, which exec.LookPath misses when
+// PATHEXT doesn't list them, e.g. in some Git Bash or CI shells.
+var shimSuffixes = []string{".exe", ".cmd", ".bat"}
+
+// windowsShim returns the path of bin on PATH as a .exe, .cmd or .bat file
+// when exec.LookPath can't find it under its bare name, or "" when that
+// isn't needed: off Windows, or when bin is a path or has an extension.
+func windowsShim(bin string) string {
+	if runtime.GOOS != "windows" || strings.ContainsAny(bin, `/\`) || filepath.Ext(bin) != "" {
+		return ""
+	}
+	if _, err := exec.LookPath(bin); err == nil {
+		return ""
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		for _, suffix := range shimSuffixes {
+			path := filepath.Join(dir, bin+suffix)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// maxWindowsPath is MAX_PATH: longer paths need the \\?\ prefix for Windows
+// to accept them when long paths aren't enabled system-wide.
+const maxWindowsPath = 260
+
+// longPath returns path with the \\?\ prefix on Windows when it is too long
+// to be used without it, e.g. an executable in a deep --out directory. Go's
+// own file functions do this by themselves, but the programs run is given
+// paths to don't.
+func longPath(path string) string {
+	if runtime.GOOS != "windows" || len(path) < maxWindowsPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if unc, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + unc
+	}
+	return `\\?\` + abs
+}
+
+// hasCRLF reports whether the file at path has Windows line endings, going
+// by its first line.
+func hasCRLF(path string) bool {
+	line := shebangLine(path)
+	return strings.HasSuffix(line, "\r\n")
+}
+
+// crlfError explains why a script with CRLF line endings can't run as is.
+func crlfError(path string) *runError {
+	what := "Bash"
+	if strings.HasPrefix(shebangLine(path), "#!") {
+		what = "Its #! line names an interpreter ending in ^M, and bash"
+	}
+	return newRunError("crlf-line-endings", exitUnsupported,
+		"%s has Windows (CRLF) line endings. %s reads the carriage return as part of each line, failing with errors such as $'\\r': command not found.\n"+
+			"Convert it with dos2unix %s, or pass --fix-crlf to run a converted copy.", path, what, shellQuote(path))
+}
+
+// convertCRLF returns content with CRLF line endings turned into LF.
+func convertCRLF(content []byte) []byte {
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWindowsShim(t *testing.T) {
+	dir := t.TempDir()
+	shim := writeFile(t, dir, "fakenode.cmd", "@echo off\r\n")
+	t.Setenv("PATH", dir)
+	t.Setenv("PATHEXT", ".EXE")
+
+	got := windowsShim("fakenode")
+	if runtime.GOOS != "windows" {
+		if got != "" {
+			t.Errorf("windowsShim found %s off Windows", got)
+		}
+		return
+	}
+	if got != shim {
+		t.Errorf("windowsShim(fakenode) = %q, want %q", got, shim)
+	}
+	for _, bin := range []string{"missing", `C:\tools\fakenode`, "fakenode.exe"} {
+		if got := windowsShim(bin); got != "" {
+			t.Errorf("windowsShim(%q) = %q, want nothing", bin, got)
+		}
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	short := filepath.Join(t.TempDir(), "main.exe")
+	if got := longPath(short); got != short {
+		t.Errorf("longPath(%q) = %q, want it unchanged", short, got)
+	}
+	long := filepath.Join(t.TempDir(), strings.Repeat("d", 120), strings.Repeat("e", 120), "main.exe")
+	got := longPath(long)
+	if runtime.GOOS != "windows" {
+		if got != long {
+			t.Errorf("longPath changed %q to %q off Windows", long, got)
+		}
+		return
+	}
+	if got != `\\?\`+long {
+		t.Errorf("longPath(%q) = %q, want the \\\\?\\ prefix", long, got)
+	}
+	if again := longPath(got); again != got {
+		t.Errorf("longPath prefixed %q again: %q", got, again)
+	}
+	unc := `\\server\share\` + strings.Repeat("x", 260)
+	if got := longPath(unc); got != `\\?\UNC\server\share\`+strings.Repeat("x", 260) {
+		t.Errorf("longPath(UNC path) = %q", got)
+	}
+}
+
+func TestCRLFScripts(t *testing.T) {
+	if got := string(convertCRLF([]byte("a\r\nb\r\n\r\nc\rd\n"))); got != "a\nb\n\nc\rd\n" {
+		t.Errorf("convertCRLF = %q", got)
+	}
+	dir := t.TempDir()
+	crlf := writeFile(t, dir, "crlf.sh", "#!/bin/bash\r\necho hello\r\n")
+	lf := writeFile(t, dir, "lf.sh", "#!/bin/bash\necho hello\n")
+	if !hasCRLF(crlf) || hasCRLF(lf) {
+		t.Errorf("hasCRLF(crlf) = %v, hasCRLF(lf) = %v", hasCRLF(crlf), hasCRLF(lf))
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("running shell scripts needs bash")
+	}
+	requireTools(t, "bash")
+	env := newRunEnv(t)
+	res := env.run(t, dir, "crlf.sh")
+	if res.Code != exitUnsupported || !strings.Contains(res.Stdout+res.Stderr, "--fix-crlf") {
+		t.Errorf("CRLF script: exit code %d, want %d and a hint at --fix-crlf\n%s%s", res.Code, exitUnsupported, res.Stdout, res.Stderr)
+	}
+	res = env.run(t, dir, "--quiet", "--fix-crlf", "crlf.sh")
+	if res.Code != 0 || res.Stdout != "hello\n" {
+		t.Errorf("--fix-crlf: exit code %d, stdout %q\n%s", res.Code, res.Stdout, res.Stderr)
+	}
+	if data, _ := os.ReadFile(crlf); !strings.Contains(string(data), "\r\n") {
+		t.Error("--fix-crlf changed the script itself")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+var (
+	// workDir is the directory --cwd runs interpreted programs in. When it is
+	// empty they run in their source file's directory, so that relative
+	// imports and data files resolve wherever run was started from.
+	workDir string
+	// keepWorkDir runs interpreted programs in the current directory, for
+	// sources that are temporary copies of a URL or a pipe, and for #!
+	// scripts, which behave like their interpreter would.
+	keepWorkDir bool
+)
+
+// applyWorkDir sets the directory the interpreted program of plan runs in.
+// Python also gets the source's directory on PYTHONPATH when it runs from
+// elsewhere, so that the program's own subprocesses find its modules too.
+// It is called before the program's arguments are added, so the source is
+// the last argument.
+func applyWorkDir(plan *execPlan, ext string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	srcDir, err := filepath.Abs(filepath.Dir(plan.SourceFile))
+	if err != nil {
+		return
+	}
+	dir := srcDir
+	switch {
+	case workDir != "":
+		if dir, err = filepath.Abs(workDir); err != nil {
+			return
+		}
+	case keepWorkDir:
+		dir = cwd
+	}
+
+	if dir != cwd {
+		plan.Dir = dir
+		// The source path, and the runtime's when it is a relative path
+		// such as RUN_PY_BIN=venv/bin/python, are relative to the current
+		// directory
+		last := len(plan.Run) - 1
+		plan.Run[last], _ = filepath.Abs(plan.Run[last])
+		if bin := plan.Run[0]; !filepath.IsAbs(bin) && filepath.Base(bin) != bin {
+			plan.Run[0], _ = filepath.Abs(bin)
+		}
+	}
+	if ext == ".py" && srcDir != cwd {
+		path := srcDir
+		if existing := os.Getenv("PYTHONPATH"); existing != "" {
+			path += string(os.PathListSeparator) + existing
+		}
+		plan.Env = append(plan.Env, "PYTHONPATH="+path)
+	}
+}
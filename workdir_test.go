@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSiblingImportsFromAnotherDirectory runs scripts that import a file
+// next to them, and read a data file by a relative path, from an unrelated
+// working directory.
+func TestSiblingImportsFromAnotherDirectory(t *testing.T) {
+	env := newRunEnv(t)
+	tests := []struct {
+		tool, main string
+		files      map[string]string
+	}{
+		{"python3", "app.py", map[string]string{
+			"app.py":   "import utils\nprint(utils.greet(), open('data.txt').read().strip())\n",
+			"utils.py": "def greet():\n    return 'hello'\n",
+		}},
+		{"node", "app.js", map[string]string{
+			"app.js":         "const { greet } = require('./lib/helpers.js');\nconsole.log(greet(), require('fs').readFileSync('data.txt', 'utf8').trim());\n",
+			"lib/helpers.js": "exports.greet = () => 'hello';\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.main, func(t *testing.T) {
+			requireTools(t, tt.tool)
+			project := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, project, name, content)
+			}
+			writeFile(t, project, "data.txt", "from data\n")
+			elsewhere := t.TempDir()
+
+			for _, file := range []string{filepath.Join(project, tt.main), mustRel(t, elsewhere, filepath.Join(project, tt.main))} {
+				res := env.run(t, elsewhere, "--quiet", file)
+				if res.Code != 0 || res.Stdout != "hello from data\n" {
+					t.Errorf("run %s from another directory: exit code %d, stdout %q\n%s", file, res.Code, res.Stdout, res.Stderr)
+				}
+			}
+
+			res := env.run(t, elsewhere, "--dry-run", filepath.Join(project, tt.main))
+			if !strings.Contains(res.Stdout, "Directory: "+project+"\n") {
+				t.Errorf("--dry-run doesn't show the working directory %s:\n%s", project, res.Stdout)
+			}
+		})
+	}
+}
+
+func mustRel(t *testing.T, base, target string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
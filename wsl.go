@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	wslOnce sync.Once
+	wslName string
+)
+
+// wslDistro returns the name of the WSL distribution run is in, "WSL" when
+// the name is unknown, or "" outside WSL.
+func wslDistro() string {
+	wslOnce.Do(func() {
+		if runtime.GOOS != "linux" {
+			return
+		}
+		if name := os.Getenv("WSL_DISTRO_NAME"); name != "" {
+			wslName = name
+			return
+		}
+		version := strings.ToLower(readTrimmed("/proc/version"))
+		if strings.Contains(version, "microsoft") || strings.Contains(version, "wsl") {
+			wslName = "WSL"
+		}
+	})
+	return wslName
+}
+
+// windowsMount matches paths on Windows drives mounted into WSL.
+var windowsMount = regexp.MustCompile(`^/mnt/[a-zA-Z](/|$)`)
+
+// onWindowsDrive reports whether path lives on a Windows drive under WSL,
+// where file I/O goes through the slow 9P bridge.
+func onWindowsDrive(path string) bool {
+	if wslDistro() == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && windowsMount.MatchString(abs)
+}
+
+// windowsToolchain returns the path of bin when, under WSL, it resolves to
+// a Windows program through the interop PATH rather than a Linux one.
+func windowsToolchain(bin string) string {
+	if wslDistro() == "" {
+		return ""
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil || !windowsMount.MatchString(path) {
+		return ""
+	}
+	return path
+}